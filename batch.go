@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Target description for batch testing
+type BatchTarget struct {
+	Name      string   `json:"name"`
+	KnownPins JtagPins `json:"known_pins"`
+	// IDCODEs as strings, e.g. "0x4ba00477" or "0x4ba00477/0x0fffffff" with
+	// mask, of devices having IDCODE in chain order
+	ExpectedIdcodes []string `json:"expected_idcodes"`
+	// GPIO levels to set before testing this target (e.g. to switch a mux),
+	// operator is prompted to connect the target if none given
+	Mux map[string]JtagPinState `json:"mux"`
+}
+
+func loadBatchTargets(path string) []BatchTarget {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	targets := []BatchTarget{}
+	if err := json.Unmarshal(data, &targets); err != nil {
+		panic(err)
+	}
+	return targets
+}
+
+// returns GPIO of a mux pin given as a key of BatchTarget.Mux
+func parseMuxPin(s string) (JtagPin, error) {
+	pin, err := strconv.ParseUint(s, 0, 8)
+	return JtagPin(pin), err
+}
+
+// Pins the targets use besides the JTAG ones selected at start: their known
+// pins and mux pins, so the driver checks and requests them up front.
+// returns the pins, each once, or nil and description of the problem
+func batchPins(targets []BatchTarget) ([]JtagPin, string) {
+	ret := []JtagPin{}
+	seen := map[JtagPin]bool{}
+	add := func(pin JtagPin) {
+		if !seen[pin] {
+			seen[pin] = true
+			ret = append(ret, pin)
+		}
+	}
+	for _, t := range targets {
+		k := t.KnownPins
+		for _, pin := range []JtagPin{k.TDI, k.TDO, k.TCK, k.TMS, k.TRST} {
+			add(pin)
+		}
+		for pinStr := range t.Mux {
+			pin, err := parseMuxPin(pinStr)
+			if err != nil {
+				return nil, fmt.Sprintf("target %s: invalid mux pin '%s'", t.Name, pinStr)
+			}
+			add(pin)
+		}
+	}
+	return ret, ""
+}
+
+// Run IDCODE test against every target from the list, one after another,
+// and print pass/fail summary.
+// returns true if all targets passed
+// anyVersion -- ignore version field of expected IDCODEs
+func (J *Jtag) batchTest(targets []BatchTarget, anyVersion bool) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Starting batch test of %d targets...\n", len(targets))
+	defer fmt.Fprintln(J.out, "================================")
+
+	stdin := bufio.NewReader(os.Stdin)
+	results := []string{}
+	passed := 0
+	for _, t := range targets {
+		if len(t.Mux) == 0 {
+			fmt.Fprintf(J.out, "connect target %s and press Enter...", t.Name)
+			stdin.ReadString('\n')
+		} else {
+			for pinStr, state := range t.Mux {
+				// checked by batchPins
+				pin, _ := parseMuxPin(pinStr)
+				J.drv.pinOutput(pin)
+				J.drv.pinWrite(pin, state)
+			}
+			delay(J.DELAY_RESET)
+		}
+
+		fmt.Fprintf(J.out, "testing %s:\n", t.Name)
+		J.KnownPins = t.KnownPins
+		idcodes := J.readKnownIdcodes()
+		for i, idcode := range idcodes {
+			fmt.Fprintf(J.out, "device %d: %s\n", i, describeChainIdcode(idcode))
+		}
+
+		expected := []IdcodeMatch{}
+		for _, e := range t.ExpectedIdcodes {
+			expected = append(expected, parseIdcodeMatch(e, anyVersion))
+		}
+		reason := checkExpectedIdcodes(idcodes, expected)
+		if reason == "" {
+			passed += 1
+			results = append(results, fmt.Sprintf("%s: PASS", t.Name))
+		} else {
+			results = append(results, fmt.Sprintf("%s: FAIL (%s)", t.Name, reason))
+		}
+	}
+
+	fmt.Fprintln(J.out, "summary:")
+	for _, r := range results {
+		fmt.Fprintln(J.out, r)
+	}
+	fmt.Fprintf(J.out, "%d of %d targets passed\n", passed, len(targets))
+
+	return passed == len(targets)
+}
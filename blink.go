@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Time each port is kept high and then low while blinking
+const BLINK_HALF_PERIOD = 500 * time.Millisecond
+
+// Toggle ports of the target device through EXTEST for duration, so the
+// package can be found on the board by a LED or a probe on one of its pins:
+// with -chain-ir-lens and -device it tells which package is at which chain
+// position. Ports blinked are the allowed ones, power and clock nets are
+// refused anyway; the other cells stay in safe state.
+// returns true if ports were blinked
+func (J *Jtag) blinkDevice(bsdl *Bsdl, allow map[string]bool, duration time.Duration) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Blinking device ports...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	if len(allow) == 0 {
+		fmt.Fprintln(J.out, "nothing to blink, give ports wired to a LED or a test point with -allow")
+		return false
+	}
+	if J.prepareBoundary(bsdl) == 0 {
+		return false
+	}
+
+	ports := []string{}
+	for port := range allow {
+		if reason := bsdl.checkDrivable(port, allow); reason != "" {
+			fmt.Fprintf(J.out, "refusing to blink: %s\n", reason)
+			return false
+		}
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	p := J.newBoundaryPins(bsdl)
+	if p == nil {
+		return false
+	}
+	// Reset TAP to Run-Test-Idle, pins are given back to the device
+	defer J.setTapState(TAP_RESET)
+
+	fmt.Fprintf(J.out, "blinking %v of device %d every %v for %v\n", ports, J.CHAIN.Device, BLINK_HALF_PERIOD, duration)
+	state := StateHigh
+	for start := time.Now(); time.Since(start) < duration; {
+		for _, port := range ports {
+			p.set(port, state)
+		}
+		p.update()
+		state ^= 1
+		time.Sleep(BLINK_HALF_PERIOD)
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Subset of BSDL (IEEE Std. 1149.1 Boundary Scan Description Language)
+// needed to drive a device: instruction register and boundary register
+// description.
+type Bsdl struct {
+	Entity      string
+	IrLen       uint32
+	BoundaryLen uint32
+	// opcodes by instruction name, values as written in BSDL (MSB first)
+	Opcodes map[string][]uint32
+	// boundary register cells, cell 0 is the closest to TDO
+	Cells []BsdlCell
+	// ports declared as linkage (power, ground, analog)
+	Linkage map[string]bool
+}
+
+// Boundary register cell
+type BsdlCell struct {
+	Num int
+	// cell type, e.g. BC_1
+	Cell string
+	// port name or "*" for internal cells
+	Port string
+	// input, output2, output3, control, bidir, clock, internal, ...
+	Function string
+	// value to load when cell is not used, 'X' if any
+	Safe byte
+	// number of control cell disabling this output, -1 if none
+	Ccell int
+	// value of control cell which disables the output
+	Disval byte
+}
+
+var bsdlEntityRe = regexp.MustCompile(`(?i)\bentity\s+(\w+)\s+is`)
+var bsdlIrLenRe = regexp.MustCompile(`(?i)attribute\s+INSTRUCTION_LENGTH\s+of\s+\w+\s*:\s*entity\s+is\s+(\d+)`)
+var bsdlBoundaryLenRe = regexp.MustCompile(`(?i)attribute\s+BOUNDARY_LENGTH\s+of\s+\w+\s*:\s*entity\s+is\s+(\d+)`)
+var bsdlOpcodesRe = regexp.MustCompile(`(?is)attribute\s+INSTRUCTION_OPCODE\s+of\s+\w+\s*:\s*entity\s+is\s+(.*?);`)
+var bsdlOpcodeRe = regexp.MustCompile(`(\w+)\s*\(([01xX,\s]+)\)`)
+var bsdlBoundaryRe = regexp.MustCompile(`(?is)attribute\s+BOUNDARY_REGISTER\s+of\s+\w+\s*:\s*entity\s+is\s+(.*?);`)
+var bsdlCellRe = regexp.MustCompile(`(\d+)\s*\(\s*(\w+)\s*,\s*([^,]+?)\s*,\s*(\w+)\s*,\s*(\w)\s*(?:,\s*(\d+)\s*,\s*(\d)\s*,\s*\w+\s*)?\)`)
+var bsdlLinkageRe = regexp.MustCompile(`(?i)([\w\s,]+?)\s*:\s*linkage\b`)
+
+// Strip comments and join string literals concatenated with '&'.
+func bsdlStrings(s string) string {
+	ret := ""
+	for _, part := range regexp.MustCompile(`"([^"]*)"`).FindAllStringSubmatch(s, -1) {
+		ret += part[1]
+	}
+	return ret
+}
+
+func parseBsdl(text string) *Bsdl {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		if c := strings.Index(l, "--"); c >= 0 {
+			lines[i] = l[:c]
+		}
+	}
+	text = strings.Join(lines, "\n")
+
+	b := &Bsdl{Opcodes: map[string][]uint32{}, Linkage: map[string]bool{}}
+	if m := bsdlEntityRe.FindStringSubmatch(text); m != nil {
+		b.Entity = m[1]
+	}
+	if m := bsdlIrLenRe.FindStringSubmatch(text); m != nil {
+		v, _ := strconv.ParseUint(m[1], 10, 32)
+		b.IrLen = uint32(v)
+	}
+	if m := bsdlBoundaryLenRe.FindStringSubmatch(text); m != nil {
+		v, _ := strconv.ParseUint(m[1], 10, 32)
+		b.BoundaryLen = uint32(v)
+	}
+	if m := bsdlOpcodesRe.FindStringSubmatch(text); m != nil {
+		for _, op := range bsdlOpcodeRe.FindAllStringSubmatch(bsdlStrings(m[1]), -1) {
+			name := strings.ToUpper(op[1])
+			for _, bits := range strings.Split(op[2], ",") {
+				// don't care bits are taken as 0
+				bits = strings.Map(func(r rune) rune {
+					if r == 'x' || r == 'X' {
+						return '0'
+					}
+					return r
+				}, strings.TrimSpace(bits))
+				v, err := strconv.ParseUint(bits, 2, 32)
+				if err == nil {
+					b.Opcodes[name] = append(b.Opcodes[name], uint32(v))
+				}
+			}
+		}
+	}
+	if m := bsdlBoundaryRe.FindStringSubmatch(text); m != nil {
+		for _, c := range bsdlCellRe.FindAllStringSubmatch(bsdlStrings(m[1]), -1) {
+			cell := BsdlCell{
+				Cell:     strings.ToUpper(c[2]),
+				Port:     c[3],
+				Function: strings.ToLower(c[4]),
+				Safe:     strings.ToUpper(c[5])[0],
+				Ccell:    -1,
+			}
+			cell.Num, _ = strconv.Atoi(c[1])
+			if c[6] != "" {
+				cell.Ccell, _ = strconv.Atoi(c[6])
+				cell.Disval = c[7][0]
+			}
+			b.Cells = append(b.Cells, cell)
+		}
+	}
+	for _, m := range bsdlLinkageRe.FindAllStringSubmatch(text, -1) {
+		for _, name := range strings.Split(m[1], ",") {
+			// the list may follow "port (" or ";" of the previous one
+			fields := strings.FieldsFunc(name, func(r rune) bool { return r == '(' || r == ' ' || r == '\n' || r == '\t' })
+			if len(fields) != 0 {
+				b.Linkage[fields[len(fields)-1]] = true
+			}
+		}
+	}
+	return b
+}
+
+// returns cell with the given number
+func (b *Bsdl) cell(num int) *BsdlCell {
+	for i := range b.Cells {
+		if b.Cells[i].Num == num {
+			return &b.Cells[i]
+		}
+	}
+	return nil
+}
+
+// returns output cell of the port, nil if port can't be driven
+func (b *Bsdl) outputCell(port string) *BsdlCell {
+	for i, c := range b.Cells {
+		if !strings.EqualFold(c.Port, port) {
+			continue
+		}
+		switch c.Function {
+		case "output2", "output3", "bidir":
+			return &b.Cells[i]
+		}
+	}
+	return nil
+}
+
+// returns input cell of the port, nil if port can't be observed
+func (b *Bsdl) inputCell(port string) *BsdlCell {
+	for i, c := range b.Cells {
+		if !strings.EqualFold(c.Port, port) {
+			continue
+		}
+		switch c.Function {
+		case "input", "bidir", "clock", "observe_only":
+			return &b.Cells[i]
+		}
+	}
+	return nil
+}
+
+func loadBsdl(path string) *Bsdl {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	b := parseBsdl(string(data))
+	if b.IrLen == 0 {
+		panic(fmt.Sprintf("%s: INSTRUCTION_LENGTH not found", path))
+	}
+	return b
+}
+
+// returns the first opcode of any of the given instructions
+func (b *Bsdl) opcode(names ...string) (uint32, bool) {
+	for _, name := range names {
+		if ops, ok := b.Opcodes[name]; ok && len(ops) != 0 {
+			return ops[0], true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Raised by JtagPinDriverToggles when a pin exceeds its budget, recovered
+// by runCommand
+type toggleBudgetExceeded struct {
+	pin JtagPin
+}
+
+// Driver wrapper counting level transitions driven into every pin, for as
+// long as the driver is open, and stopping the command once a pin exceeds
+// the budget, so prototype hardware is not stressed more than agreed.
+type JtagPinDriverToggles struct {
+	drv JtagPinDriver
+	// transitions allowed per pin, 0 for no limit
+	Budget uint64
+
+	Toggles map[JtagPin]uint64
+	last    map[JtagPin]JtagPinState
+}
+
+func (d *JtagPinDriverToggles) initDriver() {
+	d.Toggles = map[JtagPin]uint64{}
+	d.last = map[JtagPin]JtagPinState{}
+	d.drv.initDriver()
+}
+
+func (d *JtagPinDriverToggles) closeDriver() {
+	d.drv.closeDriver()
+}
+
+func (d *JtagPinDriverToggles) pinWrite(pin JtagPin, state JtagPinState) {
+	if last, ok := d.last[pin]; !ok || last != state {
+		d.last[pin] = state
+		d.Toggles[pin] += 1
+		if d.Budget != 0 && d.Toggles[pin] > d.Budget {
+			panic(toggleBudgetExceeded{pin})
+		}
+	}
+	d.drv.pinWrite(pin, state)
+}
+
+func (d *JtagPinDriverToggles) pinRead(pin JtagPin) JtagPinState {
+	return d.drv.pinRead(pin)
+}
+
+func (d *JtagPinDriverToggles) pinOutput(pin JtagPin) {
+	d.drv.pinOutput(pin)
+}
+
+func (d *JtagPinDriverToggles) pinInput(pin JtagPin) {
+	// level driven next is a transition again
+	delete(d.last, pin)
+	d.drv.pinInput(pin)
+}
+
+func (d *JtagPinDriverToggles) pinPullUp(pin JtagPin) {
+	d.drv.pinPullUp(pin)
+}
+
+func (d *JtagPinDriverToggles) pinPullOff(pin JtagPin) {
+	d.drv.pinPullOff(pin)
+}
+
+// Print transitions driven into every pin so far, the busiest first.
+func (J *Jtag) printToggles() {
+	if J.toggles == nil {
+		return
+	}
+	pins := []JtagPin{}
+	for pin := range J.toggles.Toggles {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, j int) bool { return J.toggles.Toggles[pins[i]] > J.toggles.Toggles[pins[j]] })
+	fmt.Fprint(J.out, "  transitions driven per pin:")
+	for _, pin := range pins {
+		fmt.Fprintf(J.out, " %s %d", J.pinName(pin), J.toggles.Toggles[pin])
+	}
+	if J.toggles.Budget != 0 {
+		fmt.Fprintf(J.out, " (budget %d)", J.toggles.Budget)
+	}
+	fmt.Fprintln(J.out)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata of captured data register written at the beginning of a dump
+// file as "# key: value" lines, followed by lines of bits in shift order.
+type CaptureInfo struct {
+	Timestamp time.Time
+	// instruction loaded into IR, bits in shift order
+	Instruction string
+	// position of the device in the chain, 0 is the closest to TDO
+	Position int
+	Bits     int
+}
+
+func (c CaptureInfo) header() string {
+	return fmt.Sprintf("# jtagenum capture\n# timestamp: %s\n# instruction: %s\n# position: %d\n# bits: %d\n",
+		c.Timestamp.Format(time.RFC3339), c.Instruction, c.Position, c.Bits)
+}
+
+// returns metadata and bits of a capture file
+func loadCapture(path string) (CaptureInfo, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	info := CaptureInfo{}
+	bits := strings.Builder{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			bits.WriteString(line)
+			continue
+		}
+		kv := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "#")), ": ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "timestamp":
+			info.Timestamp, _ = time.Parse(time.RFC3339, kv[1])
+		case "instruction":
+			info.Instruction = kv[1]
+		case "position":
+			info.Position, _ = strconv.Atoi(kv[1])
+		case "bits":
+			info.Bits, _ = strconv.Atoi(kv[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+	if bits.Len() != info.Bits {
+		fmt.Printf("warning: %s has %d bits, %d expected\n", path, bits.Len(), info.Bits)
+	}
+	return info, bits.String()
+}
+
+// Compare two capture files bit by bit and print ranges of differing bits.
+// returns true if captures are identical
+func compareCaptures(pathA, pathB string) bool {
+	fmt.Println("================================")
+	fmt.Println("Comparing captures...")
+	defer fmt.Println("================================")
+
+	infoA, a := loadCapture(pathA)
+	infoB, b := loadCapture(pathB)
+	fmt.Printf("a: %s, instruction %s, position %d, %d bits\n",
+		infoA.Timestamp.Format(time.RFC3339), infoA.Instruction, infoA.Position, len(a))
+	fmt.Printf("b: %s, instruction %s, position %d, %d bits\n",
+		infoB.Timestamp.Format(time.RFC3339), infoB.Instruction, infoB.Position, len(b))
+	if infoA.Instruction != infoB.Instruction || infoA.Position != infoB.Position {
+		fmt.Println("warning: captures were taken with different instruction or chain position")
+	}
+
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	diff := 0
+	for i := 0; i < n; i += 1 {
+		if a[i] == b[i] {
+			continue
+		}
+		start := i
+		for i+1 < n && a[i+1] != b[i+1] {
+			i += 1
+		}
+		diff += i - start + 1
+		if start == i {
+			fmt.Printf("bit %d: %c -> %c\n", start, a[start], b[start])
+		} else {
+			fmt.Printf("bits %d-%d: %s -> %s\n", start, i, a[start:i+1], b[start:i+1])
+		}
+	}
+	if len(a) != len(b) {
+		fmt.Printf("lengths differ: %d vs %d bits\n", len(a), len(b))
+	}
+
+	if diff == 0 && len(a) == len(b) {
+		fmt.Println("no differences")
+		return true
+	}
+	fmt.Printf("%d bits differ\n", diff)
+	return false
+}
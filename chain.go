@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Position of the target device in the chain. Devices are numbered from TDO,
+// the same order IDCODEs are read in, device 0 is the closest to TDO. Other
+// devices are kept in BYPASS, which is all 1s for any IR length.
+type ChainPos struct {
+	// IR length of every device in the chain, empty for a single device
+	IrLens []uint32
+	// target device
+	Device int
+}
+
+// Parse comma separated IR lengths of the chain devices, TDO first.
+func parseIrLens(s string) []uint32 {
+	ret := []uint32{}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if len(e) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(e, 10, 32)
+		if err != nil || v < MIN_IR_LEN || v > MAX_IR_LEN {
+			panic(fmt.Sprintf("invalid IR length '%s'", e))
+		}
+		ret = append(ret, uint32(v))
+	}
+	return ret
+}
+
+func ones(n int) []byte {
+	ret := make([]byte, n)
+	for i := range ret {
+		ret[i] = '1'
+	}
+	return ret
+}
+
+// returns number of devices described, 1 if IR lengths are not given
+func (c ChainPos) devices() int {
+	if len(c.IrLens) == 0 {
+		return 1
+	}
+	return len(c.IrLens)
+}
+
+// IR bits shifted in before target opcode: BYPASS for devices between the
+// target and TDO.
+func (c ChainPos) irHeader() []byte {
+	n := uint32(0)
+	for i := 0; i < c.Device && i < len(c.IrLens); i += 1 {
+		n += c.IrLens[i]
+	}
+	return ones(int(n))
+}
+
+// IR bits shifted in after target opcode: BYPASS for devices between TDI and
+// the target.
+func (c ChainPos) irTrailer() []byte {
+	n := uint32(0)
+	for i := c.Device + 1; i < len(c.IrLens); i += 1 {
+		n += c.IrLens[i]
+	}
+	return ones(int(n))
+}
+
+// Bits to shift into IR to load opcode into the target, every other device
+// in BYPASS.
+func (c ChainPos) irScan(opcode, irLen uint32) []byte {
+	ret := c.irHeader()
+	ret = append(ret, irBits(opcode, irLen)...)
+	return append(ret, c.irTrailer()...)
+}
+
+// Number of DR bits shifted in before target data, one per bypassed device
+// between the target and TDO. As many bits shifted out first are to be
+// dropped.
+func (c ChainPos) drHeader() int {
+	return c.Device
+}
+
+// Number of DR bits shifted in after target data, one per bypassed device
+// between TDI and the target.
+func (c ChainPos) drTrailer() int {
+	return c.devices() - 1 - c.Device
+}
+
+// Pad data for the target DR to the whole chain length.
+func (c ChainPos) drScan(data []byte) []byte {
+	ret := make([]byte, c.drHeader(), c.drHeader()+len(data)+c.drTrailer())
+	for i := range ret {
+		ret[i] = '0'
+	}
+	ret = append(ret, data...)
+	for i := 0; i < c.drTrailer(); i += 1 {
+		ret = append(ret, '0')
+	}
+	return ret
+}
+
+// Extract length bits of the target DR from bits shifted out of the chain.
+func (c ChainPos) drExtract(out []byte, length int) []byte {
+	return out[c.drHeader() : c.drHeader()+length]
+}
+
+// Check the position against the chain found on the pins.
+// returns empty string if ok, otherwise the reason
+func (c ChainPos) check(devCnt int, irLen uint32) string {
+	if c.Device < 0 || c.Device >= c.devices() {
+		return fmt.Sprintf("no device %d in chain of %d", c.Device, c.devices())
+	}
+	if devCnt != c.devices() {
+		if len(c.IrLens) == 0 {
+			return "more than one device in chain, give their IR lengths with -chain-ir-lens"
+		}
+		return fmt.Sprintf("%d devices in chain, %d IR lengths given", devCnt, c.devices())
+	}
+	total := uint32(0)
+	for _, l := range c.IrLens {
+		total += l
+	}
+	if len(c.IrLens) != 0 && total != irLen {
+		return fmt.Sprintf("chain IR length %d detected, IR lengths given add up to %d", irLen, total)
+	}
+	return ""
+}
+
+// IR length of the target device.
+// chainIrLen -- IR length of the whole chain, used for a single device
+func (c ChainPos) irLen(chainIrLen uint32) uint32 {
+	if len(c.IrLens) == 0 {
+		return chainIrLen
+	}
+	return c.IrLens[c.Device]
+}
+
+// Load opcode into the target device, every other device in BYPASS.
+// TAP must be in Run-Test-Idle state before being called.
+// Leaves the TAP in the Run-Test-Idle state.
+func (J *Jtag) sendDeviceInstruction(opcode, irLen uint32) {
+	J.sendInstruction(J.CHAIN.irScan(opcode, irLen))
+}
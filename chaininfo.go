@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+)
+
+// What interrogating the chain on the known pins found. It is kept by the
+// Jtag instance, so commands working on a device (and every command of the
+// REPL or of a daemon target) don't repeat the interrogation.
+type ChainInfo struct {
+	// pins the chain was found on, the info is dropped when they change
+	Pins     JtagPins
+	Devices  int
+	IrLength uint32
+	Idcodes  []uint32
+}
+
+// Interrogate the chain on the known pins, unless it was done already.
+// Pins must be initialized. Leaves the TAP in the Run-Test-Idle state.
+// returns chain info, Devices is 0 if no chain was found
+func (J *Jtag) chainInfo() *ChainInfo {
+	if c := J.chainCache; c != nil && c.Pins == J.KnownPins {
+		J.setTapState(TAP_RESET)
+		return c
+	}
+
+	c := &ChainInfo{Pins: J.KnownPins}
+	c.Devices = J.countDevices()
+	if c.Devices == 0 {
+		return c
+	}
+	c.IrLength = J.irChainLength()
+	c.Idcodes = J.readKnownIdcodes()
+	J.chainCache = c
+	return c
+}
+
+// Print devices, IR length and IDCODEs of the chain on the known pins.
+// refresh -- interrogate the chain again even if it was done already
+// returns false if no chain is found
+func (J *Jtag) printChainInfo(refresh bool) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Chain info...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	if refresh {
+		J.chainCache = nil
+	}
+	J.useKnownPins()
+
+	J.initPins()
+
+	cached := J.chainCache != nil && J.chainCache.Pins == J.KnownPins
+	c := J.chainInfo()
+	if c.Devices == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return false
+	}
+	if cached {
+		fmt.Fprintln(J.out, "cached, run 'chain_refresh' to interrogate the chain again")
+	}
+	J.results.Idcodes = c.Idcodes
+	J.results.IrLength = c.IrLength
+
+	fmt.Fprintf(J.out, "devices: %d, chain IR length: %d\n", c.Devices, c.IrLength)
+	for i, idcode := range c.Idcodes {
+		fmt.Fprintf(J.out, "device %d: %s\n", i, describeChainIdcode(idcode))
+	}
+	return true
+}
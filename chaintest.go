@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Segment of the IR test pattern for device i: its number plus one, so
+// swapped or dropped segments show.
+func chainTestSegment(i int, irLen uint32) []byte {
+	return irBits(uint32(i)+1, irLen)
+}
+
+// Name the link a failure of device i points to, devices are numbered from
+// TDO. Captured bits of device i pass through devices closer to TDO, which
+// still respond, so the link out of device i is the suspect.
+func chainTestLink(i int) string {
+	if i == 0 {
+		return "check link from TDO of device 0 to the TDO pin"
+	}
+	return fmt.Sprintf("check link from TDO of device %d to TDI of device %d", i, i-1)
+}
+
+// Verify every device of the chain on the known pins responds in its
+// position: IR of each device must capture 01 in its lowest bits where its
+// IR lengths place it, a pattern made of per-device segments must come out
+// of the IRs intact after the whole chain IR length, and a pattern must come
+// out of the BYPASS chain delayed by one bit per device. Devices closest to
+// TDO keep responding when a link further up the chain is broken, so the
+// first failing device locates the break. All devices are left in BYPASS.
+// returns true if the chain is intact
+func (J *Jtag) testChain(pattern string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting chain integrity test...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	J.useKnownPins()
+
+	J.initPins()
+	defer J.setTapState(TAP_RESET)
+
+	info := J.chainInfo()
+	if info.Devices == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return false
+	}
+	if len(J.CHAIN.IrLens) != 0 {
+		if reason := J.CHAIN.check(info.Devices, info.IrLength); reason != "" {
+			fmt.Fprintln(J.out, reason)
+			return false
+		}
+	} else if info.Devices != 1 {
+		fmt.Fprintln(J.out, "more than one device in chain, give their IR lengths with -chain-ir-lens")
+		return false
+	}
+	irLens := J.CHAIN.IrLens
+	if len(irLens) == 0 {
+		irLens = []uint32{info.IrLength}
+	}
+	total := 0
+	for _, l := range irLens {
+		total += int(l)
+	}
+	fmt.Fprintf(J.out, "devices: %d, chain IR length: %d\n", info.Devices, total)
+
+	// IR: segments shifted in come out after the captured values, the IRs
+	// end up all 1s (BYPASS)
+	segments := []byte{}
+	for i := range irLens {
+		segments = append(segments, chainTestSegment(i, irLens[i])...)
+	}
+	J.setTapState(TAP_RESET)
+	out := J.sendInstruction(append(append([]byte{}, segments...), ones(total)...))
+
+	ok := true
+	off := 0
+	for i, l := range irLens {
+		capture := string(out[off : off+int(l)])
+		if capture[:2] == "10" {
+			fmt.Fprintf(J.out, "device %d: IR captured %s, ok\n", i, J.formatBits(capture))
+		} else if ok {
+			// devices further from TDO fail as well, the first one
+			// locates the break
+			fmt.Fprintf(J.out, "device %d: IR captured %s, expected 01 in lowest bits; %s\n",
+				i, J.formatBits(capture), chainTestLink(i))
+			ok = false
+		} else {
+			fmt.Fprintf(J.out, "device %d: IR captured %s\n", i, J.formatBits(capture))
+		}
+		off += int(l)
+	}
+
+	recv := string(out[total:])
+	fmt.Fprintf(J.out, "IR pattern sent: %s\n", J.formatBits(string(segments)))
+	fmt.Fprintf(J.out, "IR pattern recv: %s\n", J.formatBits(recv))
+	if recv != string(segments) {
+		fmt.Fprintf(J.out, "IR pattern didn't come out after %d bits, IR lengths are wrong or link to TDI of device %d is broken\n",
+			total, len(irLens)-1)
+		ok = false
+	}
+
+	// DR: every device in BYPASS adds one bit of delay
+	bits := []byte(pattern)
+	for i := 0; i < info.Devices; i += 1 {
+		bits = append(bits, '0')
+	}
+	drRecv := string(J.sendData(bits)[info.Devices:])
+	fmt.Fprintf(J.out, "BYPASS pattern sent: %s\n", J.formatBits(pattern))
+	fmt.Fprintf(J.out, "BYPASS pattern recv: %s\n", J.formatBits(drRecv))
+	if drRecv != pattern {
+		fmt.Fprintf(J.out, "BYPASS pattern didn't come out after %d bits\n", info.Devices)
+		ok = false
+	}
+
+	if ok {
+		fmt.Fprintln(J.out, "chain intact")
+	} else {
+		fmt.Fprintln(J.out, "chain broken")
+	}
+	return ok
+}
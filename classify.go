@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DRs at least that long which keep what was shifted in are taken for data
+// ports (memory, debug or programming access)
+const DATA_PORT_MIN_LEN = 64
+
+// Convert bits in shift order (LSB first) to integer.
+func bitsToUint32(bits string) uint32 {
+	ret := uint32(0)
+	for i := len(bits) - 1; i >= 0; i -= 1 {
+		ret <<= 1
+		if bits[i] == '1' {
+			ret |= 1
+		}
+	}
+	return ret
+}
+
+// Guess boundary register length as the longest DR selected by several
+// opcodes, SAMPLE, PRELOAD and EXTEST all select it.
+// returns 0 if there is no such DR
+func guessBoundaryLen(opcodes []OpcodeResult) uint32 {
+	cnt := map[uint32]int{}
+	for _, o := range opcodes {
+		cnt[o.DrLength] += 1
+	}
+	ret := uint32(0)
+	for drLen, n := range cnt {
+		if n >= 2 && drLen > 32 && drLen > ret {
+			ret = drLen
+		}
+	}
+	return ret
+}
+
+// Tag likely role of the instruction.
+// boundaryLen -- boundary register length, 0 if unknown
+// writable -- DR captured what was shifted into it before
+func classifyOpcode(o OpcodeResult, boundaryLen uint32, writable bool) []string {
+	tags := []string{}
+	if o.DrLength == 32 {
+		idcode := bitsToUint32(o.Capture)
+		if isValidIdcode(idcode) && isKnownManufacturer(idcode) {
+			tags = append(tags, "IDCODE")
+		}
+	}
+	if boundaryLen != 0 && o.DrLength == boundaryLen {
+		tags = append(tags, "SAMPLE/EXTEST")
+	} else if writable && o.DrLength >= DATA_PORT_MIN_LEN {
+		tags = append(tags, "data port")
+	}
+	if !writable && len(tags) == 0 {
+		tags = append(tags, "status")
+	}
+	return tags
+}
+
+// Write a pattern into the DR selected by opcode and read it back on the
+// next Capture-DR, then the same with the pattern inverted, and write the
+// captured value back.
+// returns read-write if the DR kept both patterns, capture-only if it
+// captured the same value every time whatever was written, volatile if the
+// captured value changes on its own
+func (J *Jtag) probeDrAccess(opcode, drLen uint32) string {
+	pattern := []byte{}
+	inverse := []byte{}
+	for i := uint32(0); i < drLen; i += 1 {
+		pattern = append(pattern, "10"[i%2])
+		inverse = append(inverse, "01"[i%2])
+	}
+	J.setTapState(TAP_RESET)
+	J.sendInstruction(irBits(opcode, J.irLength()))
+	first := J.sendData(pattern)
+	second := J.sendData(inverse)
+	third := J.sendData(first)
+	J.setTapState(TAP_RESET)
+
+	switch {
+	case string(second) == string(pattern) && string(third) == string(inverse):
+		return "read-write"
+	case string(second) == string(first) && string(third) == string(first):
+		return "capture-only"
+	}
+	return "volatile"
+}
+
+// Tag instructions found by discover_opcode and print them as a table.
+// Every DR is read once more: if it captures what the first read left in
+// it, it is writable. With probeAccess patterns are written into every DR
+// and read back instead, telling data and control registers from status
+// ones for sure, at the price of writing into registers of unknown
+// function.
+// Leaves the TAP in the Run-Test-Idle state.
+func (J *Jtag) classifyOpcodes(bsdl *Bsdl, probeAccess bool) {
+	boundaryLen := guessBoundaryLen(J.results.Opcodes)
+	if bsdl != nil && bsdl.BoundaryLen != 0 {
+		boundaryLen = bsdl.BoundaryLen
+	}
+
+	fmt.Fprintln(J.out, "Likely roles:")
+	for i, o := range J.results.Opcodes {
+		access := ""
+		writable := false
+		if probeAccess {
+			access = J.probeDrAccess(o.Opcode, o.DrLength)
+			J.results.Opcodes[i].Access = access
+			writable = access == "read-write"
+			access = " (" + access + ")"
+		} else {
+			_, capture := J.detectDr(o.Opcode)
+			writable = capture != o.Capture
+		}
+		J.results.Opcodes[i].Tags = classifyOpcode(o, boundaryLen, writable)
+		fmt.Fprintf(J.out, "%s capture: %s %s%s\n", describeIrDr(J.results.IrLength, o.Opcode, o.DrLength),
+			J.bitsToHex(o.Capture), strings.Join(J.results.Opcodes[i].Tags, ", "), access)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Commands accepted by -command
+var commandNames = []string{
+	"check_loopback", "scan_bypass", "test_bypass", "scan_idcode", "scan_sbw", "scan_swd", "scan_uart", "scan_protocols", "test_idcode", "test_chain",
+	"boundary_scan", "watch_sample", "label_nets", "discover_opcode", "check_speed", "soak_idcode", "extest", "blink_device", "highz", "clamp",
+	"spi_read", "spi_erase", "spi_program", "i2c_scan", "i2c_read", "isc_read", "isc_erase", "isc_program", "chain_info", "chain_refresh", "ir_chain_length",
+	"repl", "dump", "gdb_server", "batch", "diff", "compare_capture", "init", "replay",
+}
+
+func isCommand(name string) bool {
+	for _, c := range commandNames {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept the command as words before the flags, "scan bypass -pins ..."
+// is the same as "-command scan_bypass -pins ...". Arguments not starting
+// with a command are returned unchanged.
+func subcommandArgs(args []string) []string {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return args
+	}
+	if len(args) > 1 && isCommand(args[0]+"_"+args[1]) {
+		return append([]string{"-command", args[0] + "_" + args[1]}, args[2:]...)
+	}
+	if isCommand(args[0]) {
+		return append([]string{"-command", args[0]}, args[1:]...)
+	}
+	return args
+}
+
+// returns words completing the command at position 0 and, for words
+// starting several commands, at position 1
+func commandWords() ([]string, map[string][]string) {
+	first := []string{}
+	second := map[string][]string{}
+	for _, c := range commandNames {
+		words := strings.SplitN(c, "_", 2)
+		if _, ok := second[words[0]]; !ok {
+			first = append(first, words[0])
+			second[words[0]] = []string{}
+		}
+		if len(words) == 2 {
+			second[words[0]] = append(second[words[0]], words[1])
+		}
+	}
+	sort.Strings(first)
+	return first, second
+}
+
+// Print completion script for shell (bash or zsh) completing command
+// words and flags.
+// returns false if the shell is not supported
+func printCompletion(out io.Writer, shell string) bool {
+	if shell != "bash" && shell != "zsh" {
+		return false
+	}
+
+	flags := []string{}
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, "-"+f.Name) })
+	first, second := commandWords()
+
+	if shell == "zsh" {
+		fmt.Fprintln(out, "autoload -U +X bashcompinit && bashcompinit")
+	}
+	fmt.Fprintln(out, "_go_jtagenum() {")
+	fmt.Fprintln(out, `	local cur="${COMP_WORDS[COMP_CWORD]}" words=""`)
+	fmt.Fprintln(out, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintf(out, "\t\twords=%q\n", strings.Join(flags, " "))
+	fmt.Fprintln(out, `	elif [[ $COMP_CWORD -eq 1 ]]; then`)
+	fmt.Fprintf(out, "\t\twords=%q\n", strings.Join(append(first, "completion"), " "))
+	fmt.Fprintln(out, `	elif [[ $COMP_CWORD -eq 2 ]]; then`)
+	fmt.Fprintln(out, `		case "${COMP_WORDS[1]}" in`)
+	for _, w := range first {
+		if len(second[w]) != 0 {
+			fmt.Fprintf(out, "\t\t%s) words=%q ;;\n", w, strings.Join(second[w], " "))
+		}
+	}
+	fmt.Fprintln(out, `		completion) words="bash zsh" ;;`)
+	fmt.Fprintln(out, `		esac`)
+	fmt.Fprintln(out, `	fi`)
+	fmt.Fprintln(out, `	COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(out, "}")
+	fmt.Fprintln(out, "complete -o default -F _go_jtagenum go-jtagenum")
+	return true
+}
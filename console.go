@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Console lines telling the target rebooted or crashed
+var consoleEventRes = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"panic", regexp.MustCompile(`(?i)(kernel panic|oops|unable to handle|hard ?fault|data abort|prefetch abort|undefined instruction|watchdog (reset|timeout)|exception)`)},
+	{"reboot", regexp.MustCompile(`(?i)(u-boot \d|booting linux|starting kernel|linux version \d|bootrom|hit any key to stop autoboot|reset cause)`)},
+}
+
+// Target console line telling it rebooted or crashed
+type ConsoleEvent struct {
+	Time time.Time `json:"time"`
+	// reboot or panic
+	Kind string `json:"kind"`
+	Line string `json:"line"`
+	// command and pins being tested when the line came
+	During string `json:"during"`
+}
+
+// Target console read in the background while commands run
+type consoleMonitor struct {
+	in io.ReadCloser
+
+	mu      sync.Mutex
+	during  string
+	pending []ConsoleEvent
+}
+
+func (c *consoleMonitor) run() {
+	scanner := bufio.NewScanner(c.in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for _, e := range consoleEventRes {
+			if !e.re.MatchString(line) {
+				continue
+			}
+			c.mu.Lock()
+			c.pending = append(c.pending, ConsoleEvent{Time: time.Now(), Kind: e.kind, Line: line, During: c.during})
+			c.mu.Unlock()
+			break
+		}
+	}
+}
+
+// Start monitoring target console at path, a serial device, in the
+// background.
+// returns description of the problem, empty if ok
+func (J *Jtag) startConsole(path string, baud uint) string {
+	in, reason := openSerial(path, baud, false)
+	if reason != "" {
+		return reason
+	}
+	J.console = &consoleMonitor{in: in}
+	go J.console.run()
+	return ""
+}
+
+// Report console events which came since the last call, attributing them to
+// what was being tested, then note what is tested from now on. Called by
+// commands and between permutations, output is only written from here.
+// during -- command and pins tested from now on
+// returns events reported
+func (J *Jtag) consoleTesting(during string) []ConsoleEvent {
+	c := J.console
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.during = during
+	c.mu.Unlock()
+
+	for _, e := range pending {
+		fmt.Fprintf(J.out, "console: target %s while testing %s: %s\n", e.Kind, e.During, e.Line)
+		J.results.Console = append(J.results.Console, e)
+	}
+	return pending
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job submitted to the daemon
+type JobRequest struct {
+	// jobs for the same target share pins and results of previous jobs
+	Target  string `json:"target"`
+	Command string `json:"command"`
+	// pins to scan, kept for the target once given
+	Pins map[string]JtagPin `json:"pins,omitempty"`
+	// pins assignment, kept for the target once given or found by a scan
+	KnownPins *JtagPins `json:"known_pins,omitempty"`
+	// expected IDCODEs as for -expect, used by 'test_idcode' command
+	ExpectedIdcodes []string `json:"expected_idcodes,omitempty"`
+}
+
+type Job struct {
+	ID       int         `json:"id"`
+	Request  JobRequest  `json:"request"`
+	State    string      `json:"state"`
+	Passed   bool        `json:"passed"`
+	Error    string      `json:"error,omitempty"`
+	Output   string      `json:"output"`
+	Results  *ScanResult `json:"results,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  time.Time   `json:"started,omitempty"`
+	Finished time.Time   `json:"finished,omitempty"`
+}
+
+// Daemon holding the driver open and running queued jobs one at a time, so
+// clients don't pay driver init costs or collide on pins.
+type Daemon struct {
+	base Jtag
+	opt  CommandOptions
+
+	mu      sync.Mutex
+	jobs    []*Job
+	queue   chan *Job
+	targets map[string]*Jtag
+	metrics *Metrics
+}
+
+// base -- Jtag instance with the driver already opened, its settings are
+// used for every target
+func NewDaemon(base Jtag, opt CommandOptions) *Daemon {
+	// measure driver latency for metrics, shared by all targets
+	latency := &JtagPinDriverLatency{drv: base.drv}
+	base.trace = &JtagPinDriverTrace{drv: latency}
+	base.drv = base.trace
+	return &Daemon{
+		base:    base,
+		opt:     opt,
+		queue:   make(chan *Job, 1000),
+		targets: make(map[string]*Jtag),
+		metrics: NewMetrics(latency),
+	}
+}
+
+func (d *Daemon) target(name string) *Jtag {
+	J, ok := d.targets[name]
+	if !ok {
+		t := d.base
+		t.PinNames = make(map[JtagPin]string, 0)
+		t.AllPins = nil
+		t.KnownPins = JtagPins{d.base.IGNOREPIN, d.base.IGNOREPIN, d.base.IGNOREPIN, d.base.IGNOREPIN, d.base.IGNOREPIN}
+		J = &t
+		d.targets[name] = J
+	}
+	return J
+}
+
+// Take pins of the first fully confirmed pinout found by a scan as the known
+// pins of the target.
+func (J *Jtag) learnKnownPins() {
+	byName := J.pinsByName()
+	for _, p := range J.results.Pinouts {
+		if p.TDI == "" {
+			continue
+		}
+		J.KnownPins = JtagPins{
+			TDI:  byName[p.TDI],
+			TDO:  byName[p.TDO],
+			TCK:  byName[p.TCK],
+			TMS:  byName[p.TMS],
+			TRST: J.IGNOREPIN,
+		}
+		if len(p.PossibleTRST) != 0 {
+			J.KnownPins.TRST = byName[p.PossibleTRST[0]]
+		}
+		return
+	}
+}
+
+func (d *Daemon) run(job *Job) {
+	d.mu.Lock()
+	J := d.target(job.Request.Target)
+	job.State = "running"
+	job.Started = time.Now()
+	d.mu.Unlock()
+
+	out := &bytes.Buffer{}
+	J.out = out
+	J.results = ScanResult{}
+	J.stats = ScanStats{}
+	if len(job.Request.Pins) != 0 {
+		J.setPins(job.Request.Pins)
+	}
+	if job.Request.KnownPins != nil {
+		J.KnownPins = *job.Request.KnownPins
+	}
+
+	o := d.opt
+	if len(job.Request.ExpectedIdcodes) != 0 {
+		o.Expected = []IdcodeMatch{}
+		for _, e := range job.Request.ExpectedIdcodes {
+			o.Expected = append(o.Expected, parseIdcodeMatch(e, o.AnyVersion))
+		}
+	}
+
+	passed := false
+	errStr := ""
+	func() {
+		// driver errors are reported as panics, keep serving other jobs
+		defer func() {
+			if r := recover(); r != nil {
+				errStr = fmt.Sprint(r)
+			}
+		}()
+		passed = J.runCommand(job.Request.Command, o)
+		J.learnKnownPins()
+	}()
+
+	d.mu.Lock()
+	job.State = "done"
+	job.Finished = time.Now()
+	job.Passed = passed && errStr == ""
+	job.Error = errStr
+	job.Output = out.String()
+	results := J.results
+	job.Results = &results
+	d.mu.Unlock()
+
+	d.metrics.observeJob(job, J.stats)
+}
+
+func (d *Daemon) worker() {
+	for job := range d.queue {
+		d.run(job)
+	}
+}
+
+func (d *Daemon) submit(req JobRequest) (*Job, string) {
+	switch req.Command {
+	case "batch", "repl", "gdb_server", "init", "label_nets", "diff", "compare_capture", "replay", "":
+		return nil, fmt.Sprintf("command '%s' can't be queued", req.Command)
+	}
+	if req.Target == "" {
+		req.Target = "default"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job := &Job{
+		ID:      len(d.jobs) + 1,
+		Request: req,
+		State:   "queued",
+		Created: time.Now(),
+	}
+	select {
+	case d.queue <- job:
+	default:
+		return nil, "queue is full"
+	}
+	d.jobs = append(d.jobs, job)
+	return job, ""
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// POST /jobs -- queue a job, GET /jobs -- list jobs, GET /jobs/<id> -- job
+// status, output and results
+func (d *Daemon) handleJobs(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+
+	if idStr == "" && r.Method == http.MethodPost {
+		req := JobRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		job, errStr := d.submit(req)
+		if job == nil {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": errStr})
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		writeJson(w, http.StatusAccepted, job)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if idStr == "" {
+		writeJson(w, http.StatusOK, d.jobs)
+		return
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 1 || id > len(d.jobs) {
+		writeJson(w, http.StatusNotFound, map[string]string{"error": "no such job"})
+		return
+	}
+	writeJson(w, http.StatusOK, d.jobs[id-1])
+}
+
+func (d *Daemon) serve(addr string) error {
+	go d.worker()
+	go d.base.trace.broadcast()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", d.handleJobs)
+	mux.HandleFunc("/jobs/", d.handleJobs)
+	mux.HandleFunc("/metrics", d.metrics.handle)
+	mux.HandleFunc("/live", d.base.trace.handle)
+	mux.HandleFunc("/", serveLivePage)
+	fmt.Printf("serving jobs on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
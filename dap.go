@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ARM ADIv5 JTAG-DP instructions
+const (
+	ARM_IR_LEN    = 4
+	ARM_IR_ABORT  = 0x8
+	ARM_IR_DPACC  = 0xA
+	ARM_IR_APACC  = 0xB
+	ARM_IR_IDCODE = 0xE
+)
+
+// Manufacturer field of ARM DP IDCODEs (JEP106 bank 4, 0x3b)
+const ARM_MANUFACTURER = 0x23b
+
+// DP registers
+const (
+	DP_CTRL_STAT = 0x4
+	DP_SELECT    = 0x8
+	DP_RDBUFF    = 0xc
+
+	DP_CSYSPWRUPREQ = 1 << 30
+	DP_CSYSPWRUPACK = 1 << 31
+	DP_CDBGPWRUPREQ = 1 << 28
+	DP_CDBGPWRUPACK = 1 << 29
+	DP_STICKYERR    = 1 << 5
+)
+
+// MEM-AP registers
+const (
+	AP_CSW = 0x00
+	AP_TAR = 0x04
+	AP_DRW = 0x0c
+	AP_IDR = 0xfc
+
+	// 32-bit access, no address increment, privileged data access
+	AP_CSW_WORD = 0x23000002
+)
+
+// 3-bit ACK of DPACC/APACC scans
+const (
+	DAP_ACK_WAIT     = 0x1
+	DAP_ACK_OK       = 0x2
+	DAP_WAIT_RETRIES = 100
+)
+
+// Cortex-M debug registers, reached through the MEM-AP
+const (
+	CM_DHCSR = 0xe000edf0
+	CM_DCRSR = 0xe000edf4
+	CM_DCRDR = 0xe000edf8
+
+	CM_DBGKEY    = 0xa05f0000
+	CM_C_DEBUGEN = 1 << 0
+	CM_C_HALT    = 1 << 1
+	CM_C_STEP    = 1 << 2
+	CM_REGWNR    = 1 << 16
+	CM_S_REGRDY  = 1 << 16
+	CM_S_HALT    = 1 << 17
+)
+
+var cortexMRegs = []string{
+	"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "r10", "r11", "r12",
+	"sp", "lr", "pc", "xpsr",
+}
+
+// IDCODE is the one of ARM debug port.
+func isArmDp(idcode uint32) bool {
+	return isValidIdcode(idcode) && (idcode&0xffe)>>1 == ARM_MANUFACTURER
+}
+
+// ARM debug port of the target device, accessing memory through MEM-AP
+type Dap struct {
+	J *Jtag
+	// MEM-AP to use
+	Ap uint32
+
+	// instruction loaded, 0 if not known
+	ir uint32
+	// value written to DP SELECT, to skip writing it again
+	selected    uint32
+	selectValid bool
+}
+
+func (J *Jtag) newDap(ap uint32) *Dap {
+	return &Dap{J: J, Ap: ap}
+}
+
+// Shift a DPACC/APACC request through the 35-bit DR.
+// TAP must be in Run-Test-Idle state before being called.
+// returns ACK and data read by the previous request
+func (d *Dap) scan(ir, addr uint32, read bool, data uint32) (uint32, uint32) {
+	if d.ir != ir {
+		d.J.sendDeviceInstruction(ir, ARM_IR_LEN)
+		d.ir = ir
+	}
+	in := make([]byte, 35)
+	in[0] = '0'
+	if read {
+		in[0] = '1'
+	}
+	for i := 0; i < 34; i += 1 {
+		v := uint32(0)
+		if i < 2 {
+			v = (addr >> uint(2+i)) & 1
+		} else {
+			v = (data >> uint(i-2)) & 1
+		}
+		in[i+1] = '0' + byte(v)
+	}
+	out := d.J.CHAIN.drExtract(d.J.sendData(d.J.CHAIN.drScan(in)), len(in))
+	ack := uint32(0)
+	value := uint32(0)
+	for i, b := range out {
+		if b != '1' {
+			continue
+		}
+		if i < 3 {
+			ack |= 1 << uint(i)
+		} else {
+			value |= 1 << uint(i-3)
+		}
+	}
+	return ack, value
+}
+
+// Issue a request, repeating it while the DP answers WAIT.
+// returns data read by the previous request and empty string, or the problem
+func (d *Dap) transfer(ir, addr uint32, read bool, data uint32) (uint32, string) {
+	for retry := 0; retry < DAP_WAIT_RETRIES; retry += 1 {
+		ack, value := d.scan(ir, addr, read, data)
+		switch ack {
+		case DAP_ACK_OK:
+			return value, ""
+		case DAP_ACK_WAIT:
+			continue
+		default:
+			// ABORT instruction clears the stuck transaction
+			d.J.sendDeviceInstruction(ARM_IR_ABORT, ARM_IR_LEN)
+			d.ir = 0
+			return 0, fmt.Sprintf("DAP transfer failed, ACK 0x%x", ack)
+		}
+	}
+	return 0, "DAP keeps answering WAIT"
+}
+
+func (d *Dap) readDp(addr uint32) (uint32, string) {
+	if _, err := d.transfer(ARM_IR_DPACC, addr, true, 0); err != "" {
+		return 0, err
+	}
+	return d.transfer(ARM_IR_DPACC, DP_RDBUFF, true, 0)
+}
+
+func (d *Dap) writeDp(addr, value uint32) string {
+	_, err := d.transfer(ARM_IR_DPACC, addr, false, value)
+	return err
+}
+
+// Select AP and register bank of addr.
+func (d *Dap) selectAp(addr uint32) string {
+	sel := d.Ap<<24 | addr&0xf0
+	if d.selectValid && d.selected == sel {
+		return ""
+	}
+	if err := d.writeDp(DP_SELECT, sel); err != "" {
+		return err
+	}
+	d.selected = sel
+	d.selectValid = true
+	return ""
+}
+
+func (d *Dap) readAp(addr uint32) (uint32, string) {
+	if err := d.selectAp(addr); err != "" {
+		return 0, err
+	}
+	if _, err := d.transfer(ARM_IR_APACC, addr&0xc, true, 0); err != "" {
+		return 0, err
+	}
+	return d.transfer(ARM_IR_DPACC, DP_RDBUFF, true, 0)
+}
+
+func (d *Dap) writeAp(addr, value uint32) string {
+	if err := d.selectAp(addr); err != "" {
+		return err
+	}
+	_, err := d.transfer(ARM_IR_APACC, addr&0xc, false, value)
+	return err
+}
+
+// Power up debug and system domains, clearing sticky errors.
+// returns description of the problem, empty if ok
+func (d *Dap) powerUp() string {
+	d.J.setTapState(TAP_RESET)
+	d.ir = 0
+	d.selectValid = false
+	if err := d.writeDp(DP_CTRL_STAT, DP_CSYSPWRUPREQ|DP_CDBGPWRUPREQ|DP_STICKYERR); err != "" {
+		return err
+	}
+	for retry := 0; retry < DAP_WAIT_RETRIES; retry += 1 {
+		v, err := d.readDp(DP_CTRL_STAT)
+		if err != "" {
+			return err
+		}
+		if v&(DP_CSYSPWRUPACK|DP_CDBGPWRUPACK) == DP_CSYSPWRUPACK|DP_CDBGPWRUPACK {
+			return ""
+		}
+	}
+	return "debug power-up is not acknowledged"
+}
+
+// returns 32-bit word at addr and empty string, or the problem
+func (d *Dap) readMem(addr uint32) (uint32, string) {
+	if err := d.writeAp(AP_CSW, AP_CSW_WORD); err != "" {
+		return 0, err
+	}
+	if err := d.writeAp(AP_TAR, addr); err != "" {
+		return 0, err
+	}
+	return d.readAp(AP_DRW)
+}
+
+// returns description of the problem, empty if ok
+func (d *Dap) writeMem(addr, value uint32) string {
+	if err := d.writeAp(AP_CSW, AP_CSW_WORD); err != "" {
+		return err
+	}
+	if err := d.writeAp(AP_TAR, addr); err != "" {
+		return err
+	}
+	return d.writeAp(AP_DRW, value)
+}
+
+// Halt Cortex-M core, registers can be read only while halted.
+// returns description of the problem, empty if ok
+func (d *Dap) halt() string {
+	if err := d.writeMem(CM_DHCSR, CM_DBGKEY|CM_C_DEBUGEN|CM_C_HALT); err != "" {
+		return err
+	}
+	for retry := 0; retry < DAP_WAIT_RETRIES; retry += 1 {
+		v, err := d.readMem(CM_DHCSR)
+		if err != "" {
+			return err
+		}
+		if v&CM_S_HALT != 0 {
+			return ""
+		}
+	}
+	return "core does not halt"
+}
+
+// returns description of the problem, empty if ok
+func (d *Dap) resume() string {
+	return d.writeMem(CM_DHCSR, CM_DBGKEY|CM_C_DEBUGEN)
+}
+
+// Read Cortex-M core register through DCRSR/DCRDR, core must be halted.
+// returns register value and empty string, or the problem
+func (d *Dap) readReg(n uint32) (uint32, string) {
+	if err := d.writeMem(CM_DCRSR, n); err != "" {
+		return 0, err
+	}
+	for retry := 0; retry < DAP_WAIT_RETRIES; retry += 1 {
+		v, err := d.readMem(CM_DHCSR)
+		if err != "" {
+			return 0, err
+		}
+		if v&CM_S_REGRDY != 0 {
+			return d.readMem(CM_DCRDR)
+		}
+	}
+	return 0, fmt.Sprintf("register %d is not ready", n)
+}
+
+// Write Cortex-M core register through DCRDR/DCRSR, core must be halted.
+// returns description of the problem, empty if ok
+func (d *Dap) writeReg(n, value uint32) string {
+	if err := d.writeMem(CM_DCRDR, value); err != "" {
+		return err
+	}
+	if err := d.writeMem(CM_DCRSR, n|CM_REGWNR); err != "" {
+		return err
+	}
+	for retry := 0; retry < DAP_WAIT_RETRIES; retry += 1 {
+		v, err := d.readMem(CM_DHCSR)
+		if err != "" {
+			return err
+		}
+		if v&CM_S_REGRDY != 0 {
+			return ""
+		}
+	}
+	return fmt.Sprintf("register %d is not ready", n)
+}
+
+// Execute a single instruction of the halted Cortex-M core.
+// returns description of the problem, empty if ok
+func (d *Dap) step() string {
+	if err := d.writeMem(CM_DHCSR, CM_DBGKEY|CM_C_DEBUGEN|CM_C_STEP); err != "" {
+		return err
+	}
+	for retry := 0; retry < DAP_WAIT_RETRIES; retry += 1 {
+		v, err := d.readMem(CM_DHCSR)
+		if err != "" {
+			return err
+		}
+		if v&CM_S_HALT != 0 {
+			return ""
+		}
+	}
+	return "core does not halt after step"
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Permutation being tested by a scan, to tell whether it was ruled out
+type permutationTrack struct {
+	key string
+	// pinouts recorded and timing gaps seen when it started
+	pinouts int
+	gaps    uint64
+	// the target answered something which is not a pinout
+	inconclusive bool
+}
+
+// returns pin names of the permutation, in role order, as result files
+// list ruled out permutations
+func (J *Jtag) permutationKey(p []JtagPin) string {
+	names := []string{}
+	for _, pin := range p {
+		names = append(names, J.pinName(pin))
+	}
+	return strings.Join(names, " ")
+}
+
+// Settings a scan ran with which decide what it can find, permutations it
+// ruled out are reused only by runs with the same ones
+type ScanSettings struct {
+	Driver     string `json:"driver"`
+	DelayTck   uint   `json:"delay_tck"`
+	Pullup     bool   `json:"pullup"`
+	Pattern    string `json:"pattern,omitempty"`
+	MaxDevices int    `json:"max_devices"`
+	// GPIO of every pin name given with -pins
+	Pins map[string]JtagPin `json:"pins"`
+}
+
+// returns settings of the command about to run
+func (J *Jtag) scanSettings(pattern string) *ScanSettings {
+	s := &ScanSettings{
+		Driver:     J.drvOpt.Name,
+		DelayTck:   J.DELAY_TCK,
+		Pullup:     J.PULLUP,
+		Pattern:    pattern,
+		MaxDevices: J.MAX_DEVICES,
+		Pins:       map[string]JtagPin{},
+	}
+	for pin, name := range J.PinNames {
+		s.Pins[name] = pin
+	}
+	return s
+}
+
+// returns settings which differ, empty if none do
+func (s *ScanSettings) differences(o *ScanSettings) []string {
+	ret := []string{}
+	if s.Driver != o.Driver {
+		ret = append(ret, fmt.Sprintf("driver %s", o.Driver))
+	}
+	if s.DelayTck != o.DelayTck {
+		ret = append(ret, fmt.Sprintf("delay-tck %d", o.DelayTck))
+	}
+	if s.Pullup != o.Pullup {
+		ret = append(ret, fmt.Sprintf("pullup %v", o.Pullup))
+	}
+	if s.Pattern != o.Pattern {
+		ret = append(ret, fmt.Sprintf("pattern %s", o.Pattern))
+	}
+	if s.MaxDevices != o.MaxDevices {
+		ret = append(ret, fmt.Sprintf("max-devices %d", o.MaxDevices))
+	}
+	// pins may be added or dropped, but a name must stay on its GPIO
+	names := map[JtagPin]string{}
+	for name, pin := range s.Pins {
+		names[pin] = name
+	}
+	moved := []string{}
+	for name, pin := range o.Pins {
+		if p, ok := s.Pins[name]; ok && p != pin {
+			moved = append(moved, fmt.Sprintf("pin %s on %d", name, pin))
+		} else if n, ok := names[pin]; ok && n != name {
+			moved = append(moved, fmt.Sprintf("pin %s on %d", name, pin))
+		}
+	}
+	sort.Strings(moved)
+	ret = append(ret, moved...)
+	return ret
+}
+
+// Load permutations ruled out by the same command in PREVIOUS result files
+// scanned with the same settings: a permutation ruled out at one TCK delay,
+// pull, pattern or pin map may well work with another one.
+func (J *Jtag) loadRuledOut() map[string]bool {
+	ret := map[string]bool{}
+	for _, path := range strings.Split(J.PREVIOUS, ",") {
+		if path = strings.TrimSpace(path); len(path) == 0 {
+			continue
+		}
+		r := loadResults(path)
+		if r.Command != J.results.Command {
+			continue
+		}
+		if r.Settings == nil {
+			fmt.Fprintf(J.out, "%s: no scan settings recorded, not skipping its ruled out permutations\n", path)
+			continue
+		}
+		if diff := J.results.Settings.differences(r.Settings); len(diff) != 0 {
+			fmt.Fprintf(J.out, "%s: scanned with %s, not skipping its ruled out permutations\n", path, strings.Join(diff, ", "))
+			continue
+		}
+		for _, key := range r.RuledOut {
+			ret[key] = true
+		}
+	}
+	return ret
+}
+
+// Drop permutations ruled out by previous runs, unless FORCE. They are
+// recorded as ruled out in this run's results as well, so each run's
+// result file is enough for the next one.
+func (J *Jtag) skipRuledOut(perms [][]JtagPin) [][]JtagPin {
+	if len(J.PREVIOUS) == 0 || J.FORCE {
+		return perms
+	}
+	ruledOut := J.loadRuledOut()
+	ret := [][]JtagPin{}
+	for _, p := range perms {
+		if key := J.permutationKey(p); ruledOut[key] {
+			J.results.RuledOut = append(J.results.RuledOut, key)
+			continue
+		}
+		ret = append(ret, p)
+	}
+	if len(ret) != len(perms) {
+		fmt.Fprintf(J.out, "skipping %d permutations ruled out by previous runs, give -force to test them again\n", len(perms)-len(ret))
+	}
+	return ret
+}
+
+// Called by scans before testing permutation p, nil after the last one:
+// the previous permutation is ruled out if it found no pinout, the target
+// gave no inconclusive answer and no timing gaps put its reads in doubt.
+func (J *Jtag) trackPermutation(p []JtagPin) {
+	if t := J.permTrack; t != nil && !t.inconclusive &&
+		len(J.results.Pinouts) == t.pinouts && J.stats.TimingGaps == t.gaps {
+		J.results.RuledOut = append(J.results.RuledOut, t.key)
+	}
+	J.permTrack = nil
+	if p != nil {
+		J.permTrack = &permutationTrack{
+			key:     J.permutationKey(p),
+			pinouts: len(J.results.Pinouts),
+			gaps:    J.stats.TimingGaps,
+		}
+	}
+}
+
+// Mark permutation being tested as not ruled out: the target answered, but
+// not as a pinout the scan looks for.
+func (J *Jtag) permutationInconclusive() {
+	if J.permTrack != nil {
+		J.permTrack.inconclusive = true
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sort"
+)
+
+// Driver constructor.
+// extraPins -- pins used besides JTAG ones, e.g. trigger pins
+// returns the driver, or nil and the reason it can't be created
+type driverFactory func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string)
+
+// Drivers built into this binary, every driver file registers itself from
+// init(), so drivers can be left out with build tags
+var drivers = map[string]driverFactory{}
+
+// What it takes to build the drivers in, to explain why one is missing
+var driverRequirements = map[string]string{
+	"rpio":  "Linux other than Android and no 'norpio' build tag",
+	"gpiod": "Linux, cgo with libgpiod and no 'nogpiod' build tag",
+	"ftdi":  "cgo with libftdi1 and the 'ftdi' build tag",
+}
+
+func registerDriver(name string, f driverFactory) {
+	drivers[name] = f
+}
+
+// returns names of the drivers built in, sorted
+func driverNames() []string {
+	ret := []string{}
+	for name := range drivers {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// FTDI vendor ID and the MPSSE chips the ftdi driver works with, by product ID
+const FTDI_VID = 0x0403
+
+var ftdiMpssePids = map[uint]string{
+	0x6010: "FT2232H",
+	0x6011: "FT4232H",
+	0x6014: "FT232H",
+}
+
+// USB IDs of Bus Pirates with their own USB stack (v4, v5); v3 is a plain
+// FT232R, not told apart from other serial adapters
+var buspirateUsbIds = map[string]string{
+	"04d8:fb00": "Bus Pirate v4",
+	"1209:7331": "Bus Pirate v5",
+}
+
+// Read board model exposed by device tree, empty if not available.
+func boardModel() string {
+	for _, path := range []string{"/proc/device-tree/model", "/sys/firmware/devicetree/base/model"} {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimRight(string(data), "\x00\n")
+		}
+	}
+	return ""
+}
+
+// Read a hexadecimal USB ID attribute of a device in sysfs.
+// returns 0 if it can't be read
+func usbId(dev, attr string) uint {
+	data, err := os.ReadFile(filepath.Join(dev, attr))
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 16)
+	if err != nil {
+		return 0
+	}
+	return uint(v)
+}
+
+// Find FTDI MPSSE adapters on USB, the one with preferPid first.
+// returns product IDs and descriptions, e.g. "FT2232H 0403:6010 on USB 1-2"
+func findFtdi(preferPid uint) ([]uint, []string) {
+	pids, descs := []uint{}, []string{}
+	devs, _ := filepath.Glob("/sys/bus/usb/devices/*")
+	for _, dev := range devs {
+		pid := usbId(dev, "idProduct")
+		chip, ok := ftdiMpssePids[pid]
+		if !ok || usbId(dev, "idVendor") != FTDI_VID {
+			continue
+		}
+		desc := fmt.Sprintf("%s %04x:%04x on USB %s", chip, FTDI_VID, pid, filepath.Base(dev))
+		if pid == preferPid {
+			pids = append([]uint{pid}, pids...)
+			descs = append([]string{desc}, descs...)
+		} else {
+			pids = append(pids, pid)
+			descs = append(descs, desc)
+		}
+	}
+	return pids, descs
+}
+
+// Find serial ports of Bus Pirates by the USB IDs of their tty devices.
+// returns port paths and descriptions
+func findBuspirate() ([]string, []string) {
+	ports, descs := []string{}, []string{}
+	ttys, _ := filepath.Glob("/sys/class/tty/ttyACM*")
+	for _, tty := range ttys {
+		// device is the USB interface, its parent the USB device
+		dev, err := filepath.EvalSymlinks(filepath.Join(tty, "device"))
+		if err != nil {
+			continue
+		}
+		dev = filepath.Dir(dev)
+		id := fmt.Sprintf("%04x:%04x", usbId(dev, "idVendor"), usbId(dev, "idProduct"))
+		if model, ok := buspirateUsbIds[id]; ok {
+			port := "/dev/" + filepath.Base(tty)
+			ports = append(ports, port)
+			descs = append(descs, fmt.Sprintf("%s %s on %s", model, id, port))
+		}
+	}
+	return ports, descs
+}
+
+// Pick the best driver available on this host: rpio on Raspberry Pi (fastest),
+// then a USB adapter plugged in (FTDI MPSSE, Bus Pirate), then gpiod if any
+// GPIO chip character device exists. Options of the adapter found, FTDI
+// product ID or serial port unless given, are set in o.
+// returns driver name and the reason it was chosen, empty name if nothing found
+func detectDriver(o *DriverOptions) (string, string) {
+	model := boardModel()
+	_, haveRpio := drivers["rpio"]
+	_, haveGpiod := drivers["gpiod"]
+	_, haveFtdi := drivers["ftdi"]
+	_, haveBuspirate := drivers["buspirate"]
+	if haveRpio && strings.HasPrefix(model, "Raspberry Pi") {
+		if _, err := os.Stat("/dev/gpiomem"); err == nil {
+			return "rpio", model + " with /dev/gpiomem"
+		}
+	}
+
+	// adapters plugged in are meant to be used rather than GPIOs of a PC
+	if haveFtdi && o.FtdiVid == FTDI_VID {
+		if pids, descs := findFtdi(o.FtdiPid); len(pids) != 0 {
+			o.FtdiPid = pids[0]
+			reason := descs[0]
+			if len(descs) > 1 {
+				reason += fmt.Sprintf(", also found %s", strings.Join(descs[1:], ", "))
+			}
+			return "ftdi", reason
+		}
+	}
+	if haveBuspirate {
+		if ports, descs := findBuspirate(); len(ports) != 0 {
+			if len(o.SerialPort) == 0 {
+				o.SerialPort = ports[0]
+			}
+			reason := descs[0]
+			if len(descs) > 1 {
+				reason += fmt.Sprintf(", also found %s", strings.Join(descs[1:], ", "))
+			}
+			return "buspirate", reason
+		}
+	}
+
+	chips, _ := filepath.Glob("/dev/gpiochip*")
+	if haveGpiod && len(chips) != 0 {
+		reason := strings.Join(chips, ", ")
+		if model != "" {
+			reason = model + " with " + reason
+		}
+		return "gpiod", reason
+	}
+
+	if len(drivers) == 0 {
+		return "", fmt.Sprintf("no drivers are built into this binary for %s", runtime.GOOS)
+	}
+	return "", fmt.Sprintf("no device usable by built-in drivers (%s) found", strings.Join(driverNames(), ", "))
+}
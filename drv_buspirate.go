@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Binary bitbang mode commands, low bits are pins
+const (
+	BP_RESET    = 0x00
+	BP_EXIT     = 0x0f
+	BP_SET_DIRS = 0x40
+	BP_SET_PINS = 0x80
+	BP_PULLUP   = 0x20
+	BP_ALL_PINS = 0x1f
+)
+
+// Resets sent to enter binary bitbang mode from the terminal mode
+const BP_ENTER_TRY = 20
+
+// Pin numbers of the driver are bits of the bitbang commands
+var buspiratePins = []string{"CS", "MISO", "CLK", "MOSI", "AUX"}
+
+// Bus Pirate, or another adapter speaking its binary bitbang protocol, on a
+// serial port: pins 0-4 are CS, MISO, CLK, MOSI and AUX. Every call is a
+// command byte answered by a byte of pin levels, so it is slow. The pull-ups
+// are switched for all pins at once, pulling to the voltage given on VPU:
+// a pin asking for pull-up turns them on for all inputs.
+type JtagPinDriverBuspirate struct {
+	Port string
+	Baud uint
+
+	f *os.File
+	// direction bits, 1 for input, and pin levels with pull-up bit
+	dirs  byte
+	pins  byte
+	pulls map[JtagPin]bool
+}
+
+func init() {
+	registerDriver("buspirate", func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string) {
+		if len(o.SerialPort) == 0 {
+			return nil, "give serial port of the Bus Pirate with -port"
+		}
+		for _, pin := range append(J.usedPins(), extraPins...) {
+			if pin != J.IGNOREPIN && int(pin) >= len(buspiratePins) {
+				return nil, fmt.Sprintf("pin %d is out of Bus Pirate pins 0-%d (%s)", pin, len(buspiratePins)-1, strings.Join(buspiratePins, ", "))
+			}
+		}
+		return &JtagPinDriverBuspirate{Port: o.SerialPort, Baud: o.SerialBaud}, ""
+	})
+}
+
+// Send a command and read the byte of pin levels it is answered with.
+func (d *JtagPinDriverBuspirate) command(cmd byte) byte {
+	if _, err := d.f.Write([]byte{cmd}); err != nil {
+		panic(fmt.Sprintf("Bus Pirate write failed: %v", err))
+	}
+	b := []byte{0}
+	// a read timing out returns io.EOF
+	n, err := d.f.Read(b)
+	if err != nil && err != io.EOF {
+		panic(fmt.Sprintf("Bus Pirate read failed: %v", err))
+	}
+	if n != 1 {
+		panic("Bus Pirate does not answer")
+	}
+	return b[0]
+}
+
+func (d *JtagPinDriverBuspirate) initDriver() {
+	f, reason := openSerial(d.Port, d.Baud, true)
+	if reason != "" {
+		panic(reason)
+	}
+	d.f = f
+	d.pulls = map[JtagPin]bool{}
+
+	// it answers BBIO1 to a reset once in bitbang mode
+	got := []byte{}
+	for i := 0; i < BP_ENTER_TRY && !strings.HasSuffix(string(got), "BBIO1"); i += 1 {
+		f.Write([]byte{BP_RESET})
+		buf := make([]byte, 64)
+		n, _ := f.Read(buf)
+		got = append(got, buf[:n]...)
+	}
+	if !strings.HasSuffix(string(got), "BBIO1") {
+		f.Close()
+		panic(fmt.Sprintf("no Bus Pirate binary bitbang mode on %s at %d baud", d.Port, d.Baud))
+	}
+
+	d.dirs = BP_ALL_PINS
+	d.command(BP_SET_DIRS | d.dirs)
+	d.command(BP_SET_PINS | d.pins)
+}
+
+func (d *JtagPinDriverBuspirate) closeDriver() {
+	d.command(BP_SET_DIRS | BP_ALL_PINS)
+	d.command(BP_SET_PINS)
+	d.f.Write([]byte{BP_RESET, BP_EXIT})
+	d.f.Close()
+}
+
+func (d *JtagPinDriverBuspirate) pinWrite(pin JtagPin, state JtagPinState) {
+	if state == StateHigh {
+		d.pins |= 1 << pin
+	} else {
+		d.pins &^= 1 << pin
+	}
+	d.command(BP_SET_PINS | d.pins)
+}
+
+func (d *JtagPinDriverBuspirate) pinRead(pin JtagPin) JtagPinState {
+	return JtagPinState((d.command(BP_SET_PINS|d.pins) >> pin) & 1)
+}
+
+func (d *JtagPinDriverBuspirate) pinOutput(pin JtagPin) {
+	d.dirs &^= 1 << pin
+	d.command(BP_SET_DIRS | d.dirs)
+}
+
+func (d *JtagPinDriverBuspirate) pinInput(pin JtagPin) {
+	d.dirs |= 1 << pin
+	d.command(BP_SET_DIRS | d.dirs)
+}
+
+func (d *JtagPinDriverBuspirate) setPull(pin JtagPin, up bool) {
+	d.pulls[pin] = up
+	d.pins &^= BP_PULLUP
+	for _, up := range d.pulls {
+		if up {
+			d.pins |= BP_PULLUP
+		}
+	}
+	d.command(BP_SET_PINS | d.pins)
+}
+
+func (d *JtagPinDriverBuspirate) pinPullUp(pin JtagPin) {
+	d.setPull(pin, true)
+}
+
+func (d *JtagPinDriverBuspirate) pinPullOff(pin JtagPin) {
+	d.setPull(pin, false)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IEEE 1149.1 TAP controller states
+const (
+	emuTestLogicReset = iota
+	emuRunTestIdle
+	emuSelectDr
+	emuCaptureDr
+	emuShiftDr
+	emuExit1Dr
+	emuPauseDr
+	emuExit2Dr
+	emuUpdateDr
+	emuSelectIr
+	emuCaptureIr
+	emuShiftIr
+	emuExit1Ir
+	emuPauseIr
+	emuExit2Ir
+	emuUpdateIr
+)
+
+// Next TAP state, indexed by state and TMS
+var emuNextState = [16][2]int{
+	emuTestLogicReset: {emuRunTestIdle, emuTestLogicReset},
+	emuRunTestIdle:    {emuRunTestIdle, emuSelectDr},
+	emuSelectDr:       {emuCaptureDr, emuSelectIr},
+	emuCaptureDr:      {emuShiftDr, emuExit1Dr},
+	emuShiftDr:        {emuShiftDr, emuExit1Dr},
+	emuExit1Dr:        {emuPauseDr, emuUpdateDr},
+	emuPauseDr:        {emuPauseDr, emuExit2Dr},
+	emuExit2Dr:        {emuShiftDr, emuUpdateDr},
+	emuUpdateDr:       {emuRunTestIdle, emuSelectDr},
+	emuSelectIr:       {emuCaptureIr, emuTestLogicReset},
+	emuCaptureIr:      {emuShiftIr, emuExit1Ir},
+	emuShiftIr:        {emuShiftIr, emuExit1Ir},
+	emuExit1Ir:        {emuPauseIr, emuUpdateIr},
+	emuPauseIr:        {emuPauseIr, emuExit2Ir},
+	emuExit2Ir:        {emuShiftIr, emuUpdateIr},
+	emuUpdateIr:       {emuRunTestIdle, emuSelectDr},
+}
+
+// Virtual device of the emulated chain
+type emuDevice struct {
+	IrLen uint32
+	// IDCODE selected at reset, nothing (BYPASS selected) if !HasIdcode
+	Idcode    uint32
+	HasIdcode bool
+	// IDCODE instruction opcode
+	IdcodeOp uint32
+	// BYPASS is selected at reset even with IDCODE, it is read by loading
+	// the IDCODE instruction only
+	ResetBypass bool
+	// IR captures all 0s instead of ...01, as some non-compliant parts do
+	BadCapture bool
+	// lengths of DRs selected by opcodes, other opcodes select BYPASS
+	Drs map[uint32]uint32
+
+	ir    uint32
+	shift []byte
+	// DR contents kept from Update-DR to the next Capture-DR
+	drs map[uint32][]byte
+}
+
+// Parse emulated chain, devices separated by ',', TDO first like
+// -chain-ir-lens. A device is 'IDCODE:IRLEN' followed by optional
+// ':'-separated quirks, IDCODE is 'none' for a device without one:
+//
+//	0x4ba00477:4,none:5,0x06413041:5:badcapture:idcode-op=0x1e:resetbypass
+//
+// returns devices and empty string, or description of the problem
+func parseEmuChain(s string) ([]*emuDevice, string) {
+	ret := []*emuDevice{}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if len(e) == 0 {
+			continue
+		}
+		fields := strings.Split(e, ":")
+		if len(fields) < 2 {
+			return nil, fmt.Sprintf("emulated device '%s' is not IDCODE:IRLEN", e)
+		}
+		dev := &emuDevice{IdcodeOp: 1}
+		if fields[0] != "none" {
+			v, err := strconv.ParseUint(fields[0], 0, 32)
+			if err != nil {
+				return nil, fmt.Sprintf("invalid IDCODE '%s' of emulated device '%s'", fields[0], e)
+			}
+			dev.Idcode, dev.HasIdcode = uint32(v), true
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil || v < MIN_IR_LEN || v > MAX_IR_LEN {
+			return nil, fmt.Sprintf("invalid IR length '%s' of emulated device '%s'", fields[1], e)
+		}
+		dev.IrLen = uint32(v)
+		for _, quirk := range fields[2:] {
+			switch {
+			case quirk == "badcapture":
+				dev.BadCapture = true
+			case quirk == "resetbypass":
+				dev.ResetBypass = true
+			case strings.HasPrefix(quirk, "idcode-op="):
+				v, err := strconv.ParseUint(strings.TrimPrefix(quirk, "idcode-op="), 0, 32)
+				if err != nil {
+					return nil, fmt.Sprintf("invalid opcode in '%s' of emulated device '%s'", quirk, e)
+				}
+				dev.IdcodeOp = uint32(v)
+			default:
+				return nil, fmt.Sprintf("unknown quirk '%s' of emulated device '%s', use badcapture, resetbypass or idcode-op=N", quirk, e)
+			}
+		}
+		if dev.IdcodeOp&(1<<dev.IrLen-1) != dev.IdcodeOp || dev.IdcodeOp == 1<<dev.IrLen-1 {
+			return nil, fmt.Sprintf("IDCODE opcode of emulated device '%s' doesn't fit IR or is BYPASS", e)
+		}
+		ret = append(ret, dev)
+	}
+	if len(ret) == 0 {
+		return nil, "no emulated devices given, use -emu-chain"
+	}
+	return ret, ""
+}
+
+func (d *emuDevice) reset() {
+	d.ir = 1<<d.IrLen - 1
+	if d.HasIdcode && !d.ResetBypass {
+		d.ir = d.IdcodeOp
+	}
+}
+
+func (d *emuDevice) captureIr() {
+	v := uint32(1)
+	if d.BadCapture {
+		v = 0
+	}
+	d.shift = irBits(v, d.IrLen)
+}
+
+// IDCODE register if selected, a DR of Drs with what was last updated into
+// it, 0s at first, or BYPASS for every other instruction.
+func (d *emuDevice) captureDr() {
+	if d.HasIdcode && d.ir == d.IdcodeOp {
+		d.shift = irBits(d.Idcode, 32)
+		return
+	}
+	if drLen, ok := d.Drs[d.ir]; ok {
+		if d.drs == nil {
+			d.drs = map[uint32][]byte{}
+		}
+		if _, ok := d.drs[d.ir]; !ok {
+			d.drs[d.ir] = irBits(0, drLen)
+		}
+		d.shift = append([]byte{}, d.drs[d.ir]...)
+		return
+	}
+	d.shift = []byte{'0'}
+}
+
+func (d *emuDevice) updateDr() {
+	if _, ok := d.Drs[d.ir]; ok && !(d.HasIdcode && d.ir == d.IdcodeOp) {
+		d.drs[d.ir] = append([]byte{}, d.shift...)
+	}
+}
+
+// Shift one bit in at the TDI end of the register.
+// returns bit shifted out at the TDO end
+func (d *emuDevice) shiftBit(in byte) byte {
+	out := d.shift[0]
+	d.shift = append(d.shift[1:], in)
+	return out
+}
+
+// TAP emulator: drives no hardware, but answers on the pins it is wired to
+// like a chain of virtual devices would, to regression-test the enumerator
+// against ugly real-world chains without a target at hand.
+type JtagPinDriverEmu struct {
+	// pins the virtual chain is wired to, TRST is optional
+	Wiring  JtagPins
+	Devices []*emuDevice
+
+	state  int
+	levels map[JtagPin]JtagPinState
+	output map[JtagPin]bool
+	pullUp map[JtagPin]bool
+	// TDO level, driven in Shift-DR and Shift-IR only
+	tdo      JtagPinState
+	tdoDrive bool
+}
+
+func init() {
+	registerDriver("emu", func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string) {
+		drv := &JtagPinDriverEmu{Wiring: JtagPins{TRST: J.IGNOREPIN}}
+		if err := json.Unmarshal([]byte(o.EmuPins), &drv.Wiring); err != nil {
+			return nil, fmt.Sprintf("can't parse -emu-pins: %v", err)
+		}
+		devices, reason := parseEmuChain(o.EmuChain)
+		if reason != "" {
+			return nil, reason
+		}
+		drv.Devices = devices
+		fmt.Fprintln(J.out, drv.describe())
+		return drv, ""
+	})
+}
+
+func (d *JtagPinDriverEmu) initDriver() {
+	d.levels = map[JtagPin]JtagPinState{}
+	d.output = map[JtagPin]bool{}
+	d.pullUp = map[JtagPin]bool{}
+	d.enter(emuTestLogicReset)
+}
+
+// returns wiring and devices emulated, printed when the driver is selected
+func (d *JtagPinDriverEmu) describe() string {
+	desc := []string{}
+	for _, dev := range d.Devices {
+		s := fmt.Sprintf("IR %d", dev.IrLen)
+		if dev.HasIdcode {
+			s = fmt.Sprintf("0x%08x %s", dev.Idcode, s)
+		}
+		if dev.BadCapture {
+			s += " bad capture"
+		}
+		if dev.HasIdcode && dev.ResetBypass {
+			s += " BYPASS at reset"
+		}
+		if len(dev.Drs) != 0 {
+			s += fmt.Sprintf(" %d DRs", len(dev.Drs))
+		}
+		desc = append(desc, s)
+	}
+	return fmt.Sprintf("Emulating TCK:%d TMS:%d TDO:%d TDI:%d, devices from TDO: %s",
+		d.Wiring.TCK, d.Wiring.TMS, d.Wiring.TDO, d.Wiring.TDI, strings.Join(desc, ", "))
+}
+
+func (d *JtagPinDriverEmu) closeDriver() {
+}
+
+func (d *JtagPinDriverEmu) enter(state int) {
+	d.state = state
+	switch state {
+	case emuTestLogicReset:
+		for _, dev := range d.Devices {
+			dev.reset()
+		}
+	case emuUpdateIr:
+		for _, dev := range d.Devices {
+			dev.ir = bitsToUint32(string(dev.shift))
+		}
+	case emuUpdateDr:
+		for _, dev := range d.Devices {
+			dev.updateDr()
+		}
+	}
+}
+
+// TCK rising edge: capture or shift in the current state, then move on.
+func (d *JtagPinDriverEmu) clock() {
+	switch d.state {
+	case emuCaptureDr:
+		for _, dev := range d.Devices {
+			dev.captureDr()
+		}
+	case emuCaptureIr:
+		for _, dev := range d.Devices {
+			dev.captureIr()
+		}
+	case emuShiftDr, emuShiftIr:
+		in := byte('0')
+		if d.level(d.Wiring.TDI) == StateHigh {
+			in = '1'
+		}
+		for i := len(d.Devices) - 1; i >= 0; i -= 1 {
+			in = d.Devices[i].shiftBit(in)
+		}
+	}
+	d.enter(emuNextState[d.state][d.level(d.Wiring.TMS)])
+}
+
+// TCK falling edge: TDO presents the bit of the device closest to it.
+func (d *JtagPinDriverEmu) fall() {
+	d.tdoDrive = d.state == emuShiftDr || d.state == emuShiftIr
+	if d.tdoDrive {
+		d.tdo = JtagPinState(d.Devices[0].shift[0] - '0')
+	}
+}
+
+// returns level driven on the pin, pull level if it is not driven
+func (d *JtagPinDriverEmu) level(pin JtagPin) JtagPinState {
+	if d.output[pin] {
+		return d.levels[pin]
+	}
+	if pin == d.Wiring.TDO && d.tdoDrive {
+		return d.tdo
+	}
+	if d.pullUp[pin] {
+		return StateHigh
+	}
+	return StateLow
+}
+
+func (d *JtagPinDriverEmu) pinWrite(pin JtagPin, state JtagPinState) {
+	prev := d.level(pin)
+	d.levels[pin] = state
+	if !d.output[pin] {
+		return
+	}
+	switch {
+	case pin == d.Wiring.TRST && state == StateLow:
+		d.enter(emuTestLogicReset)
+	case pin == d.Wiring.TCK && prev == StateLow && state == StateHigh:
+		d.clock()
+	case pin == d.Wiring.TCK && prev == StateHigh && state == StateLow:
+		d.fall()
+	}
+}
+
+func (d *JtagPinDriverEmu) pinRead(pin JtagPin) JtagPinState {
+	return d.level(pin)
+}
+
+func (d *JtagPinDriverEmu) pinOutput(pin JtagPin) {
+	d.output[pin] = true
+}
+
+func (d *JtagPinDriverEmu) pinInput(pin JtagPin) {
+	d.output[pin] = false
+}
+
+func (d *JtagPinDriverEmu) pinPullUp(pin JtagPin) {
+	d.pullUp[pin] = true
+}
+
+func (d *JtagPinDriverEmu) pinPullOff(pin JtagPin) {
+	d.pullUp[pin] = false
+}
@@ -0,0 +1,166 @@
+//go:build cgo && ftdi
+
+package main
+
+// #cgo pkg-config: libftdi1
+// #include <ftdi.h>
+// #include <stdlib.h>
+import "C"
+import (
+	"fmt"
+	"strings"
+)
+
+// MPSSE commands driving the channel pins as GPIO
+const (
+	MPSSE_SET_LOW        = 0x80
+	MPSSE_READ_LOW       = 0x81
+	MPSSE_SET_HIGH       = 0x82
+	MPSSE_READ_HIGH      = 0x83
+	MPSSE_LOOPBACK_OFF   = 0x85
+	MPSSE_SEND_IMMEDIATE = 0x87
+)
+
+// ADBUS0-7 and ACBUS0-7 of the channel
+const FTDI_PINS = 16
+
+// Reads of a byte answered by the chip, each waits up to the latency timer
+const FTDI_READ_RETRIES = 100
+
+// FTDI MPSSE channel (FT2232H, FT4232H, FT232H) used as 16 GPIOs: pins 0-7
+// are ADBUS0-7, 8-15 ACBUS0-7. Directions and levels are kept here and the
+// whole byte is written on every change. The chip has no pull control, pull
+// calls do nothing: FT2232H and FT232H inputs have weak internal pull-ups.
+type JtagPinDriverFtdi struct {
+	Vid     uint
+	Pid     uint
+	Channel string
+
+	ctx    *C.struct_ftdi_context
+	levels uint16
+	dirs   uint16
+}
+
+var ftdiChannels = map[string]C.enum_ftdi_interface{
+	"A": C.INTERFACE_A,
+	"B": C.INTERFACE_B,
+	"C": C.INTERFACE_C,
+	"D": C.INTERFACE_D,
+}
+
+func init() {
+	registerDriver("ftdi", func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string) {
+		channel := strings.ToUpper(o.FtdiChannel)
+		if _, ok := ftdiChannels[channel]; !ok {
+			return nil, fmt.Sprintf("unknown FTDI channel '%s', use A, B, C or D", o.FtdiChannel)
+		}
+		for _, pin := range append(J.usedPins(), extraPins...) {
+			if pin != J.IGNOREPIN && pin >= FTDI_PINS {
+				return nil, fmt.Sprintf("pin %d is out of FTDI channel pins 0-%d (ADBUS0-7, ACBUS0-7)", pin, FTDI_PINS-1)
+			}
+		}
+		return &JtagPinDriverFtdi{Vid: o.FtdiVid, Pid: o.FtdiPid, Channel: channel}, ""
+	})
+}
+
+// returns last libftdi error
+func (d *JtagPinDriverFtdi) error() string {
+	return C.GoString(C.ftdi_get_error_string(d.ctx))
+}
+
+func (d *JtagPinDriverFtdi) initDriver() {
+	d.ctx = C.ftdi_new()
+	if d.ctx == nil {
+		panic("can't allocate libftdi context")
+	}
+	if C.ftdi_set_interface(d.ctx, ftdiChannels[d.Channel]) < 0 {
+		panic(fmt.Sprintf("can't select FTDI channel %s: %s", d.Channel, d.error()))
+	}
+	if C.ftdi_usb_open(d.ctx, C.int(d.Vid), C.int(d.Pid)) < 0 {
+		err := d.error()
+		C.ftdi_free(d.ctx)
+		panic(fmt.Sprintf("can't open FTDI device %04x:%04x: %s", d.Vid, d.Pid, err))
+	}
+	C.ftdi_usb_reset(d.ctx)
+	C.ftdi_set_latency_timer(d.ctx, 1)
+	C.ftdi_set_bitmode(d.ctx, 0, C.BITMODE_RESET)
+	if C.ftdi_set_bitmode(d.ctx, 0, C.BITMODE_MPSSE) < 0 {
+		panic(fmt.Sprintf("FTDI channel %s has no MPSSE: %s", d.Channel, d.error()))
+	}
+	C.ftdi_usb_purge_buffers(d.ctx)
+
+	// all pins inputs
+	d.write(MPSSE_LOOPBACK_OFF, MPSSE_SET_LOW, 0, 0, MPSSE_SET_HIGH, 0, 0)
+}
+
+func (d *JtagPinDriverFtdi) closeDriver() {
+	d.write(MPSSE_SET_LOW, 0, 0, MPSSE_SET_HIGH, 0, 0)
+	C.ftdi_set_bitmode(d.ctx, 0, C.BITMODE_RESET)
+	C.ftdi_usb_close(d.ctx)
+	C.ftdi_free(d.ctx)
+}
+
+func (d *JtagPinDriverFtdi) write(cmd ...byte) {
+	buf := C.CBytes(cmd)
+	defer C.free(buf)
+	if n := C.ftdi_write_data(d.ctx, (*C.uchar)(buf), C.int(len(cmd))); int(n) != len(cmd) {
+		panic(fmt.Sprintf("FTDI write failed: %s", d.error()))
+	}
+}
+
+func (d *JtagPinDriverFtdi) read() byte {
+	b := C.uchar(0)
+	for retry := 0; retry < FTDI_READ_RETRIES; retry += 1 {
+		n := C.ftdi_read_data(d.ctx, &b, 1)
+		if n < 0 {
+			panic(fmt.Sprintf("FTDI read failed: %s", d.error()))
+		}
+		if n == 1 {
+			return byte(b)
+		}
+	}
+	panic("FTDI read timed out")
+}
+
+// Write levels and directions of the byte the pin belongs to.
+func (d *JtagPinDriverFtdi) update(pin JtagPin) {
+	if pin < 8 {
+		d.write(MPSSE_SET_LOW, byte(d.levels), byte(d.dirs))
+	} else {
+		d.write(MPSSE_SET_HIGH, byte(d.levels>>8), byte(d.dirs>>8))
+	}
+}
+
+func (d *JtagPinDriverFtdi) pinWrite(pin JtagPin, state JtagPinState) {
+	if state == StateHigh {
+		d.levels |= 1 << pin
+	} else {
+		d.levels &^= 1 << pin
+	}
+	d.update(pin)
+}
+
+func (d *JtagPinDriverFtdi) pinRead(pin JtagPin) JtagPinState {
+	if pin < 8 {
+		d.write(MPSSE_READ_LOW, MPSSE_SEND_IMMEDIATE)
+	} else {
+		d.write(MPSSE_READ_HIGH, MPSSE_SEND_IMMEDIATE)
+	}
+	return JtagPinState((d.read() >> (pin % 8)) & 1)
+}
+
+func (d *JtagPinDriverFtdi) pinOutput(pin JtagPin) {
+	d.dirs |= 1 << pin
+	d.update(pin)
+}
+
+func (d *JtagPinDriverFtdi) pinInput(pin JtagPin) {
+	d.dirs &^= 1 << pin
+	d.update(pin)
+}
+
+func (d *JtagPinDriverFtdi) pinPullUp(pin JtagPin) {
+}
+
+func (d *JtagPinDriverFtdi) pinPullOff(pin JtagPin) {
+}
@@ -1,16 +1,55 @@
+//go:build linux && cgo && !nogpiod
+
 package main
 
 // #cgo pkg-config: libgpiod
 // #include <gpiod.h>
+// #include <stdlib.h>
 import "C"
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
 )
 
 type JtagPinDriverGpiod struct {
 	GpioChip uint
-	ctx      *C.struct_gpiod_chip
-	lines    map[JtagPin]*C.struct_gpiod_line
+	// consumer label shown by gpioinfo for requested lines
+	Consumer string
+	// request all Pins at init, failing if any of them is busy, instead of
+	// requesting lines on first use
+	Upfront bool
+	Pins    []JtagPin
+
+	ctx       *C.struct_gpiod_chip
+	consumer  *C.char
+	lines     map[JtagPin]*C.struct_gpiod_line
+	requested map[JtagPin]bool
+}
+
+func init() {
+	registerDriver("gpiod", func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string) {
+		drv := &JtagPinDriverGpiod{
+			GpioChip: o.GpioChip,
+			Consumer: o.GpiodConsumer,
+			Upfront:  o.GpiodUpfront,
+			Pins:     J.usedPins(),
+		}
+		// a line is requested once however many uses it has
+		seen := map[JtagPin]bool{J.IGNOREPIN: true}
+		for _, pin := range drv.Pins {
+			seen[pin] = true
+		}
+		for _, pin := range extraPins {
+			if !seen[pin] {
+				seen[pin] = true
+				drv.Pins = append(drv.Pins, pin)
+			}
+		}
+		return drv, ""
+	})
 }
 
 func (d *JtagPinDriverGpiod) initDriver() {
@@ -18,7 +57,29 @@ func (d *JtagPinDriverGpiod) initDriver() {
 	if d.ctx == nil {
 		panic(fmt.Sprintf("can't open gpio chip #%d", d.GpioChip))
 	}
+	if d.Consumer == "" {
+		d.Consumer = "jtagenum"
+	}
+	d.consumer = C.CString(d.Consumer)
 	d.lines = make(map[JtagPin]*C.struct_gpiod_line, 0)
+	d.requested = make(map[JtagPin]bool, 0)
+
+	if d.Upfront {
+		// report all busy lines at once rather than the first one
+		busy := []string{}
+		for _, pin := range d.Pins {
+			l := d.getAllocLine(pin)
+			if C.gpiod_line_is_used(l) {
+				busy = append(busy, d.describeBusy(pin))
+			}
+		}
+		if len(busy) != 0 {
+			panic(fmt.Sprintf("pins are busy: %s", strings.Join(busy, "; ")))
+		}
+		for _, pin := range d.Pins {
+			d.requestLine(pin, false)
+		}
+	}
 }
 
 func (d *JtagPinDriverGpiod) closeDriver() {
@@ -26,6 +87,7 @@ func (d *JtagPinDriverGpiod) closeDriver() {
 		C.gpiod_line_release(v)
 	}
 	C.gpiod_chip_close(d.ctx)
+	C.free(unsafe.Pointer(d.consumer))
 }
 
 func (d *JtagPinDriverGpiod) getAllocLine(pin JtagPin) *C.struct_gpiod_line {
@@ -40,6 +102,72 @@ func (d *JtagPinDriverGpiod) getAllocLine(pin JtagPin) *C.struct_gpiod_line {
 	return l
 }
 
+// List processes holding GPIO chips or lines open, except this one.
+func gpioHolders() []string {
+	ret := []string{}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	seen := map[string]bool{}
+	self := fmt.Sprintf("/proc/%d/", os.Getpid())
+	for _, fd := range fds {
+		if strings.HasPrefix(fd, self) {
+			continue
+		}
+		target, err := os.Readlink(fd)
+		if err != nil || !strings.Contains(target, "gpio") {
+			continue
+		}
+		pid := strings.Split(fd, "/")[2]
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		comm, _ := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+		ret = append(ret, fmt.Sprintf("%s(%s)", strings.TrimSpace(string(comm)), pid))
+	}
+	return ret
+}
+
+func (d *JtagPinDriverGpiod) describeBusy(pin JtagPin) string {
+	ret := fmt.Sprintf("pin #%d", pin)
+	l := d.getAllocLine(pin)
+	if C.gpiod_line_update(l) == 0 {
+		if consumer := C.gpiod_line_consumer(l); consumer != nil {
+			ret += fmt.Sprintf(" used by '%s'", C.GoString(consumer))
+		}
+	}
+	if holders := gpioHolders(); len(holders) != 0 {
+		ret += fmt.Sprintf(", processes holding GPIOs: %s", strings.Join(holders, " "))
+	}
+	return ret
+}
+
+// Request line in the given direction. Lines requested up-front are never
+// released, so another process can't grab them between direction changes.
+func (d *JtagPinDriverGpiod) requestLine(pin JtagPin, output bool) {
+	l := d.getAllocLine(pin)
+	var rv C.int
+	if d.requested[pin] && d.Upfront {
+		if output {
+			rv = C.gpiod_line_set_direction_output(l, 1)
+		} else {
+			rv = C.gpiod_line_set_direction_input(l)
+		}
+	} else {
+		if d.requested[pin] {
+			C.gpiod_line_release(l)
+		}
+		if output {
+			rv = C.gpiod_line_request_output(l, d.consumer, 1)
+		} else {
+			rv = C.gpiod_line_request_input(l, d.consumer)
+		}
+	}
+	if rv != 0 {
+		panic(fmt.Sprintf("can't reserve %s", d.describeBusy(pin)))
+	}
+	d.requested[pin] = true
+}
+
 func (d *JtagPinDriverGpiod) pinWrite(pin JtagPin, state JtagPinState) {
 	C.gpiod_line_set_value(d.getAllocLine(pin), C.int(state))
 }
@@ -53,21 +181,11 @@ func (d *JtagPinDriverGpiod) pinRead(pin JtagPin) JtagPinState {
 }
 
 func (d *JtagPinDriverGpiod) pinOutput(pin JtagPin) {
-	l, ok := d.lines[pin]
-	if ok {
-		C.gpiod_line_release(l)
-	}
-	l = d.getAllocLine(pin)
-	C.gpiod_line_request_output(l, C.CString("jtagenum"), 1)
+	d.requestLine(pin, true)
 }
 
 func (d *JtagPinDriverGpiod) pinInput(pin JtagPin) {
-	l, ok := d.lines[pin]
-	if ok {
-		C.gpiod_line_release(l)
-	}
-	l = d.getAllocLine(pin)
-	C.gpiod_line_request_input(l, C.CString("jtagenum"))
+	d.requestLine(pin, false)
 }
 
 func (d *JtagPinDriverGpiod) pinPullUp(pin JtagPin) {
@@ -1,19 +1,84 @@
+//go:build linux && !android && !norpio
+
 package main
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
 	"github.com/stianeikeland/go-rpio"
 )
 
+// GPIO memory is mapped once per process, keep it while any driver uses it
+var rpioLock sync.Mutex
+var rpioUsers int
+
+// Pad control of GPIO bank 0 before the first driver changed it, written
+// back when the last driver closes: it is system-wide and shared with other
+// GPIO users
+var rpioPadsChanged bool
+var rpioPadsBefore uint32
+
 type JtagPinDriverRpio struct {
+	// pad settings of GPIO bank 0, applied at init
+	Pads PadConfig
+
+	// where pad changes are reported
+	out io.Writer
+}
+
+func init() {
+	registerDriver("rpio", func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string) {
+		if reason := o.RpioPads.check(); reason != "" {
+			return nil, reason
+		}
+		return &JtagPinDriverRpio{Pads: o.RpioPads, out: J.out}, ""
+	})
 }
 
 func (d *JtagPinDriverRpio) initDriver() {
-	if err := rpio.Open(); err != nil {
-		panic(err)
+	rpioLock.Lock()
+	defer rpioLock.Unlock()
+	if rpioUsers == 0 {
+		if err := rpio.Open(); err != nil {
+			panic(err)
+		}
+	}
+	rpioUsers += 1
+
+	if !d.Pads.empty() {
+		before, after, err := writePads(d.Pads.apply)
+		if err != nil {
+			panic(fmt.Sprintf("can't set pad control: %v", err))
+		}
+		if !rpioPadsChanged {
+			rpioPadsChanged, rpioPadsBefore = true, before
+		}
+		fmt.Fprintf(d.out, "GPIO pads: %s, were %s\n", describePads(after), describePads(before))
 	}
 }
 
 func (d *JtagPinDriverRpio) closeDriver() {
+	rpioLock.Lock()
+	defer rpioLock.Unlock()
+	rpioUsers -= 1
+	if rpioUsers != 0 {
+		return
+	}
+	if rpioPadsChanged {
+		restore := func(uint32) uint32 { return rpioPadsBefore }
+		if _, after, err := writePads(restore); err != nil {
+			fmt.Fprintf(d.out, "can't restore pad control: %v\n", err)
+		} else {
+			fmt.Fprintf(d.out, "GPIO pads restored: %s\n", describePads(after))
+		}
+		rpioPadsChanged = false
+	}
 	rpio.Close()
 }
 
@@ -48,3 +113,46 @@ func (d *JtagPinDriverRpio) pinPullUp(pin JtagPin) {
 func (d *JtagPinDriverRpio) pinPullOff(pin JtagPin) {
 	rpio.PullMode(rpio.Pin(pin), rpio.PullOff)
 }
+
+// Physical base of peripherals from device tree, the same way go-rpio finds
+// it.
+func peripheralBase() (int64, error) {
+	ranges, err := os.ReadFile("/proc/device-tree/soc/ranges")
+	if err != nil || len(ranges) < 12 {
+		return 0, fmt.Errorf("can't read peripheral base from device tree")
+	}
+	base := binary.BigEndian.Uint32(ranges[4:8])
+	if base == 0 {
+		// 64-bit parent address on BCM2711
+		base = binary.BigEndian.Uint32(ranges[8:12])
+	}
+	return int64(base), nil
+}
+
+// Change pad control of GPIO bank 0 through /dev/mem, go-rpio has no access
+// to it. Needs root.
+// change -- returns the new register value from the current one, the
+// password is added here
+// returns register value before and after the change
+func writePads(change func(uint32) uint32) (uint32, uint32, error) {
+	base, err := peripheralBase()
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.OpenFile("/dev/mem", os.O_RDWR|os.O_SYNC, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	mem, err := syscall.Mmap(int(f.Fd()), base+PADS_OFFSET, os.Getpagesize(),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer syscall.Munmap(mem)
+
+	reg := (*uint32)(unsafe.Pointer(&mem[PADS_BANK0]))
+	before := *reg
+	*reg = PADS_PASSWORD | change(before)&0xffffff
+	return before, *reg, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// TAP of a simulation model, numbers are strings so they can be hex
+type SimTap struct {
+	IrLen uint32 `json:"irlen"`
+	// IDCODE selected at reset, none if empty
+	Idcode string `json:"idcode"`
+	// IDCODE opcode, 1 if empty
+	IdcodeOp   string `json:"idcode_op"`
+	BadCapture bool   `json:"bad_capture"`
+	// BYPASS selected at reset, IDCODE read by its instruction only
+	ResetBypass bool `json:"reset_bypass"`
+	// DR lengths by opcode, other opcodes select BYPASS
+	Drs map[string]uint32 `json:"drs"`
+}
+
+// Simulation model: TAPs, TDO first, and the pins they are wired to
+type SimModel struct {
+	Pins JtagPins `json:"pins"`
+	Taps []SimTap `json:"taps"`
+}
+
+func init() {
+	// the emulator with a model having DRs, for tests going beyond IDCODE
+	// and BYPASS
+	registerDriver("sim", func(J *Jtag, o DriverOptions, extraPins []JtagPin) (JtagPinDriver, string) {
+		if len(o.SimModel) == 0 {
+			return nil, "give the model of simulated TAPs with -sim-model"
+		}
+		drv, reason := loadSimModel(o.SimModel, J.IGNOREPIN)
+		if reason != "" {
+			return nil, fmt.Sprintf("%s: %s", o.SimModel, reason)
+		}
+		fmt.Fprintln(J.out, drv.describe())
+		return drv, ""
+	})
+}
+
+// Load simulation model from JSON file:
+//
+//	{ "pins": { "tck": 1, "tms": 2, "tdi": 3, "tdo": 4 },
+//	  "taps": [ { "irlen": 4, "idcode": "0x4ba00477", "idcode_op": "0xe",
+//	              "drs": { "0xa": 35, "0xb": 35 } },
+//	            { "irlen": 5, "bad_capture": true } ] }
+//
+// returns the emulator driver and empty string, or description of the
+// problem
+func loadSimModel(path string, ignorePin JtagPin) (*JtagPinDriverEmu, string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err.Error()
+	}
+	m := SimModel{Pins: JtagPins{TRST: ignorePin}}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err.Error()
+	}
+	if len(m.Taps) == 0 {
+		return nil, "no TAPs in the model"
+	}
+	drv := &JtagPinDriverEmu{Wiring: m.Pins}
+	for i, t := range m.Taps {
+		dev, reason := t.device()
+		if reason != "" {
+			return nil, fmt.Sprintf("TAP %d: %s", i, reason)
+		}
+		drv.Devices = append(drv.Devices, dev)
+	}
+	return drv, ""
+}
+
+// returns emulated device of the TAP and empty string, or description of
+// the problem
+func (t SimTap) device() (*emuDevice, string) {
+	if t.IrLen < MIN_IR_LEN || t.IrLen > MAX_IR_LEN {
+		return nil, fmt.Sprintf("invalid IR length %d", t.IrLen)
+	}
+	dev := &emuDevice{IrLen: t.IrLen, IdcodeOp: 1, BadCapture: t.BadCapture, ResetBypass: t.ResetBypass, Drs: map[uint32]uint32{}}
+	bypass := uint32(1)<<t.IrLen - 1
+	if len(t.Idcode) != 0 {
+		v, err := strconv.ParseUint(t.Idcode, 0, 32)
+		if err != nil {
+			return nil, fmt.Sprintf("invalid IDCODE '%s'", t.Idcode)
+		}
+		dev.Idcode, dev.HasIdcode = uint32(v), true
+	}
+	if len(t.IdcodeOp) != 0 {
+		v, err := strconv.ParseUint(t.IdcodeOp, 0, 32)
+		if err != nil {
+			return nil, fmt.Sprintf("invalid IDCODE opcode '%s'", t.IdcodeOp)
+		}
+		dev.IdcodeOp = uint32(v)
+	}
+	if dev.IdcodeOp&bypass != dev.IdcodeOp || dev.IdcodeOp == bypass {
+		return nil, "IDCODE opcode doesn't fit IR or is BYPASS"
+	}
+	for op, drLen := range t.Drs {
+		v, err := strconv.ParseUint(op, 0, 32)
+		if err != nil || uint32(v)&bypass != uint32(v) || uint32(v) == bypass {
+			return nil, fmt.Sprintf("opcode '%s' of a DR doesn't fit IR or is BYPASS", op)
+		}
+		if drLen == 0 || drLen > MAX_DR_LEN {
+			return nil, fmt.Sprintf("invalid length %d of DR of opcode '%s'", drLen, op)
+		}
+		dev.Drs[uint32(v)] = drLen
+	}
+	return dev, ""
+}
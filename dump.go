@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Dump is written and verified in blocks of this size
+const DUMP_BLOCK_LEN = 4096
+
+// Way of reading target memory, selected by what is found on the chain
+type dumpSource interface {
+	// returns description of the problem, empty if ok
+	read(addr uint32, buf []byte) string
+}
+
+// Word access to target memory, through ARM MEM-AP or MIPS EJTAG
+type memAccess interface {
+	// returns 32-bit word at addr and empty string, or the problem
+	readMem(addr uint32) (uint32, string)
+	// returns description of the problem, empty if ok
+	writeMem(addr, value uint32) string
+}
+
+// Memory read word by word
+type memSource struct {
+	mem       memAccess
+	bigEndian bool
+}
+
+func (s *memSource) read(addr uint32, buf []byte) string {
+	for i := 0; i < len(buf); {
+		a := addr + uint32(i)
+		w, err := s.mem.readMem(a &^ 3)
+		if err != "" {
+			return fmt.Sprintf("can't read 0x%08x: %s", a, err)
+		}
+		for off := a & 3; off < 4 && i < len(buf); off += 1 {
+			if s.bigEndian {
+				buf[i] = byte(w >> (8 * (3 - off)))
+			} else {
+				buf[i] = byte(w >> (8 * off))
+			}
+			i += 1
+		}
+	}
+	return ""
+}
+
+// SPI flash read through boundary scan
+type spiSource struct {
+	flash *SpiFlash
+}
+
+func (s *spiSource) read(addr uint32, buf []byte) string {
+	copy(buf, s.flash.read(addr, len(buf)))
+	return ""
+}
+
+// Select the way to read the target device: MEM-AP if it is an ARM debug
+// port, DMA or PrAcc if it is a MIPS EJTAG TAP, otherwise SPI flash through
+// boundary scan if BSDL and SPI signals are given.
+// bigEndian -- byte order of the target memory, for EJTAG
+// returns nil if there is no way
+func (J *Jtag) selectDumpSource(bsdl *Bsdl, spi SpiPorts, bigEndian bool) dumpSource {
+	J.useKnownPins()
+
+	J.initPins()
+
+	info := J.chainInfo()
+	if info.Devices == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return nil
+	}
+	if reason := J.CHAIN.check(info.Devices, info.IrLength); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return nil
+	}
+	idcode := info.Idcodes[J.CHAIN.Device]
+	fmt.Fprintf(J.out, "target device: %s\n", describeChainIdcode(idcode))
+
+	if isArmDp(idcode) {
+		dap := J.newDap(0)
+		if err := dap.powerUp(); err != "" {
+			fmt.Fprintf(J.out, "ARM DAP can't be powered up: %s\n", err)
+			return nil
+		}
+		fmt.Fprintln(J.out, "strategy: memory through ARM MEM-AP #0")
+		return &memSource{mem: dap}
+	}
+
+	if J.CHAIN.irLen(info.IrLength) == EJTAG_IR_LEN {
+		if e, reason := J.newEjtag(); e != nil {
+			if e.Dma {
+				fmt.Fprintln(J.out, "strategy: memory through EJTAG DMA")
+			} else {
+				fmt.Fprintln(J.out, "strategy: memory through EJTAG PrAcc")
+			}
+			return &memSource{mem: e, bigEndian: bigEndian}
+		} else if J.VERBOSE {
+			fmt.Fprintln(J.out, reason)
+		}
+	}
+
+	if bsdl != nil && spi.CS != "" {
+		if !J.ALLOW_DRIVE {
+			fmt.Fprintln(J.out, "reading SPI flash through boundary scan drives target pins, give -allow-drive")
+			return nil
+		}
+		if J.prepareBoundary(bsdl) == 0 {
+			return nil
+		}
+		f := J.openSpiFlash(bsdl, spi)
+		if f == nil {
+			return nil
+		}
+		fmt.Fprintln(J.out, "strategy: SPI flash through boundary scan")
+		return &spiSource{flash: f}
+	}
+
+	fmt.Fprintln(J.out, "no way to read this target: it is neither an ARM DAP nor MIPS EJTAG, give -bsdl and -spi to read SPI flash through boundary scan")
+	return nil
+}
+
+// Stream length bytes of target memory or flash at addr to path, block by
+// block, printing progress. With resume, blocks already in the file are
+// kept and the dump continues after them. At the end verify random blocks
+// are read again and compared with the file.
+// returns true if the dump is complete and verified
+func (J *Jtag) dump(bsdl *Bsdl, spi SpiPorts, addr, length uint32, path string, resume bool, verify uint, bigEndian bool) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Dumping target memory...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	if length == 0 {
+		fmt.Fprintln(J.out, "nothing to do, give length with -flash-len")
+		return false
+	}
+	if len(path) == 0 {
+		fmt.Fprintln(J.out, "file is required, give it with -flash-file")
+		return false
+	}
+
+	src := J.selectDumpSource(bsdl, spi, bigEndian)
+	// Reset TAP to Run-Test-Idle, pins are given back to the device
+	defer J.setTapState(TAP_RESET)
+	if src == nil {
+		return false
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		fmt.Fprintf(J.out, "can't open %s: %v\n", path, err)
+		return false
+	}
+	defer f.Close()
+
+	start := uint32(0)
+	if resume {
+		fi, err := f.Stat()
+		if err != nil {
+			panic(err)
+		}
+		// the last block might be partial, read it again
+		if fi.Size() >= int64(length) {
+			start = length
+		} else {
+			start = uint32(fi.Size()) / DUMP_BLOCK_LEN * DUMP_BLOCK_LEN
+		}
+		if start != 0 {
+			fmt.Fprintf(J.out, "resuming at offset 0x%x\n", start)
+		}
+	}
+
+	began := time.Now()
+	buf := make([]byte, DUMP_BLOCK_LEN)
+	lastPercent := -1
+	for off := start; off < length; off += DUMP_BLOCK_LEN {
+		n := length - off
+		if n > DUMP_BLOCK_LEN {
+			n = DUMP_BLOCK_LEN
+		}
+		if err := src.read(addr+off, buf[:n]); err != "" {
+			fmt.Fprintln(J.out, err)
+			fmt.Fprintf(J.out, "dump stopped at offset 0x%x, continue with -resume\n", off)
+			return false
+		}
+		if _, err := f.WriteAt(buf[:n], int64(off)); err != nil {
+			fmt.Fprintf(J.out, "can't write %s: %v\n", path, err)
+			return false
+		}
+		// keep data on disk even if the target hangs
+		f.Sync()
+
+		done := off + n
+		if percent := int(uint64(done) * 100 / uint64(length)); percent/10 != lastPercent/10 {
+			lastPercent = percent
+			rate := float64(done-start) / time.Since(began).Seconds() / 1024
+			fmt.Fprintf(J.out, "%d/%d bytes (%d%%), %.1f KiB/s\n", done, length, percent, rate)
+		}
+	}
+
+	blocks := (length + DUMP_BLOCK_LEN - 1) / DUMP_BLOCK_LEN
+	ok := true
+	for i := uint(0); i < verify; i += 1 {
+		off := uint32(rand.Intn(int(blocks))) * DUMP_BLOCK_LEN
+		n := length - off
+		if n > DUMP_BLOCK_LEN {
+			n = DUMP_BLOCK_LEN
+		}
+		saved := make([]byte, n)
+		if _, err := f.ReadAt(saved, int64(off)); err != nil {
+			panic(err)
+		}
+		if err := src.read(addr+off, buf[:n]); err != "" {
+			fmt.Fprintln(J.out, err)
+			return false
+		}
+		if !bytes.Equal(saved, buf[:n]) {
+			fmt.Fprintf(J.out, "block at offset 0x%x differs when read again\n", off)
+			ok = false
+		}
+	}
+	if !ok {
+		fmt.Fprintln(J.out, "WARNING: dump is not reliable, try slower TCK")
+		return false
+	}
+
+	fmt.Fprintf(J.out, "dumped %d bytes at 0x%08x to %s", length, addr, path)
+	if verify != 0 {
+		fmt.Fprintf(J.out, ", %d random blocks verified", verify)
+	}
+	fmt.Fprintln(J.out)
+	return true
+}
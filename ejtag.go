@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+)
+
+// MIPS EJTAG instructions, IR is 5 bits
+const (
+	EJTAG_IR_LEN     = 5
+	EJTAG_IR_IMPCODE = 0x03
+	EJTAG_IR_ADDRESS = 0x08
+	EJTAG_IR_DATA    = 0x09
+	EJTAG_IR_CONTROL = 0x0a
+)
+
+// EJTAG control register bits, DMA ones exist in EJTAG 2.0 and older
+const (
+	EJTAG_PRNW     = 1 << 19
+	EJTAG_PRACC    = 1 << 18
+	EJTAG_DMAACC   = 1 << 17
+	EJTAG_PROBEN   = 1 << 15
+	EJTAG_PROBTRAP = 1 << 14
+	EJTAG_EJTAGBRK = 1 << 12
+	EJTAG_DSTRT    = 1 << 11
+	EJTAG_DERR     = 1 << 10
+	EJTAG_DRWN     = 1 << 9
+	EJTAG_DMA_WORD = 2 << 7
+	EJTAG_DM       = 1 << 3
+
+	// IMPCODE bit telling DMA is not implemented
+	EJTAG_IMP_NODMA = 1 << 14
+)
+
+// Debug memory segment, the probe serves CPU accesses to it in PrAcc mode:
+// the debug exception vector where code is fetched from, and data area
+// used to pass values
+const (
+	EJTAG_DMSEG_DATA = 0xff200000
+	EJTAG_DMSEG_CODE = 0xff200200
+)
+
+const EJTAG_POLL_RETRIES = 100
+
+// MIPS EJTAG TAP of the target device, accessing memory by DMA or, if it is
+// not implemented, by feeding the CPU code through processor access (PrAcc).
+type Ejtag struct {
+	J   *Jtag
+	Dma bool
+
+	// instruction loaded, 0 if not known
+	ir uint32
+}
+
+// Shift 32-bit value through the register selected by ir.
+// TAP must be in Run-Test-Idle state before being called.
+// returns the value shifted out
+func (e *Ejtag) shift(ir, value uint32) uint32 {
+	if e.ir != ir {
+		e.J.sendDeviceInstruction(ir, EJTAG_IR_LEN)
+		e.ir = ir
+	}
+	in := make([]byte, 32)
+	for i := range in {
+		in[i] = '0' + byte((value>>uint(i))&1)
+	}
+	out := e.J.CHAIN.drExtract(e.J.sendData(e.J.CHAIN.drScan(in)), len(in))
+	ret := uint32(0)
+	for i, b := range out {
+		if b == '1' {
+			ret |= 1 << uint(i)
+		}
+	}
+	return ret
+}
+
+// Check the target device is an EJTAG TAP and stop its CPU in debug mode,
+// so it does not interfere with memory accesses.
+// TAP must be in Run-Test-Idle state with the target device selected.
+// returns nil and the reason if not EJTAG or the CPU does not stop
+func (J *Jtag) newEjtag() (*Ejtag, string) {
+	e := &Ejtag{J: J}
+	impcode := e.shift(EJTAG_IR_IMPCODE, 0)
+	if impcode == 0 || impcode == 0xffffffff {
+		return nil, "no EJTAG implementation code, not a MIPS EJTAG TAP"
+	}
+	e.Dma = impcode&EJTAG_IMP_NODMA == 0
+	fmt.Fprintf(J.out, "EJTAG IMPCODE 0x%08x, version %d, DMA %v\n", impcode, impcode>>29, e.Dma)
+
+	e.shift(EJTAG_IR_CONTROL, EJTAG_PRACC|EJTAG_PROBEN|EJTAG_PROBTRAP|EJTAG_EJTAGBRK)
+	for retry := 0; retry < EJTAG_POLL_RETRIES; retry += 1 {
+		if e.shift(EJTAG_IR_CONTROL, EJTAG_PRACC|EJTAG_PROBEN|EJTAG_PROBTRAP)&EJTAG_DM != 0 {
+			return e, ""
+		}
+	}
+	return nil, "CPU does not enter debug mode"
+}
+
+// Run single DMA transfer of a word.
+// returns word read and empty string, or the problem
+func (e *Ejtag) dma(addr uint32, read bool, value uint32) (uint32, string) {
+	e.shift(EJTAG_IR_ADDRESS, addr)
+	ctrl := uint32(EJTAG_DMAACC | EJTAG_DMA_WORD | EJTAG_DSTRT | EJTAG_PROBEN | EJTAG_PRACC)
+	if read {
+		ctrl |= EJTAG_DRWN
+	} else {
+		e.shift(EJTAG_IR_DATA, value)
+	}
+	e.shift(EJTAG_IR_CONTROL, ctrl)
+	done := false
+	for retry := 0; retry < EJTAG_POLL_RETRIES && !done; retry += 1 {
+		done = e.shift(EJTAG_IR_CONTROL, EJTAG_DMAACC|EJTAG_PROBEN|EJTAG_PRACC)&EJTAG_DSTRT == 0
+	}
+	if !done {
+		return 0, "DMA transfer does not finish"
+	}
+	if read {
+		value = e.shift(EJTAG_IR_DATA, 0)
+	}
+	if e.shift(EJTAG_IR_CONTROL, EJTAG_PROBEN|EJTAG_PRACC)&EJTAG_DERR != 0 {
+		return 0, fmt.Sprintf("DMA error at 0x%08x", addr)
+	}
+	return value, ""
+}
+
+// Let the CPU in debug mode run code, serving its accesses to dmseg: fetches
+// from the debug vector get code, data area accesses read and write data.
+// Returns once the last instruction is fetched, the code must branch back to
+// the debug vector so the CPU waits for the next run there.
+// returns description of the problem, empty if ok
+func (e *Ejtag) pracc(code []uint32, data []uint32) string {
+	for fetched := 0; fetched < len(code); {
+		ctrl := uint32(0)
+		for retry := 0; retry < EJTAG_POLL_RETRIES && ctrl&EJTAG_PRACC == 0; retry += 1 {
+			ctrl = e.shift(EJTAG_IR_CONTROL, EJTAG_PRACC|EJTAG_PROBEN|EJTAG_PROBTRAP)
+		}
+		if ctrl&EJTAG_PRACC == 0 {
+			return "CPU does not access debug memory"
+		}
+
+		addr := e.shift(EJTAG_IR_ADDRESS, 0)
+		switch {
+		case addr >= EJTAG_DMSEG_CODE && addr < EJTAG_DMSEG_CODE+4*uint32(len(code)):
+			if ctrl&EJTAG_PRNW != 0 {
+				return fmt.Sprintf("CPU writes to code at 0x%08x", addr)
+			}
+			i := (addr - EJTAG_DMSEG_CODE) / 4
+			e.shift(EJTAG_IR_DATA, code[i])
+			if int(i) == len(code)-1 {
+				fetched = len(code)
+			}
+		case addr >= EJTAG_DMSEG_DATA && addr < EJTAG_DMSEG_DATA+4*uint32(len(data)):
+			i := (addr - EJTAG_DMSEG_DATA) / 4
+			if ctrl&EJTAG_PRNW != 0 {
+				data[i] = e.shift(EJTAG_IR_DATA, 0)
+			} else {
+				e.shift(EJTAG_IR_DATA, data[i])
+			}
+		default:
+			return fmt.Sprintf("unexpected CPU access to 0x%08x", addr)
+		}
+
+		// Access served, let the CPU go on
+		e.shift(EJTAG_IR_CONTROL, EJTAG_PROBEN|EJTAG_PROBTRAP)
+	}
+	return ""
+}
+
+// MIPS32 instructions used by PrAcc code, registers: t0 ($8), t1 ($9),
+// t7 ($15) saved in DESAVE and pointing to dmseg data area
+func mipsLui(rt, imm uint32) uint32 {
+	return 0x3c000000 | rt<<16 | imm&0xffff
+}
+
+func mipsOri(rt, rs, imm uint32) uint32 {
+	return 0x34000000 | rs<<21 | rt<<16 | imm&0xffff
+}
+
+func mipsLw(rt, off, base uint32) uint32 {
+	return 0x8c000000 | base<<21 | rt<<16 | off&0xffff
+}
+
+func mipsSw(rt, off, base uint32) uint32 {
+	return 0xac000000 | base<<21 | rt<<16 | off&0xffff
+}
+
+// Wrap code so it saves t0, t1 and t7 first, restores them afterwards and
+// branches back to the debug vector.
+func mipsPraccCode(body ...uint32) []uint32 {
+	code := []uint32{
+		0x408ff800, // mtc0 $15, DESAVE
+		mipsLui(15, EJTAG_DMSEG_DATA>>16),
+		mipsSw(8, 0, 15),
+		mipsSw(9, 4, 15),
+	}
+	code = append(code, body...)
+	code = append(code,
+		mipsLw(8, 0, 15),
+		mipsLw(9, 4, 15),
+		0x400ff800, // mfc0 $15, DESAVE
+	)
+	// b EJTAG_DMSEG_CODE, then nop in the delay slot
+	offset := -(len(code) + 1)
+	return append(code, 0x10000000|uint32(offset)&0xffff, 0)
+}
+
+// returns 32-bit word at addr and empty string, or the problem
+func (e *Ejtag) readMem(addr uint32) (uint32, string) {
+	if e.Dma {
+		return e.dma(addr, true, 0)
+	}
+	// data area: saved t0, t1, the word read
+	data := make([]uint32, 3)
+	code := mipsPraccCode(
+		mipsLui(8, addr>>16),
+		mipsOri(8, 8, addr),
+		mipsLw(8, 0, 8),
+		mipsSw(8, 8, 15),
+	)
+	if err := e.pracc(code, data); err != "" {
+		return 0, err
+	}
+	return data[2], ""
+}
+
+// returns description of the problem, empty if ok
+func (e *Ejtag) writeMem(addr, value uint32) string {
+	if e.Dma {
+		_, err := e.dma(addr, false, value)
+		return err
+	}
+	data := make([]uint32, 2)
+	code := mipsPraccCode(
+		mipsLui(8, addr>>16),
+		mipsOri(8, 8, addr),
+		mipsLui(9, value>>16),
+		mipsOri(9, 9, value),
+		mipsSw(9, 0, 8),
+	)
+	return e.pracc(code, data)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Event published for lab and factory automation
+type Event struct {
+	// pinout_found or command_done
+	Type    string        `json:"type"`
+	Time    time.Time     `json:"time"`
+	Command string        `json:"command"`
+	Board   string        `json:"board,omitempty"`
+	Pinout  *PinoutResult `json:"pinout,omitempty"`
+	// for command_done, false if a test failed
+	Passed *bool `json:"passed,omitempty"`
+}
+
+// Where to publish events, empty fields disable the destination
+type Publisher struct {
+	// URL to POST every event to as JSON
+	Webhook string
+	// MQTT broker host:port and topic to publish events to
+	MqttBroker string
+	MqttTopic  string
+	// shell command to run for every event
+	Hook string
+}
+
+func (p *Publisher) enabled() bool {
+	return p != nil && (p.Webhook != "" || p.MqttBroker != "" || p.Hook != "")
+}
+
+func (J *Jtag) eventBoard() string {
+	if J.results.Meta != nil {
+		return J.results.Meta.Board
+	}
+	return ""
+}
+
+// Events describing pinouts found since the last call, which aren't
+// published yet.
+func (J *Jtag) pinoutEvents() []Event {
+	now := time.Now()
+	events := []Event{}
+	for i := J.publishedPinouts; i < len(J.results.Pinouts); i += 1 {
+		events = append(events, Event{Type: "pinout_found", Time: now, Command: J.results.Command,
+			Board: J.eventBoard(), Pinout: &J.results.Pinouts[i]})
+	}
+	J.publishedPinouts = len(J.results.Pinouts)
+	return events
+}
+
+// Called by scans once details of a pinout are complete: publishes it right
+// away, so long scans can trigger the next stage before they finish.
+func (J *Jtag) pinoutDone() {
+	if J.publish.enabled() {
+		J.publishEvents(J.publish, J.pinoutEvents())
+	}
+}
+
+// Events describing results of the command just run.
+func (J *Jtag) resultEvents(passed bool) []Event {
+	events := J.pinoutEvents()
+	return append(events, Event{Type: "command_done", Time: time.Now(), Command: J.results.Command,
+		Board: J.eventBoard(), Passed: &passed})
+}
+
+func (p *Publisher) postWebhook(payloads [][]byte) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	for _, payload := range payloads {
+		resp, err := client.Post(p.Webhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// MQTT 3.1.1 remaining length encoding
+func mqttLength(n int) []byte {
+	ret := []byte{}
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		ret = append(ret, b)
+		if n == 0 {
+			return ret
+		}
+	}
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	return append(append([]byte{header}, mqttLength(len(body))...), body...)
+}
+
+// Publish payloads with QoS 0 over a plain MQTT 3.1.1 connection.
+func (p *Publisher) publishMqtt(payloads [][]byte) error {
+	conn, err := net.DialTimeout("tcp", p.MqttBroker, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// protocol name and level 4, clean session, keep alive 60s
+	connect := append(mqttString("MQTT"), 4, 0x02, 0, 60)
+	connect = append(connect, mqttString(fmt.Sprintf("jtagenum-%d", os.Getpid()))...)
+	if _, err := conn.Write(mqttPacket(0x10, connect)); err != nil {
+		return err
+	}
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		return err
+	}
+	if connack[0] != 0x20 || connack[3] != 0 {
+		return fmt.Errorf("broker refused connection, code %d", connack[3])
+	}
+
+	for _, payload := range payloads {
+		if _, err := conn.Write(mqttPacket(0x30, append(mqttString(p.MqttTopic), payload...))); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write([]byte{0xe0, 0})
+	return err
+}
+
+// Publish events to every configured destination. Failures are reported,
+// but don't affect the command result.
+func (J *Jtag) publishEvents(p *Publisher, events []Event) {
+	payloads := [][]byte{}
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			panic(err)
+		}
+		payloads = append(payloads, data)
+	}
+	if p.Webhook != "" {
+		if err := p.postWebhook(payloads); err != nil {
+			fmt.Fprintf(J.out, "warning: can't post events to webhook: %v\n", err)
+		}
+	}
+	if p.MqttBroker != "" {
+		if err := p.publishMqtt(payloads); err != nil {
+			fmt.Fprintf(J.out, "warning: can't publish events to MQTT: %v\n", err)
+		}
+	}
+	if p.Hook != "" {
+		for i, e := range events {
+			if err := J.runHook(p.Hook, e, payloads[i]); err != "" {
+				fmt.Fprintf(J.out, "warning: hook failed for %s: %s\n", e.Type, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version field of IDCODE
+const IDCODE_VERSION_MASK = uint32(0xf0000000)
+
+// Manufacturer field of IDCODE (JEP106 bank and id)
+const IDCODE_MFG_MASK = uint32(0x00000ffe)
+
+// Expected IDCODE, only bits set in Mask are compared
+type IdcodeMatch struct {
+	Value uint32
+	Mask  uint32
+}
+
+func parseIdcode(s string) uint32 {
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		panic(err)
+	}
+	return uint32(v)
+}
+
+// Find manufacturer by its JEP106 name, exact match (case-insensitive) is
+// preferred over substring one.
+// returns manufacturer field of IDCODE
+func jep106Lookup(name string) uint32 {
+	name = strings.ToLower(name)
+	found := []uint32{}
+	for bank := uint32(0); bank < 16; bank += 1 {
+		for id := uint32(1); ; id += 1 {
+			mfg := Jep106Manufacturer(bank, id)
+			if mfg == "invalid" {
+				break
+			}
+			if strings.ToLower(mfg) == name {
+				return bank<<8 | id<<1
+			}
+			if strings.Contains(strings.ToLower(mfg), name) {
+				found = append(found, bank<<8|id<<1)
+			}
+		}
+	}
+	if len(found) != 1 {
+		panic(fmt.Sprintf("manufacturer '%s' matches %d JEP106 entries", name, len(found)))
+	}
+	return found[0]
+}
+
+// Parse expected IDCODE in form of "value" or "value/mask", e.g.
+// "0x4ba00477" or "0x4ba00477/0x0fffffff", or manufacturer only as
+// "mfg:<JEP106 name or code>", e.g. "mfg:Broadcom" or "mfg:0x0bf".
+func parseIdcodeMatch(s string, anyVersion bool) IdcodeMatch {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "mfg:") {
+		mfg := strings.TrimPrefix(s, "mfg:")
+		if v, err := strconv.ParseUint(mfg, 0, 12); err == nil {
+			return IdcodeMatch{Value: uint32(v) << 1, Mask: IDCODE_MFG_MASK}
+		}
+		return IdcodeMatch{Value: jep106Lookup(mfg), Mask: IDCODE_MFG_MASK}
+	}
+
+	m := IdcodeMatch{Mask: 0xffffffff}
+	parts := strings.SplitN(s, "/", 2)
+	m.Value = parseIdcode(parts[0])
+	if len(parts) == 2 {
+		m.Mask = parseIdcode(parts[1])
+	}
+	if anyVersion {
+		m.Mask &^= IDCODE_VERSION_MASK
+	}
+	return m
+}
+
+// Parse comma separated list of expected IDCODEs.
+func parseIdcodeMatches(s string, anyVersion bool) []IdcodeMatch {
+	ret := []IdcodeMatch{}
+	for _, e := range strings.Split(s, ",") {
+		if len(strings.TrimSpace(e)) != 0 {
+			ret = append(ret, parseIdcodeMatch(e, anyVersion))
+		}
+	}
+	return ret
+}
+
+func (m IdcodeMatch) matches(idcode uint32) bool {
+	return idcode&m.Mask == m.Value&m.Mask
+}
+
+func (m IdcodeMatch) String() string {
+	if m.Mask == IDCODE_MFG_MASK {
+		return fmt.Sprintf("mfg:0x%03x (%s)", m.Value>>1,
+			Jep106Manufacturer((m.Value&0xf00)>>8, (m.Value&0xfe)>>1))
+	}
+	if m.Mask == 0xffffffff {
+		return fmt.Sprintf("0x%08x", m.Value)
+	}
+	return fmt.Sprintf("0x%08x/0x%08x", m.Value&m.Mask, m.Mask)
+}
+
+// returns true if any of IDCODEs matches any of filters, or if there are no
+// filters
+func matchAnyIdcode(idcodes []uint32, filters []IdcodeMatch) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, idcode := range validIdcodes(idcodes) {
+		for _, f := range filters {
+			if f.matches(idcode) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Compare IDCODEs read from the chain with expected ones.
+// returns empty string if they match, otherwise the reason of mismatch
+func checkExpectedIdcodes(idcodes []uint32, expected []IdcodeMatch) string {
+	found := validIdcodes(idcodes)
+	if len(expected) == 0 {
+		if len(found) == 0 {
+			return "no devices found"
+		}
+		return ""
+	}
+	if len(found) != len(expected) {
+		return fmt.Sprintf("expected %d devices, found %d", len(expected), len(found))
+	}
+	for i, e := range expected {
+		if !e.matches(found[i]) {
+			return fmt.Sprintf("device %d: expected %s, got 0x%08x", i, e, found[i])
+		}
+	}
+	return ""
+}
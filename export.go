@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Formats results can be saved in
+var outputFormats = []string{"json", "csv"}
+
+// Device of a chain with its IDCODE decoded
+type DeviceResult struct {
+	// as 0x%08x, empty for a device without IDCODE (BYPASS)
+	Idcode       string `json:"idcode,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	// JEP106 bank and ID as the 11-bit IDCODE field
+	MfgId   uint32 `json:"mfg_id,omitempty"`
+	Part    uint32 `json:"part,omitempty"`
+	Version uint32 `json:"version,omitempty"`
+}
+
+func decodeIdcode(idcode uint32) DeviceResult {
+	if idcode == BYPASS_IDCODE {
+		return DeviceResult{}
+	}
+	return DeviceResult{
+		Idcode:       fmt.Sprintf("0x%08x", idcode),
+		Manufacturer: Jep106Manufacturer((idcode&0xf00)>>8, (idcode&0xfe)>>1),
+		MfgId:        (idcode & 0xffe) >> 1,
+		Part:         (idcode & 0xffff000) >> 12,
+		Version:      (idcode & 0xf0000000) >> 28,
+	}
+}
+
+func decodeIdcodes(idcodes []uint32) []DeviceResult {
+	ret := []DeviceResult{}
+	for _, idcode := range idcodes {
+		ret = append(ret, decodeIdcode(idcode))
+	}
+	return ret
+}
+
+// Fill decoded devices in from IDCODEs, so readers of saved results don't
+// have to decode them.
+func (r *ScanResult) decodeDevices() {
+	r.Devices = nil
+	if len(r.Idcodes) != 0 {
+		r.Devices = decodeIdcodes(r.Idcodes)
+	}
+	for i := range r.Pinouts {
+		p := &r.Pinouts[i]
+		p.Devices = nil
+		if len(p.Idcodes) != 0 {
+			p.Devices = decodeIdcodes(p.Idcodes)
+		}
+	}
+}
+
+// Tell file and format of -output: a format name takes the file from
+// -out-file, anything else is a JSON file as it always was. -out-file alone
+// is CSV if it ends with .csv, JSON otherwise.
+// returns path, format and empty string, or description of the problem
+func outputTarget(output, outFile string) (string, string, string) {
+	for _, f := range outputFormats {
+		if output == f {
+			if len(outFile) == 0 {
+				return "", "", fmt.Sprintf("give file to save %s results to with -out-file", f)
+			}
+			return outFile, f, ""
+		}
+	}
+	if len(output) != 0 {
+		if len(outFile) != 0 {
+			return "", "", "-output is a file already, give -output json or csv with -out-file"
+		}
+		return output, "json", ""
+	}
+	if strings.HasSuffix(strings.ToLower(outFile), ".csv") {
+		return outFile, "csv", ""
+	}
+	return outFile, "json", ""
+}
+
+// Save results in the format, JSON as -output always wrote it or CSV.
+func writeResults(path, format string, r ScanResult) {
+	r.decodeDevices()
+	if format == "csv" {
+		saveResultsCsv(path, r)
+		return
+	}
+	saveResults(path, r)
+}
+
+var resultsCsvHeader = []string{
+	"command", "tck", "tms", "tdo", "tdi", "device_count", "possible_trst",
+	"device", "idcode", "manufacturer", "mfg_id", "part", "version",
+}
+
+// Save results as CSV, a row per device of every pinout, a pinout without
+// IDCODEs (scan_bypass) has a single row. Devices found on known pins
+// (chain_info, test_idcode) have no pins.
+func saveResultsCsv(path string, r ScanResult) {
+	rows := [][]string{resultsCsvHeader}
+	device := func(pins []string, i int, d DeviceResult) []string {
+		ret := append([]string{r.Command}, pins...)
+		ret = append(ret, fmt.Sprintf("%d", i), d.Idcode, d.Manufacturer)
+		if d.Idcode == "" {
+			return append(ret, "", "", "")
+		}
+		return append(ret, fmt.Sprintf("0x%03x", d.MfgId), fmt.Sprintf("0x%04x", d.Part), fmt.Sprintf("0x%x", d.Version))
+	}
+	for _, p := range r.Pinouts {
+		pins := []string{p.TCK, p.TMS, p.TDO, p.TDI, "", strings.Join(p.PossibleTRST, " ")}
+		if p.DeviceCount != 0 {
+			pins[4] = fmt.Sprintf("%d", p.DeviceCount)
+		}
+		if len(p.Devices) == 0 {
+			rows = append(rows, append(append([]string{r.Command}, pins...), "", "", "", "", "", ""))
+			continue
+		}
+		for i, d := range p.Devices {
+			rows = append(rows, device(pins, i, d))
+		}
+	}
+	for i, d := range r.Devices {
+		rows = append(rows, device([]string{"", "", "", "", fmt.Sprintf("%d", len(r.Devices)), ""}, i, d))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		panic(err)
+	}
+}
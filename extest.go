@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Ports which are never driven, whatever the allow-list says
+var powerPortRe = regexp.MustCompile(`(?i)(vcc|vdd|vss|gnd|vref|pwr|power)`)
+
+// Ports never driven either, unless meant to carry a clock as SPI SCK
+var clockPortRe = regexp.MustCompile(`(?i)(clk|clock|xtal|osc)`)
+
+func bitOf(state JtagPinState) byte {
+	if state == StateHigh {
+		return '1'
+	}
+	return '0'
+}
+
+// Parse comma separated list of port=state, e.g. "IO_A0=1,IO_A1=0".
+func parsePortStates(s string) map[string]JtagPinState {
+	ret := map[string]JtagPinState{}
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(kv) != 2 || (kv[1] != "0" && kv[1] != "1") {
+			if len(strings.TrimSpace(e)) != 0 {
+				panic(fmt.Sprintf("invalid port state '%s', use PORT=0 or PORT=1", e))
+			}
+			continue
+		}
+		ret[kv[0]] = JtagPinState(kv[1][0] - '0')
+	}
+	return ret
+}
+
+// Parse comma separated list of port names.
+func parsePortList(s string) map[string]bool {
+	ret := map[string]bool{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); len(e) != 0 {
+			ret[strings.ToUpper(e)] = true
+		}
+	}
+	return ret
+}
+
+// Boundary register values, in cell order, putting every cell into its safe
+// state and disabling every output having a control cell: a control cell
+// with safe value X would enable its output if loaded with 0 but disval is 1.
+func (b *Bsdl) safeVector() []byte {
+	v := make([]byte, b.BoundaryLen)
+	for i := range v {
+		v[i] = '0'
+	}
+	for _, c := range b.Cells {
+		if c.Num < len(v) && c.Safe == '1' {
+			v[c.Num] = '1'
+		}
+	}
+	for _, c := range b.Cells {
+		if c.Ccell >= 0 && c.Ccell < len(v) && (c.Disval == '0' || c.Disval == '1') {
+			v[c.Ccell] = c.Disval
+		}
+	}
+	return v
+}
+
+// Check that the port may be driven at all: it must be explicitly allowed,
+// must not be a power/ground/clock net and must have an output cell.
+// returns empty string if ok, otherwise the reason
+func (b *Bsdl) checkDrivable(port string, allow map[string]bool) string {
+	if reason := b.checkAllowed(port, allow, false); reason != "" {
+		return reason
+	}
+	return b.checkOutput(port)
+}
+
+// Check that the port is allowed and is not a power/ground/clock net.
+// clockName -- the port is meant to carry a clock the tool generates, e.g.
+// SPI SCK, so a name looking like a clock net is not refused
+// returns empty string if ok, otherwise the reason
+func (b *Bsdl) checkAllowed(port string, allow map[string]bool, clockName bool) string {
+	if !allow[strings.ToUpper(port)] {
+		return fmt.Sprintf("port %s is not in the allow-list", port)
+	}
+	if b.Linkage[port] || powerPortRe.MatchString(port) || (!clockName && clockPortRe.MatchString(port)) {
+		return fmt.Sprintf("port %s looks like a power or clock net", port)
+	}
+	for _, c := range b.Cells {
+		if strings.EqualFold(c.Port, port) && c.Function == "clock" {
+			return fmt.Sprintf("port %s is a clock input", port)
+		}
+	}
+	return ""
+}
+
+// Check that the port has an output cell which can be driven.
+// returns empty string if ok, otherwise the reason
+func (b *Bsdl) checkOutput(port string) string {
+	if b.Linkage[port] {
+		return fmt.Sprintf("port %s is a linkage port", port)
+	}
+	if b.outputCell(port) == nil {
+		return fmt.Sprintf("port %s has no output cell", port)
+	}
+	return ""
+}
+
+// Build boundary register values driving the given ports, the rest is left
+// in safe state.
+// returns vector in cell order and empty string, or the reason it can't be
+// done
+func (b *Bsdl) extestVector(drive map[string]JtagPinState, allow map[string]bool) ([]byte, string) {
+	v := b.safeVector()
+	for port, state := range drive {
+		if reason := b.checkDrivable(port, allow); reason != "" {
+			return nil, reason
+		}
+		b.setOutput(v, port, bitOf(state))
+	}
+	return v, ""
+}
+
+// Drive port in boundary register vector, enabling its output.
+func (b *Bsdl) setOutput(v []byte, port string, bit byte) {
+	c := b.outputCell(port)
+	v[c.Num] = bit
+	if c.Ccell >= 0 {
+		if c.Disval == '0' {
+			v[c.Ccell] = '1'
+		} else {
+			v[c.Ccell] = '0'
+		}
+	}
+}
+
+// Disable output of the port in boundary register vector.
+func (b *Bsdl) releaseOutput(v []byte, port string) {
+	c := b.outputCell(port)
+	if c.Ccell >= 0 {
+		v[c.Ccell] = c.Disval
+	}
+}
+
+// Shift vector given in cell order through the boundary register of the
+// target device. Cell 0 is the closest to TDO: its captured value is shifted
+// out first, and the first bit shifted in travels all the way to it, so both
+// vectors are in shift order.
+// TAP must be in Run-Test-Idle state before being called.
+// returns captured values in cell order
+func (J *Jtag) shiftBoundary(v []byte) []byte {
+	in := J.CHAIN.drScan(v)
+	out := []byte{}
+	J.shiftDrStream(len(in), DR_CHUNK_LEN, func(start int) []byte { return in[start:] },
+		func(chunk []byte) { out = append(out, chunk...) })
+	return J.CHAIN.drExtract(out, len(v))
+}
+
+// Select the target device on known pins and check it matches BSDL.
+// returns IR length of the device, 0 on failure
+func (J *Jtag) prepareDevice(bsdl *Bsdl) uint32 {
+	if bsdl == nil {
+		fmt.Fprintln(J.out, "BSDL file is required, give it with -bsdl")
+		return 0
+	}
+
+	J.useKnownPins()
+
+	J.initPins()
+
+	info := J.chainInfo()
+	if info.Devices == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return 0
+	}
+	if reason := J.CHAIN.check(info.Devices, info.IrLength); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return 0
+	}
+
+	irLen := J.CHAIN.irLen(info.IrLength)
+	if irLen != bsdl.IrLen {
+		fmt.Fprintf(J.out, "IR length %d detected, BSDL says %d, wrong BSDL?\n", irLen, bsdl.IrLen)
+		return 0
+	}
+	return irLen
+}
+
+// Select the target device on known pins and check it matches BSDL
+// describing its boundary register.
+// returns IR length of the device, 0 on failure
+func (J *Jtag) prepareBoundary(bsdl *Bsdl) uint32 {
+	if bsdl != nil && (bsdl.BoundaryLen == 0 || len(bsdl.Cells) == 0) {
+		fmt.Fprintln(J.out, "BSDL does not describe boundary register")
+		return 0
+	}
+	return J.prepareDevice(bsdl)
+}
+
+// Preload boundary register and switch to EXTEST, so the device pins take
+// the values given.
+// returns false if instructions are not described in BSDL
+func (J *Jtag) enterExtest(bsdl *Bsdl, v []byte) bool {
+	sample, ok := bsdl.opcode("SAMPLE", "SAMPLE/PRELOAD", "SAMPLE_PRELOAD", "PRELOAD")
+	if !ok {
+		fmt.Fprintln(J.out, "no SAMPLE/PRELOAD instruction in BSDL")
+		return false
+	}
+	extest, ok := bsdl.opcode("EXTEST")
+	if !ok {
+		fmt.Fprintln(J.out, "no EXTEST instruction in BSDL")
+		return false
+	}
+
+	J.setTapState(TAP_RESET)
+	// Preload values first, so pins don't glitch when EXTEST takes effect
+	J.sendDeviceInstruction(sample, bsdl.IrLen)
+	J.shiftBoundary(v)
+	J.sendDeviceInstruction(extest, bsdl.IrLen)
+	return true
+}
+
+// Drive ports of the target device via EXTEST for a while, then reset the
+// TAP to give pins back to the device. Only ports listed in allow may be
+// driven, power and clock nets are refused anyway.
+// returns true if ports were driven
+func (J *Jtag) extest(bsdl *Bsdl, drive map[string]JtagPinState, allow map[string]bool, hold time.Duration) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting EXTEST...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	if len(drive) == 0 {
+		fmt.Fprintln(J.out, "nothing to drive, give ports with -drive")
+		return false
+	}
+	if J.prepareBoundary(bsdl) == 0 {
+		return false
+	}
+
+	v, reason := bsdl.extestVector(drive, allow)
+	if reason != "" {
+		fmt.Fprintf(J.out, "refusing to drive: %s\n", reason)
+		return false
+	}
+
+	if !J.enterExtest(bsdl, v) {
+		return false
+	}
+	// Reset TAP to Run-Test-Idle, pins are given back to the device
+	defer J.setTapState(TAP_RESET)
+
+	for port, state := range drive {
+		fmt.Fprintf(J.out, "driving %s=%d\n", port, state)
+	}
+
+	// Read back what the pins actually are
+	captured := J.shiftBoundary(v)
+	for port := range drive {
+		if c := bsdl.inputCell(port); c != nil {
+			fmt.Fprintf(J.out, "%s reads %c\n", port, captured[c.Num])
+		}
+	}
+
+	fmt.Fprintf(J.out, "holding for %v\n", hold)
+	time.Sleep(hold)
+	return true
+}
+
+// Device pins controlled through boundary register while in EXTEST, for
+// bit-banging buses attached to the device.
+type BoundaryPins struct {
+	J    *Jtag
+	bsdl *Bsdl
+	// values to shift in, cell order
+	v []byte
+	// values captured by the last update, cell order
+	captured []byte
+}
+
+// Enter EXTEST with all cells in safe state.
+// returns nil if EXTEST can't be entered
+func (J *Jtag) newBoundaryPins(bsdl *Bsdl) *BoundaryPins {
+	p := &BoundaryPins{J: J, bsdl: bsdl, v: bsdl.safeVector()}
+	if !J.enterExtest(bsdl, p.v) {
+		return nil
+	}
+	return p
+}
+
+// Drive the port on next update.
+func (p *BoundaryPins) set(port string, state JtagPinState) {
+	p.bsdl.setOutput(p.v, port, bitOf(state))
+}
+
+// Stop driving the port on next update.
+func (p *BoundaryPins) release(port string) {
+	p.bsdl.releaseOutput(p.v, port)
+}
+
+// Apply pending changes. Pins are captured before the new values take effect,
+// so get() returns pin states as they were set by the previous update.
+func (p *BoundaryPins) update() {
+	p.captured = p.J.shiftBoundary(p.v)
+}
+
+// Port state captured by the last update.
+func (p *BoundaryPins) get(port string) JtagPinState {
+	c := p.bsdl.inputCell(port)
+	if c == nil || p.captured == nil || p.captured[c.Num] != '1' {
+		return StateLow
+	}
+	return StateHigh
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// One TCK cycle of that many is timed
+const GAP_CHECK_CYCLES = 8
+
+// Account TCK cycle started at start if it took longer than MAX_GAP on top
+// of the TCK delays, which means the process was preempted (Wi-Fi interrupts,
+// other tasks) long enough to plausibly violate target timing.
+func (J *Jtag) checkGap(start time.Time) {
+	d := time.Since(start) - 2*time.Duration(J.DELAY_TCK)*time.Microsecond
+	if d <= J.MAX_GAP {
+		return
+	}
+	J.stats.TimingGaps += 1
+	if d > J.stats.LongestGap {
+		J.stats.LongestGap = d
+	}
+}
+
+// Print a warning if timing gaps were seen while running the command.
+func (J *Jtag) warnGaps() {
+	if J.stats.TimingGaps == 0 {
+		return
+	}
+	fmt.Fprintf(J.out, "WARNING: %d TCK cycles were delayed by more than %v (longest %v), the process was preempted, data may be wrong; retry on a quieter system or give -gap-retry\n",
+		J.stats.TimingGaps, J.MAX_GAP, J.stats.LongestGap.Round(time.Microsecond))
+}
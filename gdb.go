@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often the core is checked for halt while gdb waits after 'continue'
+const GDB_POLL_INTERVAL = 100 * time.Millisecond
+
+// Target description given to gdb: Cortex-M registers in the order of
+// cortexMRegs, which is the order of DCRSR register selectors, so register
+// numbers of 'g' and 'p' packets are the selectors
+var gdbTargetXml = func() string {
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0"?><!DOCTYPE target SYSTEM "gdb-target.dtd">`)
+	b.WriteString(`<target><architecture>arm</architecture><feature name="org.gnu.gdb.arm.m-profile">`)
+	for _, name := range cortexMRegs {
+		typ := "uint32"
+		switch name {
+		case "sp":
+			typ = "data_ptr"
+		case "pc":
+			typ = "code_ptr"
+		}
+		fmt.Fprintf(b, `<reg name="%s" bitsize="32" type="%s"/>`, name, typ)
+	}
+	b.WriteString(`</feature></target>`)
+	return b.String()
+}()
+
+// GDB remote serial protocol connection
+type gdbConn struct {
+	conn net.Conn
+	in   *bufio.Reader
+}
+
+// Read the next packet, acknowledging it. Acks of our packets are skipped.
+// returns packet data, "\x03" for interrupt request, and nil, or the error
+// reading the connection
+func (g *gdbConn) readPacket() (string, error) {
+	for {
+		c, err := g.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case 0x03:
+			return "\x03", nil
+		case '$':
+			data, err := g.in.ReadString('#')
+			if err != nil {
+				return "", err
+			}
+			if _, err := g.in.Discard(2); err != nil {
+				return "", err
+			}
+			if _, err := g.conn.Write([]byte{'+'}); err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(data, "#"), nil
+		}
+	}
+}
+
+func (g *gdbConn) writePacket(data string) error {
+	sum := byte(0)
+	for i := 0; i < len(data); i += 1 {
+		sum += data[i]
+	}
+	_, err := fmt.Fprintf(g.conn, "$%s#%02x", data, sum)
+	return err
+}
+
+// GDB stub session on a halted Cortex-M core
+type gdbStub struct {
+	J   *Jtag
+	dap *Dap
+	g   *gdbConn
+}
+
+// Check that length bytes at addr fit in the 32-bit address space.
+// returns the end of the range, 64-bit so it doesn't wrap to 0, and
+// description of the problem, empty if ok
+func memRangeEnd(addr uint32, length uint64) (uint64, string) {
+	end := uint64(addr) + length
+	if end > 1<<32 {
+		return 0, fmt.Sprintf("%d bytes at 0x%08x run past the end of the address space", length, addr)
+	}
+	return end, ""
+}
+
+// Read bytes of target memory word by word, words are little-endian.
+func (s *gdbStub) readMem(addr, length uint32) ([]byte, string) {
+	end, reason := memRangeEnd(addr, uint64(length))
+	if reason != "" {
+		return nil, reason
+	}
+	ret := []byte{}
+	for a := uint64(addr &^ 3); a < end; a += 4 {
+		v, err := s.dap.readMem(uint32(a))
+		if err != "" {
+			return nil, err
+		}
+		for i := uint64(0); i < 4; i += 1 {
+			if a+i >= uint64(addr) && a+i < end {
+				ret = append(ret, byte(v>>(8*i)))
+			}
+		}
+	}
+	return ret, ""
+}
+
+// Write bytes of target memory word by word, partial words are read first.
+func (s *gdbStub) writeMem(addr uint32, data []byte) string {
+	end, reason := memRangeEnd(addr, uint64(len(data)))
+	if reason != "" {
+		return reason
+	}
+	for a := uint64(addr &^ 3); a < end; a += 4 {
+		v := uint32(0)
+		if a < uint64(addr) || a+4 > end {
+			err := ""
+			if v, err = s.dap.readMem(uint32(a)); err != "" {
+				return err
+			}
+		}
+		for i := uint64(0); i < 4; i += 1 {
+			if a+i >= uint64(addr) && a+i < end {
+				v = v&^(0xff<<(8*i)) | uint32(data[a+i-uint64(addr)])<<(8*i)
+			}
+		}
+		if err := s.dap.writeMem(uint32(a), v); err != "" {
+			return err
+		}
+	}
+	return ""
+}
+
+// returns 32-bit value as little-endian hex, as gdb expects registers
+func gdbHex32(v uint32) string {
+	return hex.EncodeToString([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+}
+
+// Parse 'ADDR,LENGTH' of memory packets.
+func gdbAddrLen(s string) (uint32, uint32, bool) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err1 := strconv.ParseUint(parts[0], 16, 32)
+	length, err2 := strconv.ParseUint(parts[1], 16, 32)
+	return uint32(addr), uint32(length), err1 == nil && err2 == nil
+}
+
+// Let the core run until it halts on its own (breakpoint, fault) or gdb
+// sends an interrupt, which halts it.
+// returns description of the problem, empty if ok
+func (s *gdbStub) waitHalt() string {
+	for {
+		v, err := s.dap.readMem(CM_DHCSR)
+		if err != "" {
+			return err
+		}
+		if v&CM_S_HALT != 0 {
+			return ""
+		}
+		s.g.conn.SetReadDeadline(time.Now().Add(GDB_POLL_INTERVAL))
+		c, rerr := s.g.in.ReadByte()
+		s.g.conn.SetReadDeadline(time.Time{})
+		if rerr == nil && c == 0x03 {
+			return s.dap.halt()
+		}
+		if ne, ok := rerr.(net.Error); rerr != nil && !(ok && ne.Timeout()) {
+			return rerr.Error()
+		}
+	}
+}
+
+// Handle one packet.
+// returns reply, false if the session is over
+func (s *gdbStub) handle(p string) (string, bool) {
+	if len(p) == 0 {
+		return "", true
+	}
+	fail := func(err string) string {
+		fmt.Fprintf(s.J.out, "gdb: %s\n", err)
+		return "E01"
+	}
+	switch p[0] {
+	case '?', 0x03:
+		if p[0] == 0x03 {
+			if err := s.dap.halt(); err != "" {
+				return fail(err), true
+			}
+		}
+		return "S05", true
+	case 'g':
+		b := &strings.Builder{}
+		for n := range cortexMRegs {
+			v, err := s.dap.readReg(uint32(n))
+			if err != "" {
+				return fail(err), true
+			}
+			b.WriteString(gdbHex32(v))
+		}
+		return b.String(), true
+	case 'p':
+		n, err := strconv.ParseUint(p[1:], 16, 32)
+		if err != nil || int(n) >= len(cortexMRegs) {
+			return "E01", true
+		}
+		v, rerr := s.dap.readReg(uint32(n))
+		if rerr != "" {
+			return fail(rerr), true
+		}
+		return gdbHex32(v), true
+	case 'P':
+		parts := strings.SplitN(p[1:], "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 32)
+		if err != nil || len(parts) != 2 || int(n) >= len(cortexMRegs) {
+			return "E01", true
+		}
+		b, err := hex.DecodeString(parts[1])
+		if err != nil || len(b) != 4 {
+			return "E01", true
+		}
+		v := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+		if err := s.dap.writeReg(uint32(n), v); err != "" {
+			return fail(err), true
+		}
+		return "OK", true
+	case 'm':
+		addr, length, ok := gdbAddrLen(p[1:])
+		if !ok {
+			return "E01", true
+		}
+		data, err := s.readMem(addr, length)
+		if err != "" {
+			return fail(err), true
+		}
+		return hex.EncodeToString(data), true
+	case 'M':
+		parts := strings.SplitN(p[1:], ":", 2)
+		addr, _, ok := gdbAddrLen(parts[0])
+		if !ok || len(parts) != 2 {
+			return "E01", true
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return "E01", true
+		}
+		if err := s.writeMem(addr, data); err != "" {
+			return fail(err), true
+		}
+		return "OK", true
+	case 'c', 's':
+		if len(p) > 1 {
+			// continue or step at an address is not supported
+			return "E01", true
+		}
+		err := ""
+		if p[0] == 's' {
+			err = s.dap.step()
+		} else if err = s.dap.resume(); err == "" {
+			err = s.waitHalt()
+		}
+		if err != "" {
+			return fail(err), true
+		}
+		return "S05", true
+	case 'D':
+		s.dap.resume()
+		return "OK", false
+	case 'k':
+		s.dap.resume()
+		return "", false
+	case 'H':
+		return "OK", true
+	case 'q':
+		switch {
+		case strings.HasPrefix(p, "qSupported"):
+			return "PacketSize=1000;qXfer:features:read+", true
+		case p == "qAttached":
+			return "1", true
+		case strings.HasPrefix(p, "qXfer:features:read:target.xml:"):
+			off, length, ok := gdbAddrLen(strings.TrimPrefix(p, "qXfer:features:read:target.xml:"))
+			if !ok {
+				return "E01", true
+			}
+			if off >= uint32(len(gdbTargetXml)) {
+				return "l", true
+			}
+			end := off + length
+			if end >= uint32(len(gdbTargetXml)) {
+				return "l" + gdbTargetXml[off:], true
+			}
+			return "m" + gdbTargetXml[off:end], true
+		}
+	}
+	// not supported
+	return "", true
+}
+
+// Serve gdb sessions over TCP on the target device, an ARM debug port: gdb
+// reads and writes memory and core registers of the Cortex-M behind MEM-AP
+// #0, halts, steps and continues it, without configuring OpenOCD. The core
+// is halted while gdb is connected and resumed when it detaches, 'kill'
+// ends the command. No breakpoints are set in hardware, gdb falls back to
+// writing breakpoint instructions, which works in RAM only.
+// addr -- host:port to listen on
+// returns false if the target can't be debugged
+func (J *Jtag) gdbServer(addr string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting GDB server...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	J.useKnownPins()
+
+	J.initPins()
+
+	info := J.chainInfo()
+	if info.Devices == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return false
+	}
+	if reason := J.CHAIN.check(info.Devices, info.IrLength); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	idcode := info.Idcodes[J.CHAIN.Device]
+	if !isArmDp(idcode) {
+		fmt.Fprintf(J.out, "target device %s is not an ARM debug port\n", describeChainIdcode(idcode))
+		return false
+	}
+	dap := J.newDap(0)
+	if err := dap.powerUp(); err != "" {
+		fmt.Fprintf(J.out, "ARM DAP can't be powered up: %s\n", err)
+		return false
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintln(J.out, err)
+		return false
+	}
+	defer l.Close()
+
+	for {
+		fmt.Fprintf(J.out, "waiting for gdb on %s, e.g. gdb -ex 'target extended-remote %s'\n", l.Addr(), l.Addr())
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Fprintln(J.out, err)
+			return false
+		}
+		fmt.Fprintf(J.out, "gdb connected from %s\n", conn.RemoteAddr())
+		s := &gdbStub{J: J, dap: dap, g: &gdbConn{conn: conn, in: bufio.NewReader(conn)}}
+		if err := dap.halt(); err != "" {
+			fmt.Fprintf(J.out, "core can't be halted: %s\n", err)
+			conn.Close()
+			return false
+		}
+
+		more, killed := true, false
+		for more {
+			p, err := s.g.readPacket()
+			if err != nil {
+				fmt.Fprintf(J.out, "gdb disconnected: %v\n", err)
+				dap.resume()
+				break
+			}
+			reply := ""
+			reply, more = s.handle(p)
+			killed = !more && p[0] == 'k'
+			if err := s.g.writePacket(reply); err != nil {
+				more = false
+			}
+		}
+		conn.Close()
+		if killed {
+			fmt.Fprintln(J.out, "gdb killed the session")
+			J.setTapState(TAP_RESET)
+			return true
+		}
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/gremwell/go-jtagenum
+
+go 1.20
+
+require github.com/stianeikeland/go-rpio v4.2.0+incompatible
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Physical layout of the header: rows of pin names as seen looking at the
+// connector. Entries which aren't scanned pins (GND, VCC, NC) are shown as
+// labels.
+type HeaderLayout [][]string
+
+// Parse layout given as rows separated by ';', pin names in a row by ','.
+func parseHeaderLayout(s string) HeaderLayout {
+	ret := HeaderLayout{}
+	for _, row := range strings.Split(s, ";") {
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		names := []string{}
+		for _, name := range strings.Split(row, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+		ret = append(ret, names)
+	}
+	return ret
+}
+
+// returns JTAG signal found on pin name, empty if none
+func (p PinoutResult) roleOf(name string) string {
+	switch name {
+	case p.TCK:
+		return "TCK"
+	case p.TMS:
+		return "TMS"
+	case p.TDO:
+		return "TDO"
+	case p.TDI:
+		return "TDI"
+	}
+	if len(p.PossibleTRST) != 0 && p.PossibleTRST[0] == name {
+		return "nTRST?"
+	}
+	return ""
+}
+
+// Draw the header with signals of the pinout labeled in place.
+func (J *Jtag) renderHeader(layout HeaderLayout, p PinoutResult) {
+	width := 0
+	for _, row := range layout {
+		for _, name := range row {
+			if l := len(name) + len(" nTRST?"); l > width {
+				width = l
+			}
+		}
+	}
+
+	columns := 0
+	for _, row := range layout {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	border := "+" + strings.Repeat(strings.Repeat("-", width+2)+"+", columns)
+
+	fmt.Fprintln(J.out, border)
+	for _, row := range layout {
+		line := "|"
+		for i := 0; i < columns; i += 1 {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+				if role := p.roleOf(row[i]); role != "" {
+					cell += " " + role
+				}
+			}
+			line += fmt.Sprintf(" %-*s |", width, cell)
+		}
+		fmt.Fprintln(J.out, line)
+		fmt.Fprintln(J.out, border)
+	}
+}
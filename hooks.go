@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// OpenOCD ftdi driver clocks JTAG out of the MPSSE on fixed pins
+var ftdiMpsseJtag = []struct {
+	name string
+	pin  JtagPin
+}{{"TCK", 0}, {"TDI", 1}, {"TDO", 2}, {"TMS", 3}}
+
+// OpenOCD configuration of the adapter for a found pinout, GPIO numbers as
+// the driver in use numbers them: linuxgpiod with gpiod, bcm2835gpio with
+// rpio, and ftdi if the pinout is on the MPSSE JTAG pins.
+// returns the configuration, or empty and why OpenOCD can't use the driver
+// on the pinout
+func (J *Jtag) openocdConfig(p *PinoutResult) (string, string) {
+	pins := J.pinsByName()
+	b := &strings.Builder{}
+	chip := ""
+	switch J.drvOpt.Name {
+	case "gpiod":
+		fmt.Fprintln(b, "adapter driver linuxgpiod")
+		chip = fmt.Sprintf(" -chip %d", J.drvOpt.GpioChip)
+	case "rpio":
+		fmt.Fprintln(b, "adapter driver bcm2835gpio")
+	case "ftdi":
+		return J.openocdFtdiConfig(p)
+	default:
+		return "", fmt.Sprintf("OpenOCD has no GPIO adapter driving pins as driver %s does", J.drvOpt.Name)
+	}
+	signals := []struct{ name, pin string }{{"tck", p.TCK}, {"tms", p.TMS}, {"tdo", p.TDO}, {"tdi", p.TDI}}
+	if len(p.PossibleTRST) != 0 {
+		signals = append(signals, struct{ name, pin string }{"trst", p.PossibleTRST[0]})
+	}
+	for _, s := range signals {
+		if pin, ok := pins[s.pin]; ok && s.pin != "" {
+			fmt.Fprintf(b, "adapter gpio %s %d%s\n", s.name, pin, chip)
+		}
+	}
+	fmt.Fprintln(b, "transport select jtag")
+	return b.String(), ""
+}
+
+// OpenOCD ftdi configuration for a pinout found with the ftdi driver. JTAG
+// signals must be on ADBUS0-3 as the MPSSE shifts them, nTRST may be any
+// other pin.
+// returns the configuration, or empty and why the pinout can't be used
+func (J *Jtag) openocdFtdiConfig(p *PinoutResult) (string, string) {
+	pins := J.pinsByName()
+	found := map[string]string{"TCK": p.TCK, "TDI": p.TDI, "TDO": p.TDO, "TMS": p.TMS}
+	for _, s := range ftdiMpsseJtag {
+		name := found[s.name]
+		if name == "" {
+			return "", fmt.Sprintf("OpenOCD ftdi driver needs TCK, TDI, TDO and TMS, %s is not known", s.name)
+		}
+		if pins[name] != s.pin {
+			return "", fmt.Sprintf("OpenOCD ftdi driver needs TCK, TDI, TDO and TMS on ADBUS0-3, %s is on %s, not ADBUS%d", s.name, name, s.pin)
+		}
+	}
+	// TMS high, TCK, TDI and TMS outputs
+	data, dir := uint16(0x0008), uint16(0x000b)
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "adapter driver ftdi")
+	fmt.Fprintf(b, "ftdi vid_pid 0x%04x 0x%04x\n", J.drvOpt.FtdiVid, J.drvOpt.FtdiPid)
+	fmt.Fprintf(b, "ftdi channel %d\n", strings.ToUpper(J.drvOpt.FtdiChannel)[0]-'A')
+	trst := ""
+	if len(p.PossibleTRST) != 0 {
+		if pin, ok := pins[p.PossibleTRST[0]]; ok && pin >= 4 {
+			// released, high
+			data |= 1 << pin
+			dir |= 1 << pin
+			trst = fmt.Sprintf("ftdi layout_signal nTRST -data 0x%04x\n", 1<<pin)
+		}
+	}
+	fmt.Fprintf(b, "ftdi layout_init 0x%04x 0x%04x\n", data, dir)
+	b.WriteString(trst)
+	fmt.Fprintln(b, "adapter speed 1000")
+	fmt.Fprintln(b, "transport select jtag")
+	return b.String(), ""
+}
+
+// Run the hook command for an event: the event JSON is given on stdin,
+// its type, command and pinout in JTAGENUM_* environment variables, for a
+// pinout also the path of an OpenOCD configuration using it.
+// returns description of the problem, empty if ok
+func (J *Jtag) runHook(command string, e Event, payload []byte) string {
+	env := append(os.Environ(),
+		"JTAGENUM_EVENT="+e.Type,
+		"JTAGENUM_COMMAND="+e.Command,
+		"JTAGENUM_BOARD="+e.Board)
+	if e.Passed != nil {
+		env = append(env, fmt.Sprintf("JTAGENUM_PASSED=%t", *e.Passed))
+	}
+	if p := e.Pinout; p != nil {
+		env = append(env, "JTAGENUM_TCK="+p.TCK, "JTAGENUM_TMS="+p.TMS, "JTAGENUM_TDO="+p.TDO, "JTAGENUM_TDI="+p.TDI)
+		if len(p.PossibleTRST) != 0 {
+			env = append(env, "JTAGENUM_TRST="+p.PossibleTRST[0])
+		}
+
+		dir, err := os.MkdirTemp("", "jtagenum-hook")
+		if err != nil {
+			return err.Error()
+		}
+		defer os.RemoveAll(dir)
+		// left unset if OpenOCD can't drive the pinout
+		if config, _ := J.openocdConfig(p); config != "" {
+			cfg := filepath.Join(dir, "openocd.cfg")
+			if err := os.WriteFile(cfg, []byte(config), 0644); err != nil {
+				return err.Error()
+			}
+			env = append(env, "JTAGENUM_OPENOCD_CFG="+cfg)
+		}
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = J.out
+	cmd.Stderr = J.out
+	if err := cmd.Run(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
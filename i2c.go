@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Device ports wired to I2C bus signals
+type I2cPorts struct {
+	SCL, SDA string
+}
+
+// Parse I2C signals mapping, e.g. "scl=PB6,sda=PB7".
+func parseI2cPorts(s string) I2cPorts {
+	ret := I2cPorts{}
+	if len(s) == 0 {
+		return ret
+	}
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(kv) != 2 {
+			panic(fmt.Sprintf("invalid I2C signal '%s', use SIGNAL=PORT", e))
+		}
+		switch strings.ToLower(kv[0]) {
+		case "scl":
+			ret.SCL = kv[1]
+		case "sda":
+			ret.SDA = kv[1]
+		default:
+			panic(fmt.Sprintf("unknown I2C signal '%s', use scl or sda", kv[0]))
+		}
+	}
+	return ret
+}
+
+// Check the mapping against BSDL, both signals need output cells which can
+// be disabled (lines are open drain) and input cells. The ports mapped are
+// allowed to be driven, but power and clock nets are refused as extest does.
+// returns empty string if ok, otherwise the reason
+func (s I2cPorts) check(bsdl *Bsdl) string {
+	if s.SCL == "" || s.SDA == "" {
+		return "both scl and sda must be given with -i2c"
+	}
+	allow := parsePortList(s.SCL + "," + s.SDA)
+	for _, port := range []string{s.SCL, s.SDA} {
+		if reason := bsdl.checkDrivable(port, allow); reason != "" {
+			return reason
+		}
+		if bsdl.outputCell(port).Ccell < 0 {
+			return fmt.Sprintf("port %s output can't be disabled", port)
+		}
+		if bsdl.inputCell(port) == nil {
+			return fmt.Sprintf("port %s has no input cell", port)
+		}
+	}
+	return ""
+}
+
+// I2C master bit-banged through boundary register. Lines are pulled low by
+// enabling the output driving 0 and released by disabling it, the board
+// pull-ups are relied upon. Clock stretching is not supported, but every
+// edge takes a full boundary register shift anyway.
+type I2cBus struct {
+	pins  *BoundaryPins
+	ports I2cPorts
+}
+
+func (b *I2cBus) line(port string, high bool) {
+	if high {
+		b.pins.release(port)
+	} else {
+		b.pins.set(port, StateLow)
+	}
+	b.pins.update()
+}
+
+func (b *I2cBus) start() {
+	b.line(b.ports.SDA, true)
+	b.line(b.ports.SCL, true)
+	b.line(b.ports.SDA, false)
+	b.line(b.ports.SCL, false)
+}
+
+func (b *I2cBus) stop() {
+	b.line(b.ports.SDA, false)
+	b.line(b.ports.SCL, true)
+	b.line(b.ports.SDA, true)
+}
+
+// Clock one bit, SDA must be already set.
+// returns SDA sampled while SCL was high
+func (b *I2cBus) clock() bool {
+	b.line(b.ports.SCL, true)
+	// pins are captured before SCL goes low
+	b.line(b.ports.SCL, false)
+	return b.pins.get(b.ports.SDA) == StateHigh
+}
+
+// returns true if the byte was acknowledged
+func (b *I2cBus) writeByte(v byte) bool {
+	for bit := 7; bit >= 0; bit-- {
+		b.line(b.ports.SDA, (v>>bit)&1 == 1)
+		b.clock()
+	}
+	b.line(b.ports.SDA, true)
+	return !b.clock()
+}
+
+func (b *I2cBus) readByte(ack bool) byte {
+	b.line(b.ports.SDA, true)
+	v := byte(0)
+	for bit := 0; bit < 8; bit++ {
+		v <<= 1
+		if b.clock() {
+			v |= 1
+		}
+	}
+	b.line(b.ports.SDA, !ack)
+	b.clock()
+	return v
+}
+
+// returns true if a device acknowledged the address
+func (b *I2cBus) probe(addr byte) bool {
+	b.start()
+	ack := b.writeByte(addr<<1 | 1)
+	if ack {
+		// read a byte to release the bus
+		b.readByte(false)
+	}
+	b.stop()
+	return ack
+}
+
+// Random read from an EEPROM with address of addrWidth bytes.
+// returns nil if the EEPROM does not respond
+func (b *I2cBus) readEeprom(dev byte, addr uint32, addrWidth int, length int) []byte {
+	b.start()
+	ok := b.writeByte(dev << 1)
+	for i := addrWidth - 1; i >= 0 && ok; i-- {
+		ok = b.writeByte(byte(addr >> (8 * i)))
+	}
+	if ok {
+		b.start()
+		ok = b.writeByte(dev<<1 | 1)
+	}
+	if !ok {
+		b.stop()
+		return nil
+	}
+	ret := make([]byte, length)
+	for i := range ret {
+		ret[i] = b.readByte(i != length-1)
+	}
+	b.stop()
+	return ret
+}
+
+// Access I2C bus attached to the device pins, bit-banging it through EXTEST.
+// op -- "scan" lists responding addresses, "read" saves length bytes at addr
+// of EEPROM dev to path
+// returns true on success
+func (J *Jtag) i2c(bsdl *Bsdl, ports I2cPorts, op string, dev uint, addrWidth uint, addr uint32, length uint32, path string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "I2C %s...\n", op)
+	defer fmt.Fprintln(J.out, "================================")
+
+	switch op {
+	case "scan":
+	case "read":
+		if length == 0 {
+			fmt.Fprintln(J.out, "nothing to do, give length with -flash-len")
+			return false
+		}
+		if len(path) == 0 {
+			fmt.Fprintln(J.out, "file is required, give it with -flash-file")
+			return false
+		}
+		if dev > 0x7f || addrWidth < 1 || addrWidth > 4 {
+			fmt.Fprintln(J.out, "invalid I2C device address or address width")
+			return false
+		}
+	default:
+		fmt.Fprintf(J.out, "invalid I2C operation '%s'\n", op)
+		return false
+	}
+
+	if J.prepareBoundary(bsdl) == 0 {
+		return false
+	}
+	if reason := ports.check(bsdl); reason != "" {
+		fmt.Fprintf(J.out, "can't use I2C signals: %s\n", reason)
+		return false
+	}
+
+	pins := J.newBoundaryPins(bsdl)
+	if pins == nil {
+		return false
+	}
+	// Reset TAP to Run-Test-Idle, pins are given back to the device
+	defer J.setTapState(TAP_RESET)
+	b := &I2cBus{pins: pins, ports: ports}
+	b.line(ports.SCL, true)
+	b.line(ports.SDA, true)
+	// captured with both lines released
+	b.pins.update()
+	if b.pins.get(ports.SCL) != StateHigh || b.pins.get(ports.SDA) != StateHigh {
+		fmt.Fprintln(J.out, "bus is not idle, missing pull-ups or wrong mapping?")
+		return false
+	}
+
+	switch op {
+	case "scan":
+		found := 0
+		for a := byte(0x08); a < 0x78; a++ {
+			if b.probe(a) {
+				fmt.Fprintf(J.out, "device at 0x%02x\n", a)
+				found += 1
+			}
+		}
+		if found == 0 {
+			fmt.Fprintln(J.out, "no devices responding")
+			return false
+		}
+	case "read":
+		data := b.readEeprom(byte(dev), addr, int(addrWidth), int(length))
+		if data == nil {
+			fmt.Fprintf(J.out, "no EEPROM responding at 0x%02x\n", dev)
+			return false
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			fmt.Fprintf(J.out, "can't write %s: %v\n", path, err)
+			return false
+		}
+		fmt.Fprintf(J.out, "read %d bytes at 0x%x of 0x%02x to %s\n", length, addr, dev, path)
+	}
+	return true
+}
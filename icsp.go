@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Key entering low-voltage programming, "MCHP"
+const ICSP_LVP_KEY = 0x4d434850
+
+// PIC16F1xxx ICSP commands, 6 bits LSB first
+const (
+	ICSP_LOAD_CONFIG = 0x00
+	ICSP_READ_DATA   = 0x04
+	ICSP_INC_ADDRESS = 0x06
+)
+
+// Device ID word in configuration memory, relative to its start
+const ICSP_DEVICE_ID_OFFSET = 6
+
+// Clock bits out on PGD, LSB first: data is set while PGC is high and
+// latched by the device on the falling edge.
+func (J *Jtag) icspWrite(pgc, pgd JtagPin, value uint32, n int) {
+	for i := 0; i < n; i += 1 {
+		J.pinWriteDelay(pgc, StateHigh)
+		J.drv.pinWrite(pgd, JtagPinState((value>>uint(i))&1))
+		J.pinWriteDelay(pgc, StateLow)
+	}
+}
+
+// Clock n bits in from PGD, LSB first, sampled before the falling edge.
+func (J *Jtag) icspRead(pgc, pgd JtagPin, n int) uint32 {
+	ret := uint32(0)
+	for i := 0; i < n; i += 1 {
+		J.pinWriteDelay(pgc, StateHigh)
+		ret |= uint32(J.drv.pinRead(pgd)) << uint(i)
+		J.pinWriteDelay(pgc, StateLow)
+	}
+	return ret
+}
+
+// Probe pins as MCLR, PGC and PGD of a Microchip PIC16F1xxx ICSP port:
+// hold MCLR low, enter low-voltage programming with the key and read the
+// device ID from configuration memory, twice. Only devices with low-voltage
+// programming enabled (the default) answer. MCLR is released afterwards,
+// so the target restarts.
+func probeIcsp(J *Jtag, pins []JtagPin) (uint32, string, bool) {
+	mclr, pgc, pgd := pins[0], pins[1], pins[2]
+	J.drv.pinWrite(pgc, StateLow)
+	J.drv.pinWrite(pgd, StateLow)
+	J.drv.pinWrite(mclr, StateLow)
+	delay(J.DELAY_RESET)
+	defer func() {
+		J.drv.pinOutput(pgd)
+		J.drv.pinWrite(pgd, StateHigh)
+		J.drv.pinWrite(pgc, StateHigh)
+		J.drv.pinWrite(mclr, StateHigh)
+		delay(J.DELAY_RESET)
+	}()
+
+	// 32 key bits and one more clock
+	J.icspWrite(pgc, pgd, ICSP_LVP_KEY, 33)
+	delay(J.DELAY_TCK)
+
+	J.icspWrite(pgc, pgd, ICSP_LOAD_CONFIG, 6)
+	// start bit, 14 data bits, stop bit
+	J.icspWrite(pgc, pgd, 0, 16)
+	for i := 0; i < ICSP_DEVICE_ID_OFFSET; i += 1 {
+		J.icspWrite(pgc, pgd, ICSP_INC_ADDRESS, 6)
+	}
+	// read twice, a floating PGD doesn't read the same twice
+	read := func() uint32 {
+		J.drv.pinOutput(pgd)
+		J.icspWrite(pgc, pgd, ICSP_READ_DATA, 6)
+		J.drv.pinInput(pgd)
+		return (J.icspRead(pgc, pgd, 16) >> 1) & 0x3fff
+	}
+	word := read()
+
+	if word == 0 || word == 0x3fff || read() != word {
+		return 0, "", false
+	}
+	return word, fmt.Sprintf("PIC16F1 device ID 0x%04x, revision %d", word>>5, word&0x1f), true
+}
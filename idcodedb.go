@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// Known devices are offered for reads with at most that many suspect bits
+const FUZZY_MAX_SUSPECT = 8
+
+// Known devices differing from the read in at most that many bits are
+// offered even if the bits were not seen to disagree
+const FUZZY_MAX_DISTANCE = 2
+
+// Device known by its full IDCODE
+type KnownIdcode struct {
+	Idcode uint32
+	Name   string
+}
+
+// Devices commonly found on boards, to name reads which couldn't be
+// confirmed
+var knownIdcodes = []KnownIdcode{
+	{0x0ba00477, "ARM ADIv5 JTAG-DP (Cortex-M3 r0)"},
+	{0x3ba00477, "ARM CoreSight JTAG-DP (Cortex-M3 r1)"},
+	{0x4ba00477, "ARM CoreSight JTAG-DP (Cortex-M3/M4)"},
+	{0x5ba00477, "ARM CoreSight JTAG-DP (Cortex-A)"},
+	{0x6ba00477, "ARM CoreSight JTAG-DP (Cortex-M7)"},
+	{0x06410041, "STM32F1 medium density boundary scan"},
+	{0x06412041, "STM32F1 low density boundary scan"},
+	{0x06414041, "STM32F1 high density boundary scan"},
+	{0x06418041, "STM32F1 connectivity line boundary scan"},
+	{0x06411041, "STM32F2 boundary scan"},
+	{0x06413041, "STM32F4 boundary scan"},
+	{0x020a10dd, "Altera MAX II EPM240"},
+}
+
+// Known device near a read
+type IdcodeCandidate struct {
+	KnownIdcode
+	// bits differing from the read in total and outside of suspect bits
+	Distance        int
+	OutsideSuspects int
+}
+
+// Find known devices the read could be: differing only in suspect bits
+// (those which disagreed between repeated reads), or in at most
+// FUZZY_MAX_DISTANCE bits at all. Best candidates come first.
+// returns nothing if too many bits are suspect for a meaningful guess
+func closestIdcodes(idcode, suspect uint32) []IdcodeCandidate {
+	if bits.OnesCount32(suspect) > FUZZY_MAX_SUSPECT {
+		return nil
+	}
+	ret := []IdcodeCandidate{}
+	for _, k := range knownIdcodes {
+		diff := idcode ^ k.Idcode
+		c := IdcodeCandidate{k, bits.OnesCount32(diff), bits.OnesCount32(diff &^ suspect)}
+		if c.OutsideSuspects == 0 || c.Distance <= FUZZY_MAX_DISTANCE {
+			ret = append(ret, c)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].OutsideSuspects != ret[j].OutsideSuspects {
+			return ret[i].OutsideSuspects < ret[j].OutsideSuspects
+		}
+		return ret[i].Distance < ret[j].Distance
+	})
+	return ret
+}
+
+// Describe known devices closest to an IDCODE whose reads disagreed.
+// reads -- the disagreeing reads of the same IDCODE
+// returns empty string if there are none
+func describeClosestIdcodes(reads []uint32) string {
+	suspect := uint32(0)
+	for _, r := range reads[1:] {
+		suspect |= r ^ reads[0]
+	}
+	candidates := closestIdcodes(reads[0], suspect)
+	if len(candidates) == 0 {
+		return ""
+	}
+	names := []string{}
+	for _, c := range candidates {
+		names = append(names, fmt.Sprintf("0x%08x %s (distance %d)", c.Idcode, c.Name, c.Distance))
+	}
+	return fmt.Sprintf("reads %08x disagree in bits 0x%08x, closest known devices: %s",
+		reads, suspect, strings.Join(names, ", "))
+}
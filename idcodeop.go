@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Devices with IR that long or shorter have every opcode tried by
+// PROBE_IDCODE, longer ones the known and given opcodes only
+const PROBE_IDCODE_MAX_IR = 10
+
+// IDCODE instruction of a device family, by IR length
+type IdcodeOpcodeEntry struct {
+	Vendor string
+	// 0 for any IR length
+	IrLen  uint32
+	Opcode uint32
+}
+
+// IDCODE instructions tried on devices which select BYPASS at reset, IEEE
+// 1149.1 leaves the opcode to the vendor
+var idcodeOpcodes = []IdcodeOpcodeEntry{
+	{"ARM JTAG-DP", 4, 0xe},
+	{"Atmel AVR", 4, 0x1},
+	{"MIPS EJTAG, STM32 boundary scan", 5, 0x01},
+	{"Xilinx FPGA", 6, 0x09},
+	{"TI ICEPick", 6, 0x04},
+	{"Lattice MachXO2/ECP5", 8, 0xe0},
+	{"Lattice ECP2/ECP3", 8, 0x16},
+	{"Xilinx XC9500", 8, 0xfe},
+	{"Xilinx CoolRunner", 8, 0x01},
+	{"Altera/Intel", 10, 0x006},
+	{"common choice", 0, 0x1},
+}
+
+// Opcodes to try as IDCODE instruction on a device with IR of irLen bits:
+// IDCODE_OPCODES first, then known ones for the IR length, then with probe
+// every other opcode except all 0s (EXTEST on most devices), BYPASS and the
+// ones known to be dangerous for any vendor using the IR length.
+func (J *Jtag) idcodeOpcodeCandidates(irLen uint32, probe bool) []uint32 {
+	bypass := uint32(1)<<irLen - 1
+	seen := map[uint32]bool{bypass: true}
+	ret := []uint32{}
+	add := func(op uint32) {
+		if op&bypass == op && !seen[op] {
+			seen[op] = true
+			ret = append(ret, op)
+		}
+	}
+	for _, op := range J.IDCODE_OPCODES {
+		add(op)
+	}
+	for _, e := range idcodeOpcodes {
+		if e.IrLen == irLen || e.IrLen == 0 {
+			add(e.Opcode)
+		}
+	}
+	if !probe || irLen > PROBE_IDCODE_MAX_IR {
+		return ret
+	}
+	seen[0] = true
+	for _, e := range dangerousOpcodes {
+		if e.IrLen == irLen {
+			for _, op := range e.Opcodes {
+				seen[op] = true
+			}
+		}
+	}
+	for op := uint32(1); op < bypass; op += 1 {
+		add(op)
+	}
+	return ret
+}
+
+// Load opcode into the device at pos, the other devices in BYPASS, and read
+// 32 bits of the DR it selects. The next 32 bits must be the 0s shifted in,
+// so longer DRs capturing something are not taken for IDCODE.
+// Leaves the TAP in the Run-Test-Idle state.
+// returns the DR read and true if it is a valid IDCODE of a known
+// manufacturer
+func (J *Jtag) readDeviceIdcode(pos ChainPos, opcode, irLen uint32) (uint32, bool) {
+	J.setTapState(TAP_RESET)
+	J.sendInstruction(pos.irScan(opcode, irLen))
+	out := J.sendData(pos.drScan([]byte(strings.Repeat("0", 64))))
+	J.setTapState(TAP_RESET)
+	dr := pos.drExtract(out, 64)
+	idcode := bitsToUint32(string(dr[:32]))
+	if strings.Contains(string(dr[32:]), "1") {
+		return idcode, false
+	}
+	return idcode, isValidIdcode(idcode) && isKnownManufacturer(idcode)
+}
+
+// Read IDCODEs of devices which select BYPASS at reset by loading an IDCODE
+// instruction into them: opcodes given with -idcode-opcodes, known ones for
+// their IR length and, with PROBE_IDCODE and ALLOW_DRIVE, every other
+// opcode of short IRs. An IDCODE is taken if two reads agree. IR lengths of
+// all devices are needed, from -chain-ir-lens or the chain IR length split
+// as splitIrLength does.
+// Leaves the TAP in the Run-Test-Idle state.
+// returns IDCODEs with the ones found filled in
+func (J *Jtag) loadIdcodes(idcodes []uint32) []uint32 {
+	missing := 0
+	for _, idcode := range idcodes {
+		if idcode == BYPASS_IDCODE {
+			missing += 1
+		}
+	}
+	if missing == 0 {
+		return idcodes
+	}
+	irLens := J.CHAIN.IrLens
+	if len(irLens) != len(idcodes) {
+		irLens = splitIrLength(idcodes, J.irChainLength(), J.CHAIN.IrLens)
+	}
+	for i, irLen := range irLens {
+		if irLen == 0 {
+			fmt.Fprintf(J.out, "IR length of device %d is unknown, give -chain-ir-lens to load IDCODE instructions\n", i)
+			return idcodes
+		}
+	}
+	probe := J.PROBE_IDCODE
+	if probe && !J.ALLOW_DRIVE {
+		fmt.Fprintln(J.out, "probing for IDCODE instructions loads arbitrary opcodes, give -allow-drive; trying known ones only")
+		probe = false
+	}
+
+	ret := append([]uint32{}, idcodes...)
+	for i, idcode := range idcodes {
+		if idcode != BYPASS_IDCODE {
+			continue
+		}
+		pos := ChainPos{IrLens: irLens, Device: i}
+		opcodes := J.idcodeOpcodeCandidates(irLens[i], probe)
+		found := false
+		for _, op := range opcodes {
+			first, ok := J.readDeviceIdcode(pos, op, irLens[i])
+			if !ok {
+				continue
+			}
+			if second, _ := J.readDeviceIdcode(pos, op, irLens[i]); second != first {
+				continue
+			}
+			fmt.Fprintf(J.out, "device %d: IDCODE 0x%08x read by loading opcode 0x%x\n", i, first, op)
+			ret[i] = first
+			found = true
+			break
+		}
+		if !found {
+			fmt.Fprintf(J.out, "device %d: no IDCODE instruction among %d opcodes tried (IR %d)\n", i, len(opcodes), irLens[i])
+		}
+	}
+	return ret
+}
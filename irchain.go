@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Measure IR length of the whole chain: the 1 shifted in goes through the
+// IRs of all devices, up to maxIrChainLen bits. Devices with unusual IR
+// lengths, or longer than MAX_IR_LEN, are measured with the rest.
+// Leaves the TAP in the Run-Test-Idle state.
+// returns IR length of the chain, 0 if it couldn't be measured
+func (J *Jtag) detectIrChainLength() uint32 {
+	return J.measureIrLength(J.maxIrChainLen())
+}
+
+// IR length given with -ir-len, measured one of the whole chain otherwise.
+// Leaves the TAP in the Run-Test-Idle state.
+func (J *Jtag) irChainLength() uint32 {
+	if J.IR_LEN != 0 {
+		return J.IR_LEN
+	}
+	return J.detectIrChainLength()
+}
+
+// Print the number of devices and the IR length of the chain on the known
+// pins, measured whole, and the IR length of every device as far as IDCODEs
+// and -chain-ir-lens tell. A chain IR length which doesn't add up with IR
+// lengths assumed per device points to a device with an unusual IR.
+// returns false if no chain is found or its IR length couldn't be measured
+func (J *Jtag) printIrChainLength() bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Measuring IR chain length...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	J.useKnownPins()
+
+	J.initPins()
+
+	devCnt := J.countDevices()
+	if devCnt == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return false
+	}
+	total := J.detectIrChainLength()
+	J.results.DeviceCount = devCnt
+	J.results.IrLength = total
+	if total == 0 {
+		fmt.Fprintf(J.out, "devices: %d, chain IR length: not measured, no 1 came out of TDO within %d bits\n",
+			devCnt, J.maxIrChainLen())
+		return false
+	}
+	fmt.Fprintf(J.out, "devices: %d, chain IR length: %d\n", devCnt, total)
+	if total < uint32(devCnt)*MIN_IR_LEN {
+		fmt.Fprintf(J.out, "IR length is shorter than %d bits per device, the count or the measurement is wrong\n", MIN_IR_LEN)
+		return false
+	}
+
+	idcodes := J.readKnownIdcodes()
+	J.results.Idcodes = idcodes
+	if len(idcodes) != devCnt {
+		fmt.Fprintf(J.out, "%d IDCODEs read for %d devices, IR lengths per device are not known\n", len(idcodes), devCnt)
+		return true
+	}
+	irLens := splitIrLength(idcodes, total, J.CHAIN.IrLens)
+	sum, unknown := uint32(0), 0
+	for i, idcode := range idcodes {
+		desc := "unknown"
+		if irLens[i] == 0 {
+			unknown += 1
+		} else {
+			desc = fmt.Sprintf("%d", irLens[i])
+		}
+		if irLens[i] > MAX_IR_LEN {
+			desc += fmt.Sprintf(", longer than %d", MAX_IR_LEN)
+		}
+		fmt.Fprintf(J.out, "device %d: IR %s, %s\n", i, desc, describeChainIdcode(idcode))
+		sum += irLens[i]
+	}
+	if len(J.CHAIN.IrLens) == devCnt && sum != total {
+		fmt.Fprintf(J.out, "IR lengths given with -chain-ir-lens add up to %d, not %d\n", sum, total)
+		return false
+	}
+	if unknown != 0 && total > sum {
+		fmt.Fprintf(J.out, "%d devices of unknown IR length share %d bits, give -chain-ir-lens to split them\n", unknown, total-sum)
+	}
+	return true
+}
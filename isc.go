@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Array geometry and timing of an IEEE 1532 device, not described by BSDL
+type IscGeometry struct {
+	Rows    uint
+	RowLen  uint
+	AddrLen uint
+	// addresses are gray coded (e.g. Xilinx CoolRunner-II)
+	Gray bool
+	// time to spend in Run-Test-Idle after programming a row and erasing
+	ProgramWait time.Duration
+	EraseWait   time.Duration
+}
+
+// Parse geometry, e.g. "rows=48,row-len=260,addr-len=6,gray,program-wait=10ms".
+func parseIscGeometry(s string) IscGeometry {
+	ret := IscGeometry{ProgramWait: 10 * time.Millisecond, EraseWait: 100 * time.Millisecond}
+	if len(s) == 0 {
+		return ret
+	}
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if kv[0] == "gray" && len(kv) == 1 {
+			ret.Gray = true
+			continue
+		}
+		if len(kv) != 2 {
+			panic(fmt.Sprintf("invalid ISC parameter '%s', use NAME=VALUE", e))
+		}
+		var err error
+		var v uint64
+		switch kv[0] {
+		case "rows", "row-len", "addr-len":
+			v, err = strconv.ParseUint(kv[1], 0, 32)
+		case "program-wait":
+			ret.ProgramWait, err = time.ParseDuration(kv[1])
+		case "erase-wait":
+			ret.EraseWait, err = time.ParseDuration(kv[1])
+		default:
+			panic(fmt.Sprintf("unknown ISC parameter '%s', use rows, row-len, addr-len, gray, program-wait or erase-wait", kv[0]))
+		}
+		if err != nil {
+			panic(fmt.Sprintf("invalid ISC parameter '%s'", e))
+		}
+		switch kv[0] {
+		case "rows":
+			ret.Rows = uint(v)
+		case "row-len":
+			ret.RowLen = uint(v)
+		case "addr-len":
+			ret.AddrLen = uint(v)
+		}
+	}
+	return ret
+}
+
+// Bytes of a row in the data file, bits in shift order, LSB of a byte first
+func (g IscGeometry) rowBytes() int {
+	return int(g.RowLen+7) / 8
+}
+
+// Device in ISC mode
+type iscDevice struct {
+	J       *Jtag
+	irLen   uint32
+	geo     IscGeometry
+	opcodes map[string]uint32
+}
+
+// Stay in Run-Test-Idle clocking TCK for duration, as ISC operations need.
+func (d *iscDevice) wait(duration time.Duration) {
+	start := time.Now()
+	for time.Since(start) < duration {
+		d.J.pulseTCK(8)
+	}
+}
+
+func (d *iscDevice) instruction(name string) {
+	d.J.sendDeviceInstruction(d.opcodes[name], d.irLen)
+}
+
+// Shift bits into DR of the device.
+// returns bits shifted out of it
+func (d *iscDevice) data(bits []byte) []byte {
+	out := d.J.sendData(d.J.CHAIN.drScan(bits))
+	return d.J.CHAIN.drExtract(out, len(bits))
+}
+
+func (d *iscDevice) address(row uint) {
+	a := uint32(row)
+	if d.geo.Gray {
+		a ^= a >> 1
+	}
+	d.instruction("ISC_ADDRESS_SHIFT")
+	d.data(irBits(a, uint32(d.geo.AddrLen)))
+}
+
+// Convert row bits in shift order to data file bytes and back.
+func rowToBytes(bits []byte, n int) []byte {
+	ret := make([]byte, n)
+	for i, b := range bits {
+		if b == '1' {
+			ret[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return ret
+}
+
+func bytesToRow(data []byte, rowLen uint) []byte {
+	ret := make([]byte, rowLen)
+	for i := range ret {
+		if i/8 < len(data) && data[i/8]&(1<<uint(i%8)) != 0 {
+			ret[i] = '1'
+		} else {
+			ret[i] = '0'
+		}
+	}
+	return ret
+}
+
+func (d *iscDevice) readRow(row uint) []byte {
+	d.address(row)
+	d.instruction("ISC_READ")
+	d.wait(time.Millisecond)
+	return d.data(bytes.Repeat([]byte{'0'}, int(d.geo.RowLen)))
+}
+
+// Read, erase or program the array of a CPLD through IEEE 1532 in-system
+// configuration instructions described by its BSDL: ISC_ENABLE,
+// ISC_ADDRESS_SHIFT, ISC_READ, ISC_ERASE, ISC_PROGRAM and ISC_DISABLE. Rows
+// are addressed one by one, the array geometry is given as BSDL doesn't
+// describe it. Programmed rows are read back and verified.
+// op -- read, erase or program
+// path -- file to read the array to or program from
+// returns true if the operation succeeded
+func (J *Jtag) isc(bsdl *Bsdl, geo IscGeometry, op string, path string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "ISC %s...\n", op)
+	defer fmt.Fprintln(J.out, "================================")
+
+	var data []byte
+	if op != "erase" {
+		if geo.Rows == 0 || geo.RowLen == 0 || geo.AddrLen == 0 {
+			fmt.Fprintln(J.out, "array geometry is required, give it with -isc rows=N,row-len=N,addr-len=N")
+			return false
+		}
+		if len(path) == 0 {
+			fmt.Fprintln(J.out, "file is required, give it with -flash-file")
+			return false
+		}
+	}
+	if op == "program" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			fmt.Fprintf(J.out, "can't read %s: %v\n", path, err)
+			return false
+		}
+		if len(data) != int(geo.Rows)*geo.rowBytes() {
+			fmt.Fprintf(J.out, "%s has %d bytes, %d rows of %d bytes expected\n", path, len(data), geo.Rows, geo.rowBytes())
+			return false
+		}
+	}
+
+	irLen := J.prepareDevice(bsdl)
+	if irLen == 0 {
+		return false
+	}
+	d := &iscDevice{J: J, irLen: irLen, geo: geo, opcodes: map[string]uint32{}}
+	needed := map[string][]string{
+		"read":    {"ISC_ENABLE", "ISC_DISABLE", "ISC_ADDRESS_SHIFT", "ISC_READ"},
+		"erase":   {"ISC_ENABLE", "ISC_DISABLE", "ISC_ERASE"},
+		"program": {"ISC_ENABLE", "ISC_DISABLE", "ISC_ADDRESS_SHIFT", "ISC_READ", "ISC_PROGRAM"},
+	}
+	for _, name := range needed[op] {
+		opcode, ok := bsdl.opcode(name)
+		if !ok {
+			fmt.Fprintf(J.out, "no %s instruction in BSDL, is it an IEEE 1532 BSDL?\n", name)
+			return false
+		}
+		d.opcodes[name] = opcode
+	}
+
+	J.setTapState(TAP_RESET)
+	d.instruction("ISC_ENABLE")
+	d.wait(time.Millisecond)
+	defer func() {
+		d.instruction("ISC_DISABLE")
+		d.wait(time.Millisecond)
+		J.setTapState(TAP_RESET)
+	}()
+
+	switch op {
+	case "read":
+		out := []byte{}
+		for row := uint(0); row < geo.Rows; row += 1 {
+			out = append(out, rowToBytes(d.readRow(row), geo.rowBytes())...)
+		}
+		if err := os.WriteFile(path, out, 0644); err != nil {
+			fmt.Fprintf(J.out, "can't write %s: %v\n", path, err)
+			return false
+		}
+		fmt.Fprintf(J.out, "read %d rows of %d bits to %s\n", geo.Rows, geo.RowLen, path)
+	case "erase":
+		d.instruction("ISC_ERASE")
+		d.wait(geo.EraseWait)
+		fmt.Fprintln(J.out, "array erased")
+	case "program":
+		for row := uint(0); row < geo.Rows; row += 1 {
+			bits := bytesToRow(data[int(row)*geo.rowBytes():], geo.RowLen)
+			d.address(row)
+			d.instruction("ISC_PROGRAM")
+			d.data(bits)
+			d.wait(geo.ProgramWait)
+			if got := d.readRow(row); !bytes.Equal(got, bits) {
+				fmt.Fprintf(J.out, "row %d verification failed\n", row)
+				return false
+			}
+		}
+		fmt.Fprintf(J.out, "programmed and verified %d rows from %s\n", geo.Rows, path)
+	default:
+		fmt.Fprintf(J.out, "invalid ISC operation '%s'\n", op)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Load HIGHZ (all outputs disabled) or CLAMP (outputs driven from the
+// boundary register, preloaded with safe values, BYPASS selected) into the
+// device, so it is electrically isolated while something else is done on the
+// board, e.g. programming a flash attached to its pins.
+// instruction -- "HIGHZ" or "CLAMP"
+// hold -- how long to keep the device isolated before resetting its TAP, 0 to
+// leave it isolated on exit
+// returns true if the instruction was loaded
+func (J *Jtag) isolate(bsdl *Bsdl, instruction string, hold time.Duration) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Loading %s...\n", instruction)
+	defer fmt.Fprintln(J.out, "================================")
+
+	if bsdl == nil {
+		fmt.Fprintln(J.out, "BSDL file is required, give it with -bsdl")
+		return false
+	}
+	opcode, ok := bsdl.opcode(strings.ToUpper(instruction))
+	if !ok {
+		fmt.Fprintf(J.out, "device has no %s instruction\n", instruction)
+		return false
+	}
+
+	irLen := J.prepareDevice(bsdl)
+	if irLen == 0 {
+		return false
+	}
+
+	J.setTapState(TAP_RESET)
+	if strings.ToUpper(instruction) == "CLAMP" {
+		// CLAMP drives pins from the boundary register, preload safe values
+		sample, ok := bsdl.opcode("SAMPLE", "SAMPLE/PRELOAD", "SAMPLE_PRELOAD", "PRELOAD")
+		if !ok || bsdl.BoundaryLen == 0 {
+			fmt.Fprintln(J.out, "can't preload boundary register, BSDL lacks SAMPLE/PRELOAD or boundary register")
+			return false
+		}
+		J.sendDeviceInstruction(sample, irLen)
+		J.shiftBoundary(bsdl.safeVector())
+	}
+	J.sendDeviceInstruction(opcode, irLen)
+	fmt.Fprintf(J.out, "%s loaded (opcode 0x%x)\n", instruction, opcode)
+
+	if hold == 0 {
+		fmt.Fprintln(J.out, "leaving device isolated, TAP reset or nTRST brings it back")
+		return true
+	}
+	fmt.Fprintf(J.out, "holding for %v\n", hold)
+	time.Sleep(hold)
+	// Reset TAP to Run-Test-Idle, pins are given back to the device
+	J.setTapState(TAP_RESET)
+	return true
+}
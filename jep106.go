@@ -1050,7 +1050,7 @@ func Jep106Manufacturer(bank, id uint32) (string) {
 			"Shenzhen Chixingzhe Tech Co. Ltd.",
 		},
 	}
-	if id < 1 || id >= uint32(len(jep106[0])) || bank >= uint32(len(jep106)-1) {
+	if id < 1 || bank >= uint32(len(jep106)) || id > uint32(len(jep106[bank])) {
 		return "invalid"
 	}
 
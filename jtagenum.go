@@ -11,6 +11,11 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,7 +23,7 @@ import (
 // Use something random when trying find JTAG lines
 const PATTERN = "0110011101001101101000010111001001"
 
-// Maximum number of devices allowed in a single JTAG chain
+// Default maximum number of devices in a single JTAG chain, see MAX_DEVICES
 const MAX_DEV_NR = 32
 
 // Minimum length of instruction register per IEEE Std. 1149.1
@@ -27,12 +32,21 @@ const MIN_IR_LEN = 2
 // Maximum length of instruction register
 const MAX_IR_LEN = 32
 
-// Maximum total length of JTAG chain w/ IR selected
-const MAX_IR_CHAIN_LEN = MAX_DEV_NR * MAX_IR_LEN
-
 // Maximum length of data register
 const MAX_DR_LEN = 1024
 
+// Number of bits shifted between Pause-DR states when streaming long DRs
+const DR_CHUNK_LEN = 1024
+
+// How many times TDO is sampled again when samples disagree
+const TDO_RESAMPLES = 8
+
+// Number of captured DR bits displayed by discover_opcode
+const DR_CAPTURE_LEN = 64
+
+// Placeholder for devices without IDCODE register (BYPASS selected after reset)
+const BYPASS_IDCODE = uint32(0)
+
 const TAP_RESET = "111110"
 const TAP_SHIFTDR = "100"
 const TAP_SHIFTIR = "1100"
@@ -72,9 +86,157 @@ type Jtag struct {
 
 	DELAY_TCK   uint
 	DELAY_RESET uint
-	PULLUP      bool
+	// delay after TAP reset and nTRST release, for targets which need time
+	// before IDCODE is available
+	DELAY_TAP_SETTLE uint
+	PULLUP           bool
+
+	// how many times to retry at slower TCK when consecutive reads differ
+	RETRIES uint
+
+	// how many times to re-sync the TAP and retry an operation whose reads
+	// failed verification
+	SHIFT_RETRIES uint
+
+	// TCK cycle delayed by more than this is counted as a timing gap, 0 to
+	// disable
+	MAX_GAP time.Duration
+	// treat operations with timing gaps as failed verification and retry them
+	GAP_RETRY bool
+
+	// how many times to repeat long shifts to verify the data read
+	VERIFY_READS uint
+
+	// how many consecutive reads of the first IDCODE must be identical before
+	// scan reports it as found
+	IDCODE_READS uint
+
+	// how many times to sample TDO per clock, all samples must agree
+	TDO_SAMPLES uint
+
+	// print raw data of rejected attempts
+	VERBOSE bool
+
+	// treat the first bit shifted out as the most significant one when
+	// displaying shifted data as hex (JTAG registers are LSB first)
+	MSB_FIRST bool
+
+	// wrap driver to count its calls, print statistics at the end
+	STATS bool
+
+	// abort if a driver call blocks longer than this, 0 to disable
+	WATCHDOG time.Duration
+
+	// device addressed by per-device commands and IR lengths of the chain
+	CHAIN ChainPos
+
+	// IR length of the chain to use instead of detecting it, 0 to detect
+	IR_LEN uint32
+
+	// pattern to verify device count with, empty to trust the count
+	COUNT_PATTERN string
+	// maximum number of devices looked for in a chain
+	MAX_DEVICES int
+	// 1s shifted into IR to select BYPASS, 0 to derive it
+	BYPASS_IR_LEN uint
+	// chain IR length measured on the pins formatted, 0 if it failed
+	bypassIr struct {
+		pins  string
+		irLen int
+	}
+	// read IDCODE of devices selecting BYPASS at reset by loading IDCODE
+	// instructions: the given and known opcodes, and every opcode of short
+	// IRs with PROBE_IDCODE
+	LOAD_IDCODE    bool
+	IDCODE_OPCODES []uint32
+	PROBE_IDCODE   bool
+
+	// pause between permutations tried by scans
+	COOLDOWN time.Duration
+	// fraction of time scans may spend clocking the target, resting the
+	// rest, 1 for no limit
+	DUTY_CYCLE float64
+	// TCK cycles per second not to exceed, 0 for no limit
+	MAX_TCK_RATE uint
+	pace         pacer
+
+	// seed of the shuffled order scans test permutations in, 0 for natural
+	// order, negative for a random seed
+	SHUFFLE_SEED int64
+	// observe pins before scans and test likely assignments first
+	PREDICT bool
+	// generate permutations so that consecutive ones differ in two pins
+	GRAY_ORDER bool
+	// file permutations tested are written to, and file they are read from
+	// instead of being generated
+	PERMUTATIONS_EXPORT  string
+	PERMUTATIONS_IMPORT  string
+	permutationsExported bool
+	// comma separated result files of previous runs, permutations they
+	// ruled out are skipped unless FORCE
+	PREVIOUS  string
+	FORCE     bool
+	permTrack *permutationTrack
+	// pins never driven (supplies, analog, fragile nets), only tried as TDO
+	OBSERVE_ONLY map[JtagPin]bool
+	// enforce minimum TCK delay and warn about many pins driven at once
+	PROTECT bool
+	// TCK delay commands may not go below, set by PROTECT
+	minDelayTck uint
+	// verify found pinouts again at half and double speed
+	TIMING_CHECK bool
+	// level transitions allowed per pin while the driver is open, 0 for no
+	// limit
+	TOGGLE_BUDGET uint64
+	toggles       *JtagPinDriverToggles
+	// driver calls counted with STATS, nil otherwise
+	counter *JtagPinDriverCounter
+
+	// target console monitored in the background, nil if not given
+	console *consoleMonitor
+
+	// SCHED_FIFO priority of the thread doing shifts, 0 to keep the default
+	RT_PRIORITY uint
+	// CPU to pin the thread doing shifts to, -1 not to pin
+	RT_CPU int
+
+	// allow pulling spare pins low to find nTRST
+	ALLOW_RESET bool
+	// time scan_uart listens to every pin, and whether it sends a carriage
+	// return to find RX, at UART_BAUD if not 0
+	UART_LISTEN time.Duration
+	UART_PROBE  bool
+	UART_BAUD   uint
+	// probe nTRST once at the end of a scan, on the best pinout only
+	TRST_LAST bool
+	// allow commands driving target pins or loading arbitrary instructions
+	ALLOW_DRIVE bool
+
+	// chain found on the known pins, kept across commands
+	chainCache *ChainInfo
+
+	// where scans publish pinouts as they are found, may be nil, and how
+	// many pinouts of results were published already
+	publish          *Publisher
+	publishedPinouts int
+
+	// options of the opened driver
+	drvOpt DriverOptions
+
+	// results of the command, saved with -output
+	results ScanResult
+
+	stats ScanStats
+
+	// where commands print their output
+	out io.Writer
+	// live screen output goes to with -tui, nil if not shown
+	tui *tuiScreen
 
 	drv JtagPinDriver
+
+	// driver wrapper showing TCK cycles to live viewers, nil if not served
+	trace *JtagPinDriverTrace
 }
 
 type JtagPinDriver interface {
@@ -99,10 +261,32 @@ func NewJtag() Jtag {
 	jtag.DELAY_TCK = 10
 	jtag.DELAY_RESET = 10 * 1000
 	jtag.PULLUP = false
+	jtag.IDCODE_READS = 3
+	jtag.SHIFT_RETRIES = 2
+	jtag.MAX_DEVICES = MAX_DEV_NR
+	jtag.RT_CPU = -1
+	jtag.TIMING_CHECK = true
+	jtag.GRAY_ORDER = true
+	jtag.out = os.Stdout
 	return jtag
 }
 
 func (J *Jtag) setJtagDriver(driver JtagPinDriver) {
+	if J.WATCHDOG != 0 {
+		driver = &JtagPinDriverWatchdog{drv: driver, Timeout: J.WATCHDOG}
+	}
+	// outside of the watchdog, its calls must return when the budget is
+	// exceeded
+	if J.STATS || J.TOGGLE_BUDGET != 0 {
+		J.toggles = &JtagPinDriverToggles{drv: driver, Budget: J.TOGGLE_BUDGET}
+		driver = J.toggles
+	}
+	if J.STATS {
+		J.counter = &JtagPinDriverCounter{drv: driver}
+		driver = J.counter
+	}
+	// outermost, so the wrappers are spared calls changing nothing
+	driver = &JtagPinDriverCache{drv: driver}
 	J.drv = driver
 	J.drv.initDriver()
 }
@@ -118,10 +302,50 @@ func (J *Jtag) pinWriteDelay(pin JtagPin, state JtagPinState) {
 	delay(J.DELAY_TCK)
 }
 
+// Read TDO TDO_SAMPLES times and require all reads to agree, starting over
+// on disagreement, so a marginal edge or noise is not taken for data. Gives
+// up after TDO_RESAMPLES attempts and takes the majority of the last one.
+func (J *Jtag) readTdo() JtagPinState {
+	if J.TDO_SAMPLES <= 1 {
+		return J.drv.pinRead(J.TDO)
+	}
+	high := uint(0)
+	for attempt := uint(0); attempt <= TDO_RESAMPLES; attempt += 1 {
+		high = 0
+		for i := uint(0); i < J.TDO_SAMPLES; i += 1 {
+			if J.drv.pinRead(J.TDO) == StateHigh {
+				high += 1
+			}
+		}
+		if high == 0 || high == J.TDO_SAMPLES {
+			break
+		}
+		J.stats.TdoResamples += 1
+	}
+	if 2*high > J.TDO_SAMPLES {
+		return StateHigh
+	}
+	return StateLow
+}
+
 func (J *Jtag) pulseTCK(cnt int) {
+	first := J.stats.TckCycles
+	J.stats.TckCycles += uint64(cnt)
 	for i := 0; i < cnt; i += 1 {
+		J.paceTck()
+		if J.trace != nil {
+			J.trace.clock(J.TMS, J.TDI, J.TDO)
+		}
+		// reading the clock costs as much as a pin call, sample cycles
+		if J.MAX_GAP == 0 || (first+uint64(i))%GAP_CHECK_CYCLES != 0 {
+			J.pinWriteDelay(J.TCK, StateHigh)
+			J.pinWriteDelay(J.TCK, StateLow)
+			continue
+		}
+		start := time.Now()
 		J.pinWriteDelay(J.TCK, StateHigh)
 		J.pinWriteDelay(J.TCK, StateLow)
+		J.checkGap(start)
 	}
 }
 
@@ -139,6 +363,16 @@ func (J *Jtag) setTapState(tapState string) {
 		}
 		J.pulseTCK(1)
 	}
+	if tapState == TAP_RESET {
+		J.settleTap()
+	}
+}
+
+// Give the target DELAY_TAP_SETTLE after the TAP was reset.
+func (J *Jtag) settleTap() {
+	if J.DELAY_TAP_SETTLE != 0 {
+		delay(J.DELAY_TAP_SETTLE)
+	}
 }
 
 // initialize pins to a default state.
@@ -152,45 +386,50 @@ func (J *Jtag) initPins() {
 		allPins = []JtagPin{J.TCK, J.TMS, J.TDI, J.TDO, J.TRST}
 	}
 
+	// only pins which changed since the previous call reach the driver, so
+	// scans walking permutations which differ in few pins reconfigure few
+	// pins
+	listed := map[JtagPin]bool{}
 	for _, pin := range allPins {
 		if pin == J.IGNOREPIN {
 			continue
 		}
-		J.drv.pinOutput(pin)
-		J.drv.pinWrite(pin, StateHigh)
-		if J.PULLUP == true {
-			J.drv.pinPullUp(pin)
-		} else {
-			J.drv.pinPullOff(pin)
-		}
+		J.setupPin(pin, J.pinSetupOf(pin))
+		listed[pin] = true
 	}
 
-	if J.TDO != J.IGNOREPIN {
+	// known pins out of AllPins
+	if J.TDO != J.IGNOREPIN && !listed[J.TDO] {
 		J.drv.pinInput(J.TDO)
 	}
-
-	// set known clock state
-	if J.TCK != J.IGNOREPIN {
+	if J.TCK != J.IGNOREPIN && !listed[J.TCK] {
 		J.drv.pinWrite(J.TCK, StateLow)
 	}
 }
 
 func (J *Jtag) printPins() {
+	fmt.Fprint(J.out, J.formatPins())
+}
+
+// returns currently selected pins, each preceded by a space
+func (J *Jtag) formatPins() string {
+	b := &strings.Builder{}
 	if J.TRST != J.IGNOREPIN {
-		fmt.Printf(" nTRST:%s", J.PinNames[J.TRST])
+		fmt.Fprintf(b, " nTRST:%s", J.PinNames[J.TRST])
 	}
 	if J.TCK != J.IGNOREPIN {
-		fmt.Printf(" TCK:%s", J.PinNames[J.TCK])
+		fmt.Fprintf(b, " TCK:%s", J.PinNames[J.TCK])
 	}
 	if J.TMS != J.IGNOREPIN {
-		fmt.Printf(" TMS:%s", J.PinNames[J.TMS])
+		fmt.Fprintf(b, " TMS:%s", J.PinNames[J.TMS])
 	}
 	if J.TDO != J.IGNOREPIN {
-		fmt.Printf(" TDO:%s", J.PinNames[J.TDO])
+		fmt.Fprintf(b, " TDO:%s", J.PinNames[J.TDO])
 	}
 	if J.TDI != J.IGNOREPIN {
-		fmt.Printf(" TDI:%s", J.PinNames[J.TDI])
+		fmt.Fprintf(b, " TDI:%s", J.PinNames[J.TDI])
 	}
+	return b.String()
 }
 
 // This method shifts data into the target's Data Register (DR).
@@ -207,7 +446,7 @@ func (J *Jtag) sendData(pattern []byte) []byte {
 		} else {
 			J.drv.pinWrite(J.TDI, StateLow)
 		}
-		if J.drv.pinRead(J.TDO) == StateHigh {
+		if J.readTdo() == StateHigh {
 			ret = append(ret, '1')
 		} else {
 			ret = append(ret, '0')
@@ -228,6 +467,66 @@ func (J *Jtag) sendData(pattern []byte) []byte {
 	return ret
 }
 
+// This method shifts length bits through the selected Data Register (DR) in
+// chunks, parking the TAP in Pause-DR between them, so registers of any length
+// can be produced and consumed incrementally (e.g. written to disk).
+// TAP must be in Run-Test-Idle state before being called.
+// Leaves the TAP in the Run-Test-Idle state.
+// tdi -- returns bits to shift in for the chunk starting at the given bit,
+// nil to shift in 0s
+// tdo -- receives bits shifted out, chunk by chunk
+func (J *Jtag) shiftDrStream(length, chunkLen int, tdi func(int) []byte, tdo func([]byte)) {
+	J.setTapState(TAP_SHIFTDR)
+
+	for start := 0; start < length; start += chunkLen {
+		n := chunkLen
+		if start+n > length {
+			n = length - start
+		}
+		var in []byte
+		if tdi != nil {
+			in = tdi(start)
+		}
+
+		out := make([]byte, 0, n)
+		for i := 0; i < n; i += 1 {
+			if i < len(in) && in[i] == '1' {
+				J.drv.pinWrite(J.TDI, StateHigh)
+			} else {
+				J.drv.pinWrite(J.TDI, StateLow)
+			}
+			if J.readTdo() == StateHigh {
+				out = append(out, '1')
+			} else {
+				out = append(out, '0')
+			}
+			if i == n-1 {
+				// Go to Exit1 DR with the last bit of the chunk
+				J.drv.pinWrite(J.TMS, StateHigh)
+			}
+			J.pulseTCK(1)
+		}
+
+		if start+n < length {
+			// Go to Pause DR, the register keeps its contents
+			J.pulseTMS(StateLow)
+			tdo(out)
+			// Go to Exit2 DR
+			J.pulseTMS(StateHigh)
+			// Go back to Shift DR
+			J.pulseTMS(StateLow)
+		} else {
+			tdo(out)
+		}
+	}
+
+	// Go to Update DR, new data in effect
+	J.pulseTMS(StateHigh)
+
+	// Go to Run-Test-Idle
+	J.pulseTMS(StateLow)
+}
+
 // This method loads the supplied instruction into the target's Instruction Register (IR).
 // The return value is the value read from the IR.
 // TAP must be in Run-Test-Idle state before being called.
@@ -242,7 +541,7 @@ func (J *Jtag) sendInstruction(instruction []byte) []byte {
 		} else {
 			J.drv.pinWrite(J.TDI, StateLow)
 		}
-		if J.drv.pinRead(J.TDO) == StateHigh {
+		if J.readTdo() == StateHigh {
 			ret = append(ret, '1')
 		} else {
 			ret = append(ret, '0')
@@ -265,15 +564,18 @@ func (J *Jtag) sendInstruction(instruction []byte) []byte {
 
 // Run a Bypass through every device in the chain.
 // Leaves the TAP in the Run-Test-Idle state.
+// devCnt -- number of 0s appended to the pattern so that it all comes out,
+// the BYPASS instruction is sized by bypassIrLen
 // pattern -- value to shift into TDI
 // returns value received from TDO
 func (J *Jtag) sendRecvBypassPattern(devCnt int, pattern []byte) []byte {
+	irLen := J.bypassIrLen()
 	J.setTapState(TAP_RESET)
 	J.setTapState(TAP_SHIFTIR)
 
 	// Force all devices in the chain (if they exist) into BYPASS mode using opcode of all 1s
 	J.drv.pinWrite(J.TDI, StateHigh)
-	J.pulseTCK(devCnt * MAX_IR_LEN)
+	J.pulseTCK(irLen)
 
 	// Go to Exit1 IR
 	J.pulseTMS(StateHigh)
@@ -292,18 +594,77 @@ func (J *Jtag) sendRecvBypassPattern(devCnt int, pattern []byte) []byte {
 	return J.sendData(patternExt)
 }
 
+// Maximum total length of JTAG chain w/ IR selected
+func (J *Jtag) maxIrChainLen() int {
+	return J.MAX_DEVICES * MAX_IR_LEN
+}
+
+// Number of 1s shifted into IR to put every device in BYPASS given by the
+// user: BYPASS_IR_LEN, or the total of -chain-ir-lens.
+// returns 0 if neither is given
+func (J *Jtag) givenBypassIrLen() int {
+	if J.BYPASS_IR_LEN != 0 {
+		return int(J.BYPASS_IR_LEN)
+	}
+	total := 0
+	for _, irLen := range J.CHAIN.IrLens {
+		total += int(irLen)
+	}
+	return total
+}
+
+// Number of 1s shifted into IR to put every device in BYPASS while looking
+// for a chain: as given by the user, the maximum chain IR length otherwise.
+// Devices with IR longer than MAX_IR_LEN (multi-die FPGAs) get enough 1s as
+// long as the chain total fits.
+func (J *Jtag) maxBypassIrLen() int {
+	if n := J.givenBypassIrLen(); n != 0 {
+		return n
+	}
+	return J.maxIrChainLen()
+}
+
+// Number of 1s shifted into IR to put the chain on the current pins in
+// BYPASS: as given by the user, else the chain IR length measured once for
+// the pins, the maximum chain IR length only if that fails.
+// Leaves the TAP in the Run-Test-Idle state.
+func (J *Jtag) bypassIrLen() int {
+	if n := J.givenBypassIrLen(); n != 0 {
+		return n
+	}
+	if pins := J.formatPins(); J.bypassIr.pins != pins {
+		J.bypassIr.pins = pins
+		J.bypassIr.irLen = int(J.detectIrChainLength())
+	}
+	if J.bypassIr.irLen != 0 {
+		return J.bypassIr.irLen
+	}
+	return J.maxIrChainLen()
+}
+
 // Performs a blind interrogation to determine how many devices are connected in the JTAG chain.
 // In BYPASS mode, data shifted into TDI is received on TDO delayed by one clock cycle. We can
 // force all devices into BYPASS mode, shift known data into TDI, and count how many clock
 // cycles it takes for us to see it on TDO.
 // Leaves the TAP in the Run-Test-Idle state.
+// returns number of devices, 0 if there are none or the count was not
+// found within MAX_DEVICES
 func (J *Jtag) detectDevices() int {
+	devCnt, _ := J.detectDevicesLimit()
+	return devCnt
+}
+
+// Same as detectDevices, also telling whether no 0 came out within
+// MAX_DEVICES bits: TDO stuck high or a chain longer than MAX_DEVICES,
+// which a higher limit might find. 0 devices is returned then as well.
+// Leaves the TAP in the Run-Test-Idle state.
+func (J *Jtag) detectDevicesLimit() (int, bool) {
 	J.setTapState(TAP_RESET)
 	J.setTapState(TAP_SHIFTIR)
 
 	// Force all devices in the chain (if they exist) into BYPASS mode using opcode of all 1s
 	J.drv.pinWrite(J.TDI, StateHigh)
-	J.pulseTCK(MAX_IR_CHAIN_LEN - 1)
+	J.pulseTCK(J.maxBypassIrLen() - 1)
 
 	// Go to Exit1 IR
 	J.pulseTMS(StateHigh)
@@ -320,22 +681,29 @@ func (J *Jtag) detectDevices() int {
 	// Go to Shift DR Scan
 	J.pulseTMS(StateLow)
 
-	// Send 1s to fill DRs of all devices in the chain (In BYPASS mode, DR length = 1 bit)
-	J.pulseTCK(MAX_DEV_NR)
+	// Send 1s to fill DRs of all devices in the chain (In BYPASS mode, DR length = 1 bit),
+	// twice as many as looked for so that a longer chain hits the limit below instead
+	// of letting a captured 0 out early
+	J.pulseTCK(2 * J.MAX_DEVICES)
 
 	// We are now in BYPASS mode with all DR set
 	// Send in a 0 on TDI and count until we see it on TDO
 	J.drv.pinWrite(J.TDI, StateLow)
 	devCnt := 0
-	for devCnt = 0; devCnt < MAX_DEV_NR; devCnt += 1 {
-		if J.drv.pinRead(J.TDO) == StateLow {
+	for devCnt = 0; devCnt < J.MAX_DEVICES; devCnt += 1 {
+		if J.readTdo() == StateLow {
 			// If we have received our 0, it has propagated through the entire chain (one clock cycle per device in the chain)
 			break
 		}
 		J.pulseTCK(1)
 	}
 
-	if devCnt > MAX_DEV_NR-1 {
+	limitHit := devCnt > J.MAX_DEVICES-1
+	if limitHit {
+		if J.VERBOSE {
+			fmt.Fprintf(J.out, "no 0 came out of the chain after %d bits: TDO stuck high or chain longer than -max-devices\n",
+				J.MAX_DEVICES)
+		}
 		devCnt = 0
 	}
 
@@ -348,6 +716,37 @@ func (J *Jtag) detectDevices() int {
 	// Go to Run-Test-Idle
 	J.pulseTMS(StateLow)
 
+	return devCnt, limitHit
+}
+
+// Detect devices and verify the count by shifting COUNT_PATTERN through the
+// bypass chain: it must come out delayed by exactly one bit per device, at
+// no other delay. Catches miscounts on chains where TDO idles high or
+// devices don't capture 0 in BYPASS.
+// Leaves the TAP in the Run-Test-Idle state.
+// returns verified number of devices, 0 if there are none or verification
+// failed
+func (J *Jtag) countDevices() int {
+	devCnt := J.detectDevices()
+	if devCnt == 0 || len(J.COUNT_PATTERN) == 0 {
+		return devCnt
+	}
+
+	pattern := J.COUNT_PATTERN
+	recv := string(J.sendRecvBypassPattern(J.MAX_DEVICES, []byte(pattern)))
+	delays := []int{}
+	for d := 0; d+len(pattern) <= len(recv); d += 1 {
+		if recv[d:d+len(pattern)] == pattern {
+			delays = append(delays, d)
+		}
+	}
+	if len(delays) != 1 || delays[0] != devCnt {
+		if J.VERBOSE {
+			fmt.Fprintf(J.out, "device count %d not confirmed, pattern delayed by %v bits: %s\n",
+				devCnt, delays, J.formatBits(recv))
+		}
+		return 0
+	}
 	return devCnt
 }
 
@@ -357,6 +756,16 @@ func (J *Jtag) detectDevices() int {
 // Leaves the TAP in the Run-Test-Idle state.
 // Returns length of the instruction register
 func (J *Jtag) detectIrLength() uint32 {
+	return J.measureIrLength(MAX_IR_LEN)
+}
+
+// Shift IR filled with 0s until a 1 shifted in comes out of TDO.
+// limit -- length limit, the IR of the whole chain is measured if it is
+// large enough
+// Leaves the TAP in the Run-Test-Idle state.
+// returns number of bits the 1 went through, 0 if it didn't come out or too
+// early
+func (J *Jtag) measureIrLength(limit int) uint32 {
 	// Reset TAP to Run-Test-Idle
 	J.setTapState(TAP_RESET)
 	// Go to Shift IR
@@ -365,22 +774,23 @@ func (J *Jtag) detectIrLength() uint32 {
 	// Flush the IR
 	J.drv.pinWrite(J.TCK, StateLow)
 	// Since the length is unknown, send lots of 0s
-	J.pulseTCK(MAX_IR_LEN - 1)
+	J.drv.pinWrite(J.TDI, StateLow)
+	J.pulseTCK(limit - 1)
 
 	// Once we are sure that the IR is filled with 0s
 	// Send in a 1 on TDI and count until we see it on TDO
 	J.drv.pinWrite(J.TDI, StateHigh)
 	num := uint32(0)
-	for num = 0; num < MAX_IR_LEN; num += 1 {
+	for num = 0; num < uint32(limit); num += 1 {
 		// If we have received our 1, it has propagated through the entire instruction register
-		if J.drv.pinRead(J.TDO) == StateHigh {
+		if J.readTdo() == StateHigh {
 			break
 		}
 		J.pulseTCK(1)
 	}
 
 	// If no 1 is received, then we are unable to determine IR length
-	if (num > MAX_IR_LEN-1) || (num < MIN_IR_LEN) {
+	if (num > uint32(limit)-1) || (num < MIN_IR_LEN) {
 		num = 0
 	}
 
@@ -396,6 +806,16 @@ func (J *Jtag) detectIrLength() uint32 {
 	return num
 }
 
+// IR length given with -ir-len, detected one otherwise. Some TAPs capture
+// fixed patterns which fool detection.
+// Leaves the TAP in the Run-Test-Idle state.
+func (J *Jtag) irLength() uint32 {
+	if J.IR_LEN != 0 {
+		return J.IR_LEN
+	}
+	return J.detectIrLength()
+}
+
 // Performs an interrogation to determine the data register length of the target device.
 // The selected data register will vary depending on the the instruction.
 // Limited in length to MAX_DR_LEN.
@@ -404,25 +824,34 @@ func (J *Jtag) detectIrLength() uint32 {
 // opcode -- opcode/instruction to be sent to TAP
 // returns length of the data register
 func (J *Jtag) detectDrLength(opcode uint32) uint32 {
+	drlen, _ := J.detectDr(opcode)
+	return drlen
+}
+
+// Same as detectDrLength, also returning the value the register captured,
+// its first min(length, DR_CAPTURE_LEN) bits in shift order. It is shifted
+// out while the register is flushed, so it costs nothing extra.
+func (J *Jtag) detectDr(opcode uint32) (uint32, string) {
 	// Determine length of TAP IR
-	len := J.detectIrLength()
+	len := J.irLength()
 	// Send instruction/opcode (only len bits)
-	opcodeStr := []byte{}
-	for i := uint32(0); i < len; i += 1 {
-		if (opcode | (1 << i)) != 0 {
-			opcodeStr = append(opcodeStr, '1')
-		} else {
-			opcodeStr = append(opcodeStr, '0')
-		}
-	}
-	J.sendInstruction(opcodeStr)
-	// Go to Shift IR
-	J.setTapState(TAP_SHIFTIR)
+	J.sendInstruction(irBits(opcode, len))
+	// Go to Shift DR
+	J.setTapState(TAP_SHIFTDR)
 
 	// At this point, a specific DR will be selected, so we can now determine its length.
-	// Flush the DR
+	// Flush the DR, its captured value comes out first
 	J.drv.pinWrite(J.TDI, StateLow)
-	J.pulseTCK(MAX_DR_LEN - 1)
+	capture := []byte{}
+	for i := 0; i < DR_CAPTURE_LEN; i += 1 {
+		if J.readTdo() == StateHigh {
+			capture = append(capture, '1')
+		} else {
+			capture = append(capture, '0')
+		}
+		J.pulseTCK(1)
+	}
+	J.pulseTCK(MAX_DR_LEN - 1 - DR_CAPTURE_LEN)
 
 	// Once we are sure that the DR is filled with 0s
 	// Send in a 1 on TDI and count until we see it on TDO
@@ -430,16 +859,19 @@ func (J *Jtag) detectDrLength(opcode uint32) uint32 {
 	num := uint32(0)
 	for num = 0; num < MAX_DR_LEN; num += 1 {
 		// If we have received our 1, it has propagated through the entire data register
-		if J.drv.pinRead(J.TDO) == StateHigh {
+		if J.readTdo() == StateHigh {
 			break
 		}
 		J.pulseTCK(1)
 	}
 
 	// If no 1 is received, then we are unable to determine DR length
-	if num > MAX_IR_LEN-1 {
+	if num > MAX_DR_LEN-1 {
 		num = 0
 	}
+	if num < DR_CAPTURE_LEN {
+		capture = capture[:num]
+	}
 
 	// Go to Exit1 DR
 	J.pulseTMS(StateHigh)
@@ -450,158 +882,179 @@ func (J *Jtag) detectDrLength(opcode uint32) uint32 {
 	// Go to Run-Test-Idle
 	J.pulseTMS(StateLow)
 
-	return num
+	return num, string(capture)
 }
 
 func (J *Jtag) scanBypass(pattern string) {
-	fmt.Println("================================")
-	fmt.Printf("Starting scan for pattern %s\n", pattern)
-	defer fmt.Println("================================")
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Starting scan for pattern %s\n", pattern)
+	defer fmt.Fprintln(J.out, "================================")
+
+	for _, p := range J.permutations(4) {
+		tck, tms, tdo, tdi := p[0], p[1], p[2], p[3]
+		J.trackPermutation(p)
+
+		J.TDI = tdi
+		J.TDO = tdo
+		J.TMS = tms
+		J.TCK = tck
+		J.TRST = J.IGNOREPIN
+
+		J.initPins()
+		J.pacePermutation()
+		J.stats.Permutations += 1
+
+		J.stats.setPhase("detect devices")
+		devCnt, limitHit := J.detectDevicesLimit()
+		if limitHit {
+			// not ruled out, a higher -max-devices may find the chain
+			J.permutationInconclusive()
+		}
+		if devCnt == 0 {
+			continue
+		}
 
-	for _, tck := range J.AllPins {
-		for _, tms := range J.AllPins {
-			if tms == tck {
-				continue
-			}
-			for _, tdo := range J.AllPins {
-				if tdo == tck || tdo == tms {
-					continue
-				}
-				for _, tdi := range J.AllPins {
-					if tdi == tck || tdi == tms || tdi == tdo {
-						continue
-					}
+		J.stats.setPhase("bypass pattern")
+		bitsRecv := J.sendRecvBypassPattern(devCnt, []byte(pattern))
+		// we need only last len(pattern) bits
+		patternRecv := string(bitsRecv[devCnt:])
 
-					J.TDI = tdi
-					J.TDO = tdo
-					J.TMS = tms
-					J.TCK = tck
-					J.TRST = J.IGNOREPIN
+		if patternRecv == pattern {
+			fmt.Fprint(J.out, "FOUND! ")
+			J.printPins()
+			found := J.recordPinout()
+			found.DeviceCount = devCnt
 
-					J.initPins()
+			J.stats.setPhase("nTRST probing")
+			fmt.Fprintf(J.out, ", possible nTRST: %s\n", J.probeTrstIfAllowed(found))
 
-					devCnt := J.detectDevices()
-					if devCnt == 0 || devCnt > MAX_DEV_NR {
-						continue
-					}
-
-					bitsRecv := J.sendRecvBypassPattern(devCnt, []byte(pattern))
-					// we need only last len(pattern) bits
-					patternRecv := string(bitsRecv[devCnt:])
-
-					if patternRecv == pattern {
-						fmt.Print("FOUND! ")
-						J.printPins()
-
-						fmt.Print(", possible nTRST: ")
-
-						// Now try to determine if the TRST# pin is being used on the target
-						for _, trst := range J.AllPins {
-							if trst == tdi || trst == tdo || trst == tms || trst == tck {
-								continue
-							}
-
-							J.TRST = trst
-
-							// do reset
-							J.drv.pinWrite(J.TRST, StateLow)
-							// Give target time to react
-							delay(J.DELAY_RESET)
-
-							devCntNew := J.detectDevices()
-							// If the new value doesn't match what we already have, then the current pin may be a reset line.
-							if devCntNew != devCnt {
-								fmt.Printf("%s ", J.PinNames[J.TRST])
-							}
-
-							// Bring the current pin HIGH when done
-							J.drv.pinWrite(J.TRST, StateHigh)
-						}
-						fmt.Println("")
-					} else {
-						fmt.Print("active, ")
-						J.printPins()
-						fmt.Printf(", wrong data received (%s)\n", patternRecv)
-						fmt.Println("       try adjusting frequency, delays, pullup, check hardware connectivity")
-					}
-				}
-			}
+			J.stats.setPhase("timing check")
+			J.checkTimingMargin(found, pattern)
+			J.pinoutDone()
+		} else {
+			J.permutationInconclusive()
+			fmt.Fprint(J.out, "active, ")
+			J.printPins()
+			fmt.Fprintf(J.out, ", wrong data received (%s)\n", J.formatBits(patternRecv))
+			fmt.Fprintln(J.out, "       try adjusting frequency, delays, pullup, check hardware connectivity")
 		}
 	}
+	J.trackPermutation(nil)
+
+	J.stats.setPhase("nTRST probing")
+	J.probeTrstDeferred()
 }
 
-func (J *Jtag) testBypass(pattern string) {
-	fmt.Println("================================")
-	fmt.Printf("Starting BYPASS test for pattern %s\n", pattern)
-	defer fmt.Println("================================")
+// returns all pins commands may use, either pins to scan or known pins
+func (J *Jtag) usedPins() []JtagPin {
+	if len(J.AllPins) != 0 {
+		return J.AllPins
+	}
+	ret := []JtagPin{}
+	k := J.KnownPins
+	for _, pin := range []JtagPin{k.TDI, k.TDO, k.TCK, k.TMS, k.TRST} {
+		if pin != J.IGNOREPIN {
+			ret = append(ret, pin)
+		}
+	}
+	return ret
+}
 
-	J.TCK = J.KnownPins.TCK
-	J.TDO = J.KnownPins.TDO
+// Select pins provided by user as known JTAG pins assignment.
+func (J *Jtag) useKnownPins() {
 	J.TDI = J.KnownPins.TDI
+	J.TDO = J.KnownPins.TDO
+	J.TCK = J.KnownPins.TCK
 	J.TMS = J.KnownPins.TMS
 	J.TRST = J.KnownPins.TRST
+}
 
-	J.initPins()
-
-	devCnt := J.detectDevices()
-	if devCnt == 0 || devCnt >= MAX_DEV_NR-1 {
-		fmt.Println("no devices found")
-		return
+// Detect devices on currently selected pins and run the pattern through
+// the bypass chain.
+// returns number of devices and the pattern received back (empty if no
+// devices found)
+func (J *Jtag) bypassRoundtrip(pattern string) (int, string) {
+	devCnt := J.countDevices()
+	if devCnt == 0 {
+		return 0, ""
 	}
 
 	bitsRecv := J.sendRecvBypassPattern(devCnt, []byte(pattern))
 	// we need only last len(pattern) bits
-	patternRecv := string(bitsRecv[devCnt:])
+	return devCnt, string(bitsRecv[devCnt:])
+}
 
-	fmt.Printf("sent pattern: %s\n", pattern)
-	fmt.Printf("recv pattern: %s\n", patternRecv)
+// returns true if pattern went through the chain intact
+func (J *Jtag) testBypass(pattern string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Starting BYPASS test for pattern %s\n", pattern)
+	defer fmt.Fprintln(J.out, "================================")
+
+	J.useKnownPins()
+
+	J.initPins()
+
+	devCnt, patternRecv := J.bypassRoundtrip(pattern)
+	if devCnt == 0 {
+		fmt.Fprintln(J.out, "no devices found")
+		return false
+	}
+
+	fmt.Fprintf(J.out, "sent pattern: %s\n", J.formatBits(pattern))
+	fmt.Fprintf(J.out, "recv pattern: %s\n", J.formatBits(patternRecv))
 
 	if patternRecv == pattern {
-		fmt.Println("match!")
-	} else {
-		fmt.Println("no match")
+		fmt.Fprintln(J.out, "match!")
+		return true
 	}
+	fmt.Fprintln(J.out, "no match")
+	return false
 }
 
 // Retrieves the JTAG device ID from each device in the chain.
 // Leaves the TAP in the Run-Test-Idle state.
 // The Device Identification register (if it exists) should be immediately available
 // in the DR after power-up of the target device or after TAP reset.
+// Devices without IDCODE register load BYPASS instead which is a single bit
+// captured as 0, while IDCODE always has bit 0 set. Thus, a leading 0 means
+// 1-bit BYPASS register and the next bit belongs to the following device.
 // argument -- number of devices in JTAG chain
-// returns array of idcodes obtained (they still need verification)
+// returns array of idcodes obtained (they still need verification), BYPASS_IDCODE
+// for devices without IDCODE
 func (J *Jtag) getIdcodes(devCnt int) []uint32 {
 	// Reset TAP to Run-Test-Idle
 	J.setTapState(TAP_RESET)
 	// Go to Shift DR
 	J.setTapState(TAP_SHIFTDR)
 
+	// Shift in 1s, so the end of the chain reads as 0xFFFFFFFF rather than
+	// a sequence of BYPASS devices
+	if J.TDI != J.IGNOREPIN {
+		J.drv.pinWrite(J.TDI, StateHigh)
+	}
+
 	idcodes := []uint32{}
 
 	// For each device in the chain...
 	for i := 0; i < devCnt; i += 1 {
-		// Receive 32-bit value from DR (should be IDCODE if exists), leaves the TAP in Exit1 DR
-		idcode := uint32(0)
-		for k := 0; k < 32; k += 1 {
-			if J.drv.pinRead(J.TDO) == StateHigh {
+		if J.readTdo() == StateLow {
+			// 1-bit BYPASS register, no IDCODE
+			J.pulseTCK(1)
+			idcodes = append(idcodes, BYPASS_IDCODE)
+			continue
+		}
+
+		// Receive the rest of 32-bit value from DR
+		idcode := uint32(1)
+		J.pulseTCK(1)
+		for k := 1; k < 32; k += 1 {
+			if J.readTdo() == StateHigh {
 				idcode |= (1 << uint(k))
 			}
-			if k == 31 {
-				// Go to Exit1
-				J.drv.pinWrite(J.TMS, StateHigh)
-			}
 			J.pulseTCK(1)
 		}
 
 		idcodes = append(idcodes, idcode)
-
-		// Go to Pause DR
-		J.pulseTMS(StateLow)
-
-		// Go to Exit2 DR
-		J.pulseTMS(StateHigh)
-
-		// Go to Shift DR
-		J.pulseTMS(StateLow)
 	}
 
 	// Reset TAP to Run-Test-Idle
@@ -610,78 +1063,173 @@ func (J *Jtag) getIdcodes(devCnt int) []uint32 {
 	return idcodes
 }
 
-func (J *Jtag) scanIdcode() {
-	fmt.Println("================================")
-	fmt.Println("Starting scan for IDCODE...")
-	defer fmt.Println("================================")
+// pattern -- shifted through the chain to confirm TDI once IDCODE is found
+// filter -- report only chains having a device matching any of these, may be
+// empty
+func (J *Jtag) scanIdcode(pattern string, filter []IdcodeMatch) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting scan for IDCODE...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	for _, p := range J.permutations(3) {
+		tck, tms, tdo := p[0], p[1], p[2]
+		J.trackPermutation(p)
+
+		J.TCK = tck
+		J.TMS = tms
+		J.TDO = tdo
+		J.TDI = J.IGNOREPIN
+		J.TRST = J.IGNOREPIN
+
+		J.initPins()
+		J.pacePermutation()
+		J.stats.Permutations += 1
+
+		J.stats.setPhase("IDCODE")
+		// Try to get the 1st Device ID in the chain (if it exists) by reading the DR
+		idcodes := J.getIdcodes(1)
+		if isValidIdcode(idcodes[0]) {
+			// filtered out or not confirmed, it may still be a pinout
+			J.permutationInconclusive()
+		}
+
+		if isValidIdcode(idcodes[0]) && J.confirmIdcode(idcodes[0]) {
+			// Since we might not know how many devices are in the chain, try the maximum allowable number and verify the results afterwards
+			idcodes = J.getIdcodes(J.MAX_DEVICES)
+			J.warnTruncatedChain(idcodes)
 
-	for _, tck := range J.AllPins {
-		for _, tms := range J.AllPins {
-			if tms == tck {
+			if !matchAnyIdcode(chainIdcodes(idcodes), filter) {
+				if J.VERBOSE {
+					fmt.Fprint(J.out, "filtered out ")
+					J.printPins()
+					fmt.Fprintf(J.out, ": %08x\n", chainIdcodes(idcodes))
+				}
 				continue
 			}
-			for _, tdo := range J.AllPins {
-				if tdo == tck || tdo == tms {
-					continue
-				}
 
-				J.TCK = tck
-				J.TMS = tms
-				J.TDO = tdo
-				J.TDI = J.IGNOREPIN
-				J.TRST = J.IGNOREPIN
+			fmt.Fprint(J.out, "FOUND! ")
+			J.printPins()
+			score, _ := scoreIdcode(idcodes[0])
+			fmt.Fprintf(J.out, ", score %d/%d\n", score, IDCODE_SCORE_MAX)
+
+			found := J.recordPinout()
+			fmt.Fprintln(J.out, "     devices:")
+			for i, idcode := range chainIdcodes(idcodes) {
+				if idcode == BYPASS_IDCODE || isValidIdcode(idcode) {
+					fmt.Fprintf(J.out, "        device %d: %s\n", i, describeChainIdcode(idcode))
+					found.Idcodes = append(found.Idcodes, idcode)
+					score := 0
+					if idcode != BYPASS_IDCODE {
+						score, _ = scoreIdcode(idcode)
+					}
+					found.IdcodeScores = append(found.IdcodeScores, score)
+				}
+			}
+			found.DeviceCount = len(found.Idcodes)
 
-				J.initPins()
+			J.stats.setPhase("TDI verification")
+			tdi := J.findTdi(pattern)
+			if tdi != J.IGNOREPIN {
+				found.TDI = J.PinNames[tdi]
+				fmt.Fprintf(J.out, "     TDI:%s, full 4-wire pinout confirmed by BYPASS\n", found.TDI)
+			} else {
+				fmt.Fprintln(J.out, "     TDI not found, pinout confirmed by IDCODE only")
+			}
 
-				// Try to get the 1st Device ID in the chain (if it exists) by reading the DR
-				idcodes := J.getIdcodes(1)
+			J.stats.setPhase("SWD check")
+			J.checkSwd(found)
 
-				// Ignore if received Device ID is 0xFFFFFFFF or if bit 0 != 1
-				if idcodes[0] != 0xFFFFFFFF && (idcodes[0]%2) != 0 {
-					fmt.Print("FOUND! ")
-					J.printPins()
-					fmt.Println("")
+			J.stats.setPhase("nTRST probing")
+			fmt.Fprintf(J.out, "     possible nTRST: %s\n", J.probeTrstIfAllowed(found))
 
-					// Since we might not know how many devices are in the chain, try the maximum allowable number and verify the results afterwards
-					idcodes = J.getIdcodes(MAX_DEV_NR)
-
-					fmt.Println("     devices:")
-					for _, idcode := range idcodes {
-						if idcode != 0xFFFFFFFF && (idcode%2) != 0 {
-							fmt.Printf("        %s\n", describeIdcode(idcode))
-						}
-					}
+			J.stats.setPhase("timing check")
+			J.checkTimingMargin(found, pattern)
+			J.pinoutDone()
+		}
+	}
+	J.trackPermutation(nil)
 
-					fmt.Print("     possible nTRST: ")
+	J.stats.setPhase("nTRST probing")
+	J.probeTrstDeferred()
+}
 
-					// Now try to determine if the TRST# pin is being used on the target
-					for _, trst := range J.AllPins {
-						if trst == tck || trst == tms || trst == tdo {
-							continue
-						}
+// A floating TDO may produce a random odd value once, so require the first
+// IDCODE to be read identically J.IDCODE_READS times and its manufacturer to
+// exist in JEP106.
+// returns true if IDCODE looks genuine
+func (J *Jtag) confirmIdcode(idcode uint32) bool {
+	reads := []uint32{idcode}
+	consistent := true
+	for len(reads) < int(J.IDCODE_READS) {
+		idcodeNew := J.getIdcodes(1)[0]
+		reads = append(reads, idcodeNew)
+		if idcodeNew != idcode {
+			consistent = false
+			break
+		}
+	}
 
-						J.TRST = trst
+	reason := ""
+	if !consistent {
+		reason = "inconsistent reads"
+	} else if !isKnownManufacturer(idcode) {
+		reason = "unknown manufacturer"
+	}
+	if reason != "" && J.VERBOSE {
+		fmt.Fprint(J.out, "rejected ")
+		J.printPins()
+		fmt.Fprintf(J.out, ": %s, reads %08x\n", reason, reads)
+	}
+	if !consistent {
+		if closest := describeClosestIdcodes(reads); closest != "" {
+			fmt.Fprint(J.out, "unconfirmed ")
+			J.printPins()
+			fmt.Fprintf(J.out, ": %s\n", closest)
+		}
+	}
+	return reason == ""
+}
 
-						// do reset
-						J.drv.pinWrite(J.TRST, StateLow)
-						// Give target time to react
-						delay(J.DELAY_RESET)
+// Once TCK, TMS and TDO are known, try the remaining pins as TDI and shift
+// the pattern through the chain in BYPASS, it must come back intact.
+// returns TDI pin found or IGNOREPIN
+func (J *Jtag) findTdi(pattern string) JtagPin {
+	for _, tdi := range J.AllPins {
+		if tdi == J.TCK || tdi == J.TMS || tdi == J.TDO || J.OBSERVE_ONLY[tdi] {
+			continue
+		}
 
-						// Try to get Device ID again by reading the DR (1st in the chain)
-						idcodesNew := J.getIdcodes(1)
-						// If the new value doesn't match what we already have, then the current pin may be a reset line.
-						if len(idcodesNew) != len(idcodes) || (idcodesNew[0] != idcodes[0]) {
-							fmt.Printf("%s ", J.PinNames[J.TRST])
-						}
+		J.TDI = tdi
+		devCnt, patternRecv := J.bypassRoundtrip(pattern)
+		// all spare pins are kept high
+		J.drv.pinWrite(tdi, StateHigh)
+		if devCnt != 0 && patternRecv == pattern {
+			return tdi
+		}
+	}
+	J.TDI = J.IGNOREPIN
+	return J.IGNOREPIN
+}
 
-						// Bring the current pin HIGH when done
-						J.drv.pinWrite(J.TRST, StateHigh)
-					}
-					fmt.Println("")
-				}
-			}
+// Write pattern to TDI and sample TDO after each bit, TAP state is ignored.
+// settle -- delay in microseconds between write and read
+// returns bits sampled on TDO
+func (J *Jtag) shiftLoopback(pattern string, settle uint) []byte {
+	recv := []byte{}
+	for _, s := range pattern {
+		if s == '1' {
+			J.drv.pinWrite(J.TDI, StateHigh)
+		} else {
+			J.drv.pinWrite(J.TDI, StateLow)
+		}
+		delay(settle)
+		if J.readTdo() == StateHigh {
+			recv = append(recv, '1')
+		} else {
+			recv = append(recv, '0')
 		}
 	}
+	return recv
 }
 
 // Check for pins that pass pattern[] between tdi and tdo
@@ -692,13 +1240,13 @@ func (J *Jtag) scanIdcode() {
 // the test again without the cable connected between controller
 // and target. Run with the verbose flag to examine closely.
 func (J *Jtag) checkLoopback(pattern string) {
-	fmt.Println("================================")
-	fmt.Println("Starting loopback check...")
-	defer fmt.Println("================================")
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting loopback check...")
+	defer fmt.Fprintln(J.out, "================================")
 
 	for _, tdo := range J.AllPins {
 		for _, tdi := range J.AllPins {
-			if tdi == tdo {
+			if tdi == tdo || J.OBSERVE_ONLY[tdi] {
 				continue
 			}
 
@@ -709,27 +1257,17 @@ func (J *Jtag) checkLoopback(pattern string) {
 			J.TMS = J.IGNOREPIN
 
 			J.initPins()
+			J.pacePermutation()
+			J.stats.Permutations += 1
 
-			recv := []byte{}
-			for _, s := range pattern {
-				if s == '1' {
-					J.drv.pinWrite(J.TDI, StateHigh)
-				} else {
-					J.drv.pinWrite(J.TDI, StateLow)
-				}
-				if J.drv.pinRead(J.TDO) == StateHigh {
-					recv = append(recv, '1')
-				} else {
-					recv = append(recv, '0')
-				}
-			}
+			recv := J.shiftLoopback(pattern, 0)
 
 			if string(recv) == pattern {
-				fmt.Printf("possible short detected between %s and %s\n", J.PinNames[J.TDO], J.PinNames[J.TDI])
+				fmt.Fprintf(J.out, "possible short detected between %s and %s\n", J.PinNames[J.TDO], J.PinNames[J.TDI])
 			} else {
 				for i := 1; i < len(recv); i += 1 {
 					if recv[i] != recv[0] {
-						fmt.Printf("possible interconnection (check cable) detected between %s and %s\n", J.PinNames[J.TDO], J.PinNames[J.TDI])
+						fmt.Fprintf(J.out, "possible interconnection (check cable) detected between %s and %s\n", J.PinNames[J.TDO], J.PinNames[J.TDI])
 						return
 					}
 				}
@@ -738,142 +1276,314 @@ func (J *Jtag) checkLoopback(pattern string) {
 	}
 }
 
-func (J *Jtag) testIdcode() {
-	fmt.Println("================================")
-	fmt.Println("Attempting to retreive IDCODE...")
-	defer fmt.Println("================================")
+// expected -- IDCODEs to verify the chain against, may be empty
+// returns true if IDCODEs match expected ones, or if at least one device was
+// found when nothing is expected
+func (J *Jtag) testIdcode(expected []IdcodeMatch) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Attempting to retreive IDCODE...")
+	defer fmt.Fprintln(J.out, "================================")
 
-	J.TDI = J.KnownPins.TDI
-	J.TDO = J.KnownPins.TDO
-	J.TCK = J.KnownPins.TCK
-	J.TMS = J.KnownPins.TMS
-	J.TRST = J.KnownPins.TRST
+	idcodes := J.readKnownIdcodes()
+	J.results.Idcodes = idcodes
 
-	J.initPins()
+	fmt.Fprintln(J.out, "devices:")
 
-	// Since we might not know how many devices are in the chain, try the maximum allowable number and verify the results afterwards
-	idcodes := J.getIdcodes(MAX_DEV_NR)
+	// For each device in the chain...
+	for i, idcode := range idcodes {
+		fmt.Fprintf(J.out, "device %d: %s\n", i, describeChainIdcode(idcode))
+	}
+
+	reason := checkExpectedIdcodes(idcodes, expected)
+	if len(expected) != 0 {
+		if reason == "" {
+			fmt.Fprintln(J.out, "expected IDCODEs match!")
+		} else {
+			fmt.Fprintf(J.out, "no match: %s\n", reason)
+		}
+	}
+	return reason == ""
+}
 
-	fmt.Println("devices:")
+// Read IDCODEs of the chain on known pins.
+// returns IDCODEs up to the end of the chain, BYPASS_IDCODE for devices
+// without IDCODE
+func (J *Jtag) readKnownIdcodes() []uint32 {
+	J.useKnownPins()
 
-	// For each device in the chain...
-	for _, idcode := range idcodes {
-		// Ignore if received Device ID is 0xFFFFFFFF or if bit 0 != 1
-		if idcode != 0xFFFFFFFF && (idcode%2) != 0 {
-			fmt.Println(describeIdcode(idcode))
+	J.initPins()
+
+	// Since we might not know how many devices are in the chain, try the maximum allowable number and verify the results afterwards
+	idcodes := J.getStableIdcodes(J.MAX_DEVICES)
+	J.warnTruncatedChain(idcodes)
+	ret := []uint32{}
+	for _, idcode := range chainIdcodes(idcodes) {
+		if idcode == BYPASS_IDCODE || isValidIdcode(idcode) {
+			ret = append(ret, idcode)
 		}
 	}
+	if J.LOAD_IDCODE {
+		ret = J.loadIdcodes(ret)
+	}
+	return ret
 }
 
-func (J *Jtag) discoverOpcode() {
-	fmt.Println("================================")
-	fmt.Println("Attempting to retreive IDCODE...")
-	defer fmt.Println("================================")
+// bsdl -- description of the device giving boundary register length, may be
+// nil
+// safe -- skip dangerous opcodes and check the target stays healthy
+// probeAccess -- write into DRs found to tell read-write ones from
+// capture-only ones
+func (J *Jtag) discoverOpcode(bsdl *Bsdl, safe SafeOptions, probeAccess bool) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Attempting to retreive IDCODE...")
+	defer fmt.Fprintln(J.out, "================================")
 
-	J.TDI = J.KnownPins.TDI
-	J.TDO = J.KnownPins.TDO
-	J.TCK = J.KnownPins.TCK
-	J.TMS = J.KnownPins.TMS
-	J.TRST = J.KnownPins.TRST
+	J.useKnownPins()
 
 	J.initPins()
 
 	// Get number of devices in the chain
-	devCnt := J.detectDevices()
+	devCnt := J.countDevices()
 	if devCnt == 0 {
-		fmt.Println("no devices in chain")
+		fmt.Fprintln(J.out, "no devices in chain")
 		return
 	} else if devCnt > 1 {
-		fmt.Println("more than one device in chain")
+		fmt.Fprintln(J.out, "more than one device in chain")
 		return
 	}
 
-	irlen := J.detectIrLength()
-	fmt.Print("IR length: ")
+	irlen := J.irLength()
+	fmt.Fprint(J.out, "IR length: ")
 	if irlen == 0 {
-		fmt.Println("N/A")
+		fmt.Fprintln(J.out, "N/A")
 		return
 	} else {
-		fmt.Println(irlen)
+		fmt.Fprintln(J.out, irlen)
 	}
+	J.results.IrLength = irlen
 
 	opcodeMax := uint32((1 << irlen) - 1)
-	fmt.Printf("Possible instructions: %d\n", opcodeMax)
+	fmt.Fprintf(J.out, "Possible instructions: %d\n", opcodeMax)
+
+	denied := map[uint32]bool{}
+	idcode := uint32(0)
+	if safe.Enabled {
+		idcode = J.getIdcodes(1)[0]
+		var vendors []string
+		denied, vendors = safe.deniedOpcodes(idcode, irlen)
+		fmt.Fprintf(J.out, "safe mode: skipping %d opcodes", len(denied))
+		if len(vendors) != 0 {
+			fmt.Fprintf(J.out, " (incl. known dangerous ones for %s)", strings.Join(vendors, ", "))
+		}
+		fmt.Fprintln(J.out)
+		if !J.confirm(fmt.Sprintf("load %d opcodes into device with IDCODE 0x%08x?", int(opcodeMax)-len(denied), idcode), safe.Yes) {
+			fmt.Fprintln(J.out, "aborted")
+			return
+		}
+	}
 
 	// For every possible instruction...
+	tried := 0
+	lastChecked := uint32(0)
 	for opcode := uint32(0); opcode < opcodeMax; opcode += 1 {
+		if denied[opcode] {
+			if J.VERBOSE {
+				fmt.Fprintf(J.out, "skipping opcode 0x%x\n", opcode)
+			}
+			continue
+		}
+		if safe.Enabled && safe.HealthEvery != 0 && tried != 0 && tried%safe.HealthEvery == 0 {
+			if got := J.getIdcodes(1)[0]; got != idcode {
+				fmt.Fprintf(J.out, "target upset: IDCODE reads 0x%08x instead of 0x%08x after opcodes 0x%x-0x%x\n",
+					got, idcode, lastChecked, opcode-1)
+				if !J.confirm("continue?", false) {
+					break
+				}
+				idcode = got
+			}
+			lastChecked = opcode
+		}
+		if safe.Delay != 0 && tried != 0 {
+			time.Sleep(safe.Delay)
+		}
+		tried += 1
+
 		// Get the DR length
-		drlen := J.detectDrLength(opcode)
+		drlen, capture := J.detectDr(opcode)
 		// ignore 1-bit instructions
 		if drlen > 1 {
 			// Display the result
-			fmt.Printf("%s\n", describeIrDr(irlen, opcode, drlen))
+			fmt.Fprintf(J.out, "%s capture: %s\n", describeIrDr(irlen, opcode, drlen), J.bitsToHex(capture))
+			J.results.Opcodes = append(J.results.Opcodes, OpcodeResult{Opcode: opcode, DrLength: drlen, Capture: capture})
 		}
 	}
 
+	J.classifyOpcodes(bsdl, probeAccess)
+
 	// Reset TAP to Run-Test-Idle
 	J.setTapState(TAP_RESET)
 }
 
-func (J *Jtag) boundaryScan() {
-	fmt.Println("================================")
-	fmt.Println("Starting boundary scan...")
-	defer fmt.Println("================================")
+// drLen -- number of bits to capture, 0 for the boundary register length
+// dumpPath -- file to stream captured bits to instead of printing them
+// bsdl -- description of the device, may be nil
+// sampleOpcode -- SAMPLE/PRELOAD opcode, negative to discover it
+func (J *Jtag) boundaryScan(drLen int, dumpPath string, bsdl *Bsdl, sampleOpcode int64) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting boundary scan...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	irSample, drLen := J.prepareSample(drLen, bsdl, sampleOpcode)
+	if irSample == nil {
+		return
+	}
 
-	J.TDI = J.KnownPins.TDI
-	J.TDO = J.KnownPins.TDO
-	J.TCK = J.KnownPins.TCK
-	J.TMS = J.KnownPins.TMS
-	J.TRST = J.KnownPins.TRST
+	// Shift out the data register selected by the instruction we sent, in
+	// our case SAMPLE/boundary scan
+	capture := func(tdo func([]byte)) {
+		J.setTapState(TAP_RESET)
+		J.sendInstruction(irSample)
+		J.shiftDrStream(drLen, DR_CHUNK_LEN, nil, tdo)
+	}
+
+	if len(dumpPath) != 0 {
+		info := CaptureInfo{
+			Timestamp:   time.Now(),
+			Instruction: string(irSample),
+			Position:    0,
+			Bits:        drLen,
+		}
+		J.dumpStream(dumpPath, info, capture)
+		J.setTapState(TAP_RESET)
+		return
+	}
+
+	bits, _ := J.verifiedShift(func() string {
+		bits := []byte{}
+		capture(func(chunk []byte) { bits = append(bits, chunk...) })
+		return string(bits)
+	})
+	for i, b := range bits {
+		fmt.Fprintf(J.out, "%c", b)
+		if i%32 == 31 {
+			fmt.Fprint(J.out, " ")
+		}
+		if i%128 == 127 {
+			fmt.Fprintln(J.out)
+		}
+	}
+	fmt.Fprintln(J.out)
+	fmt.Fprintf(J.out, "hex: %s\n", J.bitsToHex(string(bits)))
+
+	// Reset TAP to Run-Test-Idle
+	J.setTapState(TAP_RESET)
+}
+
+// Find SAMPLE/PRELOAD opcode and boundary register length of the single
+// device on the known pins.
+// drLen -- number of bits to capture, 0 for the boundary register length
+// sampleOpcode -- SAMPLE/PRELOAD opcode, negative to find it
+// returns bits to shift into IR and number of bits to capture, nil if the
+// device can't be sampled
+func (J *Jtag) prepareSample(drLen int, bsdl *Bsdl, sampleOpcode int64) ([]byte, int) {
+	J.useKnownPins()
 
 	J.initPins()
 
 	// Get number of devices in the chain
-	devCnt := J.detectDevices()
+	devCnt := J.countDevices()
 	if devCnt == 0 {
-		fmt.Println("no devices in chain")
-		return
+		fmt.Fprintln(J.out, "no devices in chain")
+		return nil, 0
 	} else if devCnt > 1 {
-		fmt.Println("more than one device in chain, not supported")
-		return
+		fmt.Fprintln(J.out, "more than one device in chain, not supported")
+		return nil, 0
 	}
 
 	// Determine length of TAP IR
-	irLen := J.detectIrLength()
-	// IR registers must be IR_LEN wide:
-	irSample := []byte{'1', '0', '1'}
-	if irLen > uint32(len(irSample)) {
-		for i := uint32(0); i < irLen-3; i += 1 {
-			irSample = append(irSample, '0')
-		}
-	}
-
-	// send instruction and go to ShiftDR
-	J.sendInstruction(irSample)
-
-	// Tell TAP to go to shiftout of selected data register (DR)
-	// is determined by the instruction we sent, in our case
-	// SAMPLE/boundary scan
-	for i := 0; i < 2000; i += 1 {
-		// no need to set TMS. It's set to the '0' state to
-		// force a Shift DR by the TAP
-		if J.drv.pinRead(J.TDO) == StateHigh {
-			fmt.Print('1')
-		} else {
-			fmt.Print('0')
+	irLen := J.irLength()
+	if irLen == 0 {
+		fmt.Fprintln(J.out, "can't detect IR length")
+		return nil, 0
+	}
+
+	opcode := uint32(sampleOpcode)
+	boundaryLen := uint32(0)
+	if sampleOpcode < 0 {
+		var source string
+		var ok bool
+		opcode, boundaryLen, source, ok = J.findSampleOpcode(irLen, bsdl)
+		if !ok {
+			fmt.Fprintln(J.out, "can't find SAMPLE/PRELOAD opcode, give it with -sample-opcode")
+			return nil, 0
 		}
-		J.pulseTCK(1)
-		if i%32 == 31 {
-			fmt.Print(" ")
+		fmt.Fprintf(J.out, "SAMPLE/PRELOAD opcode 0x%x (%s)\n", opcode, source)
+	}
+	if boundaryLen != 0 {
+		fmt.Fprintf(J.out, "boundary register length: %d\n", boundaryLen)
+	}
+	if drLen == 0 {
+		drLen = int(boundaryLen)
+		if drLen == 0 {
+			drLen = 2000
 		}
-		if i%128 == 127 {
-			fmt.Println("")
+	}
+	// IR registers must be IR_LEN wide
+	return irBits(opcode, irLen), drLen
+}
+
+// Ignore if received Device ID is 0xFFFFFFFF or if bit 0 != 1
+func isValidIdcode(idcode uint32) bool {
+	return idcode != 0xFFFFFFFF && (idcode%2) != 0
+}
+
+// Manufacturer field refers to existing JEP106 entry.
+func isKnownManufacturer(idcode uint32) bool {
+	bank := (idcode & 0xf00) >> 8
+	id := (idcode & 0xfe) >> 1
+	return Jep106Manufacturer(bank, id) != "invalid"
+}
+
+// Keep only IDCODEs which look valid.
+func validIdcodes(idcodes []uint32) []uint32 {
+	ret := []uint32{}
+	for _, idcode := range idcodes {
+		if isValidIdcode(idcode) {
+			ret = append(ret, idcode)
 		}
 	}
-	fmt.Println("")
+	return ret
+}
 
-	// Reset TAP to Run-Test-Idle
-	J.setTapState(TAP_RESET)
+// Cut IDCODEs read from the chain at the first 0xFFFFFFFF (1s shifted in
+// on TDI are coming out, i.e. this is the end of the chain), keeping
+// BYPASS_IDCODE placeholders for devices without IDCODE.
+func chainIdcodes(idcodes []uint32) []uint32 {
+	ret := []uint32{}
+	for _, idcode := range idcodes {
+		if idcode == 0xFFFFFFFF {
+			break
+		}
+		ret = append(ret, idcode)
+	}
+	return ret
+}
+
+// Warn if IDCODEs read from the chain don't reach its end (no 0xFFFFFFFF
+// shifted in was seen), the chain is likely longer than MAX_DEVICES.
+func (J *Jtag) warnTruncatedChain(idcodes []uint32) {
+	if len(idcodes) != 0 && len(chainIdcodes(idcodes)) == len(idcodes) {
+		fmt.Fprintf(J.out, "WARNING: end of chain not seen after %d devices, it may be longer, raise -max-devices\n",
+			len(idcodes))
+	}
+}
+
+// Describe entry returned by getIdcodes, including devices without IDCODE.
+func describeChainIdcode(idcode uint32) string {
+	if idcode == BYPASS_IDCODE {
+		return "no IDCODE (BYPASS)"
+	}
+	return describeIdcode(idcode)
 }
 
 func describeIdcode(idcode uint32) string {
@@ -884,8 +1594,84 @@ func describeIdcode(idcode uint32) string {
 	id := (idcode & 0xfe) >> 1
 	mfgName := Jep106Manufacturer(bank, id)
 
-	return fmt.Sprintf("0x%08x (mfg: 0x%3.3x (%s), part: 0x%4.4x, ver: 0x%1.1x)",
+	ret := fmt.Sprintf("0x%08x (mfg: 0x%3.3x (%s), part: 0x%4.4x, ver: 0x%1.1x)",
 		idcode, mfg, mfgName, part, ver)
+
+	score, issues := scoreIdcode(idcode)
+	ret += fmt.Sprintf(" score %d/%d", score, IDCODE_SCORE_MAX)
+	if len(issues) != 0 {
+		ret += fmt.Sprintf(" (%s)", strings.Join(issues, ", "))
+	}
+	return ret
+}
+
+// Maximum score of IDCODE sanity checks
+const IDCODE_SCORE_MAX = 3
+
+// Values a floating or stuck TDO tends to produce
+func isJunkIdcode(idcode uint32) bool {
+	ones := 0
+	for v := idcode; v != 0; v >>= 1 {
+		ones += int(v & 1)
+	}
+	if ones <= 2 || ones >= 30 {
+		return true
+	}
+	// repeating nibble or byte, e.g. 0x55555555 or 0x01010101
+	if idcode == (idcode&0xf)*0x11111111 || idcode == (idcode&0xff)*0x01010101 {
+		return true
+	}
+	return false
+}
+
+// Score how much IDCODE looks like a genuine one: manufacturer exists in
+// JEP106, part number is non-zero and the value is not a junk pattern.
+// returns score and list of failed checks
+func scoreIdcode(idcode uint32) (int, []string) {
+	issues := []string{}
+	if !isKnownManufacturer(idcode) {
+		issues = append(issues, "unknown manufacturer")
+	}
+	if (idcode&0xffff000)>>12 == 0 {
+		issues = append(issues, "part is 0")
+	}
+	if isJunkIdcode(idcode) {
+		issues = append(issues, "junk pattern")
+	}
+	return IDCODE_SCORE_MAX - len(issues), issues
+}
+
+// Convert bits in shift order to hex. By default the first bit shifted
+// is the least significant one, as JTAG registers are defined.
+func (J *Jtag) bitsToHex(bits string) string {
+	msbFirst := []byte(bits)
+	if !J.MSB_FIRST {
+		for i, j := 0, len(msbFirst)-1; i < j; i, j = i+1, j-1 {
+			msbFirst[i], msbFirst[j] = msbFirst[j], msbFirst[i]
+		}
+	}
+	// pad to whole nibbles
+	for len(msbFirst)%4 != 0 {
+		msbFirst = append([]byte{'0'}, msbFirst...)
+	}
+
+	ret := "0x"
+	for i := 0; i < len(msbFirst); i += 4 {
+		nibble := 0
+		for _, b := range msbFirst[i : i+4] {
+			nibble <<= 1
+			if b == '1' {
+				nibble |= 1
+			}
+		}
+		ret += fmt.Sprintf("%x", nibble)
+	}
+	return ret
+}
+
+// Display shifted bits along with their hex value.
+func (J *Jtag) formatBits(bits string) string {
+	return fmt.Sprintf("%s (%s)", bits, J.bitsToHex(bits))
 }
 
 func describeIrDr(irlen, opcode, drlen uint32) string {
@@ -912,6 +1698,285 @@ func describeIrDr(irlen, opcode, drlen uint32) string {
 	return ret
 }
 
+// Driver to use and its settings
+type DriverOptions struct {
+	Name          string
+	GpioChip      uint
+	GpiodConsumer string
+	GpiodUpfront  bool
+	RpioPads      PadConfig
+	EmuPins       string
+	EmuChain      string
+	SimModel      string
+	FtdiVid       uint
+	FtdiPid       uint
+	FtdiChannel   string
+	SerialPort    string
+	SerialBaud    uint
+}
+
+// Select, check and initialize the driver.
+// extraPins -- pins used besides JTAG ones, e.g. trigger pins
+// returns false if the driver can't be used
+func (J *Jtag) openDriver(o DriverOptions, extraPins []JtagPin) bool {
+	if o.Name == "auto" {
+		name, reason := detectDriver(&o)
+		if name == "" {
+			fmt.Fprintf(J.out, "can't select driver automatically: %s\n", reason)
+			return false
+		}
+		fmt.Fprintf(J.out, "selected driver %s: %s\n", name, reason)
+		o.Name = name
+	}
+
+	if problems := preflightDriver(o.Name, o.GpioChip); len(problems) != 0 {
+		fmt.Fprintf(J.out, "driver %s can't be used:\n", o.Name)
+		for _, p := range problems {
+			fmt.Fprintf(J.out, "  %s\n", p)
+		}
+		return false
+	}
+
+	factory, ok := drivers[o.Name]
+	if !ok {
+		if req, known := driverRequirements[o.Name]; known {
+			fmt.Fprintf(J.out, "driver %s is not built into this binary for %s, it needs %s\n", o.Name, runtime.GOOS, req)
+		} else {
+			fmt.Fprintf(J.out, "invalid driver %s\n", o.Name)
+		}
+		return false
+	}
+	drv, reason := factory(J, o, extraPins)
+	if drv == nil {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	J.setJtagDriver(drv)
+	J.drvOpt = o
+	return true
+}
+
+// Set pins to scan, given by their names.
+func (J *Jtag) setPins(pins map[string]JtagPin) {
+	J.PinNames = make(map[JtagPin]string, 0)
+	J.AllPins = []JtagPin{}
+	for name, pin := range pins {
+		J.PinNames[pin] = name
+		J.AllPins = append(J.AllPins, pin)
+	}
+}
+
+// returns name of the pin given with -pins, GPIO number for known pins
+func (J *Jtag) pinName(pin JtagPin) string {
+	if name, ok := J.PinNames[pin]; ok {
+		return name
+	}
+	return fmt.Sprintf("gpio%d", pin)
+}
+
+// Mark pins to scan, given by comma separated names, as observe-only: they
+// are never driven, but still tried as TDO.
+// returns description of the problem, empty if ok
+func (J *Jtag) setObserveOnly(names string) string {
+	byName := J.pinsByName()
+	J.OBSERVE_ONLY = map[JtagPin]bool{}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) == 0 {
+			continue
+		}
+		pin, ok := byName[name]
+		if !ok {
+			return fmt.Sprintf("observe-only pin '%s' is not in -pins", name)
+		}
+		J.OBSERVE_ONLY[pin] = true
+	}
+	return ""
+}
+
+// Settings of commands
+type CommandOptions struct {
+	Pattern    string
+	Expected   []IdcodeMatch
+	Filter     []IdcodeMatch
+	AnyVersion bool
+	Delays     []uint
+	Repeat     int
+	Duration   time.Duration
+	DrLen      int
+	DumpPath   string
+	Bsdl       *Bsdl
+	// negative if not given
+	SampleOpcode int64
+	Drive        map[string]JtagPinState
+	Allow        map[string]bool
+	Hold         time.Duration
+	Safe         SafeOptions
+	// embedded into results, may be nil
+	Meta *ReportMeta
+	// where to publish results, may be nil
+	Publish      *Publisher
+	Spi          SpiPorts
+	FlashAddr    uint
+	FlashLen     uint
+	FlashFile    string
+	I2c          I2cPorts
+	Isc          IscGeometry
+	I2cDev       uint
+	I2cAddrWidth uint
+	Resume       bool
+	DumpVerify   uint
+	BigEndian    bool
+	Targets      string
+	PinsFile     string
+	NetMap       string
+	Header       HeaderLayout
+	Protocols    []string
+	ProbeAccess  bool
+	GdbListen    string
+	Trigger      TriggerPins
+}
+
+// Run the command on already configured pins and driver.
+// returns result of test commands, true for other ones
+func (J *Jtag) runCommand(cmd string, o CommandOptions) (passed bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		e, ok := r.(toggleBudgetExceeded)
+		if !ok {
+			panic(r)
+		}
+		fmt.Fprintf(J.out, "pin %s exceeded the budget of %d transitions, %s aborted\n", J.pinName(e.pin), J.TOGGLE_BUDGET, cmd)
+		J.printToggles()
+		passed = false
+	}()
+	J.results.Command = cmd
+	J.results.Settings = J.scanSettings(o.Pattern)
+	J.consoleTesting(cmd)
+	J.publish = o.Publish
+	J.publishedPinouts = 0
+	if o.Meta != nil {
+		meta := *o.Meta
+		meta.Started = time.Now()
+		J.results.Meta = &meta
+	}
+	J.stats.begin(cmd)
+
+	switch cmd {
+	case "check_loopback", "scan_bypass", "test_bypass", "check_speed", "scan_idcode":
+		fmt.Fprintf(J.out, "pattern: %s\n", describePatternStrength(o.Pattern))
+	}
+
+	if reason := J.checkPermission(cmd); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	if reason := J.enterRealtime(); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	J.applyProtection(&o)
+
+	triggered := o.Trigger.Start != J.IGNOREPIN
+	passed = true
+	switch cmd {
+	default:
+		fmt.Fprintln(J.out, "invalid command")
+		return false
+	case "check_loopback":
+		J.checkLoopback(o.Pattern)
+	case "scan_bypass":
+		J.scanBypass(o.Pattern)
+	case "test_bypass":
+		if triggered {
+			J.runTriggered(o.Trigger, func() bool { return J.testBypass(o.Pattern) })
+		} else {
+			passed = J.testBypass(o.Pattern)
+		}
+	case "scan_idcode":
+		J.scanIdcode(o.Pattern, o.Filter)
+	case "scan_sbw":
+		J.scanProtocols([]string{"spy-bi-wire"})
+	case "scan_swd":
+		J.scanProtocols([]string{"swd"})
+	case "scan_uart":
+		J.scanUart()
+	case "scan_protocols":
+		J.scanProtocols(o.Protocols)
+	case "test_chain":
+		passed = J.testChain(o.Pattern)
+	case "test_idcode":
+		if triggered {
+			J.runTriggered(o.Trigger, func() bool { return J.testIdcode(o.Expected) })
+		} else {
+			passed = J.testIdcode(o.Expected)
+		}
+	case "boundary_scan":
+		J.boundaryScan(o.DrLen, o.DumpPath, o.Bsdl, o.SampleOpcode)
+	case "watch_sample":
+		J.watchSample(o.DrLen, o.Bsdl, o.SampleOpcode, o.Duration)
+	case "label_nets":
+		passed = J.labelNets(os.Stdin, o.NetMap, o.DrLen, o.Bsdl, o.SampleOpcode)
+	case "discover_opcode":
+		J.discoverOpcode(o.Bsdl, o.Safe, o.ProbeAccess)
+	case "check_speed":
+		J.checkSpeed(o.Pattern, o.Delays, o.Repeat)
+	case "soak_idcode":
+		J.soakIdcode(o.Duration)
+	case "extest":
+		passed = J.extest(o.Bsdl, o.Drive, o.Allow, o.Hold)
+	case "blink_device":
+		passed = J.blinkDevice(o.Bsdl, o.Allow, o.Duration)
+	case "highz":
+		passed = J.isolate(o.Bsdl, "HIGHZ", o.Hold)
+	case "clamp":
+		passed = J.isolate(o.Bsdl, "CLAMP", o.Hold)
+	case "spi_read", "spi_erase", "spi_program":
+		passed = J.spiFlash(o.Bsdl, o.Spi, strings.TrimPrefix(cmd, "spi_"), uint32(o.FlashAddr), uint32(o.FlashLen), o.FlashFile)
+	case "isc_read", "isc_erase", "isc_program":
+		passed = J.isc(o.Bsdl, o.Isc, strings.TrimPrefix(cmd, "isc_"), o.FlashFile)
+	case "i2c_scan", "i2c_read":
+		passed = J.i2c(o.Bsdl, o.I2c, strings.TrimPrefix(cmd, "i2c_"), o.I2cDev, o.I2cAddrWidth, uint32(o.FlashAddr), uint32(o.FlashLen), o.FlashFile)
+	case "batch":
+		passed = J.batchTest(loadBatchTargets(o.Targets), o.AnyVersion)
+	case "chain_info", "chain_refresh":
+		passed = J.printChainInfo(cmd == "chain_refresh")
+	case "ir_chain_length":
+		passed = J.printIrChainLength()
+	case "repl":
+		passed = J.repl(os.Stdin)
+	case "init":
+		passed = J.initWizard(os.Stdin, o.PinsFile)
+	case "gdb_server":
+		passed = J.gdbServer(o.GdbListen)
+	case "dump":
+		passed = J.dump(o.Bsdl, o.Spi, uint32(o.FlashAddr), uint32(o.FlashLen), o.FlashFile, o.Resume, o.DumpVerify, o.BigEndian)
+	}
+
+	J.consoleTesting(cmd)
+
+	if len(o.Header) != 0 && (cmd == "scan_bypass" || cmd == "scan_idcode") {
+		for _, p := range J.results.Pinouts {
+			fmt.Fprintf(J.out, "%s on the header:\n", p)
+			J.renderHeader(o.Header, p)
+		}
+	}
+
+	if o.Publish.enabled() {
+		J.publishEvents(o.Publish, J.resultEvents(passed))
+	}
+
+	J.warnGaps()
+	J.warnTimingOutliers()
+
+	if J.STATS {
+		J.printStats()
+	}
+	return passed
+}
+
 func main() {
 	jtag := NewJtag()
 	defer jtag.closeJtag()
@@ -920,25 +1985,329 @@ func main() {
 		"delay after TCK toggle in microseconds (a kind of frequency)")
 	flag.UintVar(&(jtag.DELAY_RESET), "delay-reset", 10*1000,
 		"delay of reset pulse on TRST pin in microseconds")
+	flag.UintVar(&(jtag.DELAY_TAP_SETTLE), "delay-tap-settle", 0,
+		"delay after TAP reset and TRST release in microseconds, for targets which need time before IDCODE is available")
 	flag.BoolVar(&(jtag.PULLUP), "pullup", false,
 		"make pins pulled-up, compare results for both cases")
+	flag.BoolVar(&(jtag.MSB_FIRST), "msb-first", false,
+		"display shifted data as hex taking the first bit shifted as MSB (default is LSB first)")
+	flag.UintVar(&(jtag.RETRIES), "retries", 4,
+		"retry with doubled TCK delay up to this many times when consecutive reads differ")
+	flag.UintVar(&(jtag.SHIFT_RETRIES), "shift-retries", 2,
+		"re-sync the TAP and retry up to this many times when reads fail verification")
+	flag.DurationVar(&(jtag.MAX_GAP), "max-gap", 2*time.Millisecond,
+		"warn when a TCK cycle is delayed by more than this, i.e. the process was preempted (0 to disable)")
+	flag.BoolVar(&(jtag.GAP_RETRY), "gap-retry", false,
+		"retry operations which had a timing gap as if their reads failed verification")
+	flag.Int64Var(&(jtag.SHUFFLE_SEED), "shuffle-seed", 0,
+		"test pin permutations in an order shuffled with this seed, -1 for a random one (printed), used by scan commands")
+	flag.BoolVar(&(jtag.TIMING_CHECK), "timing-check", true,
+		"verify found pinouts again at half and double speed and flag timing-sensitive ones, used by scan commands")
+	flag.BoolVar(&(jtag.GRAY_ORDER), "gray-order", true,
+		"test pin permutations in an order where consecutive ones differ in two pins, so fewer pins are reconfigured, false for lexicographic order, used by scan commands")
+	flag.BoolVar(&(jtag.PREDICT), "predict", false,
+		"observe idle levels, pulls and toggling of pins first and test likely pin assignments first, used by scan commands")
+	flag.StringVar(&(jtag.PERMUTATIONS_EXPORT), "export-permutations", "",
+		"write pin permutations scans test, in the order they test them, to this file, one per line as pin names, used by scan commands")
+	flag.StringVar(&(jtag.PREVIOUS), "previous", "",
+		"comma-separated result files of previous runs (-output) of the same scan, permutations they ruled out are skipped, used by 'scan_bypass' and 'scan_idcode' commands")
+	flag.BoolVar(&(jtag.FORCE), "force", false,
+		"test permutations ruled out by -previous result files again")
+	flag.StringVar(&(jtag.PERMUTATIONS_IMPORT), "import-permutations", "",
+		"test exactly the pin permutations listed in this file, in its order, as written by -export-permutations, used by scan commands")
+	flag.UintVar(&(jtag.RT_PRIORITY), "rt-priority", 0,
+		"run shifts at this SCHED_FIFO priority (1-99) to reduce timing jitter, needs root or CAP_SYS_NICE")
+	flag.IntVar(&(jtag.RT_CPU), "rt-cpu", -1,
+		"pin the thread doing shifts to this CPU, preferably one isolated with isolcpus=")
+	flag.UintVar(&(jtag.VERIFY_READS), "verify-reads", 1,
+		"repeat long shifts this many times and compare CRC32 of the data, used by 'boundary_scan'")
+	flag.UintVar(&(jtag.IDCODE_READS), "idcode-reads", 3,
+		"number of identical reads of IDCODE required to report it, used by 'scan_idcode' command")
+	flag.UintVar(&(jtag.TDO_SAMPLES), "tdo-samples", 1,
+		"sample TDO that many times per clock and re-read when samples disagree, filters noise on marginal setups")
+	flag.BoolVar(&(jtag.VERBOSE), "verbose", false,
+		"print raw data of rejected attempts")
+	flag.BoolVar(&(jtag.STATS), "stats", false,
+		"print permutations tested, TCK cycles, timing, driver call counts and transitions driven per pin at the end")
+	consolePathPtr := flag.String("console", "",
+		"serial device the target console is wired to, reboots and crashes it reports are attributed to the command and pins being tested")
+	consoleBaud := flag.Uint("console-baud", 115200,
+		"baud rate of the target console")
+	flag.Uint64Var(&(jtag.TOGGLE_BUDGET), "toggle-budget", 0,
+		"abort the command once a pin was driven through this many level transitions, 0 for no limit")
+	flag.IntVar(&(jtag.CHAIN.Device), "device", 0,
+		"device addressed by per-device commands, counted from TDO (the order IDCODEs are printed in)")
+	flag.DurationVar(&(jtag.COOLDOWN), "cooldown", 0,
+		"pause between permutations tried by scans, for fragile targets")
+	flag.Float64Var(&(jtag.DUTY_CYCLE), "duty-cycle", 1,
+		"fraction of time scans may spend clocking the target, e.g. 0.25 rests 3 times as long as it works")
+	flag.UintVar(&(jtag.MAX_TCK_RATE), "max-tck-rate", 0,
+		"cap on TCK cycles per second, 0 for no limit")
+	flag.BoolVar(&(jtag.ALLOW_RESET), "allow-reset", false,
+		"probe spare pins for nTRST by pulling them low, which may reset the target")
+	flag.BoolVar(&(jtag.TRST_LAST), "trst-last", false,
+		"probe nTRST once at the end of a scan against the best pinout, instead of for every one found")
+	flag.BoolVar(&(jtag.ALLOW_DRIVE), "allow-drive", false,
+		"allow commands which drive target pins or load arbitrary instructions (extest, blink_device, highz, clamp, spi_*, i2c_*, discover_opcode, SAMPLE opcode probing)")
+	flag.StringVar(&(jtag.COUNT_PATTERN), "count-pattern", "1010101010101010",
+		"pattern shifted through the bypass chain to verify device count, empty to disable")
+	flag.UintVar(&(jtag.BYPASS_IR_LEN), "bypass-ir-len", 0,
+		"number of 1s shifted into IR to put the chain into BYPASS, 0 for the total of -chain-ir-lens if given, the measured chain IR length otherwise (max-devices times 32 while looking for a chain or if measuring fails); raise for devices with IR longer than 32 bits")
+	flag.BoolVar(&(jtag.LOAD_IDCODE), "load-idcode", false,
+		"read IDCODE of devices selecting BYPASS at reset by loading IDCODE instructions known for their IR length, on known pins")
+	idcodeOpcodesPtr := flag.String("idcode-opcodes", "",
+		"comma-separated IDCODE opcodes to try first with -load-idcode, e.g. '0x9,0x1e'")
+	flag.BoolVar(&(jtag.PROBE_IDCODE), "probe-idcode", false,
+		fmt.Sprintf("with -load-idcode, try every opcode of IRs up to %d bits except known dangerous ones, needs -allow-drive", PROBE_IDCODE_MAX_IR))
+	flag.IntVar(&(jtag.MAX_DEVICES), "max-devices", MAX_DEV_NR,
+		"maximum number of devices looked for in a chain, raise for long daisy chains")
+	irLen := flag.Uint("ir-len", 0,
+		"IR length of the chain, overriding detection, used by 'discover_opcode', 'boundary_scan' and per-device commands")
+	chainIrLensPtr := flag.String("chain-ir-lens", "",
+		"IR lengths of all devices in chain, TDO first, e.g. '4,6', needed by per-device commands on chains of several devices")
+	openocdCfgPtr := flag.String("openocd-cfg", "",
+		"comma-separated OpenOCD target configs to take chain IR lengths and expected IDCODEs from ('jtag newtap' lines), when -chain-ir-lens or -expect are not given")
+	flag.DurationVar(&(jtag.WATCHDOG), "watchdog", 0,
+		"abort if a single driver call blocks longer than this (hung adapter), e.g. 5s with USB adapters, 0 to disable")
 
 	pinsStrPtr := flag.String("pins", "",
 		"describe pins in JSON, example: '{ \"pin1\": 18, \"pin2\": 23, \"pin3\": 24, \"pin4\": 25, \"pin5\": 8, \"pin6\": 7, \"pin7\": 10, \"pin8\": 9, \"pin9\": 11 }'")
 
+	gdbListenPtr := flag.String("gdb-listen", "localhost:3333",
+		"address to accept gdb connections on, used by 'gdb_server' command")
+	probeAccess := flag.Bool("probe-access", false,
+		"write patterns into every DR found and read them back to tell read-write registers from capture-only ones, used by 'discover_opcode' command")
+	protocolsStrPtr := flag.String("protocols", "",
+		"comma-separated debug port protocols to try, all if empty: <"+strings.Join(protocolNames(), "|")+">, used by 'scan_protocols' command")
+	flag.DurationVar(&(jtag.UART_LISTEN), "uart-listen", 2*time.Second,
+		"time to listen to every pin for serial traffic, used by 'scan_uart' command")
+	flag.BoolVar(&(jtag.UART_PROBE), "uart-probe", false,
+		"send a carriage return on every pin and watch TX for a reaction to find RX, used by 'scan_uart' command")
+	flag.UintVar(&(jtag.UART_BAUD), "uart-baud", 0,
+		"baud rate of the carriage return sent by -uart-probe, 0 for the one estimated on TX or 115200")
+	observeOnlyStrPtr := flag.String("observe-only", "",
+		"comma-separated names of pins from -pins which are never driven (supplies, analog or fragile nets) but still tried as TDO, used by scan commands")
+
+	headerStrPtr := flag.String("header", "",
+		"layout of the header, rows separated by ';', e.g. 'pin1,pin2;pin3,pin4;GND,pin5', to draw pinouts found by scans on")
+	netMapPtr := flag.String("net-map", "nets.json",
+		"file 'label_nets' command writes test points and their boundary cells to")
+	pinsFilePtr := flag.String("pins-file", "pins.json",
+		"file 'init' command writes pins description to")
+
 	knownPinsStrPtr := flag.String("known-pins", "",
 		"provide known pins assignment in JSON, example: '{ \"tdi\": 18, \"tdo\": 23, \"tms\": 24, \"tck\": 25, \"trst\": 8 }'")
 
-	cmdPtr := flag.String("command", "", "action to perform: <check_loopback|scan_bypass|test_bypass|scan_idcode|test_idcode|boundary_scan|discover_opcode>")
-
-	drvPtr := flag.String("driver", "rpio", "drive GPIO via: <rpio|gpiod>")
-	gpiodChip := uint(0)
-	flag.UintVar(&(gpiodChip), "gpiochip", 0,
+	cmdPtr := flag.String("command", "", "action to perform: <"+strings.Join(commandNames, "|")+
+		">, may also be given as words before flags, e.g. 'go-jtagenum scan bypass -pins ...'")
+	outputPathPtr := flag.String("output", "",
+		"save results of the command to this JSON file, 'diff' command compares two such files given as arguments; or format of -out-file: <"+strings.Join(outputFormats, "|")+">")
+	emitOpenocdPtr := flag.String("emit-openocd", "",
+		"write OpenOCD configuration of the adapter pins and chain TAPs for the first pinout found to this file, used by scan commands")
+	outFilePtr := flag.String("out-file", "",
+		"save results of the command to this file in the format given with -output, CSV if it ends with .csv and -output is not given, JSON otherwise")
+	tuiPtr := flag.Bool("tui", false,
+		"show a live screen with progress, current permutation, pinouts found and log instead of scrolling output")
+	transcriptPathPtr := flag.String("transcript", "",
+		"append arguments, all flag values, driver, delay and results of the command to this JSON lines file, 'replay' command runs an entry again")
+	kbPathPtr := flag.String("kb", "",
+		"JSON file keeping pinouts, delays and notes of chains seen before, reported when the same IDCODEs are found again")
+	boardPtr := flag.String("board", "",
+		"name of the board under test, stored in the knowledge base and embedded into results")
+	kbNotePtr := flag.String("kb-note", "",
+		"note to store with the chain in the knowledge base")
+	metaPathPtr := flag.String("meta", "",
+		"JSON file with target metadata and notes to embed into results, fields: board, photo, voltage, series_resistors, notes")
+	photoPtr := flag.String("photo", "",
+		"path to a photo of the target, embedded into results")
+	voltagePtr := flag.String("voltage", "",
+		"target I/O voltage, embedded into results")
+	seriesResistorsPtr := flag.String("series-resistors", "",
+		"whether series resistors are fitted to the pins: <yes|no>, embedded into results, with -protect 'yes' allows faster TCK")
+	flag.BoolVar(&(jtag.PROTECT), "protect", false,
+		fmt.Sprintf("enforce TCK delay of at least %d us unless -series-resistors yes, warn when more than %d pins are driven at once", PROTECT_MIN_DELAY_TCK, PROTECT_MAX_DRIVEN))
+	var notes stringList
+	flag.Var(&notes, "note", "time-stamped note embedded into results, may be given several times")
+	publish := &Publisher{}
+	flag.StringVar(&(publish.Webhook), "webhook", "",
+		"URL to POST found pinouts and command results to as JSON")
+	flag.StringVar(&(publish.MqttBroker), "mqtt", "",
+		"MQTT broker host:port to publish found pinouts and command results to")
+	flag.StringVar(&(publish.MqttTopic), "mqtt-topic", "jtagenum",
+		"MQTT topic to publish to")
+	flag.StringVar(&(publish.Hook), "hook", "",
+		"shell command run for every found pinout and when the command is done, event JSON on stdin, details and OpenOCD config path in JTAGENUM_* variables")
+	sessionsPathPtr := flag.String("sessions", "",
+		"JSON file describing several targets on disjoint pins to run commands on concurrently")
+	serveAddrPtr := flag.String("serve", "",
+		"keep the driver open and run jobs queued over HTTP on this address, e.g. ':8080'")
+
+	patternStrPtr := flag.String("pattern", "",
+		"bits to shift through the chain and between pins instead of the default pattern, e.g. '0110'")
+	prbsOrder := flag.Int("prbs", 0,
+		"use PRBS7 or PRBS15 sequence as pattern: <7|15>")
+	patternLen := flag.Int("pattern-len", 64,
+		"length of PRBS pattern in bits, used with -prbs")
+	drLen := flag.Int("dr-len", 0,
+		"number of bits to capture, boundary register length if known or 2000 by default, used by 'boundary_scan', 'watch_sample' and 'label_nets' commands")
+	bsdlPathPtr := flag.String("bsdl", "",
+		"BSDL file of the device to take instruction opcodes and boundary register description from")
+	sampleOpcode := flag.Int64("sample-opcode", -1,
+		"SAMPLE/PRELOAD opcode, discovered from BSDL, known vendor opcodes or by probing if not given, used by 'boundary_scan', 'watch_sample' and 'label_nets' commands")
+	dumpPathPtr := flag.String("dump", "",
+		"stream captured bits to this file instead of printing them, used by 'boundary_scan' command")
+	driveStrPtr := flag.String("drive", "",
+		"comma-separated ports to drive as PORT=0|1, used by 'extest' command")
+	allowStrPtr := flag.String("allow", "",
+		"comma-separated ports which may be driven via EXTEST, anything else is refused; ports to toggle for 'blink_device' command")
+	safeOpt := SafeOptions{}
+	flag.BoolVar(&(safeOpt.Enabled), "safe", false,
+		"skip opcodes known to erase, program or blow fuses and check IDCODE between opcodes, used by 'discover_opcode' command")
+	denyStrPtr := flag.String("deny", "",
+		"comma separated opcodes never to load in safe mode, e.g. '0x0b,0x10', used by 'discover_opcode' command")
+	flag.IntVar(&(safeOpt.HealthEvery), "health-every", 16,
+		"read IDCODE every that many opcodes in safe mode, 0 to disable, used by 'discover_opcode' command")
+	flag.DurationVar(&(safeOpt.Delay), "opcode-delay", 0,
+		"pause between opcodes, used by 'discover_opcode' command")
+	flag.BoolVar(&(safeOpt.Yes), "yes", false,
+		"don't ask for confirmation")
+	spiStrPtr := flag.String("spi", "",
+		"device ports wired to SPI flash, e.g. 'cs=IO_A0,sck=IO_A1,mosi=IO_A2,miso=IO_A3', used by 'spi_*' commands")
+	iscStrPtr := flag.String("isc", "",
+		"CPLD array geometry and timing, e.g. 'rows=48,row-len=260,addr-len=6,gray,program-wait=10ms,erase-wait=100ms', used by 'isc_*' commands")
+	i2cStrPtr := flag.String("i2c", "",
+		"device ports wired to I2C bus, e.g. 'scl=PB6,sda=PB7', used by 'i2c_*' commands")
+	i2cDev := flag.Uint("i2c-dev", 0x50,
+		"I2C address of EEPROM, used by 'i2c_read' command")
+	i2cAddrWidth := flag.Uint("i2c-addr-width", 1,
+		"EEPROM address width in bytes, 2 for EEPROMs larger than 2KB, used by 'i2c_read' command")
+	flashAddr := flag.Uint("flash-addr", 0,
+		"SPI flash, EEPROM or memory address, used by 'spi_*', 'i2c_read' and 'dump' commands")
+	flashLen := flag.Uint("flash-len", 0,
+		"number of bytes to read or erase, used by 'spi_read', 'spi_erase', 'i2c_read' and 'dump' commands")
+	flashFilePtr := flag.String("flash-file", "",
+		"file to save flash contents to or program from, used by 'spi_read', 'spi_program', 'i2c_read', 'isc_read', 'isc_program' and 'dump' commands")
+	resume := flag.Bool("resume", false,
+		"keep blocks already in -flash-file and continue after them, used by 'dump' command")
+	dumpVerify := flag.Uint("dump-verify", 4,
+		"number of random blocks to read again and compare with the file, used by 'dump' command")
+	bigEndian := flag.Bool("big-endian", false,
+		"target memory is big-endian (MIPS EJTAG), used by 'dump' command")
+	hold := flag.Duration("hold", time.Second,
+		"how long to keep driving ports or keep the device isolated (0 to leave it isolated on exit), used by 'extest', 'highz' and 'clamp' commands")
+	delaysStrPtr := flag.String("delays", "0,1,2,5,10,20,50,100",
+		"comma-separated TCK delays in microseconds to try, used by 'check_speed' command")
+	repeat := flag.Int("repeat", 10,
+		"number of checks per TCK delay, used by 'check_speed' command")
+	expectStrPtr := flag.String("expect", "",
+		"comma-separated expected IDCODEs as value[/mask] or mfg:<JEP106 name or code>, e.g. '0x4ba00477/0x0fffffff', used by 'test_idcode' command; exit status reflects the result")
+	filterStrPtr := flag.String("filter", "",
+		"comma-separated IDCODEs as for -expect, 'scan_idcode' reports only chains having a matching device, e.g. 'mfg:Broadcom'")
+	anyVersion := flag.Bool("any-version", false,
+		"ignore version field when comparing expected IDCODEs")
+	targetsPathPtr := flag.String("targets", "",
+		"JSON file with list of targets to test, used by 'batch' command")
+	duration := flag.Duration("duration", 10*time.Minute,
+		"how long to keep reading IDCODEs or capturing boundary cells, used by 'soak_idcode', 'watch_sample' and 'blink_device' commands")
+
+	drvOpt := DriverOptions{}
+	flag.StringVar(&(drvOpt.Name), "driver", "auto",
+		"drive GPIO via: <auto|"+strings.Join(driverNames(), "|")+">")
+	flag.UintVar(&(drvOpt.GpioChip), "gpiochip", 0,
 		"GPIO chip number to take pins from one of /dev/gpiochipX, used by 'gpiod' driver")
+	flag.StringVar(&(drvOpt.GpiodConsumer), "gpiod-consumer", "jtagenum",
+		"consumer label of requested lines shown by gpioinfo, used by 'gpiod' driver")
+	flag.BoolVar(&(drvOpt.GpiodUpfront), "gpiod-upfront", false,
+		"request all pins at start and keep them, failing at once if any is busy, used by 'gpiod' driver")
+	flag.UintVar(&(drvOpt.RpioPads.DriveMa), "pad-drive", 0,
+		"drive strength of GPIO 0-27 in mA (2-16), 0 to keep, used by 'rpio' driver")
+	flag.StringVar(&(drvOpt.RpioPads.Slew), "pad-slew", "",
+		"slew rate of GPIO 0-27: <limited|fast>, empty to keep, used by 'rpio' driver")
+	flag.StringVar(&(drvOpt.RpioPads.Hysteresis), "pad-hysteresis", "",
+		"input hysteresis of GPIO 0-27: <on|off>, empty to keep, used by 'rpio' driver")
+	flag.UintVar(&(drvOpt.FtdiVid), "ftdi-vid", 0x0403,
+		"USB vendor ID of the FTDI adapter, used by 'ftdi' driver")
+	flag.UintVar(&(drvOpt.FtdiPid), "ftdi-pid", 0x6010,
+		"USB product ID of the FTDI adapter, 0x6010 for FT2232H, 0x6011 for FT4232H, 0x6014 for FT232H, used by 'ftdi' driver")
+	flag.StringVar(&(drvOpt.FtdiChannel), "ftdi-channel", "A",
+		"MPSSE channel of the FTDI adapter: <A|B|C|D>, pins 0-7 are its ADBUS0-7, 8-15 ACBUS0-7, used by 'ftdi' driver")
+	flag.StringVar(&(drvOpt.SerialPort), "port", "",
+		"serial port of the adapter, e.g. /dev/ttyUSB0, used by 'buspirate' driver")
+	flag.UintVar(&(drvOpt.SerialBaud), "baud", 115200,
+		"baud rate of -port, used by 'buspirate' driver")
+	flag.StringVar(&(drvOpt.EmuPins), "emu-pins", "",
+		"pins the emulated chain is wired to in JSON, example: '{ \"tdi\": 18, \"tdo\": 23, \"tms\": 24, \"tck\": 25 }', used by 'emu' driver")
+	flag.StringVar(&(drvOpt.EmuChain), "emu-chain", "",
+		"emulated devices, TDO first, as IDCODE:IRLEN[:badcapture][:idcode-op=N], IDCODE 'none' for no IDCODE, e.g. '0x4ba00477:4,none:5', used by 'emu' driver")
+	flag.StringVar(&(drvOpt.SimModel), "sim-model", "",
+		"JSON file modelling the simulated TAPs (IR length, IDCODE, DR lengths) and the pins they are wired to, used by 'sim' driver")
+
+	triggerPin := flag.Int("trigger-pin", -1,
+		"GPIO number of fixture start input, makes 'test_bypass' and 'test_idcode' run in a loop on every start signal")
+	passPin := flag.Int("pass-pin", -1,
+		"GPIO number driven high when triggered test passes")
+	failPin := flag.Int("fail-pin", -1,
+		"GPIO number driven high when triggered test fails")
+
+	profile := flag.String("profile", "normal",
+		"set delays, repeats, pattern length and pull-ups at once: <"+profileNames()+">, flags given explicitly take precedence")
+
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if len(os.Args) != 3 || !printCompletion(os.Stdout, os.Args[2]) {
+			fmt.Println("usage: go-jtagenum completion <bash|zsh>")
+		}
+		return
+	}
+	flag.CommandLine.Parse(subcommandArgs(os.Args[1:]))
 
-	flag.Parse()
+	if !applyProfile(*profile) {
+		fmt.Printf("unknown profile '%s'\n", *profile)
+		return
+	}
 
-	if len(*cmdPtr) == 0 {
+	jtag.CHAIN.IrLens = parseIrLens(*chainIrLensPtr)
+	jtag.IDCODE_OPCODES = parseOpcodes(*idcodeOpcodesPtr)
+	openocdTaps := []openocdTap{}
+	if len(*openocdCfgPtr) != 0 {
+		taps, reason := loadOpenocdChain(*openocdCfgPtr)
+		if reason != "" {
+			fmt.Println(reason)
+			return
+		}
+		printOpenocdChain(taps)
+		if len(jtag.CHAIN.IrLens) == 0 {
+			jtag.CHAIN.IrLens = openocdIrLens(taps)
+		}
+		openocdTaps = taps
+	}
+	if *irLen != 0 && (*irLen < MIN_IR_LEN || *irLen > MAX_IR_LEN) {
+		fmt.Printf("IR length must be %d to %d\n", MIN_IR_LEN, MAX_IR_LEN)
+		return
+	}
+	jtag.IR_LEN = uint32(*irLen)
+	if jtag.MAX_DEVICES < 1 {
+		fmt.Println("maximum number of devices must be at least 1")
+		return
+	}
+	if jtag.DUTY_CYCLE <= 0 || jtag.DUTY_CYCLE > 1 {
+		fmt.Println("duty cycle must be above 0 and at most 1")
+		return
+	}
+	if jtag.RT_PRIORITY > 99 {
+		fmt.Println("real-time priority must be 1 to 99")
+		return
+	}
+	if strings.Trim(jtag.COUNT_PATTERN, "01") != "" {
+		fmt.Println("count pattern must consist of 0s and 1s")
+		return
+	}
+	outputPath, outputFormat, reason := outputTarget(*outputPathPtr, *outFilePtr)
+	if reason != "" {
+		fmt.Println(reason)
+		return
+	}
+
+	if len(*cmdPtr) == 0 && len(*sessionsPathPtr) == 0 && len(*serveAddrPtr) == 0 {
 		fmt.Println("provide command")
 		return
 	}
@@ -946,11 +2315,133 @@ func main() {
 	jtag.PinNames = make(map[JtagPin]string, 0)
 	jtag.KnownPins = JtagPins{}
 
+	// results comparison does not touch hardware
+	switch *cmdPtr {
+	case "diff":
+		if flag.NArg() != 2 {
+			fmt.Println("provide two result files to compare")
+			return
+		}
+		if !diffResults(loadResults(flag.Arg(0)), loadResults(flag.Arg(1))) {
+			os.Exit(1)
+		}
+		return
+	case "compare_capture":
+		if flag.NArg() != 2 {
+			fmt.Println("provide two capture files to compare")
+			return
+		}
+		if !compareCaptures(flag.Arg(0), flag.Arg(1)) {
+			os.Exit(1)
+		}
+		return
+	case "replay":
+		index := -1
+		if flag.NArg() == 2 {
+			v, err := strconv.Atoi(flag.Arg(1))
+			if err != nil {
+				fmt.Printf("invalid entry number '%s'\n", flag.Arg(1))
+				return
+			}
+			index = v
+		} else if flag.NArg() != 1 {
+			fmt.Println("provide transcript file and optionally number of the entry to replay")
+			return
+		}
+		if !replayTranscript(flag.Arg(0), index) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	optPin := func(pin int) JtagPin {
+		if pin < 0 {
+			return jtag.IGNOREPIN
+		}
+		return JtagPin(pin)
+	}
+	safeOpt.Deny = parseOpcodes(*denyStrPtr)
+	protocols, reason := parseProtocols(*protocolsStrPtr)
+	if reason != "" {
+		fmt.Println(reason)
+		return
+	}
+	opt := CommandOptions{
+		Pattern:      makePattern(*patternStrPtr, *prbsOrder, *patternLen),
+		Expected:     parseIdcodeMatches(*expectStrPtr, *anyVersion),
+		Filter:       parseIdcodeMatches(*filterStrPtr, *anyVersion),
+		AnyVersion:   *anyVersion,
+		Delays:       parseDelays(*delaysStrPtr),
+		Repeat:       *repeat,
+		Duration:     *duration,
+		DrLen:        *drLen,
+		DumpPath:     *dumpPathPtr,
+		SampleOpcode: *sampleOpcode,
+		Drive:        parsePortStates(*driveStrPtr),
+		Allow:        parsePortList(*allowStrPtr),
+		Hold:         *hold,
+		Safe:         safeOpt,
+		Publish:      publish,
+		Meta:         loadMeta(*metaPathPtr, *boardPtr, *photoPtr, *voltagePtr, *seriesResistorsPtr, notes),
+		Spi:          parseSpiPorts(*spiStrPtr),
+		FlashAddr:    *flashAddr,
+		FlashLen:     *flashLen,
+		FlashFile:    *flashFilePtr,
+		I2c:          parseI2cPorts(*i2cStrPtr),
+		Isc:          parseIscGeometry(*iscStrPtr),
+		I2cDev:       *i2cDev,
+		I2cAddrWidth: *i2cAddrWidth,
+		Resume:       *resume,
+		DumpVerify:   *dumpVerify,
+		BigEndian:    *bigEndian,
+		Targets:      *targetsPathPtr,
+		PinsFile:     *pinsFilePtr,
+		NetMap:       *netMapPtr,
+		Header:       parseHeaderLayout(*headerStrPtr),
+		Protocols:    protocols,
+		ProbeAccess:  *probeAccess,
+		GdbListen:    *gdbListenPtr,
+		Trigger: TriggerPins{
+			Start: optPin(*triggerPin),
+			Pass:  optPin(*passPin),
+			Fail:  optPin(*failPin),
+		},
+	}
+	if len(opt.Expected) == 0 && len(openocdTaps) != 0 {
+		opt.Expected = openocdExpected(openocdTaps, *anyVersion)
+	}
+
+	if len(*bsdlPathPtr) != 0 {
+		opt.Bsdl = loadBsdl(*bsdlPathPtr)
+	}
+
+	if len(*sessionsPathPtr) != 0 {
+		if !runSessions(jtag, loadSessions(*sessionsPathPtr), *cmdPtr, drvOpt, opt) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(*serveAddrPtr) != 0 {
+		if !jtag.openDriver(drvOpt, nil) {
+			return
+		}
+		if err := NewDaemon(jtag, opt).serve(*serveAddrPtr); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if *tuiPtr && (*cmdPtr == "repl" || *cmdPtr == "init" || *cmdPtr == "label_nets") {
+		fmt.Printf("live screen can't be used with %s\n", *cmdPtr)
+		return
+	}
+
 	switch *cmdPtr {
 	default:
 		fmt.Println("invalid command")
 		return
-	case "check_loopback", "scan_bypass", "scan_idcode":
+	case "check_loopback", "scan_bypass", "scan_idcode", "scan_sbw", "scan_swd", "scan_uart", "scan_protocols":
 		if len(*pinsStrPtr) == 0 {
 			fmt.Println("provide pins description")
 			return
@@ -961,17 +2452,27 @@ func main() {
 			panic(err)
 		}
 
+		pins := map[string]JtagPin{}
 		for key, value := range pinsJson {
 			// the following will fail with panic if input is garbage
-			jtag.PinNames[JtagPin(int(value.(float64)))] = key
+			pins[key] = JtagPin(int(value.(float64)))
 		}
-
-		for k := range jtag.PinNames {
-			jtag.AllPins = append(jtag.AllPins, k)
+		jtag.setPins(pins)
+		if reason := jtag.setObserveOnly(*observeOnlyStrPtr); reason != "" {
+			fmt.Println(reason)
+			return
 		}
 
 		fmt.Printf("defined pins: %v\n", jtag.PinNames)
-	case "test_bypass", "boundary_scan", "test_idcode", "discover_opcode":
+	case "init":
+	case "batch":
+		if len(*targetsPathPtr) == 0 {
+			fmt.Println("provide targets list file")
+			return
+		}
+	case "test_bypass", "boundary_scan", "watch_sample", "label_nets", "test_idcode", "test_chain", "discover_opcode", "check_speed", "soak_idcode", "extest",
+		"blink_device", "highz", "clamp", "spi_read", "spi_erase", "spi_program",
+		"i2c_scan", "i2c_read", "isc_read", "isc_erase", "isc_program", "chain_info", "chain_refresh", "ir_chain_length", "repl", "dump", "gdb_server":
 		if len(*knownPinsStrPtr) == 0 {
 			fmt.Printf("provide known pins description for %s command\n", *cmdPtr)
 			return
@@ -982,35 +2483,52 @@ func main() {
 		}
 	}
 
-	switch *drvPtr {
-	default:
-		drv := &JtagPinDriverRpio{}
-		jtag.setJtagDriver(drv)
-	case "rpio":
-		drv := &JtagPinDriverRpio{}
-		jtag.setJtagDriver(drv)
-	case "gpiod":
-		drv := &JtagPinDriverGpiod{}
-		jtag.setJtagDriver(drv)
+	extraPins := []JtagPin{opt.Trigger.Start, opt.Trigger.Pass, opt.Trigger.Fail}
+	if *cmdPtr == "batch" {
+		pins, reason := batchPins(loadBatchTargets(*targetsPathPtr))
+		if reason != "" {
+			fmt.Println(reason)
+			return
+		}
+		extraPins = append(extraPins, pins...)
 	}
-
-	switch *cmdPtr {
-	default:
-		fmt.Println("invalid command")
+	if !jtag.openDriver(drvOpt, extraPins) {
 		return
-	case "check_loopback":
-		jtag.checkLoopback(PATTERN)
-	case "scan_bypass":
-		jtag.scanBypass(PATTERN)
-	case "test_bypass":
-		jtag.testBypass(PATTERN)
-	case "scan_idcode":
-		jtag.scanIdcode()
-	case "test_idcode":
-		jtag.testIdcode()
-	case "boundary_scan":
-		jtag.boundaryScan()
-	case "discover_opcode":
-		jtag.discoverOpcode()
+	}
+	if len(*consolePathPtr) != 0 {
+		if reason := jtag.startConsole(*consolePathPtr, *consoleBaud); reason != "" {
+			fmt.Println(reason)
+			return
+		}
+	}
+
+	// result of test commands, reflected in exit status
+	passed := false
+	if *tuiPtr {
+		passed = jtag.runCommandTui(*cmdPtr, opt)
+	} else {
+		passed = jtag.runCommand(*cmdPtr, opt)
+	}
+
+	if len(*kbPathPtr) != 0 {
+		jtag.consultKb(*kbPathPtr, *boardPtr, *kbNotePtr)
+	}
+
+	if len(*emitOpenocdPtr) != 0 && !jtag.emitOpenocd(*emitOpenocdPtr) {
+		passed = false
+	}
+
+	if outputPath != "" {
+		writeResults(outputPath, outputFormat, jtag.results)
+	}
+
+	if len(*transcriptPathPtr) != 0 {
+		jtag.appendTranscript(*transcriptPathPtr, os.Args[1:], passed)
+	}
+
+	if !passed {
+		// deferred calls are not run by os.Exit
+		jtag.closeJtag()
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// What is known about a chain seen on a board
+type KbEntry struct {
+	// IDCODEs of the chain, the key
+	Idcodes  []uint32       `json:"idcodes"`
+	Board    string         `json:"board,omitempty"`
+	Pinouts  []PinoutResult `json:"pinouts,omitempty"`
+	DelayTck uint           `json:"delay_tck"`
+	Notes    []string       `json:"notes,omitempty"`
+	Seen     time.Time      `json:"seen"`
+}
+
+// Local database of previously scanned targets
+type Kb struct {
+	Entries []KbEntry `json:"entries"`
+}
+
+// returns empty knowledge base if the file does not exist yet
+func loadKb(path string) *Kb {
+	kb := &Kb{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return kb
+	} else if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(data, kb); err != nil {
+		panic(err)
+	}
+	return kb
+}
+
+func (kb *Kb) save(path string) {
+	data, err := json.MarshalIndent(kb, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// Distinct IDCODE sets found by the command.
+func (r ScanResult) idcodeSets() [][]uint32 {
+	ret := [][]uint32{}
+	add := func(idcodes []uint32) {
+		if len(validIdcodes(idcodes)) == 0 {
+			return
+		}
+		for _, s := range ret {
+			if equalIdcodes(s, idcodes) {
+				return
+			}
+		}
+		ret = append(ret, idcodes)
+	}
+	add(r.Idcodes)
+	for _, p := range r.Pinouts {
+		add(p.Idcodes)
+	}
+	return ret
+}
+
+// Print what is known about chains found by the command, then remember
+// them as seen on the board.
+// note -- free text stored with the entry, may be empty
+func (J *Jtag) consultKb(path, board, note string) {
+	kb := loadKb(path)
+	for _, idcodes := range J.results.idcodeSets() {
+		var current *KbEntry
+		for i, e := range kb.Entries {
+			if !equalIdcodes(e.Idcodes, idcodes) {
+				continue
+			}
+			if e.Board == board {
+				current = &kb.Entries[i]
+			}
+			fmt.Fprintf(J.out, "seen chain %s before on board '%s' %s with -delay-tck %d\n",
+				formatIdcodes(idcodes), e.Board, e.Seen.Format("2006-01-02"), e.DelayTck)
+			for _, p := range e.Pinouts {
+				fmt.Fprintf(J.out, "    pinout %s\n", p)
+			}
+			for _, n := range e.Notes {
+				fmt.Fprintf(J.out, "    note: %s\n", n)
+			}
+		}
+
+		if current == nil {
+			kb.Entries = append(kb.Entries, KbEntry{Idcodes: idcodes, Board: board})
+			current = &kb.Entries[len(kb.Entries)-1]
+		}
+		current.DelayTck = J.DELAY_TCK
+		current.Seen = time.Now()
+		for _, p := range J.results.Pinouts {
+			if !equalIdcodes(p.Idcodes, idcodes) && len(p.Idcodes) != 0 {
+				continue
+			}
+			known := false
+			for _, kp := range current.Pinouts {
+				known = known || kp.String() == p.String()
+			}
+			if !known {
+				current.Pinouts = append(current.Pinouts, p)
+			}
+		}
+		if note = strings.TrimSpace(note); len(note) != 0 {
+			current.Notes = append(current.Notes, note)
+		}
+	}
+	kb.save(path)
+}
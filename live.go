@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bits sent to live viewers at most that often
+const LIVE_INTERVAL = 100 * time.Millisecond
+
+// Clock cycles kept per interval, older ones are dropped
+const LIVE_MAX_CYCLES = 256
+
+// TAP controller state transitions on TMS 0 and 1
+var tapTransitions = map[string][2]string{
+	"Test-Logic-Reset": {"Run-Test-Idle", "Test-Logic-Reset"},
+	"Run-Test-Idle":    {"Run-Test-Idle", "Select-DR-Scan"},
+	"Select-DR-Scan":   {"Capture-DR", "Select-IR-Scan"},
+	"Capture-DR":       {"Shift-DR", "Exit1-DR"},
+	"Shift-DR":         {"Shift-DR", "Exit1-DR"},
+	"Exit1-DR":         {"Pause-DR", "Update-DR"},
+	"Pause-DR":         {"Pause-DR", "Exit2-DR"},
+	"Exit2-DR":         {"Shift-DR", "Update-DR"},
+	"Update-DR":        {"Run-Test-Idle", "Select-DR-Scan"},
+	"Select-IR-Scan":   {"Capture-IR", "Test-Logic-Reset"},
+	"Capture-IR":       {"Shift-IR", "Exit1-IR"},
+	"Shift-IR":         {"Shift-IR", "Exit1-IR"},
+	"Exit1-IR":         {"Pause-IR", "Update-IR"},
+	"Pause-IR":         {"Pause-IR", "Exit2-IR"},
+	"Exit2-IR":         {"Shift-IR", "Update-IR"},
+	"Update-IR":        {"Run-Test-Idle", "Select-DR-Scan"},
+}
+
+// Signals sampled on a TCK rising edge and the TAP state entered
+type LiveCycle struct {
+	TMS   JtagPinState `json:"tms"`
+	TDI   JtagPinState `json:"tdi"`
+	TDO   JtagPinState `json:"tdo"`
+	State string       `json:"state"`
+}
+
+// Driver wrapper remembering pin states, so every TCK cycle can be shown to
+// live viewers together with the TAP state it leads to. Does nothing but
+// forwarding while nobody watches.
+type JtagPinDriverTrace struct {
+	drv JtagPinDriver
+
+	pins [256]JtagPinState
+	// number of connected viewers
+	watching int32
+
+	mu      sync.Mutex
+	state   string
+	ones    int
+	cycles  []LiveCycle
+	dropped int
+	viewers map[chan []byte]bool
+}
+
+func (d *JtagPinDriverTrace) initDriver() {
+	d.drv.initDriver()
+}
+
+func (d *JtagPinDriverTrace) closeDriver() {
+	d.drv.closeDriver()
+}
+
+func (d *JtagPinDriverTrace) pinWrite(pin JtagPin, state JtagPinState) {
+	d.pins[pin] = state
+	d.drv.pinWrite(pin, state)
+}
+
+func (d *JtagPinDriverTrace) pinRead(pin JtagPin) JtagPinState {
+	state := d.drv.pinRead(pin)
+	d.pins[pin] = state
+	return state
+}
+
+func (d *JtagPinDriverTrace) pinOutput(pin JtagPin) {
+	d.drv.pinOutput(pin)
+}
+
+func (d *JtagPinDriverTrace) pinInput(pin JtagPin) {
+	d.drv.pinInput(pin)
+}
+
+func (d *JtagPinDriverTrace) pinPullUp(pin JtagPin) {
+	d.drv.pinPullUp(pin)
+}
+
+func (d *JtagPinDriverTrace) pinPullOff(pin JtagPin) {
+	d.drv.pinPullOff(pin)
+}
+
+// Record TCK cycle on the given pins. The TAP state is unknown until TMS is
+// held high for 5 cycles.
+func (d *JtagPinDriverTrace) clock(tms, tdi, tdo JtagPin) {
+	if atomic.LoadInt32(&d.watching) == 0 {
+		return
+	}
+	c := LiveCycle{TMS: d.pins[tms], TDI: d.pins[tdi], TDO: d.pins[tdo]}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c.TMS == StateHigh {
+		d.ones += 1
+	} else {
+		d.ones = 0
+	}
+	if t, ok := tapTransitions[d.state]; ok {
+		d.state = t[c.TMS]
+	} else if d.ones >= 5 {
+		d.state = "Test-Logic-Reset"
+	}
+	c.State = d.state
+	if len(d.cycles) == LIVE_MAX_CYCLES {
+		d.cycles = d.cycles[1:]
+		d.dropped += 1
+	}
+	d.cycles = append(d.cycles, c)
+}
+
+// Send recorded cycles to viewers every LIVE_INTERVAL.
+func (d *JtagPinDriverTrace) broadcast() {
+	for range time.Tick(LIVE_INTERVAL) {
+		d.mu.Lock()
+		if len(d.cycles) == 0 {
+			d.mu.Unlock()
+			continue
+		}
+		msg, _ := json.Marshal(map[string]interface{}{"cycles": d.cycles, "dropped": d.dropped})
+		d.cycles = nil
+		d.dropped = 0
+		for v := range d.viewers {
+			select {
+			case v <- msg:
+			default:
+				// slow viewer, skip this update
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// Upgrade to WebSocket (RFC 6455) and stream cycles as JSON text frames.
+// Anything the viewer sends is ignored.
+func (d *JtagPinDriverTrace) handle(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	hj, ok := w.(http.Hijacker)
+	if key == "" || !ok {
+		http.Error(w, "WebSocket expected", http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if rw.Flush() != nil {
+		return
+	}
+
+	v := make(chan []byte, 4)
+	d.mu.Lock()
+	if d.viewers == nil {
+		d.viewers = make(map[chan []byte]bool)
+	}
+	d.viewers[v] = true
+	d.mu.Unlock()
+	atomic.AddInt32(&d.watching, 1)
+	defer func() {
+		atomic.AddInt32(&d.watching, -1)
+		d.mu.Lock()
+		delete(d.viewers, v)
+		d.mu.Unlock()
+	}()
+
+	closed := make(chan bool)
+	go func() {
+		// drain frames until the viewer goes away
+		rw.Reader.WriteTo(discardConn{})
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case msg := <-v:
+			if writeTextFrame(rw.Writer, msg) != nil {
+				return
+			}
+		}
+	}
+}
+
+type discardConn struct{}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func writeTextFrame(w *bufio.Writer, payload []byte) error {
+	// FIN and text opcode, server frames are not masked
+	w.WriteByte(0x81)
+	switch {
+	case len(payload) < 126:
+		w.WriteByte(byte(len(payload)))
+	case len(payload) < 65536:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(len(payload)))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(len(payload)))
+	}
+	w.Write(payload)
+	return w.Flush()
+}
+
+const livePage = `<!DOCTYPE html>
+<html><head><title>go-jtagenum live</title>
+<style>body{font-family:monospace} td{padding:0 .5em}</style></head>
+<body>
+<h3>TCK cycles of the running job</h3>
+<div id="info"></div>
+<table><tr><th>TMS</th><th>TDI</th><th>TDO</th><th>TAP state</th></tr>
+<tbody id="cycles"></tbody></table>
+<script>
+var ws = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/live");
+ws.onmessage = function(e) {
+	var m = JSON.parse(e.data);
+	var rows = "";
+	m.cycles.slice(-64).forEach(function(c) {
+		rows += "<tr><td>" + c.tms + "</td><td>" + c.tdi + "</td><td>" + c.tdo + "</td><td>" + c.state + "</td></tr>";
+	});
+	document.getElementById("cycles").innerHTML = rows;
+	document.getElementById("info").textContent = m.dropped ? m.dropped + " cycles not shown" : "";
+};
+</script>
+</body></html>
+`
+
+func serveLivePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(livePage))
+}
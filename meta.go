@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Operator note, stamped with the time it was given
+type ReportNote struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// Target description and operator notes embedded into results, so they
+// remain interpretable later
+type ReportMeta struct {
+	Started time.Time `json:"started"`
+	Board   string    `json:"board,omitempty"`
+	Photo   string    `json:"photo,omitempty"`
+	Voltage string    `json:"voltage,omitempty"`
+	// "yes" or "no", empty if not known
+	SeriesResistors string       `json:"series_resistors,omitempty"`
+	Notes           []ReportNote `json:"notes,omitempty"`
+}
+
+// Flag which may be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// Load metadata from JSON file if given, then override it with values given
+// by flags.
+// returns nil if there is no metadata at all
+func loadMeta(path, board, photo, voltage, seriesResistors string, notes []string) *ReportMeta {
+	m := &ReportMeta{}
+	if len(path) != 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+		if err := json.Unmarshal(data, m); err != nil {
+			panic(err)
+		}
+	}
+	if len(board) != 0 {
+		m.Board = board
+	}
+	if len(photo) != 0 {
+		m.Photo = photo
+	}
+	if len(voltage) != 0 {
+		m.Voltage = voltage
+	}
+	if len(seriesResistors) != 0 {
+		m.SeriesResistors = seriesResistors
+	}
+	if m.SeriesResistors != "" && m.SeriesResistors != "yes" && m.SeriesResistors != "no" {
+		panic(fmt.Sprintf("invalid series resistors '%s', use yes or no", m.SeriesResistors))
+	}
+	now := time.Now()
+	for _, n := range notes {
+		m.Notes = append(m.Notes, ReportNote{now, n})
+	}
+	if m.Board == "" && m.Photo == "" && m.Voltage == "" && m.SeriesResistors == "" && len(m.Notes) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of driver call latency histogram buckets, in seconds
+var latencyBuckets = []float64{1e-7, 1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 1e-1}
+
+// Driver wrapper measuring latency of pin reads and writes
+type JtagPinDriverLatency struct {
+	drv JtagPinDriver
+
+	// per bucket, the last one counts calls over all bounds
+	buckets [8]uint64
+	count   uint64
+	// total in nanoseconds
+	sum uint64
+}
+
+func (d *JtagPinDriverLatency) observe(start time.Time) {
+	elapsed := time.Since(start)
+	i := 0
+	for i < len(latencyBuckets) && elapsed.Seconds() > latencyBuckets[i] {
+		i += 1
+	}
+	atomic.AddUint64(&d.buckets[i], 1)
+	atomic.AddUint64(&d.count, 1)
+	atomic.AddUint64(&d.sum, uint64(elapsed.Nanoseconds()))
+}
+
+func (d *JtagPinDriverLatency) initDriver() {
+	d.drv.initDriver()
+}
+
+func (d *JtagPinDriverLatency) closeDriver() {
+	d.drv.closeDriver()
+}
+
+func (d *JtagPinDriverLatency) pinWrite(pin JtagPin, state JtagPinState) {
+	defer d.observe(time.Now())
+	d.drv.pinWrite(pin, state)
+}
+
+func (d *JtagPinDriverLatency) pinRead(pin JtagPin) JtagPinState {
+	defer d.observe(time.Now())
+	return d.drv.pinRead(pin)
+}
+
+func (d *JtagPinDriverLatency) pinOutput(pin JtagPin) {
+	d.drv.pinOutput(pin)
+}
+
+func (d *JtagPinDriverLatency) pinInput(pin JtagPin) {
+	d.drv.pinInput(pin)
+}
+
+func (d *JtagPinDriverLatency) pinPullUp(pin JtagPin) {
+	d.drv.pinPullUp(pin)
+}
+
+func (d *JtagPinDriverLatency) pinPullOff(pin JtagPin) {
+	d.drv.pinPullOff(pin)
+}
+
+// Daemon counters exposed in Prometheus text format
+type Metrics struct {
+	mu sync.Mutex
+	// by command and result (pass, fail, error)
+	jobs         map[[2]string]uint64
+	permutations uint64
+	tckCycles    uint64
+	jobSeconds   float64
+	// of the last scan job
+	permutationsRate float64
+
+	latency *JtagPinDriverLatency
+}
+
+func NewMetrics(latency *JtagPinDriverLatency) *Metrics {
+	return &Metrics{jobs: make(map[[2]string]uint64), latency: latency}
+}
+
+func (m *Metrics) observeJob(job *Job, stats ScanStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := "pass"
+	if job.Error != "" {
+		result = "error"
+	} else if !job.Passed {
+		result = "fail"
+	}
+	m.jobs[[2]string{job.Request.Command, result}] += 1
+	m.permutations += stats.Permutations
+	m.tckCycles += stats.TckCycles
+	elapsed := job.Finished.Sub(job.Started).Seconds()
+	m.jobSeconds += elapsed
+	if stats.Permutations != 0 && elapsed > 0 {
+		m.permutationsRate = float64(stats.Permutations) / elapsed
+	}
+}
+
+func (m *Metrics) handle(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP jtagenum_jobs_total Jobs run by command and result.")
+	fmt.Fprintln(w, "# TYPE jtagenum_jobs_total counter")
+	keys := [][2]string{}
+	for k := range m.jobs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "jtagenum_jobs_total{command=%q,result=%q} %d\n", k[0], k[1], m.jobs[k])
+	}
+
+	fmt.Fprintln(w, "# HELP jtagenum_permutations_total Pin assignments tried by scan jobs.")
+	fmt.Fprintln(w, "# TYPE jtagenum_permutations_total counter")
+	fmt.Fprintf(w, "jtagenum_permutations_total %d\n", m.permutations)
+	fmt.Fprintln(w, "# HELP jtagenum_permutations_per_second Pin assignments tried per second by the last scan job.")
+	fmt.Fprintln(w, "# TYPE jtagenum_permutations_per_second gauge")
+	fmt.Fprintf(w, "jtagenum_permutations_per_second %g\n", m.permutationsRate)
+	fmt.Fprintln(w, "# HELP jtagenum_tck_cycles_total TCK cycles clocked by jobs.")
+	fmt.Fprintln(w, "# TYPE jtagenum_tck_cycles_total counter")
+	fmt.Fprintf(w, "jtagenum_tck_cycles_total %d\n", m.tckCycles)
+	fmt.Fprintln(w, "# HELP jtagenum_job_seconds_total Time spent running jobs.")
+	fmt.Fprintln(w, "# TYPE jtagenum_job_seconds_total counter")
+	fmt.Fprintf(w, "jtagenum_job_seconds_total %g\n", m.jobSeconds)
+
+	if m.latency == nil {
+		return
+	}
+	fmt.Fprintln(w, "# HELP jtagenum_driver_call_seconds Latency of driver pin reads and writes.")
+	fmt.Fprintln(w, "# TYPE jtagenum_driver_call_seconds histogram")
+	cumulative := uint64(0)
+	for i, le := range latencyBuckets {
+		cumulative += atomic.LoadUint64(&m.latency.buckets[i])
+		fmt.Fprintf(w, "jtagenum_driver_call_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(w, "jtagenum_driver_call_seconds_bucket{le=\"+Inf\"} %d\n", atomic.LoadUint64(&m.latency.count))
+	fmt.Fprintf(w, "jtagenum_driver_call_seconds_sum %g\n", float64(atomic.LoadUint64(&m.latency.sum))/1e9)
+	fmt.Fprintf(w, "jtagenum_driver_call_seconds_count %d\n", atomic.LoadUint64(&m.latency.count))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Captures taken for reference and with the probe applied
+const NET_CAPTURES = 8
+
+// Test point found to be wired to boundary cells
+type NetLabel struct {
+	TestPoint string `json:"test_point"`
+	Cells     []int  `json:"cells"`
+	// BSDL ports of the cells, with -bsdl
+	Ports []string `json:"ports,omitempty"`
+}
+
+// Interactively map test points to boundary cells: the user shorts a probe
+// (to GND or VCC) to one test point at a time, cells which change under the
+// probe on every capture, and never without it, are wired to it. Cells
+// toggling on their own are ignored. The map is written to path.
+// in -- where answers are read from
+// returns true if the map was written
+func (J *Jtag) labelNets(in io.Reader, path string, drLen int, bsdl *Bsdl, sampleOpcode int64) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Labeling nets...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	irSample, drLen := J.prepareSample(drLen, bsdl, sampleOpcode)
+	if irSample == nil {
+		return false
+	}
+	defer J.setTapState(TAP_RESET)
+
+	capture := func() []byte {
+		bits := []byte{}
+		J.setTapState(TAP_RESET)
+		J.sendInstruction(irSample)
+		J.shiftDrStream(drLen, DR_CHUNK_LEN, nil, func(chunk []byte) { bits = append(bits, chunk...) })
+		return bits
+	}
+	// returns the last capture and cells which didn't keep their value
+	captureSeries := func() ([]byte, []bool) {
+		first := capture()
+		unstable := make([]bool, drLen)
+		for n := 1; n < NET_CAPTURES; n += 1 {
+			for i, b := range capture() {
+				if b != first[i] {
+					unstable[i] = true
+				}
+			}
+		}
+		return first, unstable
+	}
+
+	scanner := bufio.NewScanner(in)
+	ask := func(question string) (string, bool) {
+		fmt.Fprintf(J.out, "%s ", question)
+		if !scanner.Scan() {
+			fmt.Fprintln(J.out)
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	reference, noisy := captureSeries()
+	cnt := 0
+	for _, n := range noisy {
+		if n {
+			cnt += 1
+		}
+	}
+	fmt.Fprintf(J.out, "%d of %d cells toggle on their own and are ignored\n", cnt, drLen)
+
+	labels := []NetLabel{}
+	for {
+		name, ok := ask("test point name (empty to finish)?")
+		if !ok || name == "" {
+			break
+		}
+		if _, ok := ask(fmt.Sprintf("short the probe to %s and press Enter", name)); !ok {
+			break
+		}
+		probed, unstable := captureSeries()
+
+		label := NetLabel{TestPoint: name}
+		for i := range probed {
+			if !noisy[i] && !unstable[i] && probed[i] != reference[i] {
+				label.Cells = append(label.Cells, i)
+				if bsdl != nil {
+					if c := bsdl.cell(i); c != nil && c.Port != "*" {
+						label.Ports = append(label.Ports, c.Port)
+					}
+				}
+			}
+		}
+		if len(label.Cells) == 0 {
+			fmt.Fprintf(J.out, "no cell follows %s, it is not wired to the device or already at the probe level\n", name)
+		} else {
+			desc := []string{}
+			for _, i := range label.Cells {
+				desc = append(desc, cellName(bsdl, i))
+			}
+			fmt.Fprintf(J.out, "%s: cell %s\n", name, strings.Join(desc, ", cell "))
+			labels = append(labels, label)
+		}
+
+		if _, ok := ask("remove the probe and press Enter"); !ok {
+			break
+		}
+		// pins may settle at another level after being probed
+		reference, _ = captureSeries()
+	}
+
+	if len(labels) == 0 {
+		fmt.Fprintln(J.out, "nothing labeled")
+		return false
+	}
+	data, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		fmt.Fprintf(J.out, "can't write %s: %v\n", path, err)
+		return false
+	}
+	fmt.Fprintf(J.out, "%d test points written to %s\n", len(labels), path)
+	return true
+}
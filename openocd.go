@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TAP declared by 'jtag newtap' or 'swj_newdap' in an OpenOCD config
+type openocdTap struct {
+	Name  string
+	IrLen uint32
+	// any of these is accepted, none given means any IDCODE
+	Expected      []uint32
+	IgnoreVersion bool
+}
+
+var openocdVarRe = regexp.MustCompile(`\$\{?([A-Za-z0-9_]+)\}?`)
+
+// Split a line of Tcl into words, braces, brackets and ';' are words on
+// their own so they end the statement before them.
+func openocdWords(line string) []string {
+	ret := []string{}
+	word := &strings.Builder{}
+	flush := func() {
+		if word.Len() != 0 {
+			ret = append(ret, strings.Trim(word.String(), "\""))
+			word.Reset()
+		}
+	}
+	for _, c := range line {
+		switch {
+		case strings.ContainsRune("{}[];", c):
+			flush()
+			ret = append(ret, string(c))
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			word.WriteRune(c)
+		}
+	}
+	flush()
+	return ret
+}
+
+// Parse TAP declarations of an OpenOCD target config. Only plain 'set'
+// of variables is understood, not Tcl: the first value a variable is set to
+// wins, which in the usual 'if { [info exists CPUTAPID] } ... else' blocks
+// is the default for JTAG. Disabled TAPs (-disable) are not in the chain
+// until enabled and are left out.
+// returns TAPs, TDO first as declared, and empty string, or description of
+// the problem
+func parseOpenocdConfig(text string, vars map[string]string) ([]openocdTap, string) {
+	ret := []openocdTap{}
+	text = strings.ReplaceAll(text, "\\\r\n", " ")
+	text = strings.ReplaceAll(text, "\\\n", " ")
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		unresolved := false
+		line = openocdVarRe.ReplaceAllStringFunc(line, func(s string) string {
+			name := openocdVarRe.FindStringSubmatch(s)[1]
+			if v, ok := vars[name]; ok {
+				return v
+			}
+			unresolved = true
+			return s
+		})
+
+		words := openocdWords(line)
+		for i := 0; i < len(words); i += 1 {
+			// statement words up to the next brace, bracket or ';'
+			end := i
+			for end < len(words) && !strings.Contains("{}[];", words[end]) {
+				end += 1
+			}
+			stmt := words[i:end]
+			i = end
+			switch {
+			case len(stmt) == 3 && stmt[0] == "set":
+				if _, ok := vars[stmt[1]]; !ok && !strings.Contains(stmt[2], "$") {
+					vars[stmt[1]] = stmt[2]
+				}
+			case len(stmt) >= 3 && stmt[0] == "jtag" && stmt[1] == "newtap":
+				if unresolved {
+					return nil, fmt.Sprintf("can't resolve variables in '%s'", strings.TrimSpace(line))
+				}
+				tap, ok, reason := parseOpenocdTap(stmt[2:])
+				if reason != "" {
+					return nil, reason
+				}
+				if ok {
+					ret = append(ret, tap)
+				}
+			case len(stmt) >= 2 && stmt[0] == "swj_newdap":
+				if unresolved {
+					return nil, fmt.Sprintf("can't resolve variables in '%s'", strings.TrimSpace(line))
+				}
+				tap, ok, reason := parseOpenocdTap(stmt[1:])
+				if reason != "" {
+					return nil, reason
+				}
+				if ok {
+					ret = append(ret, tap)
+				}
+			}
+		}
+	}
+	return ret, ""
+}
+
+// Parse 'CHIP TAP -option value...' of a TAP declaration.
+// returns TAP, false if it is disabled, and empty string, or description of
+// the problem
+func parseOpenocdTap(args []string) (openocdTap, bool, string) {
+	if len(args) < 2 {
+		return openocdTap{}, false, fmt.Sprintf("TAP declaration '%s' has no chip and TAP name", strings.Join(args, " "))
+	}
+	tap := openocdTap{Name: args[0] + "." + args[1]}
+	enabled := true
+	for i := 2; i < len(args); i += 1 {
+		arg := func() string {
+			if i+1 >= len(args) {
+				return ""
+			}
+			i += 1
+			return args[i]
+		}
+		switch args[i] {
+		case "-irlen":
+			v, err := strconv.ParseUint(arg(), 0, 32)
+			if err != nil || v < MIN_IR_LEN || v > MAX_IR_LEN {
+				return tap, false, fmt.Sprintf("invalid -irlen of TAP %s", tap.Name)
+			}
+			tap.IrLen = uint32(v)
+		case "-expected-id":
+			v, err := strconv.ParseUint(arg(), 0, 32)
+			if err != nil {
+				return tap, false, fmt.Sprintf("invalid -expected-id of TAP %s", tap.Name)
+			}
+			// 0 means any IDCODE in OpenOCD
+			if v != 0 {
+				tap.Expected = append(tap.Expected, uint32(v))
+			}
+		case "-ignore-version":
+			tap.IgnoreVersion = true
+		case "-disable":
+			enabled = false
+		case "-enable", "-ignore-bypass":
+		default:
+			// -ircapture, -irmask and the like take a value
+			arg()
+		}
+	}
+	if tap.IrLen == 0 {
+		return tap, false, fmt.Sprintf("TAP %s has no -irlen", tap.Name)
+	}
+	return tap, enabled, ""
+}
+
+// Load the chain from comma separated OpenOCD config files, their TAPs
+// appended in the order files are given, variables set in one file are seen
+// by the next ones as with 'source'.
+// returns TAPs and empty string, or description of the problem
+func loadOpenocdChain(paths string) ([]openocdTap, string) {
+	ret := []openocdTap{}
+	vars := map[string]string{}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); len(path) == 0 {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err.Error()
+		}
+		taps, reason := parseOpenocdConfig(string(data), vars)
+		if reason != "" {
+			return nil, fmt.Sprintf("%s: %s", path, reason)
+		}
+		ret = append(ret, taps...)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Sprintf("no 'jtag newtap' or 'swj_newdap' found in %s", paths)
+	}
+	return ret, ""
+}
+
+// returns IR lengths of the TAPs in the form of -chain-ir-lens
+func openocdIrLens(taps []openocdTap) []uint32 {
+	ret := []uint32{}
+	for _, tap := range taps {
+		ret = append(ret, tap.IrLen)
+	}
+	return ret
+}
+
+// Expected IDCODEs in the form of -expect, the first one of each TAP: a
+// match holds a single value. TAPs without one match any IDCODE.
+func openocdExpected(taps []openocdTap, anyVersion bool) []IdcodeMatch {
+	ret := []IdcodeMatch{}
+	for _, tap := range taps {
+		if len(tap.Expected) == 0 {
+			ret = append(ret, IdcodeMatch{})
+			continue
+		}
+		m := IdcodeMatch{Value: tap.Expected[0], Mask: 0xffffffff}
+		if anyVersion || tap.IgnoreVersion {
+			m.Mask &^= IDCODE_VERSION_MASK
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func printOpenocdChain(taps []openocdTap) {
+	fmt.Printf("chain from OpenOCD config, TDO first:\n")
+	for i, tap := range taps {
+		ids := []string{}
+		for _, id := range tap.Expected {
+			ids = append(ids, fmt.Sprintf("0x%08x", id))
+		}
+		if len(ids) == 0 {
+			ids = append(ids, "any IDCODE")
+		}
+		fmt.Printf("    device %d: %s, IR %d, %s\n", i, tap.Name, tap.IrLen, strings.Join(ids, " or "))
+	}
+}
+
+// Split the chain IR length between devices: -chain-ir-lens if it matches,
+// the whole of it for a single device, 4 for ARM debug ports and the rest
+// for the one device left.
+// returns IR length of every device, 0 where it is unknown
+func splitIrLength(idcodes []uint32, total uint32, given []uint32) []uint32 {
+	if len(given) == len(idcodes) {
+		return given
+	}
+	ret := make([]uint32, len(idcodes))
+	if len(idcodes) == 1 {
+		ret[0] = total
+		return ret
+	}
+	unknown, known := []int{}, uint32(0)
+	for i, idcode := range idcodes {
+		if isArmDp(idcode) {
+			ret[i] = 4
+			known += 4
+		} else {
+			unknown = append(unknown, i)
+		}
+	}
+	if len(unknown) == 1 && total > known && total-known >= MIN_IR_LEN {
+		ret[unknown[0]] = total - known
+	}
+	return ret
+}
+
+// OpenOCD configuration attaching to the chain: the adapter wired as the
+// pinout says and a TAP per device, TDO first, expecting the IDCODE found.
+// ARM debug ports get a DAP. TAPs whose IR length is unknown are commented
+// out, OpenOCD needs it.
+// returns the configuration, or empty and why OpenOCD can't use the adapter
+func (J *Jtag) openocdTargetConfig(p *PinoutResult, idcodes []uint32, irLens []uint32) (string, string) {
+	adapter, reason := J.openocdConfig(p)
+	if adapter == "" {
+		return "", reason
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# %s found by go-jtagenum\n", p)
+	b.WriteString(adapter)
+	for i, idcode := range idcodes {
+		tap := fmt.Sprintf("jtag newtap dev%d tap -irlen %d", i, irLens[i])
+		if idcode != BYPASS_IDCODE {
+			tap += fmt.Sprintf(" -expected-id 0x%08x", idcode)
+		}
+		if irLens[i] == 0 {
+			fmt.Fprintf(b, "# IR length of device %d (%s) is unknown, give -chain-ir-lens or fix -irlen\n",
+				i, describeChainIdcode(idcode))
+			tap = "# " + strings.Replace(tap, "-irlen 0", "-irlen ?", 1)
+		}
+		fmt.Fprintln(b, tap)
+		if isArmDp(idcode) && irLens[i] != 0 {
+			fmt.Fprintf(b, "dap create dev%d.dap -chain-position dev%d.tap\n", i, i)
+		}
+	}
+	return b.String(), ""
+}
+
+// Write an OpenOCD configuration for the first pinout found: the chain is
+// interrogated on it again for IDCODEs and the IR length, which needs TDI.
+// returns false if there is no pinout or chain to write it for
+func (J *Jtag) emitOpenocd(path string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Writing OpenOCD configuration...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	if len(J.results.Pinouts) == 0 {
+		fmt.Fprintln(J.out, "no pinout found, nothing to configure")
+		return false
+	}
+	p := &J.results.Pinouts[0]
+	if p.TDI == "" {
+		fmt.Fprintf(J.out, "TDI of %s is not known, OpenOCD needs it\n", p)
+		return false
+	}
+	if _, reason := J.openocdConfig(p); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	pins := J.pinsByName()
+	J.KnownPins = JtagPins{TCK: pins[p.TCK], TMS: pins[p.TMS], TDO: pins[p.TDO], TDI: pins[p.TDI], TRST: J.IGNOREPIN}
+	J.useKnownPins()
+	J.initPins()
+	info := J.chainInfo()
+	if info.Devices == 0 {
+		fmt.Fprintf(J.out, "no devices on %s any more\n", p)
+		return false
+	}
+	irLens := splitIrLength(info.Idcodes, info.IrLength, J.CHAIN.IrLens)
+	cfg, reason := J.openocdTargetConfig(p, info.Idcodes, irLens)
+	if cfg == "" {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		fmt.Fprintln(J.out, err)
+		return false
+	}
+	fmt.Fprint(J.out, cfg)
+	fmt.Fprintf(J.out, "written to %s, e.g. openocd -f %s -c init\n", path, path)
+	return true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"time"
+)
+
+// TCK rate is checked every that many cycles
+const PACE_CHECK_CYCLES = 64
+
+// State of scan pacing, see COOLDOWN, DUTY_CYCLE and MAX_TCK_RATE
+type pacer struct {
+	// start of the current busy period
+	busyStart time.Time
+	// start of the current TCK rate window and cycles clocked since
+	rateStart  time.Time
+	rateCycles uint64
+}
+
+// Called before every permutation tried by scans: rests for COOLDOWN, and
+// as long as needed to keep time spent clocking the target within
+// DUTY_CYCLE. Updates the live screen if shown.
+func (J *Jtag) pacePermutation() {
+	if J.tui != nil {
+		J.tui.redraw(false)
+	}
+	J.consoleTesting(J.results.Command + J.formatPins())
+	defer J.timePermutation(J.formatPins(), true)
+	p := &J.pace
+	now := time.Now()
+	if p.busyStart.IsZero() {
+		p.busyStart = now
+		return
+	}
+	rest := J.COOLDOWN
+	if J.DUTY_CYCLE > 0 && J.DUTY_CYCLE < 1 {
+		busy := now.Sub(p.busyStart)
+		rest += time.Duration(float64(busy) * (1 - J.DUTY_CYCLE) / J.DUTY_CYCLE)
+	}
+	if rest > 0 {
+		time.Sleep(rest)
+	}
+	p.busyStart = time.Now()
+}
+
+// Called for every TCK cycle: sleeps when cycles come faster than
+// MAX_TCK_RATE.
+func (J *Jtag) paceTck() {
+	if J.MAX_TCK_RATE == 0 {
+		return
+	}
+	p := &J.pace
+	p.rateCycles += 1
+	if p.rateCycles%PACE_CHECK_CYCLES != 0 {
+		return
+	}
+	now := time.Now()
+	if p.rateStart.IsZero() {
+		p.rateStart = now
+		p.rateCycles = 0
+		return
+	}
+	due := time.Duration(float64(p.rateCycles) / float64(J.MAX_TCK_RATE) * float64(time.Second))
+	if elapsed := now.Sub(p.rateStart); elapsed < due {
+		time.Sleep(due - elapsed)
+	}
+	// start a new window now and then, so pauses don't earn credit
+	if p.rateCycles >= 64*PACE_CHECK_CYCLES {
+		p.rateStart = time.Now()
+		p.rateCycles = 0
+	}
+}
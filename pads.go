@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+)
+
+// BCM283x pad control: drive strength, slew rate and hysteresis, set per
+// bank of GPIOs, all header pins are in bank 0 (GPIO 0-27)
+const (
+	PADS_OFFSET    = 0x100000
+	PADS_BANK0     = 0x2c
+	PADS_PASSWORD  = 0x5a000000
+	PADS_DRIVE     = 0x7
+	PADS_HYST      = 1 << 3
+	PADS_SLEW_FAST = 1 << 4
+)
+
+// Pad settings, zero values keep the current ones
+type PadConfig struct {
+	// drive strength in mA, 2 to 16 in steps of 2
+	DriveMa uint
+	// "limited" or "fast"
+	Slew string
+	// "on" or "off"
+	Hysteresis string
+}
+
+func (c PadConfig) empty() bool {
+	return c.DriveMa == 0 && c.Slew == "" && c.Hysteresis == ""
+}
+
+// returns description of the problem, empty if ok
+func (c PadConfig) check() string {
+	if c.DriveMa != 0 && (c.DriveMa < 2 || c.DriveMa > 16 || c.DriveMa%2 != 0) {
+		return "drive strength must be 2 to 16 mA in steps of 2"
+	}
+	if c.Slew != "" && c.Slew != "limited" && c.Slew != "fast" {
+		return "slew must be 'limited' or 'fast'"
+	}
+	if c.Hysteresis != "" && c.Hysteresis != "on" && c.Hysteresis != "off" {
+		return "hysteresis must be 'on' or 'off'"
+	}
+	return ""
+}
+
+// Apply settings to the register value.
+func (c PadConfig) apply(v uint32) uint32 {
+	if c.DriveMa != 0 {
+		v = v&^PADS_DRIVE | uint32(c.DriveMa/2-1)
+	}
+	switch c.Slew {
+	case "limited":
+		v &^= PADS_SLEW_FAST
+	case "fast":
+		v |= PADS_SLEW_FAST
+	}
+	switch c.Hysteresis {
+	case "on":
+		v |= PADS_HYST
+	case "off":
+		v &^= PADS_HYST
+	}
+	return v
+}
+
+func describePads(v uint32) string {
+	slew := "limited"
+	if v&PADS_SLEW_FAST != 0 {
+		slew = "fast"
+	}
+	hyst := "off"
+	if v&PADS_HYST != 0 {
+		hyst = "on"
+	}
+	return fmt.Sprintf("drive %dmA, slew %s, hysteresis %s", (v&PADS_DRIVE+1)*2, slew, hyst)
+}
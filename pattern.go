@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Generate pseudo-random binary sequence as a string of '0' and '1'.
+// order -- 7 (x^7 + x^6 + 1) or 15 (x^15 + x^14 + 1)
+// length -- number of bits to generate
+func prbs(order, length int) string {
+	var taps uint
+	switch order {
+	case 7:
+		taps = 6
+	case 15:
+		taps = 14
+	default:
+		panic(fmt.Sprintf("unsupported PRBS order %d, use 7 or 15", order))
+	}
+
+	lfsr := uint32(1<<uint(order)) - 1
+	ret := make([]byte, 0, length)
+	for i := 0; i < length; i += 1 {
+		bit := ((lfsr >> uint(order-1)) ^ (lfsr >> (taps - 1))) & 1
+		lfsr = ((lfsr << 1) | bit) & (1<<uint(order) - 1)
+		ret = append(ret, byte('0'+bit))
+	}
+	return string(ret)
+}
+
+// Select pattern used by tests: explicit bits, PRBS of the given order or
+// the default PATTERN.
+func makePattern(bits string, prbsOrder, length int) string {
+	if len(bits) != 0 {
+		if strings.Trim(bits, "01") != "" {
+			panic(fmt.Sprintf("pattern %s must consist of 0s and 1s", bits))
+		}
+		return bits
+	}
+	if prbsOrder != 0 {
+		return prbs(prbsOrder, length)
+	}
+	return PATTERN
+}
+
+// Describe probability that random TDO noise matches the pattern by chance.
+func describePatternStrength(pattern string) string {
+	return fmt.Sprintf("%d bits, false positive probability 2^-%d", len(pattern), len(pattern))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Commands which change what the target pins do or load arbitrary
+// instructions, they need -allow-drive. Read-only ones (IDCODE, BYPASS,
+// SAMPLE) are always allowed.
+var driveCommands = map[string]bool{
+	"extest":          true,
+	"blink_device":    true,
+	"highz":           true,
+	"clamp":           true,
+	"spi_read":        true,
+	"spi_erase":       true,
+	"spi_program":     true,
+	"i2c_scan":        true,
+	"i2c_read":        true,
+	"isc_read":        true,
+	"isc_erase":       true,
+	"isc_program":     true,
+	"discover_opcode": true,
+	"gdb_server":      true,
+}
+
+// returns empty string if the command may run, otherwise what is missing
+func (J *Jtag) checkPermission(cmd string) string {
+	if driveCommands[cmd] && !J.ALLOW_DRIVE {
+		return fmt.Sprintf("'%s' may disturb the target, give -allow-drive to run it", cmd)
+	}
+	return ""
+}
+
+// Probe for nTRST if allowed, pulling pins low may reset the target.
+// returns description of candidates for the found pinout
+func (J *Jtag) probeTrstIfAllowed(found *PinoutResult) string {
+	if !J.ALLOW_RESET {
+		return "not probed, give -allow-reset"
+	}
+	if J.TRST_LAST {
+		return "deferred to the end of the scan"
+	}
+	return J.describeTrst(J.probeTrst(), found)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// Pin assignments tried by scans: every ordered selection of n distinct pins
+// out of AllPins, in TCK, TMS, TDO, TDI order. In natural order, with
+// GRAY_ORDER, consecutive assignments differ in two pins, so initPins has
+// little to reconfigure, otherwise the first pin is the slowest to change
+// (lexicographic order). With SHUFFLE_SEED the order is shuffled
+// deterministically, so likely assignments aren't always tested last when
+// pins happen to be listed in an unlucky order. With PREDICT pins are
+// observed first and assignments matching their behaviour are tested first.
+// Observe-only pins are tried as TDO only. With PERMUTATIONS_IMPORT the
+// permutations are taken from the file as they are, with
+// PERMUTATIONS_EXPORT they are written to the file.
+func (J *Jtag) permutations(n int) [][]JtagPin {
+	if len(J.PERMUTATIONS_IMPORT) != 0 {
+		ret := J.importPermutations(n)
+		fmt.Fprintf(J.out, "testing %d permutations from %s\n", len(ret), J.PERMUTATIONS_IMPORT)
+		if J.tui != nil {
+			J.tui.total = len(ret)
+		}
+		return ret
+	}
+
+	ret := [][]JtagPin{}
+	var gen func(prefix []JtagPin)
+	gen = func(prefix []JtagPin) {
+		if len(prefix) == n {
+			ret = append(ret, append([]JtagPin{}, prefix...))
+			return
+		}
+	next:
+		for _, pin := range J.AllPins {
+			for _, used := range prefix {
+				if pin == used {
+					continue next
+				}
+			}
+			if J.OBSERVE_ONLY[pin] && scanRoles[len(prefix)] != "TDO" {
+				continue
+			}
+			gen(append(prefix, pin))
+		}
+	}
+	if J.GRAY_ORDER {
+		for _, idx := range grayPermutations(len(J.AllPins), n) {
+			p := []JtagPin{}
+			for _, i := range idx {
+				p = append(p, J.AllPins[i])
+			}
+			if J.permutationAllowed(p) {
+				ret = append(ret, p)
+			}
+		}
+	} else {
+		gen([]JtagPin{})
+	}
+
+	if J.SHUFFLE_SEED != 0 {
+		seed := J.SHUFFLE_SEED
+		if seed < 0 {
+			seed = time.Now().UnixNano()
+		}
+		fmt.Fprintf(J.out, "testing %d permutations in shuffled order, seed %d\n", len(ret), seed)
+		r := rand.New(rand.NewSource(seed))
+		r.Shuffle(len(ret), func(i, j int) { ret[i], ret[j] = ret[j], ret[i] })
+	}
+	if J.PREDICT {
+		J.predictOrder(ret)
+	}
+	ret = J.skipRuledOut(ret)
+	if len(J.PERMUTATIONS_EXPORT) != 0 {
+		J.exportPermutations(ret, n)
+	}
+	if J.tui != nil {
+		J.tui.total = len(ret)
+	}
+	return ret
+}
+
+// returns false if p puts an observe-only pin in a role other than TDO
+func (J *Jtag) permutationAllowed(p []JtagPin) bool {
+	for i, pin := range p {
+		if J.OBSERVE_ONLY[pin] && scanRoles[i] != "TDO" {
+			return false
+		}
+	}
+	return true
+}
+
+// Write permutations of n pins to PERMUTATIONS_EXPORT as a '#' line naming
+// the roles followed by a line of pin names per permutation. The file is
+// created by the first scan and appended to by the next ones, so commands
+// scanning for several pin counts (scan_protocols) export all of them.
+func (J *Jtag) exportPermutations(perms [][]JtagPin, n int) {
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if J.permutationsExported {
+		mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(J.PERMUTATIONS_EXPORT, mode, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	J.permutationsExported = true
+
+	roles := []string{}
+	for i := 0; i < n; i += 1 {
+		if i < len(scanRoles) {
+			roles = append(roles, scanRoles[i])
+		} else {
+			roles = append(roles, fmt.Sprintf("pin%d", i+1))
+		}
+	}
+	fmt.Fprintf(f, "# %s\n", strings.Join(roles, " "))
+	for _, p := range perms {
+		names := []string{}
+		for _, pin := range p {
+			names = append(names, J.pinName(pin))
+		}
+		fmt.Fprintln(f, strings.Join(names, " "))
+	}
+	fmt.Fprintf(J.out, "%d permutations written to %s\n", len(perms), J.PERMUTATIONS_EXPORT)
+}
+
+// Read permutations of n pins from PERMUTATIONS_IMPORT: lines of pin names
+// from -pins, '#' starts a comment. Lines of another number of pins belong
+// to scans of other commands and are skipped. The list is used as it is,
+// only observe-only pins are still refused outside of TDO.
+func (J *Jtag) importPermutations(n int) [][]JtagPin {
+	data, err := os.ReadFile(J.PERMUTATIONS_IMPORT)
+	if err != nil {
+		panic(err)
+	}
+	byName := J.pinsByName()
+	ret := [][]JtagPin{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if c := strings.Index(line, "#"); c >= 0 {
+			line = line[:c]
+		}
+		names := strings.Fields(line)
+		if len(names) != n {
+			continue
+		}
+		p := []JtagPin{}
+		for j, name := range names {
+			pin, ok := byName[name]
+			if !ok {
+				panic(fmt.Sprintf("%s:%d: pin '%s' is not in -pins", J.PERMUTATIONS_IMPORT, i+1, name))
+			}
+			for _, used := range p {
+				if used == pin {
+					panic(fmt.Sprintf("%s:%d: pin '%s' is given twice", J.PERMUTATIONS_IMPORT, i+1, name))
+				}
+			}
+			if J.OBSERVE_ONLY[pin] && scanRoles[j] != "TDO" {
+				panic(fmt.Sprintf("%s:%d: observe-only pin '%s' can be TDO only", J.PERMUTATIONS_IMPORT, i+1, name))
+			}
+			p = append(p, pin)
+		}
+		ret = append(ret, p)
+	}
+	if len(ret) == 0 {
+		panic(fmt.Sprintf("no permutations of %d pins in %s", n, J.PERMUTATIONS_IMPORT))
+	}
+	return ret
+}
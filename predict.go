@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Number of reads per pin and pull setting while observing pins
+const OBSERVE_SAMPLES = 100
+
+// Roles in the order scans assign pins
+var scanRoles = []string{"TCK", "TMS", "TDO", "TDI"}
+
+// How a pin behaves while nothing drives it from our side
+type PinObservation struct {
+	// reads returning high with our pull-up off and on
+	HighNoPull int
+	HighPullUp int
+	// level changes seen with pull-up off
+	Toggles int
+}
+
+// Read every pin as an input, first without and then with our pull-up, to
+// see whether the target pulls or drives it. Pins are left as inputs,
+// initPins() sets them up again.
+func (J *Jtag) observePins() map[JtagPin]PinObservation {
+	ret := map[JtagPin]PinObservation{}
+	for _, pin := range J.AllPins {
+		J.drv.pinInput(pin)
+		o := PinObservation{}
+
+		J.drv.pinPullOff(pin)
+		delay(J.DELAY_TCK)
+		prev := J.drv.pinRead(pin)
+		for i := 0; i < OBSERVE_SAMPLES; i += 1 {
+			v := J.drv.pinRead(pin)
+			if v == StateHigh {
+				o.HighNoPull += 1
+			}
+			if v != prev {
+				o.Toggles += 1
+			}
+			prev = v
+			delay(J.DELAY_TCK)
+		}
+
+		J.drv.pinPullUp(pin)
+		delay(J.DELAY_TCK)
+		for i := 0; i < OBSERVE_SAMPLES; i += 1 {
+			if J.drv.pinRead(pin) == StateHigh {
+				o.HighPullUp += 1
+			}
+			delay(J.DELAY_TCK)
+		}
+		J.drv.pinPullOff(pin)
+
+		ret[pin] = o
+	}
+	return ret
+}
+
+// Guess roles of the pin from its behaviour: JTAG inputs of the target
+// (TMS, TDI) usually have pull-ups, TCK often a pull-down, TDO is tri-stated
+// outside of shifts and follows our pull, pins toggling on their own are
+// probably not JTAG at all.
+// returns score of every role and the reasoning
+func (o PinObservation) roleScores() (map[string]int, string) {
+	s := map[string]int{}
+	switch {
+	case o.Toggles > OBSERVE_SAMPLES/10:
+		for _, role := range scanRoles {
+			s[role] = -2
+		}
+		return s, fmt.Sprintf("toggles on its own (%d changes), probably not JTAG", o.Toggles)
+	case o.HighNoPull == OBSERVE_SAMPLES && o.HighPullUp == OBSERVE_SAMPLES:
+		s["TMS"], s["TDI"], s["TCK"] = 2, 2, -1
+		return s, "high without our pull-up, pulled up by target: likely TMS or TDI"
+	case o.HighPullUp == 0:
+		s["TCK"], s["TMS"], s["TDI"], s["TDO"] = 2, -1, -1, -1
+		return s, "low despite our pull-up, pulled down by target: likely TCK"
+	case o.HighPullUp == OBSERVE_SAMPLES && o.HighNoPull < OBSERVE_SAMPLES:
+		s["TDO"] = 2
+		return s, "floats and follows our pull-up: likely tri-stated TDO (or unconnected)"
+	}
+	return s, "no clear behaviour"
+}
+
+// Observe pins and sort permutations so assignments matching the observed
+// behaviour best are tested first, printing the reasoning. Permutations
+// scoring the same keep their order.
+func (J *Jtag) predictOrder(perms [][]JtagPin) {
+	scores := map[JtagPin]map[string]int{}
+	obs := J.observePins()
+	fmt.Fprintln(J.out, "pin behaviour:")
+	for _, pin := range J.AllPins {
+		o := obs[pin]
+		s, reason := o.roleScores()
+		scores[pin] = s
+		fmt.Fprintf(J.out, "  %s: high %d/%d without pull-up, %d/%d with it, %d toggles; %s\n",
+			J.PinNames[pin], o.HighNoPull, OBSERVE_SAMPLES, o.HighPullUp, OBSERVE_SAMPLES, o.Toggles, reason)
+	}
+
+	score := func(p []JtagPin) int {
+		ret := 0
+		for i, pin := range p {
+			ret += scores[pin][scanRoles[i]]
+		}
+		return ret
+	}
+	sort.SliceStable(perms, func(i, j int) bool { return score(perms[i]) > score(perms[j]) })
+
+	if len(perms) != 0 {
+		best := []string{}
+		for i, pin := range perms[0] {
+			best = append(best, fmt.Sprintf("%s:%s", scanRoles[i], J.PinNames[pin]))
+		}
+		fmt.Fprintf(J.out, "most likely assignment tested first: %s\n", strings.Join(best, " "))
+	}
+}
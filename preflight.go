@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Check that device file exists and can be opened for read/write.
+// returns description of the problem with a remediation hint, empty if ok
+func checkDeviceAccess(path string) string {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		f.Close()
+		return ""
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Sprintf("%s does not exist", path)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return fmt.Sprintf("can't open %s: %v", path, err)
+	}
+
+	ret := fmt.Sprintf("no permission to open %s", path)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ret
+	}
+	fileGid, ok := ownerGid(fi)
+	if !ok {
+		return ret
+	}
+	group, err := user.LookupGroupId(fmt.Sprint(fileGid))
+	if err != nil || fileGid == 0 {
+		return ret + ", run as root"
+	}
+	groups, _ := os.Getgroups()
+	for _, gid := range groups {
+		if uint32(gid) == fileGid {
+			return ret + fmt.Sprintf(", check permissions of group '%s' (%s)", group.Name, fi.Mode())
+		}
+	}
+	return ret + fmt.Sprintf(", add user to group '%s' (sudo usermod -aG %s $USER) and log in again, or run as root",
+		group.Name, group.Name)
+}
+
+// Verify prerequisites of the selected driver before touching any pin, so
+// the user gets a hint instead of a panic from inside the driver.
+// returns list of problems found
+func preflightDriver(name string, gpiochip uint) []string {
+	problems := []string{}
+	switch name {
+	case "rpio":
+		if !strings.HasPrefix(boardModel(), "Raspberry Pi") {
+			problems = append(problems, "this host does not look like a Raspberry Pi, rpio driver will not work, use -driver gpiod")
+			break
+		}
+		// go-rpio falls back to /dev/mem which requires root
+		if p := checkDeviceAccess("/dev/gpiomem"); p != "" {
+			if os.Geteuid() == 0 {
+				break
+			}
+			if _, err := os.Stat("/dev/gpiomem"); err != nil {
+				p += ", load the module (sudo modprobe bcm2835-gpiomem) or run as root"
+			}
+			problems = append(problems, p)
+		}
+	case "gpiod":
+		path := fmt.Sprintf("/dev/gpiochip%d", gpiochip)
+		if p := checkDeviceAccess(path); p != "" {
+			if _, err := os.Stat(path); err != nil {
+				chips, _ := filepath.Glob("/dev/gpiochip*")
+				if len(chips) == 0 {
+					p += ", kernel lacks GPIO character device support (CONFIG_GPIO_CDEV) or GPIO controller driver is not loaded"
+				} else {
+					p += fmt.Sprintf(", available: %s, select with -gpiochip", strings.Join(chips, ", "))
+				}
+			}
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// returns group owning the file, false if not known
+func ownerGid(fi os.FileInfo) (uint32, bool) {
+	return 0, false
+}
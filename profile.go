@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flag values set together by -profile, flags given explicitly win
+var profiles = map[string]map[string]string{
+	// quick look at a known good setup
+	"fast": {
+		"delay-tck":        "1",
+		"delay-reset":      "1000",
+		"delay-tap-settle": "0",
+		"retries":          "0",
+		"verify-reads":     "1",
+		"idcode-reads":     "1",
+		"pattern-len":      "32",
+		"pullup":           "false",
+	},
+	// the defaults
+	"normal": {
+		"delay-tck":        "10",
+		"delay-reset":      "10000",
+		"delay-tap-settle": "0",
+		"retries":          "4",
+		"verify-reads":     "1",
+		"idcode-reads":     "3",
+		"pattern-len":      "64",
+		"pullup":           "false",
+	},
+	// slow and repeated, pull-ups keep unconnected pins from floating into
+	// false positives
+	"paranoid": {
+		"delay-tck":        "50",
+		"delay-reset":      "50000",
+		"delay-tap-settle": "10000",
+		"retries":          "8",
+		"verify-reads":     "3",
+		"idcode-reads":     "5",
+		"pattern-len":      "256",
+		"pullup":           "true",
+	},
+}
+
+func profileNames() string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// Set flags of the profile which were not given explicitly. Must be called
+// after flag.Parse().
+// returns false if there is no such profile
+func applyProfile(name string) bool {
+	profile, ok := profiles[name]
+	if !ok {
+		return false
+	}
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for k, v := range profile {
+		if given[k] {
+			continue
+		}
+		if err := flag.Set(k, v); err != nil {
+			panic(fmt.Sprintf("profile %s: %v", name, err))
+		}
+	}
+	return true
+}
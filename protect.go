@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Minimum TCK delay in microseconds with PROTECT, unless series resistors
+// are fitted
+const PROTECT_MIN_DELAY_TCK = 20
+
+// Pins driven at once above which PROTECT warns, the current a GPIO bank may
+// source in total is limited (50 mA on a Raspberry Pi)
+const PROTECT_MAX_DRIVEN = 8
+
+// Apply PROTECT limits before the command is run: warn when many pins are
+// driven at once, and without series resistors recorded as fitted keep TCK
+// delays, the default one, the ones check_speed sweeps and the timing check
+// of found pinouts, at PROTECT_MIN_DELAY_TCK or above.
+func (J *Jtag) applyProtection(o *CommandOptions) {
+	J.minDelayTck = 0
+	if !J.PROTECT {
+		return
+	}
+	resistors := ""
+	if o.Meta != nil {
+		resistors = o.Meta.SeriesResistors
+	}
+
+	driven := 0
+	for _, pin := range J.usedPins() {
+		if !J.OBSERVE_ONLY[pin] {
+			driven += 1
+		}
+	}
+	if driven > PROTECT_MAX_DRIVEN {
+		fmt.Fprintf(J.out, "WARNING: %d pins are driven at once, a pin shorted to a supply or driven by the target sources or sinks current on each of them\n", driven)
+		if resistors != "yes" {
+			fmt.Fprintln(J.out, "WARNING: fit series resistors (100-330 ohm) to the pins, or mark supplies and fragile nets with -observe-only")
+		}
+	}
+	if resistors == "yes" {
+		return
+	}
+	if resistors == "" {
+		fmt.Fprintln(J.out, "series resistors are not recorded, give -series-resistors yes once they are fitted to allow faster TCK")
+	}
+
+	J.minDelayTck = PROTECT_MIN_DELAY_TCK
+	if J.DELAY_TCK < PROTECT_MIN_DELAY_TCK {
+		fmt.Fprintf(J.out, "TCK delay raised from %d to %d us\n", J.DELAY_TCK, PROTECT_MIN_DELAY_TCK)
+		J.DELAY_TCK = PROTECT_MIN_DELAY_TCK
+	}
+	delays := []uint{}
+	for _, d := range o.Delays {
+		if d >= PROTECT_MIN_DELAY_TCK {
+			delays = append(delays, d)
+		}
+	}
+	if len(delays) != len(o.Delays) {
+		fmt.Fprintf(J.out, "TCK delays below %d us are not tried\n", PROTECT_MIN_DELAY_TCK)
+		o.Delays = delays
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Samples of the data pin taken before and after reset by the reset-signal
+// probe
+const RESET_SIGNAL_SAMPLES = 2000
+
+// Debug port protocol tried on pin pairs or triples by scan_protocols
+type protocolProbe struct {
+	// signals in the order pins are assigned
+	signals []string
+	// pulls a pin low, resetting the target, needs -allow-reset
+	resets bool
+	// returns ID read and description, false if the pins don't respond
+	probe func(J *Jtag, pins []JtagPin) (uint32, string, bool)
+}
+
+var protocolProbes = map[string]protocolProbe{
+	"swd":          {signals: []string{"swclk", "swdio"}, probe: probeSwd},
+	"spy-bi-wire":  {signals: []string{"sbwtck", "sbwtdio"}, probe: probeSbw},
+	"icsp":         {signals: []string{"mclr", "pgc", "pgd"}, resets: true, probe: probeIcsp},
+	"reset-signal": {signals: []string{"reset", "data"}, resets: true, probe: probeResetSignal},
+}
+
+func protocolNames() []string {
+	names := []string{}
+	for name := range protocolProbes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse comma separated protocol names, empty for all of them.
+// returns names and empty string, or description of the problem
+func parseProtocols(s string) ([]string, string) {
+	if len(strings.TrimSpace(s)) == 0 {
+		return protocolNames(), ""
+	}
+	ret := []string{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := protocolProbes[name]; !ok {
+			return nil, fmt.Sprintf("unknown protocol '%s', use %s", name, strings.Join(protocolNames(), ", "))
+		}
+		ret = append(ret, name)
+	}
+	return ret, ""
+}
+
+// Probe pins as SWCLK and SWDIO of an ARM SWJ-DP or SW-DP: DPIDR read with
+// a correct ACK and parity identifies the port.
+func probeSwd(J *Jtag, pins []JtagPin) (uint32, string, bool) {
+	J.TCK, J.TMS = pins[0], pins[1]
+	idcode, ok := J.readSwdIdcode()
+	if !ok || idcode == 0 || idcode == 0xffffffff {
+		return 0, "", false
+	}
+	return idcode, describeDpidr(idcode), true
+}
+
+// Probe a pin for a single-wire debug or boot port: quiet before, it must
+// pulse on its own right after reset is released, as Renesas FINE and RL78
+// TOOL0 ports do when their boot code starts. Nothing is sent, so a boot UART
+// TX answers as well; the result only tells which pin pair to look at.
+func probeResetSignal(J *Jtag, pins []JtagPin) (uint32, string, bool) {
+	reset, data := pins[0], pins[1]
+	J.drv.pinInput(data)
+	J.drv.pinPullUp(data)
+	// returns level changes seen
+	watch := func() int {
+		changes := 0
+		prev := J.drv.pinRead(data)
+		for i := 0; i < RESET_SIGNAL_SAMPLES; i += 1 {
+			delay(J.DELAY_TCK)
+			if v := J.drv.pinRead(data); v != prev {
+				changes += 1
+				prev = v
+			}
+		}
+		return changes
+	}
+	if watch() != 0 {
+		return 0, "", false
+	}
+	J.drv.pinWrite(reset, StateLow)
+	delay(J.DELAY_RESET)
+	J.drv.pinWrite(reset, StateHigh)
+	changes := watch()
+	if changes < 2 {
+		return 0, "", false
+	}
+	return 0, fmt.Sprintf("%d level changes after reset, single-wire debug port (e.g. Renesas FINE, RL78 TOOL0) or boot UART", changes), true
+}
+
+// Try every assignment of the pins to the signals of each protocol and
+// record the ones which respond. This broadens discovery beyond IEEE 1149.1
+// to vendor debug ports, identified to the level of "these pins respond to
+// protocol X", with an ID where the protocol reads one.
+// names -- protocols to try
+func (J *Jtag) scanProtocols(names []string) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Starting scan for %s...\n", strings.Join(names, ", "))
+	defer fmt.Fprintln(J.out, "================================")
+
+	for _, name := range names {
+		p := protocolProbes[name]
+		if p.resets && !J.ALLOW_RESET {
+			fmt.Fprintf(J.out, "%s: skipped, it pulls a pin low to reset the target, give -allow-reset\n", name)
+			continue
+		}
+		J.stats.setPhase(name)
+
+	next:
+		for _, pins := range J.permutations(len(p.signals)) {
+			for _, pin := range pins {
+				if J.OBSERVE_ONLY[pin] {
+					continue next
+				}
+			}
+			J.TCK, J.TMS, J.TDO, J.TDI, J.TRST = J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN
+			J.initPins()
+			J.pacePermutation()
+			J.stats.Permutations += 1
+
+			id, desc, ok := p.probe(J, pins)
+			if !ok {
+				continue
+			}
+			r := ProtocolResult{Protocol: name, Pins: map[string]string{}, Id: id, Description: desc}
+			found := []string{}
+			for i, signal := range p.signals {
+				r.Pins[signal] = J.pinName(pins[i])
+				found = append(found, fmt.Sprintf("%s:%s", strings.ToUpper(signal), J.pinName(pins[i])))
+			}
+			fmt.Fprintf(J.out, "FOUND! %s %s", name, strings.Join(found, " "))
+			if id != 0 {
+				fmt.Fprintf(J.out, ", ID 0x%x", id)
+			}
+			if desc != "" {
+				fmt.Fprintf(J.out, ": %s", desc)
+			}
+			fmt.Fprintln(J.out)
+			J.results.Protocols = append(J.results.Protocols, r)
+		}
+	}
+	J.TCK, J.TMS, J.TDO, J.TDI, J.TRST = J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN
+}
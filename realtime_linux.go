@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const SCHED_FIFO = 1
+
+// Lock the calling goroutine to its OS thread, pin the thread to RT_CPU and
+// give it SCHED_FIFO priority RT_PRIORITY, whichever is configured, so the
+// bit-banged shifts are not interrupted by other tasks.
+// returns description of the problem, empty if ok
+func (J *Jtag) enterRealtime() string {
+	if J.RT_PRIORITY == 0 && J.RT_CPU < 0 {
+		return ""
+	}
+	// the thread is never unlocked, it keeps the settings for good
+	runtime.LockOSThread()
+
+	if J.RT_CPU >= 0 {
+		// 1024 CPUs, as glibc cpu_set_t
+		var set [16]uint64
+		if J.RT_CPU >= len(set)*64 {
+			return fmt.Sprintf("CPU %d is out of range", J.RT_CPU)
+		}
+		set[J.RT_CPU/64] |= 1 << uint(J.RT_CPU%64)
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0,
+			unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set)))
+		if errno != 0 {
+			return fmt.Sprintf("can't pin to CPU %d: %v", J.RT_CPU, errno)
+		}
+	}
+
+	if J.RT_PRIORITY != 0 {
+		param := struct{ priority int32 }{int32(J.RT_PRIORITY)}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0,
+			SCHED_FIFO, uintptr(unsafe.Pointer(&param)))
+		if errno != 0 {
+			return fmt.Sprintf("can't set SCHED_FIFO priority %d: %v, run as root or grant CAP_SYS_NICE",
+				J.RT_PRIORITY, errno)
+		}
+	}
+	return ""
+}
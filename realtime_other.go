@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// returns description of the problem, empty if ok
+func (J *Jtag) enterRealtime() string {
+	if J.RT_PRIORITY == 0 && J.RT_CPU < 0 {
+		return ""
+	}
+	return fmt.Sprintf("real-time scheduling and CPU pinning are not supported on %s", runtime.GOOS)
+}
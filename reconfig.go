@@ -0,0 +1,250 @@
+package main
+
+// How initPins leaves a pin
+type pinSetup int
+
+const (
+	// output driven high, pulled as PULLUP says
+	SETUP_DRIVEN pinSetup = iota + 1
+	// output driven low, TCK
+	SETUP_CLOCK
+	// input, TDO
+	SETUP_INPUT
+	// input without pull, observe-only pins
+	SETUP_OBSERVE
+)
+
+// Pin state as last set through the driver, unknown until then
+type pinCache struct {
+	output, outputKnown bool
+	level               JtagPinState
+	levelKnown          bool
+	pullUp, pullKnown   bool
+}
+
+// Driver wrapper tracking direction, level and pull of every pin and
+// dropping calls which would not change them, so initPins can set up all
+// pins for every permutation at the cost of the pins which changed. Levels
+// are forgotten on direction changes, drivers may set them when switching
+// (gpiod requests outputs high).
+type JtagPinDriverCache struct {
+	drv JtagPinDriver
+
+	pins map[JtagPin]*pinCache
+}
+
+func (d *JtagPinDriverCache) initDriver() {
+	d.pins = map[JtagPin]*pinCache{}
+	d.drv.initDriver()
+}
+
+func (d *JtagPinDriverCache) closeDriver() {
+	d.drv.closeDriver()
+}
+
+func (d *JtagPinDriverCache) pin(pin JtagPin) *pinCache {
+	c, ok := d.pins[pin]
+	if !ok {
+		c = &pinCache{}
+		d.pins[pin] = c
+	}
+	return c
+}
+
+func (d *JtagPinDriverCache) pinWrite(pin JtagPin, state JtagPinState) {
+	c := d.pin(pin)
+	if c.levelKnown && c.level == state {
+		return
+	}
+	c.level, c.levelKnown = state, true
+	d.drv.pinWrite(pin, state)
+}
+
+func (d *JtagPinDriverCache) pinRead(pin JtagPin) JtagPinState {
+	return d.drv.pinRead(pin)
+}
+
+func (d *JtagPinDriverCache) setOutput(pin JtagPin, output bool) bool {
+	c := d.pin(pin)
+	if c.outputKnown && c.output == output {
+		return false
+	}
+	c.output, c.outputKnown = output, true
+	c.levelKnown = false
+	return true
+}
+
+func (d *JtagPinDriverCache) pinOutput(pin JtagPin) {
+	if d.setOutput(pin, true) {
+		d.drv.pinOutput(pin)
+	}
+}
+
+func (d *JtagPinDriverCache) pinInput(pin JtagPin) {
+	if d.setOutput(pin, false) {
+		d.drv.pinInput(pin)
+	}
+}
+
+func (d *JtagPinDriverCache) setPull(pin JtagPin, up bool) bool {
+	c := d.pin(pin)
+	if c.pullKnown && c.pullUp == up {
+		return false
+	}
+	c.pullUp, c.pullKnown = up, true
+	return true
+}
+
+func (d *JtagPinDriverCache) pinPullUp(pin JtagPin) {
+	if d.setPull(pin, true) {
+		d.drv.pinPullUp(pin)
+	}
+}
+
+func (d *JtagPinDriverCache) pinPullOff(pin JtagPin) {
+	if d.setPull(pin, false) {
+		d.drv.pinPullOff(pin)
+	}
+}
+
+// returns how initPins sets up the pin for the current assignment
+func (J *Jtag) pinSetupOf(pin JtagPin) pinSetup {
+	switch {
+	case J.OBSERVE_ONLY[pin]:
+		return SETUP_OBSERVE
+	case pin == J.TDO:
+		return SETUP_INPUT
+	case pin == J.TCK:
+		return SETUP_CLOCK
+	}
+	return SETUP_DRIVEN
+}
+
+// Set up the pin for its role, calls which change nothing are dropped by
+// the pin state cache: outputs are driven high, TCK low, and pulled as
+// PULLUP says, inputs too except observe-only ones.
+func (J *Jtag) setupPin(pin JtagPin, setup pinSetup) {
+	switch setup {
+	case SETUP_OBSERVE:
+		J.drv.pinInput(pin)
+		J.drv.pinPullOff(pin)
+		return
+	case SETUP_INPUT:
+		J.drv.pinInput(pin)
+	case SETUP_CLOCK:
+		J.drv.pinOutput(pin)
+		J.drv.pinWrite(pin, StateLow)
+	default:
+		J.drv.pinOutput(pin)
+		J.drv.pinWrite(pin, StateHigh)
+	}
+	if J.PULLUP == true {
+		J.drv.pinPullUp(pin)
+	} else {
+		J.drv.pinPullOff(pin)
+	}
+}
+
+// Ordered selections of k out of n indexes, each one differing from the
+// previous one in two positions: subsets come in revolving door order, one
+// index swapped for another which takes over its position, and the orders
+// of each subset are walked by swapping adjacent positions (Steinhaus-
+// Johnson-Trotter).
+func grayPermutations(n, k int) [][]int {
+	if k > n {
+		return nil
+	}
+	swaps := adjacentSwaps(k)
+	ret := [][]int{}
+	cur := []int{}
+	for _, subset := range revolvingDoor(n, k) {
+		if len(ret) == 0 {
+			cur = append(cur, subset...)
+		} else {
+			// the index entering takes the place of the one leaving
+			in := map[int]bool{}
+			for _, i := range subset {
+				in[i] = true
+			}
+			had := map[int]bool{}
+			for _, i := range cur {
+				had[i] = true
+			}
+			for pos, i := range cur {
+				if !in[i] {
+					for _, j := range subset {
+						if !had[j] {
+							cur[pos] = j
+						}
+					}
+				}
+			}
+		}
+		ret = append(ret, append([]int{}, cur...))
+		for _, s := range swaps {
+			cur[s], cur[s+1] = cur[s+1], cur[s]
+			ret = append(ret, append([]int{}, cur...))
+		}
+	}
+	return ret
+}
+
+// returns k-subsets of n indexes in revolving door order, consecutive ones
+// differ in one index
+func revolvingDoor(n, k int) [][]int {
+	if k == 0 {
+		return [][]int{{}}
+	}
+	if k == n {
+		s := []int{}
+		for i := 0; i < n; i += 1 {
+			s = append(s, i)
+		}
+		return [][]int{s}
+	}
+	ret := revolvingDoor(n-1, k)
+	with := revolvingDoor(n-1, k-1)
+	for i := len(with) - 1; i >= 0; i -= 1 {
+		ret = append(ret, append(append([]int{}, with[i]...), n-1))
+	}
+	return ret
+}
+
+// returns positions swapped with the next one to walk all k! orders of k
+// items, by Even's algorithm
+func adjacentSwaps(k int) []int {
+	perm := []int{}
+	dir := []int{}
+	for i := 0; i < k; i += 1 {
+		perm = append(perm, i)
+		dir = append(dir, -1)
+	}
+	ret := []int{}
+	for {
+		// largest item whose neighbour in its direction is smaller
+		pos := -1
+		for i := range perm {
+			j := i + dir[i]
+			if j >= 0 && j < k && perm[j] < perm[i] && (pos < 0 || perm[i] > perm[pos]) {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			return ret
+		}
+		item := perm[pos]
+		j := pos + dir[pos]
+		perm[pos], perm[j] = perm[j], perm[pos]
+		dir[pos], dir[j] = dir[j], dir[pos]
+		if j < pos {
+			ret = append(ret, j)
+		} else {
+			ret = append(ret, pos)
+		}
+		for i := range perm {
+			if perm[i] > item {
+				dir[i] = -dir[i]
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `commands:
+  chain                    show devices, IR length and IDCODEs found on the chain
+  refresh                  interrogate the chain again
+  readmem ADDR [COUNT]     read COUNT 32-bit words at ADDR (ARM DAP, MIPS EJTAG)
+  writemem ADDR VALUE      write 32-bit word at ADDR (ARM DAP, MIPS EJTAG, -allow-drive)
+  halt, resume             halt or resume Cortex-M core (ARM DAP, -allow-drive)
+  regs                     halt Cortex-M core and print its registers (ARM DAP, -allow-drive)
+  help                     show this help
+  quit                     leave`
+
+func parseReplUint(s string) (uint32, string) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Sprintf("invalid number '%s'", s)
+	}
+	return uint32(v), ""
+}
+
+// Interactive session on the known pins. Memory commands become available
+// once the target device is found to be an ARM debug port or MIPS EJTAG TAP.
+// in -- where commands are read from
+// returns false if the chain can't be used
+func (J *Jtag) repl(in io.Reader) bool {
+	J.useKnownPins()
+
+	J.initPins()
+
+	info := J.chainInfo()
+	if info.Devices == 0 {
+		fmt.Fprintln(J.out, "no devices in chain")
+		return false
+	}
+	if reason := J.CHAIN.check(info.Devices, info.IrLength); reason != "" {
+		fmt.Fprintln(J.out, reason)
+		return false
+	}
+	for i, idcode := range info.Idcodes {
+		fmt.Fprintf(J.out, "device %d: %s\n", i, describeChainIdcode(idcode))
+	}
+
+	var dap *Dap
+	var ejtag *Ejtag
+	var mem memAccess
+	if isArmDp(info.Idcodes[J.CHAIN.Device]) {
+		dap = J.newDap(0)
+		if err := dap.powerUp(); err != "" {
+			fmt.Fprintf(J.out, "ARM DAP found but can't be powered up: %s\n", err)
+			dap = nil
+		} else {
+			mem = dap
+			if idr, err := dap.readAp(AP_IDR); err == "" {
+				fmt.Fprintf(J.out, "ARM DAP found, AP #0 IDR 0x%08x, memory commands available\n", idr)
+			}
+		}
+	} else if J.CHAIN.irLen(info.IrLength) == EJTAG_IR_LEN {
+		reason := ""
+		if ejtag, reason = J.newEjtag(); ejtag != nil {
+			mem = ejtag
+			fmt.Fprintln(J.out, "MIPS EJTAG found, CPU is in debug mode, memory commands available")
+		} else if J.VERBOSE {
+			fmt.Fprintln(J.out, reason)
+		}
+	}
+	if mem == nil {
+		fmt.Fprintln(J.out, "target device is neither an ARM DAP nor MIPS EJTAG, memory commands are not available")
+	}
+	fmt.Fprintln(J.out, "type 'help' for commands")
+
+	needMem := func() bool {
+		if mem == nil {
+			fmt.Fprintln(J.out, "no memory access")
+		}
+		return mem != nil
+	}
+	needDap := func() bool {
+		if dap == nil {
+			fmt.Fprintln(J.out, "no ARM DAP")
+		}
+		return dap != nil
+	}
+	needDrive := func() bool {
+		if !J.ALLOW_DRIVE {
+			fmt.Fprintln(J.out, "this may disturb the target, give -allow-drive")
+		}
+		return J.ALLOW_DRIVE
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(J.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(J.out)
+			break
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+
+		err := ""
+		switch args[0] {
+		default:
+			err = fmt.Sprintf("unknown command '%s', type 'help'", args[0])
+		case "help":
+			fmt.Fprintln(J.out, replHelp)
+		case "quit", "exit":
+			J.setTapState(TAP_RESET)
+			return true
+		case "chain", "refresh":
+			J.printChainInfo(args[0] == "refresh")
+			// IR got reset, reload it on next access
+			if dap != nil {
+				dap.ir = 0
+			}
+			if ejtag != nil {
+				ejtag.ir = 0
+			}
+		case "readmem":
+			if !needMem() {
+				continue
+			}
+			if len(args) < 2 {
+				err = "give address"
+				break
+			}
+			addr, count := uint32(0), uint32(1)
+			if addr, err = parseReplUint(args[1]); err != "" {
+				break
+			}
+			if len(args) > 2 {
+				if count, err = parseReplUint(args[2]); err != "" {
+					break
+				}
+			}
+			for i := uint32(0); i < count && err == ""; i += 1 {
+				v := uint32(0)
+				if v, err = mem.readMem(addr + 4*i); err == "" {
+					fmt.Fprintf(J.out, "0x%08x: 0x%08x\n", addr+4*i, v)
+				}
+			}
+		case "writemem":
+			if !needMem() || !needDrive() {
+				continue
+			}
+			if len(args) != 3 {
+				err = "give address and value"
+				break
+			}
+			addr, value := uint32(0), uint32(0)
+			if addr, err = parseReplUint(args[1]); err != "" {
+				break
+			}
+			if value, err = parseReplUint(args[2]); err != "" {
+				break
+			}
+			err = mem.writeMem(addr, value)
+		case "halt":
+			if needDap() && needDrive() {
+				err = dap.halt()
+			}
+		case "resume":
+			if needDap() && needDrive() {
+				err = dap.resume()
+			}
+		case "regs":
+			if !needDap() || !needDrive() {
+				continue
+			}
+			if err = dap.halt(); err != "" {
+				break
+			}
+			for n, name := range cortexMRegs {
+				v := uint32(0)
+				if v, err = dap.readReg(uint32(n)); err != "" {
+					break
+				}
+				fmt.Fprintf(J.out, "%-4s 0x%08x\n", name, v)
+			}
+		}
+		if err != "" {
+			fmt.Fprintln(J.out, err)
+		}
+	}
+
+	J.setTapState(TAP_RESET)
+	return true
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Pinout found by a scan, pins are given by their names
+type PinoutResult struct {
+	TCK string `json:"tck"`
+	TMS string `json:"tms"`
+	TDO string `json:"tdo"`
+	TDI string `json:"tdi,omitempty"`
+	// pins which changed the chain behaviour when pulled low
+	PossibleTRST []string `json:"possible_trst,omitempty"`
+	// effect observed per possible nTRST pin
+	TrstEffects map[string]string `json:"trst_effects,omitempty"`
+	// devices in the chain, when counted
+	DeviceCount int `json:"device_count,omitempty"`
+	// IDCODEs of the chain, BYPASS_IDCODE for devices without IDCODE
+	Idcodes []uint32 `json:"idcodes,omitempty"`
+	// IDCODEs decoded, filled in when saved
+	Devices []DeviceResult `json:"devices,omitempty"`
+	// sanity score of every IDCODE, up to IDCODE_SCORE_MAX, 0 for devices
+	// without IDCODE
+	IdcodeScores []int `json:"idcode_scores,omitempty"`
+	// results differed at half or double the scan speed
+	TimingSensitive bool `json:"timing_sensitive,omitempty"`
+	// DPIDR read over SWD on TCK and TMS, 0 if SWD is not available
+	SwdIdcode uint32 `json:"swd_idcode,omitempty"`
+}
+
+// Instruction and length of the data register it selects
+type OpcodeResult struct {
+	Opcode   uint32 `json:"opcode"`
+	DrLength uint32 `json:"dr_length"`
+	// value captured by the DR, its first bits in shift order
+	Capture string `json:"capture,omitempty"`
+	// likely roles, e.g. IDCODE, SAMPLE/EXTEST, data port, status
+	Tags []string `json:"tags,omitempty"`
+	// read-write, capture-only or volatile, when probed
+	Access string `json:"access,omitempty"`
+}
+
+// Debug port found on a pin pair by a protocol other than IEEE 1149.1
+type ProtocolResult struct {
+	Protocol string `json:"protocol"`
+	// pin names by signal
+	Pins map[string]string `json:"pins"`
+	// ID read through the port, 0 if it has none
+	Id uint32 `json:"id,omitempty"`
+	// bit rate of serial ports, 0 if unknown
+	Baud        uint   `json:"baud,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Results of a command, saved as JSON with -output
+type ScanResult struct {
+	Command string         `json:"command"`
+	Pinouts []PinoutResult `json:"pinouts,omitempty"`
+	Idcodes []uint32       `json:"idcodes,omitempty"`
+	// IDCODEs decoded, filled in when saved
+	Devices  []DeviceResult `json:"devices,omitempty"`
+	IrLength uint32         `json:"ir_length,omitempty"`
+	// devices counted on known pins
+	DeviceCount int            `json:"device_count,omitempty"`
+	Opcodes     []OpcodeResult `json:"opcodes,omitempty"`
+	// debug ports found by other protocols than IEEE 1149.1
+	Protocols []ProtocolResult `json:"protocols,omitempty"`
+	Meta      *ReportMeta      `json:"meta,omitempty"`
+	// target reboots and crashes seen on its console
+	Console []ConsoleEvent `json:"console,omitempty"`
+	// permutations which found nothing without timing problems, as pin
+	// names in role order, skipped by runs given this file with -previous
+	RuledOut []string `json:"ruled_out,omitempty"`
+	// settings ruled out permutations are valid for
+	Settings *ScanSettings `json:"settings,omitempty"`
+	// permutations which took much longer than the others
+	TimingOutliers []TimingOutlier `json:"timing_outliers,omitempty"`
+}
+
+func (p PinoutResult) String() string {
+	ret := fmt.Sprintf("TCK:%s TMS:%s TDO:%s", p.TCK, p.TMS, p.TDO)
+	if p.TDI != "" {
+		ret += fmt.Sprintf(" TDI:%s", p.TDI)
+	}
+	return ret
+}
+
+// Record currently selected pins as found pinout.
+// returns pointer to the record so that the caller may add details
+func (J *Jtag) recordPinout() *PinoutResult {
+	p := PinoutResult{
+		TCK: J.PinNames[J.TCK],
+		TMS: J.PinNames[J.TMS],
+		TDO: J.PinNames[J.TDO],
+	}
+	if J.TDI != J.IGNOREPIN {
+		p.TDI = J.PinNames[J.TDI]
+	}
+	J.results.Pinouts = append(J.results.Pinouts, p)
+	return &J.results.Pinouts[len(J.results.Pinouts)-1]
+}
+
+// returns pins by their names
+func (J *Jtag) pinsByName() map[string]JtagPin {
+	ret := map[string]JtagPin{}
+	for pin, name := range J.PinNames {
+		ret[name] = pin
+	}
+	return ret
+}
+
+func saveResults(path string, r ScanResult) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func loadResults(path string) ScanResult {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	r := ScanResult{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func formatIdcodes(idcodes []uint32) string {
+	ret := []string{}
+	for _, idcode := range idcodes {
+		ret = append(ret, fmt.Sprintf("0x%08x", idcode))
+	}
+	return "[" + strings.Join(ret, " ") + "]"
+}
+
+// Print lines present only in a (prefixed with '-') or only in b (prefixed
+// with '+').
+// returns number of lines printed
+func diffLines(what string, a, b []string) int {
+	inA := map[string]bool{}
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	cnt := 0
+	for _, s := range a {
+		if !inB[s] {
+			fmt.Printf("- %s: %s\n", what, s)
+			cnt += 1
+		}
+	}
+	for _, s := range b {
+		if !inA[s] {
+			fmt.Printf("+ %s: %s\n", what, s)
+			cnt += 1
+		}
+	}
+	return cnt
+}
+
+// Compare two result files, e.g. before and after a firmware update, and
+// print the differences in found pins, IDCODEs, IR length and opcodes.
+// returns true if results are the same
+func diffResults(a, b ScanResult) bool {
+	fmt.Println("================================")
+	fmt.Println("Comparing results...")
+	defer fmt.Println("================================")
+
+	if a.Command != b.Command {
+		fmt.Printf("warning: comparing results of different commands (%s vs %s)\n", a.Command, b.Command)
+	}
+
+	cnt := 0
+
+	pinouts := func(r ScanResult) []string {
+		ret := []string{}
+		for _, p := range r.Pinouts {
+			ret = append(ret, p.String())
+		}
+		return ret
+	}
+	cnt += diffLines("pinout", pinouts(a), pinouts(b))
+
+	// pinouts found in both, compare their details
+	for _, pa := range a.Pinouts {
+		for _, pb := range b.Pinouts {
+			if pa.String() != pb.String() {
+				continue
+			}
+			cnt += diffLines(pa.String()+" possible nTRST", pa.PossibleTRST, pb.PossibleTRST)
+			if !equalIdcodes(pa.Idcodes, pb.Idcodes) {
+				fmt.Printf("- %s devices: %s\n", pa, formatIdcodes(pa.Idcodes))
+				fmt.Printf("+ %s devices: %s\n", pb, formatIdcodes(pb.Idcodes))
+				cnt += 2
+			}
+		}
+	}
+
+	if !equalIdcodes(a.Idcodes, b.Idcodes) {
+		fmt.Printf("- devices: %s\n", formatIdcodes(a.Idcodes))
+		fmt.Printf("+ devices: %s\n", formatIdcodes(b.Idcodes))
+		cnt += 2
+	}
+
+	if a.IrLength != b.IrLength {
+		fmt.Printf("- IR length: %d\n", a.IrLength)
+		fmt.Printf("+ IR length: %d\n", b.IrLength)
+		cnt += 2
+	}
+
+	opcodes := func(r ScanResult) []string {
+		sorted := append([]OpcodeResult{}, r.Opcodes...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Opcode < sorted[j].Opcode })
+		ret := []string{}
+		for _, o := range sorted {
+			ret = append(ret, describeIrDr(r.IrLength, o.Opcode, o.DrLength))
+		}
+		return ret
+	}
+	cnt += diffLines("opcode", opcodes(a), opcodes(b))
+
+	if cnt == 0 {
+		fmt.Println("no differences")
+		return true
+	}
+	return false
+}
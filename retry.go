@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Bring the TAP back to a known state after a failed verification: pulse
+// nTRST if it is known and resets are allowed, then clock TMS high enough
+// times to reach Test-Logic-Reset from any state and go to Run-Test-Idle.
+func (J *Jtag) resyncTap() {
+	if J.TRST != J.IGNOREPIN && J.ALLOW_RESET {
+		J.drv.pinWrite(J.TRST, StateLow)
+		delay(J.DELAY_RESET)
+		J.drv.pinWrite(J.TRST, StateHigh)
+		J.settleTap()
+	}
+	J.setTapState(TAP_RESET)
+}
+
+// Run operation navigating the TAP from Run-Test-Idle and verifying what it
+// reads. On failure the TAP is re-synced and the operation is run again, up
+// to J.SHIFT_RETRIES times, before the failure is reported. With J.GAP_RETRY
+// an operation which had a timing gap is retried as well.
+// what -- operation description for messages
+// op -- returns false if verification failed
+// returns true if the operation eventually succeeded
+func (J *Jtag) retryShift(what string, op func() bool) bool {
+	for retry := uint(0); ; retry += 1 {
+		gaps := J.stats.TimingGaps
+		ok := op()
+		if ok && J.GAP_RETRY && J.stats.TimingGaps != gaps {
+			fmt.Fprintf(J.out, "timing gap during %s\n", what)
+			ok = false
+		}
+		if ok {
+			if retry != 0 {
+				fmt.Fprintf(J.out, "%s succeeded after %d retries\n", what, retry)
+			}
+			return true
+		}
+		if retry == J.SHIFT_RETRIES {
+			if retry != 0 {
+				fmt.Fprintf(J.out, "%s still failing after %d retries\n", what, retry)
+			}
+			return false
+		}
+		J.stats.ShiftRetries += 1
+		fmt.Fprintf(J.out, "%s failed verification, re-syncing TAP and retrying\n", what)
+		J.resyncTap()
+	}
+}
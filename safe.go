@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Opcodes known to erase, program, blow fuses or reconfigure the device,
+// by manufacturer and IR length
+type DangerousOpcodesEntry struct {
+	Vendor  string
+	Match   IdcodeMatch
+	IrLen   uint32
+	Opcodes []uint32
+}
+
+var dangerousOpcodes = []DangerousOpcodesEntry{
+	// JPROGRAM, ISC_ENABLE, ISC_PROGRAM, XSC_PROGRAM_KEY, ISC_DISABLE,
+	// FUSE_CTS, FUSE_KEY, FUSE_DNA, FUSE_USER, FUSE_CNTL
+	{"Xilinx", IdcodeMatch{0x049 << 1, IDCODE_MFG_MASK}, 6,
+		[]uint32{0x0b, 0x10, 0x11, 0x12, 0x16, 0x30, 0x31, 0x32, 0x33, 0x34}},
+	// PULSE_NCONFIG, PROGRAM, STARTUP, CONFIG_IO
+	{"Altera/Intel", IdcodeMatch{0x06e << 1, IDCODE_MFG_MASK}, 10,
+		[]uint32{0x001, 0x002, 0x003, 0x00d}},
+	// ISC_ERASE, ISC_PROGRAM_DONE, LSC_PROG_INCR_NV, LSC_REFRESH,
+	// LSC_PROG_INCR_RTI, ISC_PROGRAM_USERCODE, LSC_ERASE_TAG,
+	// ISC_PROGRAM_SECURITY, LSC_PROG_FEATURE
+	{"Lattice", IdcodeMatch{0x021 << 1, IDCODE_MFG_MASK}, 8,
+		[]uint32{0x0e, 0x5e, 0x70, 0x79, 0x82, 0xc2, 0xcb, 0xce, 0xe4}},
+}
+
+// Settings of discover_opcode safe mode
+type SafeOptions struct {
+	Enabled bool
+	// opcodes to skip in addition to the known dangerous ones
+	Deny []uint32
+	// read IDCODE every that many opcodes to check the target is still fine,
+	// 0 to disable
+	HealthEvery int
+	// pause between opcodes
+	Delay time.Duration
+	// don't ask for confirmation
+	Yes bool
+}
+
+// Parse comma separated list of opcodes, e.g. "0x0b,0x10".
+func parseOpcodes(s string) []uint32 {
+	ret := []uint32{}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if len(e) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(e, 0, 32)
+		if err != nil {
+			panic(fmt.Sprintf("invalid opcode '%s'", e))
+		}
+		ret = append(ret, uint32(v))
+	}
+	return ret
+}
+
+// Opcodes not to load on the device: known dangerous ones for its IDCODE,
+// the ones given and all 0s, which is EXTEST on most devices and would drive
+// pins with whatever the boundary register holds.
+// returns set of opcodes and the vendors they are known for
+func (o SafeOptions) deniedOpcodes(idcode, irLen uint32) (map[uint32]bool, []string) {
+	ret := map[uint32]bool{0: true}
+	for _, op := range o.Deny {
+		ret[op] = true
+	}
+	vendors := []string{}
+	if isValidIdcode(idcode) {
+		for _, e := range dangerousOpcodes {
+			if e.IrLen == irLen && e.Match.matches(idcode) {
+				for _, op := range e.Opcodes {
+					ret[op] = true
+				}
+				vendors = append(vendors, e.Vendor)
+			}
+		}
+	}
+	return ret, vendors
+}
+
+// Ask operator a yes/no question on stdin, anything but yes is no.
+func (J *Jtag) confirm(question string, yes bool) bool {
+	if yes {
+		return true
+	}
+	fmt.Fprintf(J.out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Known SAMPLE/PRELOAD opcodes by manufacturer and IR length
+type SampleOpcodeEntry struct {
+	Vendor string
+	Match  IdcodeMatch
+	IrLen  uint32
+	Opcode uint32
+}
+
+var sampleOpcodes = []SampleOpcodeEntry{
+	{"Xilinx", IdcodeMatch{0x049 << 1, IDCODE_MFG_MASK}, 6, 0x01},
+	{"Altera/Intel", IdcodeMatch{0x06e << 1, IDCODE_MFG_MASK}, 10, 0x005},
+	{"Lattice", IdcodeMatch{0x021 << 1, IDCODE_MFG_MASK}, 8, 0x1c},
+	{"STMicroelectronics", IdcodeMatch{0x020 << 1, IDCODE_MFG_MASK}, 5, 0x02},
+}
+
+// Convert opcode to bits in shift order (LSB first).
+func irBits(opcode, irLen uint32) []byte {
+	ret := []byte{}
+	for i := uint32(0); i < irLen; i += 1 {
+		if (opcode & (1 << i)) != 0 {
+			ret = append(ret, '1')
+		} else {
+			ret = append(ret, '0')
+		}
+	}
+	return ret
+}
+
+// Find SAMPLE/PRELOAD opcode of a single device in the chain: from BSDL if
+// given, from known vendor opcodes by IDCODE, otherwise by probing for
+// opcodes selecting the longest DR shared by several instructions (SAMPLE,
+// PRELOAD and EXTEST all select the boundary register).
+// returns opcode, boundary register length (0 if unknown) and where the
+// opcode comes from, ok is false if nothing was found
+func (J *Jtag) findSampleOpcode(irLen uint32, bsdl *Bsdl) (opcode, boundaryLen uint32, source string, ok bool) {
+	if bsdl != nil {
+		if bsdl.IrLen != irLen {
+			fmt.Fprintf(J.out, "warning: IR length %d detected, BSDL says %d\n", irLen, bsdl.IrLen)
+		}
+		if op, found := bsdl.opcode("SAMPLE", "SAMPLE/PRELOAD", "SAMPLE_PRELOAD", "PRELOAD"); found {
+			return op, bsdl.BoundaryLen, "BSDL " + bsdl.Entity, true
+		}
+		fmt.Fprintln(J.out, "no SAMPLE instruction in BSDL")
+	}
+
+	idcode := J.getIdcodes(1)[0]
+	if isValidIdcode(idcode) {
+		for _, e := range sampleOpcodes {
+			if e.IrLen == irLen && e.Match.matches(idcode) {
+				return e.Opcode, 0, "known " + e.Vendor + " opcode", true
+			}
+		}
+	}
+
+	if !J.ALLOW_DRIVE {
+		fmt.Fprintln(J.out, "probing loads arbitrary instructions, give -allow-drive to do it")
+		return 0, 0, "", false
+	}
+
+	// All-zeros opcode is EXTEST on most devices, all-ones is BYPASS,
+	// never load them while probing.
+	lengths := map[uint32][]uint32{}
+	for op := uint32(1); op < (1<<irLen)-1; op += 1 {
+		drLen := J.detectDrLength(op)
+		if drLen > 32 {
+			lengths[drLen] = append(lengths[drLen], op)
+		}
+	}
+	for drLen, ops := range lengths {
+		if len(ops) >= 2 && drLen > boundaryLen {
+			boundaryLen = drLen
+			opcode = ops[0]
+		}
+	}
+	if boundaryLen == 0 {
+		return 0, 0, "", false
+	}
+	if len(lengths[boundaryLen]) > 2 {
+		fmt.Fprintf(J.out, "warning: %d opcodes select %d-bit DR, taking the first one, EXTEST can't be told from SAMPLE by probing\n",
+			len(lengths[boundaryLen]), boundaryLen)
+	}
+	return opcode, boundaryLen, "probing", true
+}
@@ -0,0 +1,83 @@
+package main
+
+// MSP430 JTAG IDs, shifted out of the 8-bit IR
+var sbwJtagIds = map[uint32]string{
+	0x89: "MSP430 (1xx, 2xx, 4xx)",
+	0x91: "MSP430 CPUXv2 (5xx, 6xx, FR5xx, FR6xx)",
+	0x98: "MSP430 CPUXv2 (FR2xx, FR4xx)",
+	0x99: "MSP430 CPUXv2 (FR57xx)",
+}
+
+const SBW_IR_LEN = 8
+
+// Drive one Spy-Bi-Wire time slot: SBWTDIO (TMS pin) is set and SBWTCK (TCK
+// pin) pulsed low. The low phase is kept short, the target leaves
+// Spy-Bi-Wire when SBWTCK stays low for more than 7 us.
+func (J *Jtag) sbwSlot(bit JtagPinState) {
+	J.drv.pinWrite(J.TMS, bit)
+	J.drv.pinWrite(J.TCK, StateLow)
+	J.pinWriteDelay(J.TCK, StateHigh)
+}
+
+// One JTAG TCK cycle over Spy-Bi-Wire: TMS, TDI and TDO slots.
+// returns TDO
+func (J *Jtag) sbwClock(tms, tdi JtagPinState) JtagPinState {
+	J.sbwSlot(tms)
+	J.sbwSlot(tdi)
+	J.drv.pinInput(J.TMS)
+	J.drv.pinWrite(J.TCK, StateLow)
+	tdo := J.drv.pinRead(J.TMS)
+	J.pinWriteDelay(J.TCK, StateHigh)
+	J.drv.pinOutput(J.TMS)
+	J.stats.TckCycles += 1
+	return tdo
+}
+
+// Enter Spy-Bi-Wire on TCK (SBWTCK) and TMS (SBWTDIO), reset the TAP and
+// shift the IR, which shifts out the JTAG ID, then leave Spy-Bi-Wire.
+// returns the JTAG ID, MSB first as MSP430 shifts it
+func (J *Jtag) sbwReadId() uint32 {
+	J.drv.pinOutput(J.TCK)
+	J.drv.pinOutput(J.TMS)
+	// SBWTCK rising while SBWTDIO (RST) is high selects Spy-Bi-Wire
+	J.drv.pinWrite(J.TMS, StateHigh)
+	J.pinWriteDelay(J.TCK, StateLow)
+	delay(J.DELAY_RESET)
+	J.pinWriteDelay(J.TCK, StateHigh)
+	delay(J.DELAY_RESET)
+
+	for i := 0; i < 6; i += 1 {
+		J.sbwClock(StateHigh, StateHigh)
+	}
+	// Run-Test-Idle, Select-DR, Select-IR, Capture-IR, Shift-IR
+	for _, tms := range []JtagPinState{StateLow, StateHigh, StateHigh, StateLow, StateLow} {
+		J.sbwClock(tms, StateHigh)
+	}
+	id := uint32(0)
+	for i := 0; i < SBW_IR_LEN; i += 1 {
+		tms := StateLow
+		if i == SBW_IR_LEN-1 {
+			tms = StateHigh
+		}
+		id = id<<1 | uint32(J.sbwClock(tms, StateHigh))
+	}
+	// Update-IR, Run-Test-Idle
+	J.sbwClock(StateHigh, StateHigh)
+	J.sbwClock(StateLow, StateHigh)
+
+	// SBWTCK low for long leaves Spy-Bi-Wire
+	J.drv.pinWrite(J.TCK, StateLow)
+	delay(J.DELAY_RESET)
+	J.drv.pinWrite(J.TCK, StateHigh)
+	return id
+}
+
+// Probe pins as SBWTCK and SBWTDIO of a TI Spy-Bi-Wire (two-wire JTAG)
+// port, as found on MSP430: a known JTAG ID shifted out of the IR
+// identifies the port.
+func probeSbw(J *Jtag, pins []JtagPin) (uint32, string, bool) {
+	J.TCK, J.TMS = pins[0], pins[1]
+	id := J.sbwReadId()
+	desc, ok := sbwJtagIds[id]
+	return id, desc, ok
+}
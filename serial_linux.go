@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Baud rate bits of c_cflag, missing from package syscall
+const CBAUD = 0x100f
+
+// Reads of serial devices opened for writing return nothing after this long,
+// in tenths of a second
+const SERIAL_READ_TIMEOUT = 5
+
+var serialBauds = map[uint]uint32{
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+// Open serial device in raw 8N1 mode at baud. Reads block until data comes,
+// unless it is opened for writing, then they time out after
+// SERIAL_READ_TIMEOUT.
+// write -- open for writing as well
+// returns device and empty string, or description of the problem
+func openSerial(path string, baud uint, write bool) (*os.File, string) {
+	speed, ok := serialBauds[baud]
+	if !ok {
+		return nil, fmt.Sprintf("unsupported baud rate %d", baud)
+	}
+	mode := os.O_RDONLY
+	if write {
+		mode = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, mode|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	t := syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Sprintf("%s is not a serial device: %v", path, errno)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | CBAUD
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if write {
+		t.Cc[syscall.VMIN] = 0
+		t.Cc[syscall.VTIME] = SERIAL_READ_TIMEOUT
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Sprintf("can't configure %s: %v", path, errno)
+	}
+	return f, ""
+}
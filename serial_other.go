@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// Open serial device, baud rate and mode must be set beforehand, e.g. with
+// stty, and reads don't time out.
+// write -- open for writing as well
+// returns device and empty string, or description of the problem
+func openSerial(path string, baud uint, write bool) (*os.File, string) {
+	mode := os.O_RDONLY
+	if write {
+		mode = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, mode, 0)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return f, ""
+}
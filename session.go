@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Target wired to its own pins, possibly on its own driver
+type SessionConfig struct {
+	Name string `json:"name"`
+	// command to run, the one given with -command if empty
+	Command  string `json:"command"`
+	Driver   string `json:"driver"`
+	GpioChip *uint  `json:"gpiochip"`
+	// pins to scan for scan commands
+	Pins map[string]JtagPin `json:"pins"`
+	// pins assignment for test commands
+	KnownPins *JtagPins `json:"known_pins"`
+	// expected IDCODEs as for -expect, used by 'test_idcode' command
+	ExpectedIdcodes []string `json:"expected_idcodes"`
+	// JSON file to save results to
+	Output string `json:"output"`
+}
+
+func loadSessions(path string) []SessionConfig {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	sessions := []SessionConfig{}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		panic(err)
+	}
+	return sessions
+}
+
+// Writer prefixing every line with session name, so output of concurrent
+// sessions does not get mixed up.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.mu.Lock()
+		fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:i+1])
+		p.mu.Unlock()
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+func (p *prefixWriter) flush() {
+	if len(p.buf) != 0 {
+		p.Write([]byte("\n"))
+	}
+}
+
+// Run commands on every session concurrently, each one on its own Jtag
+// instance configured like base.
+// returns true if all sessions passed
+func runSessions(base Jtag, sessions []SessionConfig, cmd string, drvOpt DriverOptions, opt CommandOptions) bool {
+	var mu sync.Mutex
+	jtags := make([]*Jtag, len(sessions))
+	outs := make([]*prefixWriter, len(sessions))
+
+	// pins may be shared by sessions only if they are on different chips
+	owners := map[string]string{}
+	for i, s := range sessions {
+		J := base
+		J.out = os.Stdout
+		J.drv = nil
+		J.results = ScanResult{}
+		J.stats = ScanStats{}
+		J.PinNames = make(map[JtagPin]string, 0)
+		J.AllPins = nil
+		if len(s.Pins) != 0 {
+			J.setPins(s.Pins)
+		}
+		if s.KnownPins != nil {
+			J.KnownPins = *s.KnownPins
+		}
+		jtags[i] = &J
+
+		if s.Command == "" {
+			sessions[i].Command = cmd
+		}
+		if s.Driver == "" {
+			sessions[i].Driver = drvOpt.Name
+		}
+		chip := drvOpt.GpioChip
+		if s.GpioChip != nil {
+			chip = *s.GpioChip
+		}
+		for _, pin := range J.usedPins() {
+			key := fmt.Sprintf("%s/%d/%d", sessions[i].Driver, chip, pin)
+			if owner, ok := owners[key]; ok {
+				fmt.Printf("sessions %s and %s share pin %d\n", owner, s.Name, pin)
+				return false
+			}
+			owners[key] = s.Name
+		}
+
+		outs[i] = &prefixWriter{mu: &mu, w: os.Stdout, prefix: fmt.Sprintf("[%s] ", s.Name)}
+		J.out = outs[i]
+	}
+
+	results := make([]bool, len(sessions))
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := sessions[i]
+			J := jtags[i]
+			defer outs[i].flush()
+
+			o := drvOpt
+			o.Name = s.Driver
+			if s.GpioChip != nil {
+				o.GpioChip = *s.GpioChip
+			}
+			if !J.openDriver(o, nil) {
+				return
+			}
+			defer J.closeJtag()
+
+			so := opt
+			// fixture trigger is shared by the whole process
+			so.Trigger = TriggerPins{J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN}
+			if len(s.ExpectedIdcodes) != 0 {
+				so.Expected = []IdcodeMatch{}
+				for _, e := range s.ExpectedIdcodes {
+					so.Expected = append(so.Expected, parseIdcodeMatch(e, opt.AnyVersion))
+				}
+			}
+			results[i] = J.runCommand(s.Command, so)
+
+			if len(s.Output) != 0 {
+				saveResults(s.Output, J.results)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	passed := true
+	fmt.Println("sessions summary:")
+	for i, s := range sessions {
+		if results[i] {
+			fmt.Printf("%s: PASS\n", s.Name)
+		} else {
+			fmt.Printf("%s: FAIL\n", s.Name)
+			passed = false
+		}
+	}
+	return passed
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// pins the emulated chain is wired to in tests
+var testWiring = JtagPins{TCK: 1, TMS: 2, TDI: 3, TDO: 4, TRST: 0xff}
+
+// returns Jtag on the emulator with the chain given as -emu-chain, known pins
+// selected and initialized, and the emulator to look into
+func newEmuJtag(t *testing.T, chain string) (*Jtag, *JtagPinDriverEmu) {
+	t.Helper()
+	devices, reason := parseEmuChain(chain)
+	if reason != "" {
+		t.Fatal(reason)
+	}
+	return newTestJtag(t, &JtagPinDriverEmu{Wiring: testWiring, Devices: devices})
+}
+
+// returns Jtag on the emulator with devices of a simulation model
+func newSimJtag(t *testing.T, taps ...SimTap) (*Jtag, *JtagPinDriverEmu) {
+	t.Helper()
+	drv := &JtagPinDriverEmu{Wiring: testWiring}
+	for i, tap := range taps {
+		dev, reason := tap.device()
+		if reason != "" {
+			t.Fatalf("TAP %d: %s", i, reason)
+		}
+		drv.Devices = append(drv.Devices, dev)
+	}
+	return newTestJtag(t, drv)
+}
+
+func newTestJtag(t *testing.T, drv *JtagPinDriverEmu) (*Jtag, *JtagPinDriverEmu) {
+	J := NewJtag()
+	J.out = io.Discard
+	J.WATCHDOG = 0
+	J.DELAY_TCK = 0
+	J.DELAY_RESET = 0
+	J.KnownPins = testWiring
+	J.setJtagDriver(drv)
+	t.Cleanup(J.closeJtag)
+	J.useKnownPins()
+	J.initPins()
+	return &J, drv
+}
+
+func TestDetectDevices(t *testing.T) {
+	for chain, want := range map[string]int{
+		"0x4ba00477:4":                              1,
+		"0x4ba00477:4,none:5,0x06413041:5":          3,
+		"none:8,none:8,none:8,none:8,0x020a10dd:10": 5,
+	} {
+		J, _ := newEmuJtag(t, chain)
+		if got := J.detectDevices(); got != want {
+			t.Errorf("%s: detectDevices() = %d, want %d", chain, got, want)
+		}
+	}
+}
+
+func TestDetectIrLength(t *testing.T) {
+	J, _ := newEmuJtag(t, "0x4ba00477:4")
+	if got := J.detectIrLength(); got != 4 {
+		t.Errorf("detectIrLength() = %d, want 4", got)
+	}
+	J, _ = newEmuJtag(t, "0x4ba00477:4,0x0362d093:24,0x020a10dd:10")
+	if got := J.detectIrChainLength(); got != 38 {
+		t.Errorf("detectIrChainLength() = %d, want 38", got)
+	}
+	// captures 0s instead of ...01, the 1 shifted in still comes out
+	J, _ = newEmuJtag(t, "0x4ba00477:6:badcapture")
+	if got := J.detectIrLength(); got != 6 {
+		t.Errorf("detectIrLength() with bad capture = %d, want 6", got)
+	}
+}
+
+func TestGetIdcodes(t *testing.T) {
+	J, _ := newEmuJtag(t, "0x4ba00477:4,none:5,0x06413041:5")
+	got := J.readKnownIdcodes()
+	want := []uint32{0x4ba00477, BYPASS_IDCODE, 0x06413041}
+	if !equalIdcodes(got, want) {
+		t.Errorf("readKnownIdcodes() = %08x, want %08x", got, want)
+	}
+}
+
+func TestDetectDrLength(t *testing.T) {
+	J, _ := newSimJtag(t, SimTap{IrLen: 4, Idcode: "0x4ba00477", IdcodeOp: "0xe", Drs: map[string]uint32{"0xa": 35}})
+	for opcode, want := range map[uint32]uint32{0xa: 35, 0xe: 32, 0x3: 1} {
+		if got := J.detectDrLength(opcode); got != want {
+			t.Errorf("detectDrLength(0x%x) = %d, want %d", opcode, got, want)
+		}
+	}
+}
+
+// Every shift leaves the TAP in Run-Test-Idle, where the next one starts
+func TestTapStateSequencing(t *testing.T) {
+	J, emu := newEmuJtag(t, "0x4ba00477:4,0x06413041:5")
+	check := func(what string) {
+		t.Helper()
+		if emu.state != emuRunTestIdle {
+			t.Errorf("after %s TAP is in state %d, want Run-Test-Idle", what, emu.state)
+		}
+	}
+	J.setTapState(TAP_RESET)
+	check("reset")
+	J.sendInstruction(ones(9))
+	check("IR shift")
+	if out := string(J.sendData([]byte("0110"))); out != "0001" {
+		t.Errorf("BYPASS DR shift returned %s, want 0001", out)
+	}
+	check("DR shift")
+	J.detectDevices()
+	check("detectDevices")
+	J.detectIrChainLength()
+	check("detectIrChainLength")
+}
+
+func TestScanBypass(t *testing.T) {
+	J, _ := newEmuJtag(t, "0x4ba00477:4,none:5")
+	J.setPins(map[string]JtagPin{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5})
+	J.GRAY_ORDER = false
+	J.TIMING_CHECK = false
+	J.scanBypass("0110011101001101101000010111001001")
+	if len(J.results.Pinouts) != 1 {
+		t.Fatalf("found %d pinouts, want 1: %v", len(J.results.Pinouts), J.results.Pinouts)
+	}
+	p := J.results.Pinouts[0]
+	if p.TCK != "a" || p.TMS != "b" || p.TDI != "c" || p.TDO != "d" || p.DeviceCount != 2 {
+		t.Errorf("found %s with %d devices, want TCK:a TMS:b TDO:d TDI:c with 2", p, p.DeviceCount)
+	}
+}
+
+// Vectors are in cell order both ways: cell 0, closest to TDO, is the first
+// bit shifted in and out
+func TestShiftBoundaryCellOrder(t *testing.T) {
+	J, emu := newSimJtag(t, SimTap{IrLen: 4, Idcode: "0x4ba00477", IdcodeOp: "0xe", Drs: map[string]uint32{"0xa": 8}})
+	J.setTapState(TAP_RESET)
+	J.sendInstruction(irBits(0xa, 4))
+	J.shiftBoundary([]byte("10000110"))
+	if dr := string(emu.Devices[0].drs[0xa]); dr != "10000110" {
+		t.Errorf("DR holds %s from cell 0, want 10000110", dr)
+	}
+	if got := string(J.shiftBoundary([]byte("00000000"))); got != "10000110" {
+		t.Errorf("shiftBoundary() captured %s, want 10000110", got)
+	}
+}
+
+// A chain longer than MAX_DEVICES is not taken for no chain
+func TestDetectDevicesLimit(t *testing.T) {
+	J, _ := newEmuJtag(t, "none:4,none:4,none:4")
+	J.MAX_DEVICES = 2
+	if devCnt, limitHit := J.detectDevicesLimit(); devCnt != 0 || !limitHit {
+		t.Errorf("detectDevicesLimit() = %d, %v, want 0, true", devCnt, limitHit)
+	}
+	J.setPins(map[string]JtagPin{"a": 1, "b": 2, "c": 3, "d": 4})
+	J.TIMING_CHECK = false
+	J.scanBypass("0110011101001101101000010111001001")
+	for _, key := range J.results.RuledOut {
+		if key == "a b d c" {
+			t.Errorf("permutation of the chain is ruled out")
+		}
+	}
+}
+
+// Writes a U-Boot banner to the console when pin goes low, as a target
+// whose nRST it is would
+type rebootingDriver struct {
+	JtagPinDriver
+	pin     JtagPin
+	console *consoleMonitor
+}
+
+func (d *rebootingDriver) pinWrite(pin JtagPin, state JtagPinState) {
+	if pin == d.pin && state == StateLow {
+		d.console.mu.Lock()
+		d.console.pending = append(d.console.pending, ConsoleEvent{Kind: "reboot", Line: "U-Boot 2020.01", During: d.console.during})
+		d.console.mu.Unlock()
+	}
+	d.JtagPinDriver.pinWrite(pin, state)
+}
+
+func TestProbeTrstConsoleReboot(t *testing.T) {
+	J, _ := newEmuJtag(t, "0x4ba00477:4")
+	J.console = &consoleMonitor{}
+	J.drv = &rebootingDriver{JtagPinDriver: J.drv, pin: 6, console: J.console}
+	J.setPins(map[string]JtagPin{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6})
+	got := J.probeTrst()
+	if len(got) != 1 || got[0].Pin != 6 || got[0].Effect != TrstTargetRebooted {
+		t.Fatalf("probeTrst() = %v, want pin 6 rebooting the target", got)
+	}
+	if len(J.results.Console) != 1 || J.results.Console[0].During != " nTRST:f TCK:a TMS:b TDO:d TDI:c" {
+		t.Errorf("console events %v, want the reboot during the pulse of f", J.results.Console)
+	}
+}
+
+// Output PA0 is disabled by control cell 1 loaded with 1, whose safe value
+// is X
+const testBsdl = `
+entity TEST is
+attribute INSTRUCTION_LENGTH of TEST : entity is 4;
+attribute INSTRUCTION_OPCODE of TEST : entity is
+  "EXTEST (0000)," &
+  "SAMPLE (0010)," &
+  "IDCODE (1110)," &
+  "BYPASS (1111)";
+attribute BOUNDARY_LENGTH of TEST : entity is 3;
+attribute BOUNDARY_REGISTER of TEST : entity is
+  "0 (BC_1, PA0, output3, X, 1, 1, Z)," &
+  "1 (BC_1, *, control, X)," &
+  "2 (BC_1, PA1, input, X)";
+end TEST;
+`
+
+func TestSafeVectorDisablesOutputs(t *testing.T) {
+	bsdl := parseBsdl(testBsdl)
+	if v := string(bsdl.safeVector()); v != "010" {
+		t.Errorf("safeVector() = %s, want 010", v)
+	}
+	J, emu := newSimJtag(t, SimTap{IrLen: 4, Idcode: "0x4ba00477", IdcodeOp: "0xe", Drs: map[string]uint32{"0x0": 3, "0x2": 3}})
+	if J.newBoundaryPins(bsdl) == nil {
+		t.Fatal("newBoundaryPins() failed")
+	}
+	if dr := string(emu.Devices[0].drs[0x2]); dr != "010" {
+		t.Errorf("preloaded %s, want 010 keeping PA0 disabled", dr)
+	}
+}
+
+// BYPASS is loaded with as many 1s as the chain IR measured on the pins has
+func TestBypassIrLenMeasured(t *testing.T) {
+	J, _ := newEmuJtag(t, "0x4ba00477:4,0x020a10dd:10")
+	if got := J.bypassIrLen(); got != 14 {
+		t.Errorf("bypassIrLen() = %d, want 14", got)
+	}
+	if got := J.countDevices(); got != 2 {
+		t.Errorf("countDevices() = %d, want 2", got)
+	}
+	J.CHAIN.IrLens = []uint32{4, 12}
+	if got := J.bypassIrLen(); got != 16 {
+		t.Errorf("bypassIrLen() with -chain-ir-lens = %d, want 16", got)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SPI_READ_ID    = 0x9f
+	SPI_READ       = 0x03
+	SPI_WREN       = 0x06
+	SPI_RDSR       = 0x05
+	SPI_SECTOR_ERS = 0x20
+	SPI_PAGE_PROG  = 0x02
+
+	SPI_SR_BUSY     = 0x01
+	SPI_SECTOR_SIZE = 4096
+	SPI_PAGE_SIZE   = 256
+	// give up waiting for erase/program to finish after that long
+	SPI_BUSY_TIMEOUT = 10 * time.Second
+)
+
+// Device ports wired to SPI flash signals
+type SpiPorts struct {
+	CS, SCK, MOSI, MISO string
+}
+
+// Parse SPI signals mapping, e.g. "cs=IO_A0,sck=IO_A1,mosi=IO_A2,miso=IO_A3".
+func parseSpiPorts(s string) SpiPorts {
+	ret := SpiPorts{}
+	if len(s) == 0 {
+		return ret
+	}
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(kv) != 2 {
+			panic(fmt.Sprintf("invalid SPI signal '%s', use SIGNAL=PORT", e))
+		}
+		switch strings.ToLower(kv[0]) {
+		case "cs":
+			ret.CS = kv[1]
+		case "sck":
+			ret.SCK = kv[1]
+		case "mosi":
+			ret.MOSI = kv[1]
+		case "miso":
+			ret.MISO = kv[1]
+		default:
+			panic(fmt.Sprintf("unknown SPI signal '%s', use cs, sck, mosi or miso", kv[0]))
+		}
+	}
+	return ret
+}
+
+// SPI flash bit-banged through boundary register, mode 0, MSB first
+type SpiFlash struct {
+	pins  *BoundaryPins
+	ports SpiPorts
+}
+
+// Check the mapping against BSDL, CS, SCK and MOSI need output cells, MISO
+// an input cell. The ports mapped are allowed to be driven, but power and
+// clock nets are refused as extest does, except for a clock name of SCK.
+// returns empty string if ok, otherwise the reason
+func (s SpiPorts) check(bsdl *Bsdl) string {
+	if s.CS == "" || s.SCK == "" || s.MOSI == "" || s.MISO == "" {
+		return "all of cs, sck, mosi and miso must be given with -spi"
+	}
+	allow := parsePortList(strings.Join([]string{s.CS, s.SCK, s.MOSI, s.MISO}, ","))
+	for _, port := range []string{s.CS, s.MOSI} {
+		if reason := bsdl.checkDrivable(port, allow); reason != "" {
+			return reason
+		}
+	}
+	if reason := bsdl.checkAllowed(s.SCK, allow, true); reason != "" {
+		return reason
+	}
+	if reason := bsdl.checkOutput(s.SCK); reason != "" {
+		return reason
+	}
+	if reason := bsdl.checkAllowed(s.MISO, allow, false); reason != "" {
+		return reason
+	}
+	if bsdl.inputCell(s.MISO) == nil {
+		return fmt.Sprintf("port %s has no input cell", s.MISO)
+	}
+	return ""
+}
+
+// Select the flash, shift bytes out and return bytes shifted in at the same
+// time, then deselect unless more is to follow.
+func (f *SpiFlash) transfer(out []byte, in int, keepSelected bool) []byte {
+	p := f.pins
+	p.set(f.ports.CS, StateLow)
+	p.set(f.ports.SCK, StateLow)
+	p.update()
+
+	ret := make([]byte, 0, in)
+	for i := 0; i < len(out)+in; i++ {
+		b := byte(0)
+		if i < len(out) {
+			b = out[i]
+		}
+		v := byte(0)
+		for bit := 7; bit >= 0; bit-- {
+			p.set(f.ports.MOSI, JtagPinState((b>>bit)&1))
+			p.set(f.ports.SCK, StateLow)
+			p.update()
+			// MISO is captured before the rising edge takes effect, it has
+			// been valid since the falling one
+			p.set(f.ports.SCK, StateHigh)
+			p.update()
+			v = v<<1 | byte(p.get(f.ports.MISO))
+		}
+		if i >= len(out) {
+			ret = append(ret, v)
+		}
+	}
+
+	p.set(f.ports.SCK, StateLow)
+	if !keepSelected {
+		p.set(f.ports.CS, StateHigh)
+	}
+	p.update()
+	return ret
+}
+
+func spiAddr(cmd byte, addr uint32) []byte {
+	return []byte{cmd, byte(addr >> 16), byte(addr >> 8), byte(addr)}
+}
+
+func (f *SpiFlash) readId() []byte {
+	return f.transfer([]byte{SPI_READ_ID}, 3, false)
+}
+
+func (f *SpiFlash) read(addr uint32, length int) []byte {
+	return f.transfer(spiAddr(SPI_READ, addr), length, false)
+}
+
+// Wait for erase/program to complete.
+// returns false on timeout
+func (f *SpiFlash) waitReady() bool {
+	deadline := time.Now().Add(SPI_BUSY_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if f.transfer([]byte{SPI_RDSR}, 1, false)[0]&SPI_SR_BUSY == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *SpiFlash) eraseSector(addr uint32) bool {
+	f.transfer([]byte{SPI_WREN}, 0, false)
+	f.transfer(spiAddr(SPI_SECTOR_ERS, addr), 0, false)
+	return f.waitReady()
+}
+
+// data must not cross page boundary
+func (f *SpiFlash) programPage(addr uint32, data []byte) bool {
+	f.transfer([]byte{SPI_WREN}, 0, false)
+	f.transfer(append(spiAddr(SPI_PAGE_PROG, addr), data...), 0, false)
+	return f.waitReady()
+}
+
+// Enter EXTEST driving SPI signals to idle and check that a flash responds.
+// TAP must be in Run-Test-Idle state with the target device selected.
+// returns nil if SPI signals can't be used or nothing responds
+func (J *Jtag) openSpiFlash(bsdl *Bsdl, ports SpiPorts) *SpiFlash {
+	if reason := ports.check(bsdl); reason != "" {
+		fmt.Fprintf(J.out, "can't use SPI signals: %s\n", reason)
+		return nil
+	}
+
+	pins := J.newBoundaryPins(bsdl)
+	if pins == nil {
+		return nil
+	}
+	f := &SpiFlash{pins: pins, ports: ports}
+	f.pins.set(ports.CS, StateHigh)
+	f.pins.set(ports.SCK, StateLow)
+	f.pins.release(ports.MISO)
+	f.pins.update()
+
+	id := f.readId()
+	fmt.Fprintf(J.out, "JEDEC ID: %02x %02x %02x\n", id[0], id[1], id[2])
+	if (id[0] == 0x00 || id[0] == 0xff) && id[1] == id[0] && id[2] == id[0] {
+		fmt.Fprintln(J.out, "no flash responding, check SPI signals mapping")
+		return nil
+	}
+	return f
+}
+
+// Access SPI flash attached to the device pins, bit-banging it through EXTEST.
+// op -- "read" saves length bytes at addr to path, "erase" erases sectors
+// covering length bytes at addr, "program" writes contents of path at addr
+// (which must be erased) and reads it back
+// returns true on success
+func (J *Jtag) spiFlash(bsdl *Bsdl, ports SpiPorts, op string, addr uint32, length uint32, path string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "SPI flash %s...\n", op)
+	defer fmt.Fprintln(J.out, "================================")
+
+	var data []byte
+	switch op {
+	case "read", "erase":
+		if length == 0 {
+			fmt.Fprintln(J.out, "nothing to do, give length with -flash-len")
+			return false
+		}
+	case "program":
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			fmt.Fprintf(J.out, "can't read %s: %v\n", path, err)
+			return false
+		}
+		length = uint32(len(data))
+	default:
+		fmt.Fprintf(J.out, "invalid SPI flash operation '%s'\n", op)
+		return false
+	}
+	if op != "erase" && len(path) == 0 {
+		fmt.Fprintln(J.out, "file is required, give it with -flash-file")
+		return false
+	}
+
+	if J.prepareBoundary(bsdl) == 0 {
+		return false
+	}
+	f := J.openSpiFlash(bsdl, ports)
+	// Reset TAP to Run-Test-Idle, pins are given back to the device
+	defer J.setTapState(TAP_RESET)
+	if f == nil {
+		return false
+	}
+
+	switch op {
+	case "read":
+		if err := os.WriteFile(path, f.read(addr, int(length)), 0644); err != nil {
+			fmt.Fprintf(J.out, "can't write %s: %v\n", path, err)
+			return false
+		}
+		fmt.Fprintf(J.out, "read %d bytes at 0x%06x to %s\n", length, addr, path)
+	case "erase":
+		start := addr &^ (SPI_SECTOR_SIZE - 1)
+		for a := start; a < addr+length; a += SPI_SECTOR_SIZE {
+			if J.VERBOSE {
+				fmt.Fprintf(J.out, "erasing sector at 0x%06x\n", a)
+			}
+			if !f.eraseSector(a) {
+				fmt.Fprintf(J.out, "erase of sector at 0x%06x timed out\n", a)
+				return false
+			}
+		}
+		fmt.Fprintf(J.out, "erased 0x%06x-0x%06x\n", start, (addr+length+SPI_SECTOR_SIZE-1)&^(SPI_SECTOR_SIZE-1))
+	case "program":
+		for off := uint32(0); off < length; {
+			a := addr + off
+			n := SPI_PAGE_SIZE - a%SPI_PAGE_SIZE
+			if n > length-off {
+				n = length - off
+			}
+			if !f.programPage(a, data[off:off+n]) {
+				fmt.Fprintf(J.out, "program at 0x%06x timed out\n", a)
+				return false
+			}
+			off += n
+		}
+		if !bytes.Equal(f.read(addr, int(length)), data) {
+			fmt.Fprintln(J.out, "verify failed, was the flash erased?")
+			return false
+		}
+		fmt.Fprintf(J.out, "programmed and verified %d bytes at 0x%06x\n", length, addr)
+	}
+	return true
+}
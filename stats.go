@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Counters collected while running a command, printed with -stats
+type ScanStats struct {
+	// pin assignments tried by scan commands
+	Permutations uint64
+	TckCycles    uint64
+	// TDO reads repeated because samples disagreed
+	TdoResamples uint64
+	// operations retried after re-syncing the TAP
+	ShiftRetries uint64
+	// TCK cycles longer than MAX_GAP and the longest one
+	TimingGaps uint64
+	LongestGap time.Duration
+
+	start      time.Time
+	phase      string
+	phaseStart time.Time
+	phaseOrder []string
+	phases     map[string]time.Duration
+
+	// permutation being timed and times of the ones done
+	permStart   time.Time
+	permPins    string
+	permPinouts int
+	permTimes   []permutationTime
+}
+
+func (s *ScanStats) begin(phase string) {
+	s.start = time.Now()
+	s.phases = make(map[string]time.Duration)
+	s.phaseOrder = []string{}
+	s.permStart = time.Time{}
+	s.permTimes = nil
+	s.setPhase(phase)
+}
+
+// Account time spent so far to the current phase and switch to the next one.
+func (s *ScanStats) setPhase(phase string) {
+	now := time.Now()
+	if s.phases == nil {
+		s.start = now
+		s.phases = make(map[string]time.Duration)
+	}
+	if s.phase != "" {
+		s.phases[s.phase] += now.Sub(s.phaseStart)
+	}
+	if _, ok := s.phases[phase]; !ok {
+		s.phases[phase] = 0
+		s.phaseOrder = append(s.phaseOrder, phase)
+	}
+	s.phase = phase
+	s.phaseStart = now
+}
+
+// Driver wrapper counting calls of every kind
+type JtagPinDriverCounter struct {
+	drv JtagPinDriver
+
+	Writes  uint64
+	Reads   uint64
+	Outputs uint64
+	Inputs  uint64
+	Pulls   uint64
+}
+
+func (d *JtagPinDriverCounter) initDriver() {
+	d.drv.initDriver()
+}
+
+func (d *JtagPinDriverCounter) closeDriver() {
+	d.drv.closeDriver()
+}
+
+func (d *JtagPinDriverCounter) pinWrite(pin JtagPin, state JtagPinState) {
+	d.Writes += 1
+	d.drv.pinWrite(pin, state)
+}
+
+func (d *JtagPinDriverCounter) pinRead(pin JtagPin) JtagPinState {
+	d.Reads += 1
+	return d.drv.pinRead(pin)
+}
+
+func (d *JtagPinDriverCounter) pinOutput(pin JtagPin) {
+	d.Outputs += 1
+	d.drv.pinOutput(pin)
+}
+
+func (d *JtagPinDriverCounter) pinInput(pin JtagPin) {
+	d.Inputs += 1
+	d.drv.pinInput(pin)
+}
+
+func (d *JtagPinDriverCounter) pinPullUp(pin JtagPin) {
+	d.Pulls += 1
+	d.drv.pinPullUp(pin)
+}
+
+func (d *JtagPinDriverCounter) pinPullOff(pin JtagPin) {
+	d.Pulls += 1
+	d.drv.pinPullOff(pin)
+}
+
+func (J *Jtag) printStats() {
+	s := &J.stats
+	s.setPhase(s.phase)
+	elapsed := time.Since(s.start)
+
+	fmt.Fprintln(J.out, "statistics:")
+	fmt.Fprintf(J.out, "  elapsed: %v\n", elapsed.Round(time.Millisecond))
+	for _, phase := range s.phaseOrder {
+		fmt.Fprintf(J.out, "    %s: %v\n", phase, s.phases[phase].Round(time.Millisecond))
+	}
+	if s.Permutations != 0 {
+		fmt.Fprintf(J.out, "  permutations tested: %d\n", s.Permutations)
+	}
+	fmt.Fprintf(J.out, "  TCK cycles: %d\n", s.TckCycles)
+	if s.TdoResamples != 0 {
+		fmt.Fprintf(J.out, "  TDO resamples: %d\n", s.TdoResamples)
+	}
+	if s.ShiftRetries != 0 {
+		fmt.Fprintf(J.out, "  shift retries: %d\n", s.ShiftRetries)
+	}
+	if s.TimingGaps != 0 {
+		fmt.Fprintf(J.out, "  timing gaps: %d, longest %v\n", s.TimingGaps, s.LongestGap.Round(time.Microsecond))
+	}
+	if elapsed > 0 {
+		fmt.Fprintf(J.out, "  effective TCK frequency: %.1f kHz\n",
+			float64(s.TckCycles)/elapsed.Seconds()/1000.0)
+	}
+	if c := J.counter; c != nil {
+		fmt.Fprintf(J.out, "  driver calls: write %d, read %d, output %d, input %d, pull %d\n",
+			c.Writes, c.Reads, c.Outputs, c.Inputs, c.Pulls)
+	}
+	J.printToggles()
+}
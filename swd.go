@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+)
+
+// SWJ-DP switching sequences, sent LSB first after a line reset
+const (
+	SWJ_JTAG_TO_SWD = 0xe79e
+	SWJ_SWD_TO_JTAG = 0xe73c
+)
+
+// SWD request reading DP register 0 (DPIDR): start, DP, read, A[3:2]=0,
+// parity, stop, park
+const SWD_READ_DPIDR = 0xa5
+
+const SWD_ACK_OK = 0x1
+
+// Clock bits out on SWDIO (TMS), TCK is SWCLK: the target samples SWDIO on
+// the rising edge.
+func (J *Jtag) swdWrite(bits []byte) {
+	for _, b := range bits {
+		if b == '1' {
+			J.drv.pinWrite(J.TMS, StateHigh)
+		} else {
+			J.drv.pinWrite(J.TMS, StateLow)
+		}
+		J.pinWriteDelay(J.TCK, StateHigh)
+		J.pinWriteDelay(J.TCK, StateLow)
+	}
+	J.stats.TckCycles += uint64(len(bits))
+}
+
+// Clock n bits in from SWDIO, which must be an input.
+func (J *Jtag) swdRead(n int) []byte {
+	ret := []byte{}
+	for i := 0; i < n; i += 1 {
+		if J.drv.pinRead(J.TMS) == StateHigh {
+			ret = append(ret, '1')
+		} else {
+			ret = append(ret, '0')
+		}
+		J.pinWriteDelay(J.TCK, StateHigh)
+		J.pinWriteDelay(J.TCK, StateLow)
+	}
+	J.stats.TckCycles += uint64(n)
+	return ret
+}
+
+// Switch SWJ-DP to SWD and read DPIDR over TCK (SWCLK) and TMS (SWDIO),
+// then switch back to JTAG and reset the TAP.
+// returns DPIDR and true if the target answered with OK and correct parity
+func (J *Jtag) readSwdIdcode() (uint32, bool) {
+	defer func() {
+		J.swdWrite(ones(56))
+		J.swdWrite(irBits(SWJ_SWD_TO_JTAG, 16))
+		J.swdWrite(ones(8))
+		J.setTapState(TAP_RESET)
+	}()
+
+	J.swdWrite(ones(56))
+	J.swdWrite(irBits(SWJ_JTAG_TO_SWD, 16))
+	J.swdWrite(ones(56))
+	J.swdWrite(irBits(0, 8))
+	J.swdWrite(irBits(SWD_READ_DPIDR, 8))
+
+	J.drv.pinInput(J.TMS)
+	// turnaround, ACK, data, parity, turnaround
+	J.swdRead(1)
+	ack := J.swdRead(3)
+	data := J.swdRead(32)
+	parity := J.swdRead(1)
+	J.swdRead(1)
+	J.drv.pinOutput(J.TMS)
+	J.swdWrite(irBits(0, 8))
+
+	if bitsToUint32(string(ack)) != SWD_ACK_OK {
+		return 0, false
+	}
+	idcode := bitsToUint32(string(data))
+	cnt := 0
+	for _, b := range data {
+		if b == '1' {
+			cnt += 1
+		}
+	}
+	if byte('0'+cnt%2) != parity[0] {
+		return 0, false
+	}
+	return idcode, true
+}
+
+// Decode DPIDR: designer as a JEP106 code in bits 11:1 like IDCODEs have
+// it, DP architecture version, MINDP, part number and revision.
+func describeDpidr(dpidr uint32) string {
+	designer := (dpidr & 0xffe) >> 1
+	bank := (dpidr & 0xf00) >> 8
+	id := (dpidr & 0xfe) >> 1
+	version := (dpidr & 0xf000) >> 12
+	mindp := (dpidr >> 16) & 1
+	part := (dpidr & 0xff00000) >> 20
+	rev := (dpidr & 0xf0000000) >> 28
+	ret := fmt.Sprintf("0x%08x (designer: 0x%3.3x (%s), part: 0x%2.2x, rev: 0x%1.1x, DPv%d",
+		dpidr, designer, Jep106Manufacturer(bank, id), part, rev, version)
+	if mindp == 1 {
+		ret += ", MINDP"
+	}
+	if dpidr&1 != 1 {
+		ret += ", bit 0 not set"
+	}
+	return ret + ")"
+}
+
+// Check whether an ARM SWJ-DP found over JTAG also answers SWD on the same
+// TCK and TMS pins, as SWCLK and SWDIO, and record its DPIDR in the pinout:
+// downstream tools may prefer SWD, which needs two wires only.
+func (J *Jtag) checkSwd(found *PinoutResult) {
+	arm := false
+	for _, idcode := range found.Idcodes {
+		arm = arm || isArmDp(idcode)
+	}
+	if !arm {
+		return
+	}
+	idcode, ok := J.readSwdIdcode()
+	if !ok {
+		fmt.Fprintln(J.out, "     SWD: no answer, JTAG-DP only")
+		return
+	}
+	found.SwdIdcode = idcode
+	fmt.Fprintf(J.out, "     SWD: SWCLK:%s SWDIO:%s, DPIDR %s\n", found.TCK, found.TMS, describeDpidr(idcode))
+}
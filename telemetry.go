@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A permutation taking this many times the median of a scan is an outlier,
+// if it also took longer than PERMUTATION_OUTLIER_MIN
+const PERMUTATION_OUTLIER_FACTOR = 10
+const PERMUTATION_OUTLIER_MIN = 5 * time.Millisecond
+
+// Permutations timed before the median means anything
+const PERMUTATION_OUTLIER_SAMPLES = 5
+
+// Permutation which took much longer than the others of the scan: the
+// driver stalled, the target browned out or the adapter glitched, which may
+// invalidate results of the permutations around it
+type TimingOutlier struct {
+	// position in the order permutations were tested, from 1
+	Index   uint64        `json:"index"`
+	Pins    string        `json:"pins,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+	Median  time.Duration `json:"median"`
+}
+
+type permutationTime struct {
+	index   uint64
+	pins    string
+	elapsed time.Duration
+}
+
+// Account time of the permutation tested so far and start timing the next
+// one, called by pacePermutation. Permutations finding a pinout take longer
+// for verification and aren't timed.
+// pins -- pins of the next permutation
+// next -- false at the end of the command, when there is no next one
+func (J *Jtag) timePermutation(pins string, next bool) {
+	s := &J.stats
+	now := time.Now()
+	if !s.permStart.IsZero() && len(J.results.Pinouts) == s.permPinouts {
+		s.permTimes = append(s.permTimes, permutationTime{index: s.Permutations, pins: s.permPins, elapsed: now.Sub(s.permStart)})
+	}
+	s.permStart = time.Time{}
+	if next {
+		s.permStart = now
+		s.permPins = strings.TrimSpace(pins)
+		s.permPinouts = len(J.results.Pinouts)
+	}
+}
+
+// Print and record permutations which took PERMUTATION_OUTLIER_FACTOR times
+// the median time or more.
+func (J *Jtag) warnTimingOutliers() {
+	J.timePermutation("", false)
+	times := J.stats.permTimes
+	if len(times) < PERMUTATION_OUTLIER_SAMPLES {
+		return
+	}
+	sorted := []time.Duration{}
+	for _, t := range times {
+		sorted = append(sorted, t.elapsed)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	if median == 0 {
+		return
+	}
+
+	for _, t := range times {
+		if t.elapsed < PERMUTATION_OUTLIER_MIN || t.elapsed < PERMUTATION_OUTLIER_FACTOR*median {
+			continue
+		}
+		o := TimingOutlier{Index: t.index, Pins: t.pins, Elapsed: t.elapsed, Median: median}
+		J.results.TimingOutliers = append(J.results.TimingOutliers, o)
+		fmt.Fprintf(J.out, "WARNING: permutation #%d", o.Index)
+		if o.Pins != "" {
+			fmt.Fprintf(J.out, " (%s)", o.Pins)
+		}
+		fmt.Fprintf(J.out, " took %v, %dx the median %v: driver stall, target brown-out or adapter glitch, recheck results of permutations around it\n",
+			o.Elapsed.Round(time.Microsecond), o.Elapsed/median, median.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What the pinout verification saw at one TCK delay
+type timingVerification struct {
+	Idcodes []uint32
+	// pattern shifted back through BYPASS and first IR bits captured (10
+	// for a compliant TAP), empty without TDI
+	Bypass    string
+	IrCapture string
+}
+
+// Verify the current pins at TCK delay d: read IDCODEs of devCnt devices,
+// if TDI is known also shift pattern through BYPASS and capture IR, loading
+// BYPASS into all devices.
+func (J *Jtag) verifyAtDelay(d uint, pattern string, devCnt int) timingVerification {
+	J.DELAY_TCK = d
+	v := timingVerification{Idcodes: J.getIdcodes(devCnt)}
+	if J.TDI != J.IGNOREPIN {
+		_, v.Bypass = J.bypassRoundtrip(pattern)
+		J.setTapState(TAP_RESET)
+		v.IrCapture = string(J.sendInstruction(ones(J.maxIrChainLen()))[:2])
+		J.setTapState(TAP_RESET)
+	}
+	return v
+}
+
+// returns what differs from the reference verification
+func (v timingVerification) diff(ref timingVerification) []string {
+	ret := []string{}
+	if !equalIdcodes(v.Idcodes, ref.Idcodes) {
+		ret = append(ret, fmt.Sprintf("IDCODEs %08x", v.Idcodes))
+	}
+	if v.Bypass != ref.Bypass {
+		ret = append(ret, "BYPASS pattern corrupted")
+	}
+	if v.IrCapture != ref.IrCapture {
+		ret = append(ret, fmt.Sprintf("IR captured %s", v.IrCapture))
+	}
+	return ret
+}
+
+// Verify the found pinout again at half and double the scan speed: results
+// differing from the ones at the scan speed mean the pinout works by luck of
+// timing, a strong hint of marginal wiring (long leads, missing ground,
+// ringing on TCK). The result is recorded in the pinout. TCK delay is
+// restored afterwards.
+func (J *Jtag) checkTimingMargin(found *PinoutResult, pattern string) {
+	if !J.TIMING_CHECK {
+		return
+	}
+	savedDelay := J.DELAY_TCK
+	defer func() { J.DELAY_TCK = savedDelay }()
+
+	devCnt := len(found.Idcodes)
+	if devCnt == 0 {
+		devCnt = 1
+	}
+	ref := J.verifyAtDelay(savedDelay, pattern, devCnt)
+
+	speeds := []struct {
+		name  string
+		delay uint
+	}{{"half speed", savedDelay * 2}}
+	if savedDelay == 0 {
+		speeds[0].delay = 1
+	}
+	if savedDelay > 0 && savedDelay/2 >= J.minDelayTck {
+		speeds = append(speeds, struct {
+			name  string
+			delay uint
+		}{"double speed", savedDelay / 2})
+	}
+
+	names := []string{}
+	failed := []string{}
+	for _, s := range speeds {
+		names = append(names, s.name)
+		if diffs := J.verifyAtDelay(s.delay, pattern, devCnt).diff(ref); len(diffs) != 0 {
+			failed = append(failed, fmt.Sprintf("%s (%s): %s", s.name, describeTckDelay(s.delay), strings.Join(diffs, ", ")))
+		}
+	}
+	if len(failed) == 0 {
+		fmt.Fprintf(J.out, "     timing: same results at %s\n", strings.Join(names, " and "))
+		return
+	}
+	found.TimingSensitive = true
+	fmt.Fprintln(J.out, "     WARNING: timing-sensitive, check wiring for long leads, missing ground or ringing on TCK:")
+	for _, f := range failed {
+		fmt.Fprintf(J.out, "        %s\n", f)
+	}
+}
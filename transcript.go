@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Record of a command run, one JSON line of the transcript file
+type TranscriptEntry struct {
+	Time time.Time `json:"time"`
+	Host string    `json:"host,omitempty"`
+	// arguments as given and values of all flags, defaults included
+	Args  []string          `json:"args"`
+	Flags map[string]string `json:"flags"`
+	// driver actually used and TCK delay at the end of the command
+	Driver   string     `json:"driver"`
+	DelayTck uint       `json:"delay_tck"`
+	Results  ScanResult `json:"results"`
+	Passed   bool       `json:"passed"`
+}
+
+// Append record of the command just run to the transcript at path.
+func (J *Jtag) appendTranscript(path string, args []string, passed bool) {
+	e := TranscriptEntry{
+		Time:     time.Now(),
+		Args:     args,
+		Flags:    map[string]string{},
+		Driver:   J.drvOpt.Name,
+		DelayTck: J.DELAY_TCK,
+		Results:  J.results,
+		Passed:   passed,
+	}
+	e.Host, _ = os.Hostname()
+	flag.VisitAll(func(f *flag.Flag) { e.Flags[f.Name] = f.Value.String() })
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		panic(err)
+	}
+}
+
+func loadTranscript(path string) []TranscriptEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	ret := []TranscriptEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		e := TranscriptEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			panic(err)
+		}
+		ret = append(ret, e)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Run the command of transcript entry index again with the same arguments
+// and compare its results with the recorded ones.
+// index -- entry to replay, negative counts from the end
+// returns true if the results are the same
+func replayTranscript(path string, index int) bool {
+	entries := loadTranscript(path)
+	if index < 0 {
+		index += len(entries)
+	}
+	if index < 0 || index >= len(entries) {
+		fmt.Printf("no entry %d in transcript of %d entries\n", index, len(entries))
+		return false
+	}
+	e := entries[index]
+	fmt.Printf("replaying entry %d recorded %s on %s, driver %s, delay %d us:\n",
+		index, e.Time.Format(time.RFC3339), e.Host, e.Driver, e.DelayTck)
+	fmt.Println(e.Args)
+
+	self, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	dir, err := os.MkdirTemp("", "jtagenum-replay")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "results.json")
+
+	// flags given last take precedence: save results aside, don't record
+	// the replay
+	args := append(append([]string{}, e.Args...), "-output", output, "-transcript", "")
+	cmd := exec.Command(self, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if _, statErr := os.Stat(output); statErr != nil {
+		fmt.Printf("replay produced no results: %v\n", err)
+		return false
+	}
+	return diffResults(e.Results, loadResults(output))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Fixture pins used in triggered mode, IGNOREPIN if not used
+type TriggerPins struct {
+	Start JtagPin
+	Pass  JtagPin
+	Fail  JtagPin
+}
+
+// Wait until the start pin reaches the requested state, it must stay in this
+// state for a while to filter out switch bouncing.
+func (J *Jtag) waitPinState(pin JtagPin, state JtagPinState) {
+	stable := 0
+	for stable < 5 {
+		if J.drv.pinRead(pin) == state {
+			stable += 1
+		} else {
+			stable = 0
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (J *Jtag) setFixturePin(pin JtagPin, state JtagPinState) {
+	if pin != J.IGNOREPIN {
+		J.drv.pinWrite(pin, state)
+	}
+}
+
+// Production-line mode: wait for the start input to be asserted (high), run
+// the test, drive the pass or fail output high according to the result and
+// wait for the start input to be released. Loops forever.
+func (J *Jtag) runTriggered(tp TriggerPins, test func() bool) {
+	J.drv.pinInput(tp.Start)
+	for _, pin := range []JtagPin{tp.Pass, tp.Fail} {
+		if pin != J.IGNOREPIN {
+			J.drv.pinOutput(pin)
+			J.drv.pinWrite(pin, StateLow)
+		}
+	}
+
+	for run := 1; ; run += 1 {
+		fmt.Fprintln(J.out, "waiting for start signal...")
+		J.waitPinState(tp.Start, StateHigh)
+
+		J.setFixturePin(tp.Pass, StateLow)
+		J.setFixturePin(tp.Fail, StateLow)
+
+		if test() {
+			fmt.Fprintf(J.out, "run #%d: PASS\n", run)
+			J.setFixturePin(tp.Pass, StateHigh)
+		} else {
+			fmt.Fprintf(J.out, "run #%d: FAIL\n", run)
+			J.setFixturePin(tp.Fail, StateHigh)
+		}
+
+		J.waitPinState(tp.Start, StateLow)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Effect of pulling a candidate nTRST pin low, stronger effects rank higher
+type TrstEffect int
+
+const (
+	TrstNoEffect TrstEffect = iota
+	TrstIdcodeChanged
+	TrstDevCntChanged
+	TrstChainGone
+	// the target console (-console) told it rebooted or crashed, the pin
+	// likely resets the whole target, nRST rather than nTRST
+	TrstTargetRebooted
+)
+
+func (e TrstEffect) String() string {
+	switch e {
+	case TrstIdcodeChanged:
+		return "IDCODE changed"
+	case TrstDevCntChanged:
+		return "device count changed"
+	case TrstChainGone:
+		return "chain disappeared"
+	case TrstTargetRebooted:
+		return "target rebooted"
+	}
+	return "no effect"
+}
+
+// What the chain looks like on currently selected pins
+type ChainState struct {
+	// -1 if unknown, i.e. TDI is not known
+	DevCnt  int
+	Idcodes []uint32
+}
+
+type TrstCandidate struct {
+	Pin    JtagPin
+	Effect TrstEffect
+}
+
+func (J *Jtag) observeChain() ChainState {
+	if J.TDI == J.IGNOREPIN {
+		// the end of the chain can't be detected without TDI, so look at the
+		// first device only
+		return ChainState{DevCnt: -1, Idcodes: J.getIdcodes(1)}
+	}
+	devCnt := J.detectDevices()
+	return ChainState{DevCnt: devCnt, Idcodes: chainIdcodes(J.getIdcodes(devCnt))}
+}
+
+func classifyTrst(before, after ChainState) TrstEffect {
+	if after.DevCnt == 0 || len(validIdcodes(after.Idcodes)) == 0 {
+		if before.DevCnt != 0 && len(validIdcodes(before.Idcodes)) != 0 {
+			return TrstChainGone
+		}
+	}
+	if after.DevCnt != before.DevCnt {
+		return TrstDevCntChanged
+	}
+	if !equalIdcodes(after.Idcodes, before.Idcodes) {
+		return TrstIdcodeChanged
+	}
+	return TrstNoEffect
+}
+
+// Try to determine if the TRST# pin is being used on the target: hold every
+// spare pin low in turn and see what happens to the chain and, with
+// -console, whether the target reboots or crashes meanwhile.
+// returns pins having an effect, the strongest effects first
+func (J *Jtag) probeTrst() []TrstCandidate {
+	before := J.observeChain()
+	defer J.consoleTesting(J.results.Command + J.formatPins())
+
+	ret := []TrstCandidate{}
+	for _, trst := range J.AllPins {
+		if trst == J.TCK || trst == J.TMS || trst == J.TDO || trst == J.TDI || J.OBSERVE_ONLY[trst] {
+			continue
+		}
+
+		J.TRST = trst
+		// events so far go to what was tested before
+		J.consoleTesting(J.results.Command + J.formatPins())
+
+		// do reset
+		J.drv.pinWrite(J.TRST, StateLow)
+		// Give target time to react
+		delay(J.DELAY_RESET)
+
+		effect := classifyTrst(before, J.observeChain())
+
+		// Bring the current pin HIGH when done
+		J.drv.pinWrite(J.TRST, StateHigh)
+		J.settleTap()
+
+		if len(J.consoleTesting(J.results.Command+J.formatPins())) != 0 {
+			effect = TrstTargetRebooted
+		}
+		if effect != TrstNoEffect {
+			ret = append(ret, TrstCandidate{trst, effect})
+		}
+	}
+	J.TRST = J.IGNOREPIN
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Effect > ret[j].Effect })
+	return ret
+}
+
+// Describe candidates as "pin5 (chain disappeared), pin7 (IDCODE changed)"
+// and record them as possible nTRST of the found pinout.
+func (J *Jtag) describeTrst(candidates []TrstCandidate, found *PinoutResult) string {
+	ret := []string{}
+	for _, c := range candidates {
+		name := J.PinNames[c.Pin]
+		found.PossibleTRST = append(found.PossibleTRST, name)
+		if found.TrstEffects == nil {
+			found.TrstEffects = make(map[string]string)
+		}
+		found.TrstEffects[name] = c.Effect.String()
+		ret = append(ret, fmt.Sprintf("%s (%s)", name, c.Effect))
+	}
+	if len(ret) == 0 {
+		return "none"
+	}
+	return strings.Join(ret, ", ")
+}
+
+// Index of the most trustworthy pinout: confirmed TDI first, then higher
+// IDCODE scores.
+// returns -1 if there are no pinouts
+func bestPinout(pinouts []PinoutResult) int {
+	rank := func(p PinoutResult) int {
+		r := 0
+		for _, score := range p.IdcodeScores {
+			r += score
+		}
+		if p.TDI != "" {
+			r += 1000
+		}
+		return r
+	}
+	best := -1
+	for i, p := range pinouts {
+		if best < 0 || rank(p) > rank(pinouts[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// With TRST_LAST, probe nTRST once at the end of a scan, against the best
+// ranked pinout only, rather than resetting the target for every candidate.
+func (J *Jtag) probeTrstDeferred() {
+	if !J.TRST_LAST || !J.ALLOW_RESET {
+		return
+	}
+	i := bestPinout(J.results.Pinouts)
+	if i < 0 {
+		return
+	}
+	p := &J.results.Pinouts[i]
+	byName := J.pinsByName()
+	J.TCK = byName[p.TCK]
+	J.TMS = byName[p.TMS]
+	J.TDO = byName[p.TDO]
+	J.TDI = J.IGNOREPIN
+	if p.TDI != "" {
+		J.TDI = byName[p.TDI]
+	}
+	J.TRST = J.IGNOREPIN
+	J.initPins()
+	fmt.Fprintf(J.out, "best pinout %s, possible nTRST: %s\n", p, J.describeTrst(J.probeTrst(), p))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Screen is redrawn at most this often
+const TUI_REFRESH = 100 * time.Millisecond
+
+// Live terminal screen shown with -tui instead of scrolling output: progress,
+// pins of the permutation being tried, pinouts found so far and the tail of
+// the log. It is redrawn by the thread doing shifts, when output is written
+// and when scans move to the next permutation; mu only guards against stop
+// called on SIGINT meanwhile.
+type tuiScreen struct {
+	J   *Jtag
+	dst io.Writer
+	cmd string
+	// log lines and unterminated tail
+	lines   []string
+	partial string
+	// permutations the running scan will test, 0 if unknown
+	total  int
+	drawn  time.Time
+	height int
+	// set once the terminal is restored, output goes straight to dst then
+	mu      sync.Mutex
+	stopped bool
+}
+
+// Switch output of J to a live screen on its current output.
+func (J *Jtag) startTui(cmd string) *tuiScreen {
+	t := &tuiScreen{J: J, dst: J.out, cmd: cmd, height: 24}
+	if v, err := strconv.Atoi(os.Getenv("LINES")); err == nil && v > 10 {
+		t.height = v
+	}
+	J.out = t
+	J.tui = t
+	// alternate screen, hidden cursor
+	fmt.Fprint(t.dst, "\x1b[?1049h\x1b[?25l")
+	t.redraw(true)
+	return t
+}
+
+// Run cmd on a live screen, see runCommand. The terminal is restored
+// whichever way the command ends: on return, on panic before it goes on, and
+// on SIGINT, which exits with status 130 as an interrupted shell command does.
+func (J *Jtag) runCommandTui(cmd string, o CommandOptions) bool {
+	t := J.startTui(cmd)
+	defer t.stop()
+
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, os.Interrupt)
+	defer func() {
+		signal.Stop(sig)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-sig:
+			t.stop()
+			fmt.Fprintln(os.Stderr, "interrupted")
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return J.runCommand(cmd, o)
+}
+
+// Leave the live screen and print the whole log, so it stays in the
+// terminal scrollback. Does nothing once done.
+func (t *tuiScreen) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	t.stopped = true
+	fmt.Fprint(t.dst, "\x1b[?25h\x1b[?1049l")
+	for _, line := range t.lines {
+		fmt.Fprintln(t.dst, line)
+	}
+	if t.partial != "" {
+		fmt.Fprintln(t.dst, t.partial)
+	}
+	t.J.out = t.dst
+	t.J.tui = nil
+}
+
+func (t *tuiScreen) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return t.dst.Write(p)
+	}
+	s := t.partial + string(p)
+	parts := strings.Split(s, "\n")
+	t.lines = append(t.lines, parts[:len(parts)-1]...)
+	t.partial = parts[len(parts)-1]
+	t.draw(false)
+	return len(p), nil
+}
+
+// Redraw the screen, unless it was done less than TUI_REFRESH ago.
+// force -- redraw anyway
+func (t *tuiScreen) redraw(force bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.stopped {
+		t.draw(force)
+	}
+}
+
+func (t *tuiScreen) draw(force bool) {
+	if !force && time.Since(t.drawn) < TUI_REFRESH {
+		return
+	}
+	t.drawn = time.Now()
+	J := t.J
+	s := &J.stats
+
+	b := &strings.Builder{}
+	// home, clear
+	b.WriteString("\x1b[H\x1b[2J")
+	elapsed := time.Duration(0)
+	if !s.start.IsZero() {
+		elapsed = time.Since(s.start).Round(time.Second)
+	}
+	fmt.Fprintf(b, "\x1b[1m== %s ==\x1b[0m  elapsed %v, phase: %s\n", t.cmd, elapsed, s.phase)
+	if t.total != 0 {
+		done := int(s.Permutations)
+		width := 40
+		filled := done * width / t.total
+		if filled > width {
+			filled = width
+		}
+		bar := strings.Repeat("#", filled) + strings.Repeat(".", width-filled)
+		fmt.Fprintf(b, "[%s] %d/%d permutations\n", bar, done, t.total)
+	} else {
+		fmt.Fprintf(b, "%d permutations\n", s.Permutations)
+	}
+
+	b.WriteString("\x1b[1m-- current --\x1b[0m\n")
+	for _, p := range []struct {
+		name string
+		pin  JtagPin
+	}{{"TCK", J.TCK}, {"TMS", J.TMS}, {"TDO", J.TDO}, {"TDI", J.TDI}} {
+		if name, ok := J.PinNames[p.pin]; ok && p.pin != J.IGNOREPIN {
+			fmt.Fprintf(b, " %s:%s", p.name, name)
+		}
+	}
+	b.WriteString("\n")
+
+	b.WriteString("\x1b[1m-- found --\x1b[0m\n")
+	used := 5
+	for i, p := range J.results.Pinouts {
+		if i == 5 {
+			fmt.Fprintf(b, " ... %d more\n", len(J.results.Pinouts)-i)
+			used += 1
+			break
+		}
+		fmt.Fprintf(b, " TCK:%s TMS:%s TDO:%s", p.TCK, p.TMS, p.TDO)
+		if p.TDI != "" {
+			fmt.Fprintf(b, " TDI:%s", p.TDI)
+		}
+		if len(p.Idcodes) != 0 {
+			fmt.Fprintf(b, " %08x", p.Idcodes)
+		}
+		b.WriteString("\n")
+		used += 1
+	}
+
+	b.WriteString("\x1b[1m-- log --\x1b[0m\n")
+	used += 1
+	lines := t.lines
+	if t.partial != "" {
+		lines = append(lines[:len(lines):len(lines)], t.partial)
+	}
+	if n := t.height - used - 1; len(lines) > n && n > 0 {
+		lines = lines[len(lines)-n:]
+	}
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	io.WriteString(t.dst, b.String())
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Level changes a pin must make while listened to, to be taken for a UART
+// TX: a few characters
+const UART_MIN_EDGES = 20
+
+// Level changes on TX after a carriage return on RX taken for a reaction
+const UART_PROBE_MIN_EDGES = 4
+
+// Time TX is watched before and after sending a carriage return to RX
+const UART_PROBE_WAIT = 300 * time.Millisecond
+
+// Baud rate the TX probe sends at if no TX was heard to estimate it from
+const UART_PROBE_BAUD = 115200
+
+// Measured baud rates within that ratio of a standard one are taken for it
+const UART_BAUD_TOLERANCE = 0.12
+
+var uartBaudRates = []uint{
+	300, 600, 1200, 2400, 4800, 9600, 14400, 19200, 38400, 57600, 74880,
+	115200, 230400, 250000, 460800, 500000, 921600, 1000000, 1500000,
+}
+
+// What listening to a pin saw
+type uartActivity struct {
+	samples, high int
+	// level changes and time between consecutive ones
+	edges  int
+	widths []time.Duration
+	// average time a sample took
+	sampleTime time.Duration
+}
+
+// Sample the pin as fast as the driver goes for duration, recording level
+// changes. The pin must be an input.
+func (J *Jtag) listenUart(pin JtagPin, duration time.Duration) uartActivity {
+	a := uartActivity{}
+	start := time.Now()
+	prev := J.drv.pinRead(pin)
+	last := start
+	for time.Since(start) < duration {
+		v := J.drv.pinRead(pin)
+		a.samples += 1
+		if v == StateHigh {
+			a.high += 1
+		}
+		if v == prev {
+			continue
+		}
+		now := time.Now()
+		if a.edges != 0 {
+			a.widths = append(a.widths, now.Sub(last))
+		}
+		a.edges += 1
+		last, prev = now, v
+	}
+	if a.samples != 0 {
+		a.sampleTime = time.Since(start) / time.Duration(a.samples)
+	}
+	return a
+}
+
+// UART lines idle high, start bits pull them low
+func (a uartActivity) idleHigh() bool {
+	return a.high*2 > a.samples
+}
+
+// returns pulse widths, shortest first
+func (a uartActivity) sortedWidths() []time.Duration {
+	widths := append([]time.Duration{}, a.widths...)
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	return widths
+}
+
+// Clocks make pulses of one width, UART traffic has longer ones for runs of
+// equal bits and idle time between characters. The shortest and longest
+// tenth are left out, sampling jitter and preemption stretch some pulses.
+func (a uartActivity) regular() bool {
+	if len(a.widths) == 0 {
+		return false
+	}
+	widths := a.sortedWidths()
+	return widths[len(widths)*9/10] < widths[len(widths)/10]*3/2
+}
+
+// Estimate baud rate from the shortest pulses, which are single bits in
+// most traffic: the 5th percentile of pulse widths, averaged with the
+// pulses close to it, snapped to a standard rate if one is near.
+// returns baud rate, 0 if bits are too short for the sampling rate, and
+// whether it is a standard rate
+func (a uartActivity) baud() (uint, bool) {
+	if len(a.widths) == 0 {
+		return 0, false
+	}
+	widths := a.sortedWidths()
+	shortest := widths[len(widths)/20]
+	sum, n := time.Duration(0), 0
+	for _, w := range widths {
+		if w < shortest*13/10 {
+			sum += w
+			n += 1
+		}
+	}
+	bit := sum / time.Duration(n)
+	if bit < 2*a.sampleTime || bit == 0 {
+		return 0, false
+	}
+	measured := float64(time.Second) / float64(bit)
+	for _, rate := range uartBaudRates {
+		if r := measured / float64(rate); r > 1-UART_BAUD_TOLERANCE && r < 1+UART_BAUD_TOLERANCE {
+			return rate, true
+		}
+	}
+	return uint(measured), false
+}
+
+// Bit-bang a byte as 8N1 on pin at baud, LSB first. Bits are timed from the
+// start bit, so slow driver calls don't add up.
+func (J *Jtag) uartSend(pin JtagPin, b byte, baud uint) {
+	bit := time.Second / time.Duration(baud)
+	levels := []JtagPinState{StateLow}
+	for i := 0; i < 8; i += 1 {
+		levels = append(levels, JtagPinState((b>>i)&1))
+	}
+	levels = append(levels, StateHigh)
+	start := time.Now()
+	for i, level := range levels {
+		J.drv.pinWrite(pin, level)
+		for time.Since(start) < time.Duration(i+1)*bit {
+		}
+	}
+}
+
+// Send a carriage return on rx, idling high, and watch tx for a reaction: a
+// prompt or an echo makes more level changes than tx made as long before.
+// rx is left an input again.
+// returns true if tx reacted
+func (J *Jtag) probeUartRx(tx, rx JtagPin, baud uint) bool {
+	J.drv.pinOutput(rx)
+	J.drv.pinWrite(rx, StateHigh)
+	defer J.setupPin(rx, SETUP_OBSERVE)
+
+	before := J.listenUart(tx, UART_PROBE_WAIT).edges
+	J.uartSend(rx, '\r', baud)
+	after := J.listenUart(tx, UART_PROBE_WAIT).edges
+	return after >= UART_PROBE_MIN_EDGES && after > 2*before
+}
+
+// Listen to every pin for UART_LISTEN, undriven, for asynchronous serial
+// traffic: a line idling high with enough level changes is taken for the TX
+// of a UART and its baud rate estimated from the shortest pulses. With
+// UART_PROBE, a carriage return is then sent on every other pin, except
+// observe-only ones, to find RX by the reaction of TX. Quiet pins idling
+// high are tried as TX as well, a console may stay silent until it gets a
+// line. Found ports are recorded as protocol "uart".
+func (J *Jtag) scanUart() {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting scan for UART...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	J.TCK, J.TMS, J.TDO, J.TDI, J.TRST = J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN, J.IGNOREPIN
+	J.stats.setPhase("uart")
+	for _, pin := range J.AllPins {
+		J.setupPin(pin, SETUP_OBSERVE)
+	}
+
+	type uartTx struct {
+		pin  JtagPin
+		baud uint
+	}
+	txs := []uartTx{}
+	quiet := []JtagPin{}
+	for _, pin := range J.AllPins {
+		a := J.listenUart(pin, J.UART_LISTEN)
+		switch {
+		case a.edges >= UART_MIN_EDGES && a.regular():
+			fmt.Fprintf(J.out, "%s: %d level changes of the same width, a clock\n", J.pinName(pin), a.edges)
+		case a.edges >= UART_MIN_EDGES && a.idleHigh():
+			baud, standard := a.baud()
+			desc := fmt.Sprintf("idle high, %d level changes", a.edges)
+			switch {
+			case baud == 0:
+				desc += fmt.Sprintf(", bits shorter than sampling resolves (%v per sample)", a.sampleTime)
+			case standard:
+				desc += fmt.Sprintf(", %d baud", baud)
+			default:
+				desc += fmt.Sprintf(", ~%d baud, not a standard rate", baud)
+			}
+			fmt.Fprintf(J.out, "FOUND! uart TX:%s: %s\n", J.pinName(pin), desc)
+			J.results.Protocols = append(J.results.Protocols, ProtocolResult{
+				Protocol: "uart", Pins: map[string]string{"tx": J.pinName(pin)}, Baud: baud, Description: desc,
+			})
+			txs = append(txs, uartTx{pin, baud})
+		case a.edges >= UART_MIN_EDGES:
+			fmt.Fprintf(J.out, "%s: %d level changes idling low, a clock or inverted serial\n", J.pinName(pin), a.edges)
+		case a.edges == 0 && a.high == a.samples:
+			quiet = append(quiet, pin)
+		}
+	}
+	if !J.UART_PROBE {
+		return
+	}
+
+	for _, pin := range quiet {
+		txs = append(txs, uartTx{pin, 0})
+	}
+	for _, tx := range txs {
+		baud := tx.baud
+		if J.UART_BAUD != 0 {
+			baud = J.UART_BAUD
+		} else if baud == 0 {
+			baud = UART_PROBE_BAUD
+		}
+		rxs := []string{}
+		for _, rx := range J.AllPins {
+			if rx == tx.pin || J.OBSERVE_ONLY[rx] {
+				continue
+			}
+			J.stats.Permutations += 1
+			if J.probeUartRx(tx.pin, rx, baud) {
+				rxs = append(rxs, J.pinName(rx))
+			}
+		}
+		if len(rxs) == 0 {
+			continue
+		}
+		desc := fmt.Sprintf("TX reacted to a carriage return at %d baud", baud)
+		fmt.Fprintf(J.out, "FOUND! uart TX:%s RX:%s: %s\n", J.pinName(tx.pin), strings.Join(rxs, " or "), desc)
+		for _, rx := range rxs {
+			J.results.Protocols = append(J.results.Protocols, ProtocolResult{
+				Protocol: "uart", Pins: map[string]string{"tx": J.pinName(tx.pin), "rx": rx}, Baud: baud, Description: desc,
+			})
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse comma separated list of delays in microseconds, e.g. "1,5,10".
+func parseDelays(s string) []uint {
+	delays := []uint{}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		if len(d) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(d, 10, 32)
+		if err != nil {
+			panic(err)
+		}
+		delays = append(delays, uint(v))
+	}
+	return delays
+}
+
+// Approximate TCK frequency for the given toggle delay.
+func describeTckDelay(d uint) string {
+	if d == 0 {
+		return "0us (max speed)"
+	}
+	// one full TCK period consists of two toggles
+	return fmt.Sprintf("%dus (~%.1f kHz)", d, 1000.0/float64(2*d))
+}
+
+// Repeat the loopback and bypass checks on the known pins over a range of
+// TCK delays, from the slowest to the fastest one, and report at which speed
+// errors begin. This helps to pick safe settings for the cable and fixture.
+// Crosstalk means TDO follows TDI without the TAP being clocked at all;
+// bypass error means the pattern did not make it through the chain intact.
+func (J *Jtag) checkSpeed(pattern string, delays []uint, repeat int) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Starting speed characterization...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	if len(delays) == 0 {
+		fmt.Fprintln(J.out, "no delays to test")
+		return
+	}
+
+	savedDelay := J.DELAY_TCK
+	defer func() { J.DELAY_TCK = savedDelay }()
+
+	J.useKnownPins()
+
+	sorted := append([]uint{}, delays...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	safeDelay := -1
+	for _, d := range sorted {
+		J.DELAY_TCK = d
+		J.initPins()
+
+		bypassErr := 0
+		crosstalk := 0
+		for r := 0; r < repeat; r += 1 {
+			devCnt, patternRecv := J.bypassRoundtrip(pattern)
+			if devCnt == 0 || patternRecv != pattern {
+				bypassErr += 1
+			}
+			// TCK stays low, so TAP state does not change here
+			if string(J.shiftLoopback(pattern, d)) == pattern {
+				crosstalk += 1
+			}
+		}
+
+		fmt.Fprintf(J.out, "delay %s: bypass errors %d/%d, crosstalk %d/%d\n",
+			describeTckDelay(d), bypassErr, repeat, crosstalk, repeat)
+
+		if bypassErr != 0 || crosstalk != 0 {
+			if safeDelay < 0 {
+				fmt.Fprintln(J.out, "errors at the slowest speed tested, check hardware connectivity")
+			} else {
+				fmt.Fprintf(J.out, "errors begin at delay %s, use -delay-tck %d or slower\n",
+					describeTckDelay(d), safeDelay)
+			}
+			return
+		}
+		safeDelay = int(d)
+	}
+	fmt.Fprintf(J.out, "no errors detected, fastest tested setting is -delay-tck %d\n", safeDelay)
+}
+
+func equalIdcodes(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Read IDCODEs of the chain on known pins continuously for the given
+// duration and compare every read with the first one. Useful to validate
+// a fixture before doing long transfers over the same wiring.
+func (J *Jtag) soakIdcode(duration time.Duration) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Starting IDCODE soak test for %v...\n", duration)
+	defer fmt.Fprintln(J.out, "================================")
+
+	J.useKnownPins()
+
+	J.initPins()
+
+	reference := chainIdcodes(J.getIdcodes(J.MAX_DEVICES))
+	if len(validIdcodes(reference)) == 0 {
+		fmt.Fprintln(J.out, "no devices found")
+		return
+	}
+
+	fmt.Fprintln(J.out, "reference devices:")
+	for i, idcode := range reference {
+		fmt.Fprintf(J.out, "device %d: %s\n", i, describeChainIdcode(idcode))
+	}
+
+	start := time.Now()
+	reads := 0
+	mismatches := 0
+	var firstFail, lastFail time.Duration
+	for time.Since(start) < duration {
+		idcodes := chainIdcodes(J.getIdcodes(J.MAX_DEVICES))
+		reads += 1
+		if equalIdcodes(idcodes, reference) {
+			continue
+		}
+
+		elapsed := time.Since(start)
+		if mismatches == 0 {
+			firstFail = elapsed
+		}
+		lastFail = elapsed
+		mismatches += 1
+		fmt.Fprintf(J.out, "[%v] read #%d mismatch: %08x\n", elapsed.Round(time.Millisecond), reads, idcodes)
+	}
+
+	fmt.Fprintf(J.out, "total reads: %d, mismatches: %d\n", reads, mismatches)
+	if mismatches != 0 {
+		fmt.Fprintf(J.out, "first failure after %v, last failure after %v, mean time between failures %v\n",
+			firstFail.Round(time.Millisecond), lastFail.Round(time.Millisecond),
+			(time.Since(start) / time.Duration(mismatches)).Round(time.Millisecond))
+	}
+}
+
+// Read IDCODEs twice and compare results. If they differ, double the TCK
+// delay and try again, up to J.RETRIES times. The delay at which results
+// became stable is reported, the original delay is restored afterwards.
+// returns the last IDCODEs read
+func (J *Jtag) getStableIdcodes(devCnt int) []uint32 {
+	savedDelay := J.DELAY_TCK
+	defer func() { J.DELAY_TCK = savedDelay }()
+
+	idcodes := J.getIdcodes(devCnt)
+	previous := idcodes
+	for retry := uint(0); ; retry += 1 {
+		// retry at the same delay first, the TAP might have just lost sync
+		idcodesNew := []uint32{}
+		stable := J.retryShift("IDCODE read", func() bool {
+			idcodesNew = J.getIdcodes(devCnt)
+			if equalIdcodes(idcodes, idcodesNew) {
+				return true
+			}
+			previous = idcodes
+			idcodes = idcodesNew
+			return false
+		})
+		if stable {
+			if retry != 0 {
+				fmt.Fprintf(J.out, "results became stable at delay %s, consider -delay-tck %d\n",
+					describeTckDelay(J.DELAY_TCK), J.DELAY_TCK)
+			}
+			return idcodesNew
+		}
+		if retry == J.RETRIES {
+			fmt.Fprintf(J.out, "results still inconsistent at delay %s, giving up\n",
+				describeTckDelay(J.DELAY_TCK))
+			for i := 0; i < len(idcodesNew) && i < len(previous); i += 1 {
+				if idcodesNew[i] == previous[i] {
+					continue
+				}
+				if closest := describeClosestIdcodes([]uint32{idcodesNew[i], previous[i]}); closest != "" {
+					fmt.Fprintf(J.out, "device %d: %s\n", i, closest)
+				}
+			}
+			return idcodesNew
+		}
+
+		if J.DELAY_TCK == 0 {
+			J.DELAY_TCK = 1
+		} else {
+			J.DELAY_TCK *= 2
+		}
+		fmt.Fprintf(J.out, "inconsistent reads, retrying at delay %s\n", describeTckDelay(J.DELAY_TCK))
+		idcodes = J.getIdcodes(devCnt)
+	}
+}
+
+// Run long shift J.VERIFY_READS times and compare CRC32 of every result with
+// the first one, so corrupted bits are detected rather than silently saved.
+// Inconsistent reads are retried after re-syncing the TAP.
+// capture -- performs the shift, returns bits received
+// returns bits of the first read and whether all reads were identical
+func (J *Jtag) verifiedShift(capture func() string) (string, bool) {
+	bits := ""
+	ok := J.retryShift("long shift", func() bool {
+		ok := false
+		bits, ok = J.compareReads(capture)
+		return ok
+	})
+	if !ok {
+		fmt.Fprintln(J.out, "WARNING: reads are inconsistent, data is not reliable, try slower TCK")
+	}
+	return bits, ok
+}
+
+// returns bits of the first read and whether all J.VERIFY_READS reads were
+// identical
+func (J *Jtag) compareReads(capture func() string) (string, bool) {
+	bits := capture()
+	if J.VERIFY_READS <= 1 {
+		return bits, true
+	}
+
+	crc := crc32.ChecksumIEEE([]byte(bits))
+	ok := true
+	for read := uint(2); read <= J.VERIFY_READS; read += 1 {
+		bitsNew := capture()
+		crcNew := crc32.ChecksumIEEE([]byte(bitsNew))
+		if crcNew == crc && bitsNew == bits {
+			continue
+		}
+		ok = false
+		diff := 0
+		for i := 0; i < len(bits) && i < len(bitsNew); i += 1 {
+			if bits[i] != bitsNew[i] {
+				diff += 1
+			}
+		}
+		fmt.Fprintf(J.out, "read #%d differs: CRC32 0x%08x vs 0x%08x, %d bits differ\n", read, crcNew, crc, diff)
+	}
+	if ok {
+		fmt.Fprintf(J.out, "%d reads verified, CRC32 0x%08x\n", J.VERIFY_READS, crc)
+	}
+	return bits, ok
+}
+
+// Stream long shift to a file chunk by chunk, one line of bits per chunk,
+// after the header describing the capture.
+// With J.VERIFY_READS > 1 the shift is repeated and CRC32 of every repeat is
+// compared with the one of the data written.
+// capture -- performs the shift passing received chunks to the given function
+// returns whether all reads were identical
+func (J *Jtag) dumpStream(path string, info CaptureInfo, capture func(func([]byte))) bool {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	w.WriteString(info.header())
+
+	bitCnt := 0
+	crc := uint32(0)
+	capture(func(chunk []byte) {
+		bitCnt += len(chunk)
+		crc = crc32.Update(crc, crc32.IEEETable, chunk)
+		w.Write(chunk)
+		w.WriteString("\n")
+		// flush every chunk, so data is on disk even if the target hangs
+		if err := w.Flush(); err != nil {
+			panic(err)
+		}
+	})
+	fmt.Fprintf(J.out, "%d bits written to %s, CRC32 0x%08x\n", bitCnt, path, crc)
+
+	ok := true
+	for read := uint(2); read <= J.VERIFY_READS; read += 1 {
+		crcNew := uint32(0)
+		capture(func(chunk []byte) { crcNew = crc32.Update(crcNew, crc32.IEEETable, chunk) })
+		if crcNew != crc {
+			ok = false
+			fmt.Fprintf(J.out, "read #%d differs: CRC32 0x%08x vs 0x%08x\n", read, crcNew, crc)
+		}
+	}
+	if J.VERIFY_READS > 1 {
+		if ok {
+			fmt.Fprintf(J.out, "%d reads verified\n", J.VERIFY_READS)
+		} else {
+			fmt.Fprintln(J.out, "WARNING: reads are inconsistent, data is not reliable, try slower TCK")
+		}
+	}
+	return ok
+}
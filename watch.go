@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Heatmap characters, from cells which never changed to the most active
+const HEATMAP_CHARS = " .:-=+*#%@"
+
+// Cells listed by the watch summary
+const WATCH_TOP_CELLS = 20
+
+// Name boundary cell i by its BSDL port if known.
+func cellName(bsdl *Bsdl, i int) string {
+	if bsdl != nil {
+		if c := bsdl.cell(i); c != nil && c.Port != "*" && c.Port != "" {
+			return fmt.Sprintf("%d (%s %s)", i, c.Port, strings.ToLower(c.Function))
+		}
+	}
+	return fmt.Sprintf("%d", i)
+}
+
+// Capture the boundary register with SAMPLE over and over for duration and
+// count how often every cell changed between captures, then draw a heatmap
+// of the register and list the most active cells: cells changing on most
+// captures are clocks, occasionally changing ones buses or control signals,
+// the rest static.
+func (J *Jtag) watchSample(drLen int, bsdl *Bsdl, sampleOpcode int64, duration time.Duration) {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintf(J.out, "Watching boundary cells for %v...\n", duration)
+	defer fmt.Fprintln(J.out, "================================")
+
+	irSample, drLen := J.prepareSample(drLen, bsdl, sampleOpcode)
+	if irSample == nil {
+		return
+	}
+	defer J.setTapState(TAP_RESET)
+
+	capture := func() []byte {
+		bits := []byte{}
+		J.setTapState(TAP_RESET)
+		J.sendInstruction(irSample)
+		J.shiftDrStream(drLen, DR_CHUNK_LEN, nil, func(chunk []byte) { bits = append(bits, chunk...) })
+		return bits
+	}
+
+	toggles := make([]int, drLen)
+	prev := capture()
+	captures := 1
+	start := time.Now()
+	lastReport := start
+	for time.Since(start) < duration {
+		bits := capture()
+		captures += 1
+		for i := range bits {
+			if bits[i] != prev[i] {
+				toggles[i] += 1
+			}
+		}
+		prev = bits
+		if time.Since(lastReport) >= 10*time.Second {
+			lastReport = time.Now()
+			fmt.Fprintf(J.out, "[%v] %d captures\n", time.Since(start).Round(time.Second), captures)
+		}
+	}
+
+	max := 0
+	for _, t := range toggles {
+		if t > max {
+			max = t
+		}
+	}
+	fmt.Fprintf(J.out, "%d captures, %.1f per second\n", captures, float64(captures)/time.Since(start).Seconds())
+	if max == 0 {
+		fmt.Fprintln(J.out, "no cell changed")
+		return
+	}
+
+	fmt.Fprintf(J.out, "heatmap, 64 cells per line, '%s' from static to most active:\n", HEATMAP_CHARS)
+	for i := 0; i < drLen; i += 64 {
+		line := []byte{}
+		for k := i; k < i+64 && k < drLen; k += 1 {
+			level := 0
+			if toggles[k] != 0 {
+				level = 1 + toggles[k]*(len(HEATMAP_CHARS)-2)/max
+			}
+			line = append(line, HEATMAP_CHARS[level])
+		}
+		fmt.Fprintf(J.out, "%5d |%s|\n", i, line)
+	}
+
+	cells := []int{}
+	for i, t := range toggles {
+		if t != 0 {
+			cells = append(cells, i)
+		}
+	}
+	sort.SliceStable(cells, func(a, b int) bool { return toggles[cells[a]] > toggles[cells[b]] })
+	fmt.Fprintf(J.out, "%d of %d cells changed, most active:\n", len(cells), drLen)
+	for n, i := range cells {
+		if n == WATCH_TOP_CELLS {
+			fmt.Fprintf(J.out, "  ... %d more\n", len(cells)-n)
+			break
+		}
+		kind := "occasional, bus or control"
+		if toggles[i]*2 >= captures-1 {
+			kind = "clock-like"
+		}
+		fmt.Fprintf(J.out, "  cell %s: %d changes, %s\n", cellName(bsdl, i), toggles[i], kind)
+	}
+}
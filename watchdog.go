@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Driver wrapper aborting the program if any driver call does not return in
+// time, e.g. because of a hung USB adapter or stuck cgo call. A blocked call
+// can't be interrupted, so the only clean option is to report and exit.
+type JtagPinDriverWatchdog struct {
+	drv     JtagPinDriver
+	Timeout time.Duration
+
+	// start of the call in progress (UnixNano), 0 if idle
+	callStart int64
+	// name of the call in progress and its pin, read by watchdog only
+	// after callStart is set
+	callName atomic.Value
+	callPin  int32
+
+	done chan struct{}
+}
+
+func (d *JtagPinDriverWatchdog) enter(name string, pin JtagPin) {
+	d.callName.Store(name)
+	atomic.StoreInt32(&d.callPin, int32(pin))
+	atomic.StoreInt64(&d.callStart, time.Now().UnixNano())
+}
+
+func (d *JtagPinDriverWatchdog) leave() {
+	atomic.StoreInt64(&d.callStart, 0)
+}
+
+func (d *JtagPinDriverWatchdog) watch() {
+	ticker := time.NewTicker(d.Timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		start := atomic.LoadInt64(&d.callStart)
+		if start == 0 || time.Since(time.Unix(0, start)) < d.Timeout {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "watchdog: driver call %s(%d) did not return within %v, aborting\n",
+			d.callName.Load(), atomic.LoadInt32(&d.callPin), d.Timeout)
+		os.Exit(2)
+	}
+}
+
+func (d *JtagPinDriverWatchdog) initDriver() {
+	d.done = make(chan struct{})
+	go d.watch()
+	d.enter("initDriver", 0)
+	defer d.leave()
+	d.drv.initDriver()
+}
+
+func (d *JtagPinDriverWatchdog) closeDriver() {
+	d.enter("closeDriver", 0)
+	d.drv.closeDriver()
+	d.leave()
+	close(d.done)
+}
+
+func (d *JtagPinDriverWatchdog) pinWrite(pin JtagPin, state JtagPinState) {
+	d.enter("pinWrite", pin)
+	d.drv.pinWrite(pin, state)
+	d.leave()
+}
+
+func (d *JtagPinDriverWatchdog) pinRead(pin JtagPin) JtagPinState {
+	d.enter("pinRead", pin)
+	ret := d.drv.pinRead(pin)
+	d.leave()
+	return ret
+}
+
+func (d *JtagPinDriverWatchdog) pinOutput(pin JtagPin) {
+	d.enter("pinOutput", pin)
+	d.drv.pinOutput(pin)
+	d.leave()
+}
+
+func (d *JtagPinDriverWatchdog) pinInput(pin JtagPin) {
+	d.enter("pinInput", pin)
+	d.drv.pinInput(pin)
+	d.leave()
+}
+
+func (d *JtagPinDriverWatchdog) pinPullUp(pin JtagPin) {
+	d.enter("pinPullUp", pin)
+	d.drv.pinPullUp(pin)
+	d.leave()
+}
+
+func (d *JtagPinDriverWatchdog) pinPullOff(pin JtagPin) {
+	d.enter("pinPullOff", pin)
+	d.drv.pinPullOff(pin)
+	d.leave()
+}
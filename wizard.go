@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Blinks of a pin while the wizard asks the user to find it
+const WIZARD_BLINKS = 8
+
+// Toggle pin WIZARD_BLINKS times, slow enough to be seen on a LED or a
+// multimeter, and leave it as input.
+func (J *Jtag) blinkPin(pin JtagPin) {
+	J.drv.pinOutput(pin)
+	for i := 0; i < WIZARD_BLINKS; i += 1 {
+		J.drv.pinWrite(pin, StateHigh)
+		time.Sleep(250 * time.Millisecond)
+		J.drv.pinWrite(pin, StateLow)
+		time.Sleep(250 * time.Millisecond)
+	}
+	J.drv.pinInput(pin)
+}
+
+// Walk the user through wiring of the header: for every header pin ask for
+// the GPIO wired to it, blink the GPIO to have the wiring confirmed and ask
+// for its name. Writes the pins description for -pins to path.
+// in -- where answers are read from
+// returns true if the description was written
+func (J *Jtag) initWizard(in io.Reader, path string) bool {
+	fmt.Fprintln(J.out, "================================")
+	fmt.Fprintln(J.out, "Pins setup wizard...")
+	defer fmt.Fprintln(J.out, "================================")
+
+	scanner := bufio.NewScanner(in)
+	ask := func(question string) (string, bool) {
+		fmt.Fprintf(J.out, "%s ", question)
+		if !scanner.Scan() {
+			fmt.Fprintln(J.out)
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	fmt.Fprintln(J.out, "GPIOs are driven to blink, disconnect the target and attach a LED or a multimeter")
+	fmt.Fprintln(J.out, "to the header instead (GND and VCC pins are not needed)")
+	answer, ok := ask("how many header pins are to be scanned?")
+	if !ok {
+		return false
+	}
+	count, err := strconv.Atoi(answer)
+	if err != nil || count < 1 {
+		fmt.Fprintf(J.out, "invalid number of pins '%s'\n", answer)
+		return false
+	}
+
+	pins := map[string]int{}
+	used := map[int]string{}
+	for n := 1; n <= count; {
+		answer, ok := ask(fmt.Sprintf("header pin %d of %d: GPIO number wired to it?", n, count))
+		if !ok {
+			return false
+		}
+		gpio, err := strconv.Atoi(answer)
+		if err != nil || gpio < 0 || gpio >= int(J.IGNOREPIN) {
+			fmt.Fprintf(J.out, "invalid GPIO number '%s'\n", answer)
+			continue
+		}
+		if name, dup := used[gpio]; dup {
+			fmt.Fprintf(J.out, "GPIO %d is already used by %s\n", gpio, name)
+			continue
+		}
+
+		fmt.Fprintf(J.out, "blinking GPIO %d...\n", gpio)
+		J.blinkPin(JtagPin(gpio))
+		answer, ok = ask(fmt.Sprintf("did header pin %d blink? [y/N]", n))
+		if !ok {
+			return false
+		}
+		if answer = strings.ToLower(answer); answer != "y" && answer != "yes" {
+			fmt.Fprintln(J.out, "check the wiring, GPIO numbering of the driver or try another GPIO")
+			continue
+		}
+
+		name := fmt.Sprintf("pin%d", n)
+		answer, ok = ask(fmt.Sprintf("name of header pin %d? [%s]", n, name))
+		if !ok {
+			return false
+		}
+		if answer != "" {
+			name = answer
+		}
+		if _, dup := pins[name]; dup {
+			fmt.Fprintf(J.out, "name %s is already used\n", name)
+			continue
+		}
+		pins[name] = gpio
+		used[gpio] = name
+		n += 1
+	}
+
+	data, err := json.Marshal(pins)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		fmt.Fprintf(J.out, "can't write %s: %v\n", path, err)
+		return false
+	}
+	fmt.Fprintf(J.out, "pins description written to %s, reconnect the target and scan with:\n", path)
+	fmt.Fprintf(J.out, "go-jtagenum -pins \"$(cat %s)\" -command scan_idcode\n", path)
+	return true
+}